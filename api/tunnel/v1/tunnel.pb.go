@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.11
+// 	protoc-gen-go v1.36.10
 // 	protoc        v6.33.4
 // source: api/tunnel/v1/tunnel.proto
 
@@ -28,6 +28,7 @@ type ClientMessage struct {
 	//
 	//	*ClientMessage_Register
 	//	*ClientMessage_ProxyResponse
+	//	*ClientMessage_MirrorReport
 	Message       isClientMessage_Message `protobuf_oneof:"message"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -88,6 +89,15 @@ func (x *ClientMessage) GetProxyResponse() *ProxyResponse {
 	return nil
 }
 
+func (x *ClientMessage) GetMirrorReport() *MirrorReport {
+	if x != nil {
+		if x, ok := x.Message.(*ClientMessage_MirrorReport); ok {
+			return x.MirrorReport
+		}
+	}
+	return nil
+}
+
 type isClientMessage_Message interface {
 	isClientMessage_Message()
 }
@@ -100,10 +110,16 @@ type ClientMessage_ProxyResponse struct {
 	ProxyResponse *ProxyResponse `protobuf:"bytes,2,opt,name=proxy_response,json=proxyResponse,proto3,oneof"`
 }
 
+type ClientMessage_MirrorReport struct {
+	MirrorReport *MirrorReport `protobuf:"bytes,3,opt,name=mirror_report,json=mirrorReport,proto3,oneof"`
+}
+
 func (*ClientMessage_Register) isClientMessage_Message() {}
 
 func (*ClientMessage_ProxyResponse) isClientMessage_Message() {}
 
+func (*ClientMessage_MirrorReport) isClientMessage_Message() {}
+
 // ServerMessage is sent by the tunnel server.
 type ServerMessage struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -292,13 +308,16 @@ func (x *RegisterAck) GetError() string {
 }
 
 type ProxyRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Method        string                 `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
-	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
-	Query         string                 `protobuf:"bytes,4,opt,name=query,proto3" json:"query,omitempty"`
-	Headers       map[string]string      `protobuf:"bytes,5,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	Body          []byte                 `protobuf:"bytes,6,opt,name=body,proto3" json:"body,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Method  string                 `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Path    string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
+	Query   string                 `protobuf:"bytes,4,opt,name=query,proto3" json:"query,omitempty"`
+	Headers map[string]string      `protobuf:"bytes,5,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Body    []byte                 `protobuf:"bytes,6,opt,name=body,proto3" json:"body,omitempty"`
+	// Tunnel-level context that is not part of the proxied request, such as the
+	// W3C trace context (traceparent, tracestate) of the server span.
+	Metadata      map[string]string `protobuf:"bytes,7,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -375,6 +394,13 @@ func (x *ProxyRequest) GetBody() []byte {
 	return nil
 }
 
+func (x *ProxyRequest) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
 type ProxyResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
@@ -443,14 +469,117 @@ func (x *ProxyResponse) GetBody() []byte {
 	return nil
 }
 
+// MirrorReport compares the primary response with a mirrored copy of the
+// same request sent by the client to its mirror target.
+type MirrorReport struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	RequestId        string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Method           string                 `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Path             string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
+	PrimaryStatus    int32                  `protobuf:"varint,4,opt,name=primary_status,json=primaryStatus,proto3" json:"primary_status,omitempty"`
+	PrimaryLatencyMs int64                  `protobuf:"varint,5,opt,name=primary_latency_ms,json=primaryLatencyMs,proto3" json:"primary_latency_ms,omitempty"`
+	MirrorStatus     int32                  `protobuf:"varint,6,opt,name=mirror_status,json=mirrorStatus,proto3" json:"mirror_status,omitempty"`
+	MirrorLatencyMs  int64                  `protobuf:"varint,7,opt,name=mirror_latency_ms,json=mirrorLatencyMs,proto3" json:"mirror_latency_ms,omitempty"`
+	MirrorError      string                 `protobuf:"bytes,8,opt,name=mirror_error,json=mirrorError,proto3" json:"mirror_error,omitempty"` // empty when the mirror answered
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *MirrorReport) Reset() {
+	*x = MirrorReport{}
+	mi := &file_api_tunnel_v1_tunnel_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MirrorReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MirrorReport) ProtoMessage() {}
+
+func (x *MirrorReport) ProtoReflect() protoreflect.Message {
+	mi := &file_api_tunnel_v1_tunnel_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MirrorReport.ProtoReflect.Descriptor instead.
+func (*MirrorReport) Descriptor() ([]byte, []int) {
+	return file_api_tunnel_v1_tunnel_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *MirrorReport) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *MirrorReport) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *MirrorReport) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *MirrorReport) GetPrimaryStatus() int32 {
+	if x != nil {
+		return x.PrimaryStatus
+	}
+	return 0
+}
+
+func (x *MirrorReport) GetPrimaryLatencyMs() int64 {
+	if x != nil {
+		return x.PrimaryLatencyMs
+	}
+	return 0
+}
+
+func (x *MirrorReport) GetMirrorStatus() int32 {
+	if x != nil {
+		return x.MirrorStatus
+	}
+	return 0
+}
+
+func (x *MirrorReport) GetMirrorLatencyMs() int64 {
+	if x != nil {
+		return x.MirrorLatencyMs
+	}
+	return 0
+}
+
+func (x *MirrorReport) GetMirrorError() string {
+	if x != nil {
+		return x.MirrorError
+	}
+	return ""
+}
+
 var File_api_tunnel_v1_tunnel_proto protoreflect.FileDescriptor
 
 const file_api_tunnel_v1_tunnel_proto_rawDesc = "" +
 	"\n" +
-	"\x1aapi/tunnel/v1/tunnel.proto\x12\ttunnel.v1\"\x90\x01\n" +
+	"\x1aapi/tunnel/v1/tunnel.proto\x12\ttunnel.v1\"\xd0\x01\n" +
 	"\rClientMessage\x121\n" +
 	"\bregister\x18\x01 \x01(\v2\x13.tunnel.v1.RegisterH\x00R\bregister\x12A\n" +
-	"\x0eproxy_response\x18\x02 \x01(\v2\x18.tunnel.v1.ProxyResponseH\x00R\rproxyResponseB\t\n" +
+	"\x0eproxy_response\x18\x02 \x01(\v2\x18.tunnel.v1.ProxyResponseH\x00R\rproxyResponse\x12>\n" +
+	"\rmirror_report\x18\x03 \x01(\v2\x17.tunnel.v1.MirrorReportH\x00R\fmirrorReportB\t\n" +
 	"\amessage\"\x97\x01\n" +
 	"\rServerMessage\x12;\n" +
 	"\fregister_ack\x18\x01 \x01(\v2\x16.tunnel.v1.RegisterAckH\x00R\vregisterAck\x12>\n" +
@@ -462,16 +591,20 @@ const file_api_tunnel_v1_tunnel_proto_rawDesc = "" +
 	"\tlocal_url\x18\x02 \x01(\tR\blocalUrl\"3\n" +
 	"\vRegisterAck\x12\x0e\n" +
 	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
-	"\x05error\x18\x02 \x01(\tR\x05error\"\xf0\x01\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"\xf0\x02\n" +
 	"\fProxyRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
 	"\x06method\x18\x02 \x01(\tR\x06method\x12\x12\n" +
 	"\x04path\x18\x03 \x01(\tR\x04path\x12\x14\n" +
 	"\x05query\x18\x04 \x01(\tR\x05query\x12>\n" +
 	"\aheaders\x18\x05 \x03(\v2$.tunnel.v1.ProxyRequest.HeadersEntryR\aheaders\x12\x12\n" +
-	"\x04body\x18\x06 \x01(\fR\x04body\x1a:\n" +
+	"\x04body\x18\x06 \x01(\fR\x04body\x12A\n" +
+	"\bmetadata\x18\a \x03(\v2%.tunnel.v1.ProxyRequest.MetadataEntryR\bmetadata\x1a:\n" +
 	"\fHeadersEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc8\x01\n" +
 	"\rProxyResponse\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
@@ -480,7 +613,17 @@ const file_api_tunnel_v1_tunnel_proto_rawDesc = "" +
 	"\x04body\x18\x04 \x01(\fR\x04body\x1a:\n" +
 	"\fHeadersEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012R\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa2\x02\n" +
+	"\fMirrorReport\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x16\n" +
+	"\x06method\x18\x02 \x01(\tR\x06method\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12%\n" +
+	"\x0eprimary_status\x18\x04 \x01(\x05R\rprimaryStatus\x12,\n" +
+	"\x12primary_latency_ms\x18\x05 \x01(\x03R\x10primaryLatencyMs\x12#\n" +
+	"\rmirror_status\x18\x06 \x01(\x05R\fmirrorStatus\x12*\n" +
+	"\x11mirror_latency_ms\x18\a \x01(\x03R\x0fmirrorLatencyMs\x12!\n" +
+	"\fmirror_error\x18\b \x01(\tR\vmirrorError2R\n" +
 	"\rTunnelService\x12A\n" +
 	"\aConnect\x12\x18.tunnel.v1.ClientMessage\x1a\x18.tunnel.v1.ServerMessage(\x010\x01B3Z1github.com/BRAVO68WEB/fwdx/api/tunnel/v1;tunnelv1b\x06proto3"
 
@@ -496,7 +639,7 @@ func file_api_tunnel_v1_tunnel_proto_rawDescGZIP() []byte {
 	return file_api_tunnel_v1_tunnel_proto_rawDescData
 }
 
-var file_api_tunnel_v1_tunnel_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_api_tunnel_v1_tunnel_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_api_tunnel_v1_tunnel_proto_goTypes = []any{
 	(*ClientMessage)(nil), // 0: tunnel.v1.ClientMessage
 	(*ServerMessage)(nil), // 1: tunnel.v1.ServerMessage
@@ -504,23 +647,27 @@ var file_api_tunnel_v1_tunnel_proto_goTypes = []any{
 	(*RegisterAck)(nil),   // 3: tunnel.v1.RegisterAck
 	(*ProxyRequest)(nil),  // 4: tunnel.v1.ProxyRequest
 	(*ProxyResponse)(nil), // 5: tunnel.v1.ProxyResponse
-	nil,                   // 6: tunnel.v1.ProxyRequest.HeadersEntry
-	nil,                   // 7: tunnel.v1.ProxyResponse.HeadersEntry
+	(*MirrorReport)(nil),  // 6: tunnel.v1.MirrorReport
+	nil,                   // 7: tunnel.v1.ProxyRequest.HeadersEntry
+	nil,                   // 8: tunnel.v1.ProxyRequest.MetadataEntry
+	nil,                   // 9: tunnel.v1.ProxyResponse.HeadersEntry
 }
 var file_api_tunnel_v1_tunnel_proto_depIdxs = []int32{
 	2, // 0: tunnel.v1.ClientMessage.register:type_name -> tunnel.v1.Register
 	5, // 1: tunnel.v1.ClientMessage.proxy_response:type_name -> tunnel.v1.ProxyResponse
-	3, // 2: tunnel.v1.ServerMessage.register_ack:type_name -> tunnel.v1.RegisterAck
-	4, // 3: tunnel.v1.ServerMessage.proxy_request:type_name -> tunnel.v1.ProxyRequest
-	6, // 4: tunnel.v1.ProxyRequest.headers:type_name -> tunnel.v1.ProxyRequest.HeadersEntry
-	7, // 5: tunnel.v1.ProxyResponse.headers:type_name -> tunnel.v1.ProxyResponse.HeadersEntry
-	0, // 6: tunnel.v1.TunnelService.Connect:input_type -> tunnel.v1.ClientMessage
-	1, // 7: tunnel.v1.TunnelService.Connect:output_type -> tunnel.v1.ServerMessage
-	7, // [7:8] is the sub-list for method output_type
-	6, // [6:7] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	6, // 2: tunnel.v1.ClientMessage.mirror_report:type_name -> tunnel.v1.MirrorReport
+	3, // 3: tunnel.v1.ServerMessage.register_ack:type_name -> tunnel.v1.RegisterAck
+	4, // 4: tunnel.v1.ServerMessage.proxy_request:type_name -> tunnel.v1.ProxyRequest
+	7, // 5: tunnel.v1.ProxyRequest.headers:type_name -> tunnel.v1.ProxyRequest.HeadersEntry
+	8, // 6: tunnel.v1.ProxyRequest.metadata:type_name -> tunnel.v1.ProxyRequest.MetadataEntry
+	9, // 7: tunnel.v1.ProxyResponse.headers:type_name -> tunnel.v1.ProxyResponse.HeadersEntry
+	0, // 8: tunnel.v1.TunnelService.Connect:input_type -> tunnel.v1.ClientMessage
+	1, // 9: tunnel.v1.TunnelService.Connect:output_type -> tunnel.v1.ServerMessage
+	9, // [9:10] is the sub-list for method output_type
+	8, // [8:9] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_api_tunnel_v1_tunnel_proto_init() }
@@ -531,6 +678,7 @@ func file_api_tunnel_v1_tunnel_proto_init() {
 	file_api_tunnel_v1_tunnel_proto_msgTypes[0].OneofWrappers = []any{
 		(*ClientMessage_Register)(nil),
 		(*ClientMessage_ProxyResponse)(nil),
+		(*ClientMessage_MirrorReport)(nil),
 	}
 	file_api_tunnel_v1_tunnel_proto_msgTypes[1].OneofWrappers = []any{
 		(*ServerMessage_RegisterAck)(nil),
@@ -542,7 +690,7 @@ func file_api_tunnel_v1_tunnel_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_tunnel_v1_tunnel_proto_rawDesc), len(file_api_tunnel_v1_tunnel_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
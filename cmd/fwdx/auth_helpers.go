@@ -1,12 +1,17 @@
 package fwdx
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
 
 	"github.com/BRAVO68WEB/fwdx/internal/config"
+	"github.com/spf13/cobra"
 )
 
 func resolveServerURL(explicit string) (string, error) {
@@ -45,3 +50,50 @@ func requireAuthSession() (*config.AuthSession, error) {
 	}
 	return sess, nil
 }
+
+// apiRequest calls the server API with the saved session and decodes the JSON response into out
+// when non-nil, or copies the body as is when out is an io.Writer. path may carry a query string.
+func apiRequest(cmd *cobra.Command, method, path string, body any, wantStatus int, out any) (*url.URL, error) {
+	serverURL, _ := cmd.Flags().GetString("server")
+	base, err := resolveServerBase(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := requireAuthSession()
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		reader = bytes.NewReader(raw)
+	}
+	ref := &url.URL{Path: path}
+	if p, query, ok := strings.Cut(path, "?"); ok {
+		ref = &url.URL{Path: p, RawQuery: query}
+	}
+	req, _ := http.NewRequest(method, base.ResolveReference(ref).String(), reader)
+	req.Header.Set("Authorization", "Bearer "+sess.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return base, err
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, err
+		}
+	}
+	return base, nil
+}
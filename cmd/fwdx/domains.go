@@ -1,8 +1,6 @@
 package fwdx
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,19 +11,80 @@ import (
 
 var domainsCmd = &cobra.Command{
 	Use:   "domains",
-	Short: "Manage allowed domains on the fwdx server",
+	Short: "Manage custom domains on the fwdx server",
 }
 
 var domainsAddCmd = &cobra.Command{
 	Use:   "add [domain]",
-	Short: "Add a domain to the allowed list and print DNS instructions",
+	Short: "Register a custom domain and print DNS and verification instructions",
 	Args:  cobra.ExactArgs(1),
 	RunE:  runDomainsAdd,
 }
 
+var domainsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your domains and shared domains",
+	RunE:  runDomainsList,
+}
+
+var domainsVerifyCmd = &cobra.Command{
+	Use:   "verify [domain]",
+	Short: "Check a pending domain's TXT record or well-known file",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDomainsVerify,
+}
+
+var domainsApproveCmd = &cobra.Command{
+	Use:   "approve [domain]",
+	Short: "Approve a member's domain (admin)",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDomainsApproval,
+}
+
+var domainsRejectCmd = &cobra.Command{
+	Use:   "reject [domain]",
+	Short: "Reject a member's domain (admin)",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDomainsApproval,
+}
+
+var domainsRemoveCmd = &cobra.Command{
+	Use:   "remove [domain]",
+	Short: "Remove a domain you own",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDomainsRemove,
+}
+
+// domainInfo mirrors the server's domain view.
+type domainInfo struct {
+	Domain      string `json:"domain"`
+	OwnerEmail  string `json:"owner_email"`
+	Description string `json:"description"`
+	Shared      bool   `json:"shared"`
+	Approval    string `json:"approval"`
+	Status      string `json:"status"`
+	Token       string `json:"token"`
+	TXTName     string `json:"txt_name"`
+	TXTValue    string `json:"txt_value"`
+	HTTPURL     string `json:"http_url"`
+	LastError   string `json:"last_error"`
+}
+
 func init() {
-	domainsCmd.AddCommand(domainsAddCmd)
-	domainsAddCmd.Flags().String("server", "", "fwdx server URL (or FWDX_SERVER)")
+	domainsCmd.AddCommand(domainsAddCmd, domainsListCmd, domainsVerifyCmd, domainsApproveCmd, domainsRejectCmd, domainsRemoveCmd)
+	for _, c := range []*cobra.Command{domainsAddCmd, domainsListCmd, domainsVerifyCmd, domainsApproveCmd, domainsRejectCmd, domainsRemoveCmd} {
+		c.Flags().String("server", "", "fwdx server URL (or FWDX_SERVER)")
+	}
+	domainsAddCmd.Flags().String("description", "", "What the domain is used for")
+	domainsAddCmd.Flags().Bool("shared", false, "Let every user create tunnels under the domain (admin)")
+}
+
+func domainPath(domain string, action string) string {
+	p := "/api/domains/" + url.PathEscape(strings.TrimSpace(strings.ToLower(domain)))
+	if action != "" {
+		p += "/" + action
+	}
+	return p
 }
 
 func runDomainsAdd(cmd *cobra.Command, args []string) error {
@@ -33,60 +92,101 @@ func runDomainsAdd(cmd *cobra.Command, args []string) error {
 	if domain == "" {
 		return fmt.Errorf("domain is required")
 	}
+	description, _ := cmd.Flags().GetString("description")
+	shared, _ := cmd.Flags().GetBool("shared")
 
-	serverURL, _ := cmd.Flags().GetString("server")
-	base, err := resolveServerBase(serverURL)
+	var added domainInfo
+	base, err := apiRequest(cmd, http.MethodPost, "/api/domains",
+		map[string]any{"domain": domain, "description": description, "shared": shared}, http.StatusCreated, &added)
 	if err != nil {
-		return err
+		return fmt.Errorf("add domain: %w", err)
 	}
-	sess, err := requireAuthSession()
-	if err != nil {
-		return err
+
+	fmt.Printf("Added domain: %s\n\n", added.Domain)
+	fmt.Println("DNS setup:")
+	fmt.Println("  Create a wildcard CNAME for your custom domain:")
+	fmt.Printf("    CNAME  *.%s  %s\n", added.Domain, base.Hostname())
+	fmt.Println()
+	printDomainVerification(added)
+	if added.Approval != "approved" {
+		fmt.Println()
+		fmt.Println("An admin must approve the domain before tunnels can use it.")
 	}
+	return nil
+}
 
-	// POST /admin/domains
-	domainsURL := base.ResolveReference(&url.URL{Path: "/admin/domains"}).String()
-	body, _ := json.Marshal(map[string]string{"domain": domain})
-	req, _ := http.NewRequest(http.MethodPost, domainsURL, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+sess.AccessToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("add domain: %w", err)
+func runDomainsList(cmd *cobra.Command, args []string) error {
+	var list []domainInfo
+	if _, err := apiRequest(cmd, http.MethodGet, "/api/domains", nil, http.StatusOK, &list); err != nil {
+		return fmt.Errorf("list domains: %w", err)
 	}
-	resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("add domain: %s", resp.Status)
+	if len(list) == 0 {
+		fmt.Println("No domains.")
+		return nil
 	}
-
-	// GET /admin/info for hostname
-	infoURL := base.ResolveReference(&url.URL{Path: "/admin/info"}).String()
-	req2, _ := http.NewRequest(http.MethodGet, infoURL, nil)
-	req2.Header.Set("Authorization", "Bearer "+sess.AccessToken)
-	resp2, err := http.DefaultClient.Do(req2)
-	if err != nil {
-		return fmt.Errorf("get server info: %w", err)
+	for _, d := range list {
+		owner := d.OwnerEmail
+		if owner == "" {
+			owner = "server"
+		}
+		if d.Shared {
+			owner += " (shared)"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", d.Domain, d.Approval, d.Status, owner)
 	}
-	defer resp2.Body.Close()
-	var info struct {
-		Hostname string `json:"hostname"`
+	return nil
+}
+
+func runDomainsVerify(cmd *cobra.Command, args []string) error {
+	var v domainInfo
+	if _, err := apiRequest(cmd, http.MethodPost, domainPath(args[0], "verify"), nil, http.StatusOK, &v); err != nil {
+		return fmt.Errorf("verify domain: %w", err)
 	}
-	if err := json.NewDecoder(resp2.Body).Decode(&info); err != nil {
-		return fmt.Errorf("get server info: %w", err)
+	if v.Status == "verified" {
+		fmt.Printf("Domain %s is verified.\n", v.Domain)
+		if v.Approval != "approved" {
+			fmt.Printf("It is %s by an admin, so tunnels cannot use it yet.\n", approvalText(v.Approval))
+		}
+		return nil
 	}
-	serverHostname := info.Hostname
-	if serverHostname == "" {
-		serverHostname = base.Hostname()
+	if v.LastError != "" {
+		fmt.Printf("Verification failed: %s\n\n", v.LastError)
 	}
+	printDomainVerification(v)
+	return fmt.Errorf("domain %s is still pending", v.Domain)
+}
 
-	fmt.Printf("Added domain: %s\n\n", domain)
-	fmt.Println("DNS setup:")
-	fmt.Println("  If this is the first time setting up the server, create an A record:")
-	fmt.Printf("    A  %s  <server-ip>\n", serverHostname)
-	fmt.Println("  Then for your custom domain, create a wildcard CNAME:")
-	fmt.Printf("    CNAME  *.%s  %s\n", domain, serverHostname)
-	fmt.Println()
-	fmt.Printf("  Replace <server-ip> with your server's public IP (e.g. from the machine: curl -s ifconfig.me).\n")
+func runDomainsApproval(cmd *cobra.Command, args []string) error {
+	action := cmd.Name()
+	var v domainInfo
+	if _, err := apiRequest(cmd, http.MethodPost, domainPath(args[0], action), nil, http.StatusOK, &v); err != nil {
+		return fmt.Errorf("%s domain: %w", action, err)
+	}
+	fmt.Printf("Domain %s is %s.\n", v.Domain, v.Approval)
+	return nil
+}
 
+func runDomainsRemove(cmd *cobra.Command, args []string) error {
+	if _, err := apiRequest(cmd, http.MethodDelete, domainPath(args[0], ""), nil, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("remove domain: %w", err)
+	}
+	fmt.Printf("Removed domain %s\n", strings.ToLower(args[0]))
 	return nil
 }
+
+func approvalText(approval string) string {
+	if approval == "pending" {
+		return "awaiting approval"
+	}
+	return approval
+}
+
+func printDomainVerification(v domainInfo) {
+	if v.Token == "" {
+		return
+	}
+	fmt.Println("Ownership verification (the domain stays pending until one of these is published):")
+	fmt.Printf("    TXT  %s  %q\n", v.TXTName, v.TXTValue)
+	fmt.Printf("  or serve %q at %s\n", v.Token, v.HTTPURL)
+	fmt.Printf("  Then run: fwdx domains verify %s\n", v.Domain)
+}
@@ -0,0 +1,130 @@
+package fwdx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var quotasCmd = &cobra.Command{
+	Use:   "quotas",
+	Short: "Show your usage and manage per-user and per-group quotas",
+}
+
+var quotasShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show your limits and current usage",
+	RunE:  runQuotasShow,
+}
+
+var quotasListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List quota definitions (admin)",
+	RunE:  runQuotasList,
+}
+
+var quotasSetCmd = &cobra.Command{
+	Use:   "set <user|group|default> [email|group]",
+	Short: "Create or replace a quota; 0 means unlimited (admin)",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE:  runQuotasSet,
+}
+
+var quotasRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Remove a quota definition (admin)",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runQuotasRemove,
+}
+
+type quotaInfo struct {
+	ID                   int64  `json:"id"`
+	Scope                string `json:"scope"`
+	Subject              string `json:"subject"`
+	MaxTunnels           int    `json:"max_tunnels"`
+	MaxAgents            int    `json:"max_agents"`
+	MaxConnections       int    `json:"max_connections"`
+	MonthlyTransferBytes int64  `json:"monthly_transfer_bytes"`
+}
+
+func init() {
+	quotasCmd.AddCommand(quotasShowCmd, quotasListCmd, quotasSetCmd, quotasRemoveCmd)
+	for _, c := range []*cobra.Command{quotasShowCmd, quotasListCmd, quotasSetCmd, quotasRemoveCmd} {
+		c.Flags().String("server", "", "fwdx server URL (or FWDX_SERVER)")
+	}
+	quotasSetCmd.Flags().Int("tunnels", 0, "Max tunnels")
+	quotasSetCmd.Flags().Int("agents", 0, "Max agents")
+	quotasSetCmd.Flags().Int("connections", 0, "Max concurrent proxied requests")
+	quotasSetCmd.Flags().Float64("transfer-gib", 0, "Monthly transfer in GiB")
+}
+
+func runQuotasShow(cmd *cobra.Command, args []string) error {
+	var usage struct {
+		Limits        quotaInfo `json:"limits"`
+		Source        string    `json:"source"`
+		Month         string    `json:"month"`
+		Tunnels       int       `json:"tunnels"`
+		Agents        int       `json:"agents"`
+		Connections   int       `json:"connections"`
+		TransferBytes int64     `json:"transfer_bytes"`
+	}
+	if _, err := apiRequest(cmd, http.MethodGet, "/api/quotas/me", nil, http.StatusOK, &usage); err != nil {
+		return fmt.Errorf("quota usage: %w", err)
+	}
+	fmt.Printf("Limits from: %s\n", usage.Source)
+	fmt.Printf("Tunnels:     %d / %s\n", usage.Tunnels, quotaLimit(int64(usage.Limits.MaxTunnels)))
+	fmt.Printf("Agents:      %d / %s\n", usage.Agents, quotaLimit(int64(usage.Limits.MaxAgents)))
+	fmt.Printf("Connections: %d / %s\n", usage.Connections, quotaLimit(int64(usage.Limits.MaxConnections)))
+	fmt.Printf("Transfer:    %d / %s bytes (%s)\n", usage.TransferBytes, quotaLimit(usage.Limits.MonthlyTransferBytes), usage.Month)
+	return nil
+}
+
+func quotaLimit(n int64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
+
+func runQuotasList(cmd *cobra.Command, args []string) error {
+	var list []quotaInfo
+	if _, err := apiRequest(cmd, http.MethodGet, "/api/quotas", nil, http.StatusOK, &list); err != nil {
+		return fmt.Errorf("list quotas: %w", err)
+	}
+	if len(list) == 0 {
+		fmt.Println("No quotas.")
+		return nil
+	}
+	for _, q := range list {
+		fmt.Printf("%d\t%s\t%s\ttunnels=%s\tagents=%s\tconnections=%s\ttransfer=%s\n", q.ID, q.Scope, q.Subject,
+			quotaLimit(int64(q.MaxTunnels)), quotaLimit(int64(q.MaxAgents)), quotaLimit(int64(q.MaxConnections)), quotaLimit(q.MonthlyTransferBytes))
+	}
+	return nil
+}
+
+func runQuotasSet(cmd *cobra.Command, args []string) error {
+	q := quotaInfo{Scope: args[0]}
+	if len(args) == 2 {
+		q.Subject = args[1]
+	}
+	q.MaxTunnels, _ = cmd.Flags().GetInt("tunnels")
+	q.MaxAgents, _ = cmd.Flags().GetInt("agents")
+	q.MaxConnections, _ = cmd.Flags().GetInt("connections")
+	gib, _ := cmd.Flags().GetFloat64("transfer-gib")
+	q.MonthlyTransferBytes = int64(gib * (1 << 30))
+	if _, err := apiRequest(cmd, http.MethodPut, "/api/quotas", q, http.StatusOK, &q); err != nil {
+		return fmt.Errorf("set quota: %w", err)
+	}
+	fmt.Printf("Saved %s quota %s (id %d)\n", q.Scope, q.Subject, q.ID)
+	return nil
+}
+
+func runQuotasRemove(cmd *cobra.Command, args []string) error {
+	if _, err := apiRequest(cmd, http.MethodDelete, "/api/quotas/"+strings.TrimSpace(args[0]), nil, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("remove quota: %w", err)
+	}
+	fmt.Printf("Removed quota %s\n", args[0])
+	return nil
+}
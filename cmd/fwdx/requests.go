@@ -0,0 +1,105 @@
+package fwdx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var tunnelRequestsCmd = &cobra.Command{
+	Use:   "requests <name>",
+	Short: "Search a tunnel's request logs on the server, or export them",
+	Long: `Search the request logs the server keeps for a tunnel, newest first.
+
+  fwdx tunnel requests api --status 5xx --path '/webhooks/*' --from 2024-05-01T14:00:00Z --to 2024-05-01T15:00:00Z
+  fwdx tunnel requests api --from 30m --min-latency-ms 1000
+  fwdx tunnel requests api --status 5xx --format csv > errors.csv`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTunnelRequests,
+}
+
+func init() {
+	f := tunnelRequestsCmd.Flags()
+	f.String("server", "", "fwdx server URL (or FWDX_SERVER)")
+	f.String("from", "", "Start time: RFC3339 or a duration ago (e.g. 1h)")
+	f.String("to", "", "End time: RFC3339 or a duration ago")
+	f.String("status", "", "Status codes and classes, e.g. 502,5xx")
+	f.String("method", "", "HTTP method")
+	f.String("path", "", "Path prefix, or a glob such as /webhooks/*")
+	f.String("client-ip", "", "Client IP")
+	f.Int64("min-latency-ms", 0, "Only requests at least this slow")
+	f.Int("limit", 0, "Rows per page (server default 100, max 1000)")
+	f.String("cursor", "", "Continue from the cursor printed after a page")
+	f.StringP("format", "f", "table", "Output format (table, json, ndjson, csv); ndjson and csv export every match")
+	tunnelCmd.AddCommand(tunnelRequestsCmd)
+}
+
+func runTunnelRequests(cmd *cobra.Command, args []string) error {
+	q := url.Values{}
+	for _, name := range []string{"from", "to"} {
+		raw, _ := cmd.Flags().GetString(name)
+		if raw == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(raw); err == nil {
+			raw = time.Now().Add(-d).UTC().Format(time.RFC3339)
+		}
+		q.Set(name, raw)
+	}
+	for flag, param := range map[string]string{"status": "status", "method": "method", "path": "path", "client-ip": "client_ip", "cursor": "cursor"} {
+		if v, _ := cmd.Flags().GetString(flag); v != "" {
+			q.Set(param, v)
+		}
+	}
+	if v, _ := cmd.Flags().GetInt64("min-latency-ms"); v > 0 {
+		q.Set("min_latency_ms", strconv.FormatInt(v, 10))
+	}
+	if v, _ := cmd.Flags().GetInt("limit"); v > 0 {
+		q.Set("limit", strconv.Itoa(v))
+	}
+	path := "/api/tunnels/" + url.PathEscape(args[0]) + "/logs"
+	format, _ := cmd.Flags().GetString("format")
+	switch format {
+	case "ndjson", "csv", "json":
+		q.Set("format", format)
+		if _, err := apiRequest(cmd, http.MethodGet, path+"?"+q.Encode(), nil, http.StatusOK, os.Stdout); err != nil {
+			return fmt.Errorf("request logs: %w", err)
+		}
+		return nil
+	case "table":
+	default:
+		return fmt.Errorf("unknown format %q: want table, json, ndjson or csv", format)
+	}
+	var page struct {
+		Logs []struct {
+			Timestamp time.Time `json:"timestamp"`
+			Method    string    `json:"method"`
+			Path      string    `json:"path"`
+			Status    int       `json:"status"`
+			LatencyMS int64     `json:"latency_ms"`
+			ClientIP  string    `json:"client_ip"`
+			ErrorText string    `json:"error_text"`
+		} `json:"logs"`
+		NextCursor string `json:"next_cursor"`
+	}
+	if _, err := apiRequest(cmd, http.MethodGet, path+"?"+q.Encode(), nil, http.StatusOK, &page); err != nil {
+		return fmt.Errorf("request logs: %w", err)
+	}
+	if len(page.Logs) == 0 {
+		fmt.Println("No matching requests.")
+		return nil
+	}
+	for _, l := range page.Logs {
+		fmt.Printf("%s\t%s\t%s\t%d\t%dms\t%s\t%s\n", l.Timestamp.Local().Format("2006-01-02 15:04:05"), l.Method, l.Path,
+			l.Status, l.LatencyMS, l.ClientIP, l.ErrorText)
+	}
+	if page.NextCursor != "" {
+		fmt.Printf("More results: --cursor %s\n", page.NextCursor)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package fwdx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var reservationsCmd = &cobra.Command{
+	Use:   "reservations",
+	Short: "Reserve tunnel hostnames without running a tunnel",
+}
+
+var reservationsAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Reserve a subdomain or custom hostname",
+	RunE:  runReservationsAdd,
+}
+
+var reservationsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your reservations",
+	RunE:  runReservationsList,
+}
+
+var reservationsRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Release a reservation",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runReservationsRemove,
+}
+
+var reservationsPolicyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Show the server's hostname policy",
+	RunE:  runReservationsPolicy,
+}
+
+var reservationsPrefixCmd = &cobra.Command{
+	Use:   "prefix <email> <prefix>",
+	Short: "Require a user's subdomains to start with prefix, or \"\" to clear (admin)",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runReservationsPrefix,
+}
+
+type reservationInfo struct {
+	ID         int64  `json:"id"`
+	Hostname   string `json:"hostname"`
+	OwnerEmail string `json:"owner_email"`
+	Note       string `json:"note"`
+}
+
+func init() {
+	reservationsCmd.AddCommand(reservationsAddCmd, reservationsListCmd, reservationsRemoveCmd, reservationsPolicyCmd, reservationsPrefixCmd)
+	for _, c := range []*cobra.Command{reservationsAddCmd, reservationsListCmd, reservationsRemoveCmd, reservationsPolicyCmd, reservationsPrefixCmd} {
+		c.Flags().String("server", "", "fwdx server URL (or FWDX_SERVER)")
+	}
+	reservationsAddCmd.Flags().StringP("subdomain", "s", "", "Subdomain under root domain")
+	reservationsAddCmd.Flags().StringP("url", "u", "", "Custom domain hostname")
+	reservationsAddCmd.Flags().String("note", "", "What the hostname is held for")
+}
+
+func runReservationsAdd(cmd *cobra.Command, args []string) error {
+	subdomain, _ := cmd.Flags().GetString("subdomain")
+	hostURL, _ := cmd.Flags().GetString("url")
+	note, _ := cmd.Flags().GetString("note")
+	if (subdomain == "") == (hostURL == "") {
+		return fmt.Errorf("use exactly one of --subdomain or --url")
+	}
+	var rec reservationInfo
+	if _, err := apiRequest(cmd, http.MethodPost, "/api/reservations",
+		map[string]string{"subdomain": subdomain, "url": hostURL, "note": note}, http.StatusCreated, &rec); err != nil {
+		return fmt.Errorf("reserve hostname: %w", err)
+	}
+	fmt.Printf("Reserved %s (id %d)\n", rec.Hostname, rec.ID)
+	return nil
+}
+
+func runReservationsList(cmd *cobra.Command, args []string) error {
+	var list []reservationInfo
+	if _, err := apiRequest(cmd, http.MethodGet, "/api/reservations", nil, http.StatusOK, &list); err != nil {
+		return fmt.Errorf("list reservations: %w", err)
+	}
+	if len(list) == 0 {
+		fmt.Println("No reservations.")
+		return nil
+	}
+	for _, r := range list {
+		fmt.Printf("%d\t%s\t%s\t%s\n", r.ID, r.Hostname, r.OwnerEmail, r.Note)
+	}
+	return nil
+}
+
+func runReservationsRemove(cmd *cobra.Command, args []string) error {
+	if _, err := apiRequest(cmd, http.MethodDelete, "/api/reservations/"+strings.TrimSpace(args[0]), nil, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("remove reservation: %w", err)
+	}
+	fmt.Printf("Released reservation %s\n", args[0])
+	return nil
+}
+
+func runReservationsPolicy(cmd *cobra.Command, args []string) error {
+	var policy struct {
+		Reserved       []string `json:"reserved"`
+		LabelPattern   string   `json:"label_pattern"`
+		MinLabelLength int      `json:"min_label_length"`
+		MaxLabelLength int      `json:"max_label_length"`
+		Prefix         string   `json:"prefix"`
+	}
+	if _, err := apiRequest(cmd, http.MethodGet, "/api/hostname-policy", nil, http.StatusOK, &policy); err != nil {
+		return fmt.Errorf("hostname policy: %w", err)
+	}
+	fmt.Printf("Reserved:    %s\n", strings.Join(policy.Reserved, ", "))
+	fmt.Printf("Length:      %d-%d\n", policy.MinLabelLength, policy.MaxLabelLength)
+	if policy.LabelPattern != "" {
+		fmt.Printf("Pattern:     %s\n", policy.LabelPattern)
+	}
+	if policy.Prefix != "" {
+		fmt.Printf("Your prefix: %s\n", policy.Prefix)
+	}
+	return nil
+}
+
+func runReservationsPrefix(cmd *cobra.Command, args []string) error {
+	var rec struct {
+		Email  string `json:"email"`
+		Prefix string `json:"prefix"`
+	}
+	if _, err := apiRequest(cmd, http.MethodPut, "/api/hostname-policy/prefixes",
+		map[string]string{"email": args[0], "prefix": args[1]}, http.StatusOK, &rec); err != nil {
+		return fmt.Errorf("set prefix: %w", err)
+	}
+	if rec.Prefix == "" {
+		fmt.Printf("Cleared the subdomain prefix for %s\n", rec.Email)
+		return nil
+	}
+	fmt.Printf("Subdomains for %s must now start with %q\n", rec.Email, rec.Prefix)
+	return nil
+}
@@ -1,6 +1,8 @@
 package fwdx
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 )
 
@@ -18,6 +20,25 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
+// addLogFlags adds --log-level and --log-format to long-running commands.
+func addLogFlags(cmd *cobra.Command) {
+	cmd.Flags().String("log-level", "info", "Log level: debug, info, warn or error (or FWDX_LOG_LEVEL)")
+	cmd.Flags().String("log-format", "text", "Log format: text or json (or FWDX_LOG_FORMAT)")
+}
+
+// logFlags returns the log level and format, preferring explicit flags over the environment.
+func logFlags(cmd *cobra.Command) (level, format string) {
+	level, _ = cmd.Flags().GetString("log-level")
+	if env := os.Getenv("FWDX_LOG_LEVEL"); env != "" && !cmd.Flags().Changed("log-level") {
+		level = env
+	}
+	format, _ = cmd.Flags().GetString("log-format")
+	if env := os.Getenv("FWDX_LOG_FORMAT"); env != "" && !cmd.Flags().Changed("log-format") {
+		format = env
+	}
+	return level, format
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(loginCmd)
@@ -26,6 +47,9 @@ func init() {
 	rootCmd.AddCommand(agentCmd)
 	rootCmd.AddCommand(manageCmd)
 	rootCmd.AddCommand(domainsCmd)
+	rootCmd.AddCommand(reservationsCmd)
+	rootCmd.AddCommand(quotasCmd)
+	rootCmd.AddCommand(usageCmd)
 	rootCmd.AddCommand(tunnelCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(logsCmd)
@@ -1,11 +1,16 @@
 package fwdx
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/BRAVO68WEB/fwdx/internal/logging"
 	"github.com/BRAVO68WEB/fwdx/internal/server"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -39,9 +44,29 @@ func init() {
 	serveCmd.Flags().String("oidc-session-secret", "", "Secret used to hash issued session tokens")
 	serveCmd.Flags().String("oidc-device-client-id", "", "Optional OIDC device flow client ID override")
 	serveCmd.Flags().String("trusted-proxy-cidrs", "", "Comma-separated trusted proxy CIDRs for client IP resolution")
+	serveCmd.Flags().Bool("jwt-allow-http-issuers", false, "Let jwt access rules use plain http issuers; https is required otherwise (or FWDX_JWT_ALLOW_HTTP_ISSUERS=1)")
+	serveCmd.Flags().String("jwt-trusted-issuers", "", "Comma-separated jwt issuers allowed on internal addresses; others must be public hosts (or FWDX_JWT_TRUSTED_ISSUERS)")
+	serveCmd.Flags().Bool("acme", false, "Obtain and renew TLS certificates automatically via ACME (or FWDX_ACME=1)")
+	serveCmd.Flags().String("acme-email", "", "Contact email for the ACME account (or FWDX_ACME_EMAIL)")
+	serveCmd.Flags().String("acme-directory", server.LetsEncryptDirectory, "ACME directory URL (e.g. a Pebble or staging endpoint)")
+	serveCmd.Flags().String("acme-ca-file", "", "Extra CA bundle trusted when talking to the ACME directory")
+	serveCmd.Flags().Int("acme-http-port", 80, "Port for HTTP-01 challenges; other requests are redirected to HTTPS")
+	serveCmd.Flags().String("reserved-subdomains", strings.Join(server.DefaultReservedSubdomains, ","), "Comma-separated subdomains only admins may claim (or FWDX_RESERVED_SUBDOMAINS)")
+	serveCmd.Flags().String("subdomain-pattern", "", "Regular expression member subdomains must fully match (or FWDX_SUBDOMAIN_PATTERN)")
+	serveCmd.Flags().Int("subdomain-min-length", 1, "Minimum length of member subdomains")
+	serveCmd.Flags().Int("subdomain-max-length", 63, "Maximum length of member subdomains")
+	serveCmd.Flags().String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. 127.0.0.1:9090 (or FWDX_METRICS_ADDR)")
+	serveCmd.Flags().String("otlp-endpoint", "", "Export OpenTelemetry traces to this OTLP/HTTP collector, e.g. http://localhost:4318 (or FWDX_OTLP_ENDPOINT)")
+	serveCmd.Flags().StringArray("log-sink", nil, "Also ship request logs to this sink URL, repeatable: file:///path, syslog+udp://host:514, syslog+tcp://host:514 or an http(s) Loki push URL (or FWDX_LOG_SINKS, comma-separated)")
+	serveCmd.Flags().Duration("stats-retention", 30*24*time.Hour, "How long per-tunnel traffic history is kept; minute detail is kept for a day, then hourly (or FWDX_STATS_RETENTION)")
+	serveCmd.Flags().String("acme-dns-command", "", "Script run as '<cmd> present|cleanup <fqdn> <value>' for DNS-01; enables a wildcard certificate for *.hostname")
+	addLogFlags(serveCmd)
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
+	if err := logging.Setup(logFlags(cmd)); err != nil {
+		return err
+	}
 	hostname, _ := cmd.Flags().GetString("hostname")
 	if hostname == "" {
 		hostname = os.Getenv("FWDX_HOSTNAME")
@@ -95,28 +120,104 @@ func runServe(cmd *cobra.Command, args []string) error {
 	if env := os.Getenv("FWDX_TRUSTED_PROXY_CIDRS"); env != "" {
 		trustedProxyCIDRs = env
 	}
+	jwtAllowHTTPIssuers, _ := cmd.Flags().GetBool("jwt-allow-http-issuers")
+	if env := os.Getenv("FWDX_JWT_ALLOW_HTTP_ISSUERS"); env == "1" || strings.EqualFold(env, "true") {
+		jwtAllowHTTPIssuers = true
+	}
+	jwtTrustedIssuers, _ := cmd.Flags().GetString("jwt-trusted-issuers")
+	if env := os.Getenv("FWDX_JWT_TRUSTED_ISSUERS"); env != "" {
+		jwtTrustedIssuers = env
+	}
+
+	useACME, _ := cmd.Flags().GetBool("acme")
+	if env := os.Getenv("FWDX_ACME"); env == "1" || strings.EqualFold(env, "true") {
+		useACME = true
+	}
+	acmeEmail, _ := cmd.Flags().GetString("acme-email")
+	if acmeEmail == "" {
+		acmeEmail = os.Getenv("FWDX_ACME_EMAIL")
+	}
+	acmeDirectory, _ := cmd.Flags().GetString("acme-directory")
+	acmeCAFile, _ := cmd.Flags().GetString("acme-ca-file")
+	acmeHTTPPort, _ := cmd.Flags().GetInt("acme-http-port")
+	acmeDNSCommand, _ := cmd.Flags().GetString("acme-dns-command")
+
+	reservedSubdomains, _ := cmd.Flags().GetString("reserved-subdomains")
+	if env, ok := os.LookupEnv("FWDX_RESERVED_SUBDOMAINS"); ok {
+		reservedSubdomains = env
+	}
+	subdomainPattern, _ := cmd.Flags().GetString("subdomain-pattern")
+	if env := os.Getenv("FWDX_SUBDOMAIN_PATTERN"); env != "" {
+		subdomainPattern = env
+	}
+	subdomainMinLength, _ := cmd.Flags().GetInt("subdomain-min-length")
+	subdomainMaxLength, _ := cmd.Flags().GetInt("subdomain-max-length")
+	metricsAddr, _ := cmd.Flags().GetString("metrics-addr")
+	if env := os.Getenv("FWDX_METRICS_ADDR"); env != "" {
+		metricsAddr = env
+	}
+	otlpEndpoint, _ := cmd.Flags().GetString("otlp-endpoint")
+	if env := os.Getenv("FWDX_OTLP_ENDPOINT"); env != "" {
+		otlpEndpoint = env
+	}
+	statsRetention, _ := cmd.Flags().GetDuration("stats-retention")
+	if env := os.Getenv("FWDX_STATS_RETENTION"); env != "" && !cmd.Flags().Changed("stats-retention") {
+		d, err := time.ParseDuration(env)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("FWDX_STATS_RETENTION: want a positive duration such as 720h")
+		}
+		statsRetention = d
+	}
+	if statsRetention <= 0 {
+		return fmt.Errorf("--stats-retention must be positive")
+	}
+	logSinks, _ := cmd.Flags().GetStringArray("log-sink")
+	if env := os.Getenv("FWDX_LOG_SINKS"); env != "" && !cmd.Flags().Changed("log-sink") {
+		logSinks = splitCSV(env)
+	}
 
 	if hostname == "" {
 		return fmt.Errorf("hostname is required (--hostname or FWDX_HOSTNAME)")
 	}
+	var acmeCfg *server.ACMEConfig
+	if useACME {
+		acmeCfg = &server.ACMEConfig{Email: acmeEmail, DirectoryURL: acmeDirectory, CAFile: acmeCAFile}
+		if acmeDNSCommand != "" {
+			acmeCfg.DNS = server.ExecDNSProvider{Command: acmeDNSCommand}
+		}
+	}
 	cfg := server.Config{
-		Hostname:           hostname,
-		WebPort:            webPort,
-		GrpcPort:           grpcPort,
-		TLSCertFile:        tlsCert,
-		TLSKeyFile:         tlsKey,
-		DataDir:            dataDir,
-		OIDCIssuer:         oidcIssuer,
-		OIDCClientID:       oidcClientID,
-		OIDCClientSecret:   oidcClientSecret,
-		OIDCRedirectURL:    oidcRedirectURL,
-		OIDCScopes:         splitCSV(oidcScopes),
-		OIDCAdminEmails:    splitCSV(oidcAdminEmails),
-		OIDCAdminSubjects:  splitCSV(oidcAdminSubjects),
-		OIDCAdminGroups:    splitCSV(oidcAdminGroups),
-		OIDCSessionSecret:  oidcSessionSecret,
-		OIDCDeviceClientID: oidcDeviceClientID,
-		TrustedProxyCIDRs:  splitCSV(trustedProxyCIDRs),
+		Hostname:            hostname,
+		WebPort:             webPort,
+		GrpcPort:            grpcPort,
+		TLSCertFile:         tlsCert,
+		TLSKeyFile:          tlsKey,
+		DataDir:             dataDir,
+		OIDCIssuer:          oidcIssuer,
+		OIDCClientID:        oidcClientID,
+		OIDCClientSecret:    oidcClientSecret,
+		OIDCRedirectURL:     oidcRedirectURL,
+		OIDCScopes:          splitCSV(oidcScopes),
+		OIDCAdminEmails:     splitCSV(oidcAdminEmails),
+		OIDCAdminSubjects:   splitCSV(oidcAdminSubjects),
+		OIDCAdminGroups:     splitCSV(oidcAdminGroups),
+		OIDCSessionSecret:   oidcSessionSecret,
+		OIDCDeviceClientID:  oidcDeviceClientID,
+		TrustedProxyCIDRs:   splitCSV(trustedProxyCIDRs),
+		JWTAllowHTTPIssuers: jwtAllowHTTPIssuers,
+		JWTTrustedIssuers:   splitCSV(jwtTrustedIssuers),
+		ACME:                acmeCfg,
+		ACMEHTTPPort:        acmeHTTPPort,
+		HostnamePolicy: server.HostnamePolicy{
+			Reserved:       splitCSV(reservedSubdomains),
+			LabelPattern:   subdomainPattern,
+			MinLabelLength: subdomainMinLength,
+			MaxLabelLength: subdomainMaxLength,
+		},
+		MetricsAddr:    metricsAddr,
+		OTLPEndpoint:   otlpEndpoint,
+		LogSinks:       logSinks,
+		StatsRetention: statsRetention,
 	}
 
 	srv, err := server.New(cfg)
@@ -124,11 +225,26 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if tlsCert != "" && tlsKey != "" {
-		log.Printf("[fwdx] server listening https://:%d (web), grpc://:%d (tunnels)", webPort, grpcPort)
+	if acmeCfg != nil {
+		slog.Info("server listening", "web", fmt.Sprintf("https://:%d", webPort), "acme_http", fmt.Sprintf("http://:%d", acmeHTTPPort), "grpc", fmt.Sprintf("grpc://:%d", grpcPort), "tls", "acme")
+	} else if tlsCert != "" && tlsKey != "" {
+		slog.Info("server listening", "web", fmt.Sprintf("https://:%d", webPort), "grpc", fmt.Sprintf("grpc://:%d", grpcPort), "tls", "files")
 	} else {
-		log.Printf("[fwdx] server listening http://:%d (web), grpc://:%d (tunnels) — put nginx in front", webPort, grpcPort)
+		slog.Info("server listening; put nginx in front", "web", fmt.Sprintf("http://:%d", webPort), "grpc", fmt.Sprintf("grpc://:%d", grpcPort), "tls", "none")
 	}
+	// On SIGINT or SIGTERM, stop accepting traffic and persist buffered stats and usage.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		slog.Info("server shutting down", "signal", sig.String())
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("server shutdown incomplete", "error", err)
+		}
+	}()
 	return srv.Run()
 }
 
@@ -2,7 +2,10 @@ package fwdx
 
 import (
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/BRAVO68WEB/fwdx/internal/logging"
 	"github.com/BRAVO68WEB/fwdx/internal/tunnel"
 	"github.com/BRAVO68WEB/fwdx/pkg/output"
 	"github.com/spf13/cobra"
@@ -21,6 +24,8 @@ var tunnelCreateCmd = &cobra.Command{
 		subdomain, _ := cmd.Flags().GetString("subdomain")
 		url, _ := cmd.Flags().GetString("url")
 		name, _ := cmd.Flags().GetString("name")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		onExpire, _ := cmd.Flags().GetString("on-expire")
 
 		if local == "" {
 			return output.PrintError("--local is required")
@@ -32,7 +37,11 @@ var tunnelCreateCmd = &cobra.Command{
 			return output.PrintError("Cannot use both --subdomain and --url")
 		}
 
-		return handleTunnelCreate(local, subdomain, url, name)
+		if onExpire != "stop" && onExpire != "delete" {
+			return output.PrintError("--on-expire must be stop or delete")
+		}
+
+		return handleTunnelCreate(local, subdomain, url, name, tunnel.CreateOptions{TTL: ttl, ExpiryAction: onExpire})
 	},
 }
 
@@ -44,7 +53,26 @@ var tunnelStartCmd = &cobra.Command{
 		watch, _ := cmd.Flags().GetBool("watch")
 		detach, _ := cmd.Flags().GetBool("detach")
 		debug, _ := cmd.Flags().GetBool("debug")
-		return handleTunnelStart(args[0], watch, detach, debug)
+		inspect, _ := cmd.Flags().GetString("inspect")
+		metricsAddr, _ := cmd.Flags().GetString("metrics")
+		otlpEndpoint, _ := cmd.Flags().GetString("otlp-endpoint")
+		if env := os.Getenv("FWDX_OTLP_ENDPOINT"); otlpEndpoint == "" && env != "" {
+			otlpEndpoint = env
+		}
+		logLevel, logFormat := logFlags(cmd)
+		if debug && !cmd.Flags().Changed("log-level") {
+			logLevel = "debug"
+		}
+		if err := logging.Setup(logLevel, logFormat); err != nil {
+			return err
+		}
+		return handleTunnelStart(args[0], watch, detach, tunnel.StartOptions{
+			InspectAddr:  inspect,
+			MetricsAddr:  metricsAddr,
+			OTLPEndpoint: otlpEndpoint,
+			LogLevel:     logLevel,
+			LogFormat:    logFormat,
+		})
 	},
 }
 
@@ -57,6 +85,20 @@ var tunnelStopCmd = &cobra.Command{
 	},
 }
 
+var tunnelExtendCmd = &cobra.Command{
+	Use:   "extend <name>",
+	Short: "Extend or clear a tunnel's expiry",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		clearExpiry, _ := cmd.Flags().GetBool("clear")
+		if (ttl > 0) == clearExpiry {
+			return output.PrintError("use exactly one of --ttl or --clear")
+		}
+		return handleTunnelExtend(args[0], ttl, clearExpiry)
+	},
+}
+
 var tunnelListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all tunnels",
@@ -85,17 +127,217 @@ var tunnelDeleteCmd = &cobra.Command{
 	},
 }
 
+var tunnelCredentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Manage named access credentials for a tunnel",
+}
+
+var tunnelCredentialsListCmd = &cobra.Command{
+	Use:   "list <tunnel>",
+	Short: "List credentials",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelCredentialsList(args[0])
+	},
+}
+
+var tunnelCredentialsAddCmd = &cobra.Command{
+	Use:   "add <tunnel> <credential>",
+	Short: "Add a credential (the secret is generated unless --secret is set)",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, _ := cmd.Flags().GetString("kind")
+		username, _ := cmd.Flags().GetString("username")
+		secret, _ := cmd.Flags().GetString("secret")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		input := tunnel.CredentialInput{Name: args[1], Kind: kind, Username: username, Secret: secret}
+		if ttl > 0 {
+			input.TTL = ttl.String()
+		}
+		return handleTunnelCredentialsAdd(args[0], input)
+	},
+}
+
+var tunnelCredentialsRevokeCmd = &cobra.Command{
+	Use:   "revoke <tunnel> <credential>",
+	Short: "Revoke a credential",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelCredentialsRevoke(args[0], args[1])
+	},
+}
+
+var tunnelCredentialsClearPrimaryCmd = &cobra.Command{
+	Use:   "clear-primary <tunnel>",
+	Short: "Remove the primary password or secret so only named credentials are accepted",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelCredentialsClearPrimary(args[0])
+	},
+}
+
+var tunnelShareLinkCmd = &cobra.Command{
+	Use:   "share-link <name>",
+	Short: "Generate a signed, time-limited link to a signed_link tunnel",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		path, _ := cmd.Flags().GetString("path")
+		host, _ := cmd.Flags().GetString("host")
+		revoke, _ := cmd.Flags().GetBool("revoke-all")
+		if revoke {
+			return handleTunnelShareLinkRevoke(args[0])
+		}
+		return handleTunnelShareLink(args[0], ttl, path, host)
+	},
+}
+
+var tunnelMaintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Put a tunnel into or out of maintenance mode",
+}
+
+var tunnelMaintenanceOnCmd = &cobra.Command{
+	Use:   "on <name>",
+	Short: "Serve a 503 maintenance page instead of forwarding requests",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		message, _ := cmd.Flags().GetString("message")
+		retryAfter, _ := cmd.Flags().GetDuration("retry-after")
+		bypassIPs, _ := cmd.Flags().GetStringSlice("bypass-ip")
+		bypassSecret, _ := cmd.Flags().GetString("bypass-secret")
+		clearBypass, _ := cmd.Flags().GetBool("clear-bypass")
+		return handleTunnelMaintenance(args[0], true, tunnel.MaintenanceOptions{
+			Message:      message,
+			RetryAfter:   retryAfter,
+			BypassIPs:    bypassIPs,
+			BypassSecret: bypassSecret,
+			ClearBypass:  clearBypass,
+		})
+	},
+}
+
+var tunnelMaintenanceOffCmd = &cobra.Command{
+	Use:   "off <name>",
+	Short: "Resume forwarding requests",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelMaintenance(args[0], false, tunnel.MaintenanceOptions{})
+	},
+}
+
+var tunnelChaosCmd = &cobra.Command{
+	Use:   "chaos",
+	Short: "Inject latency and faults into a tunnel's traffic",
+}
+
+var tunnelChaosOnCmd = &cobra.Command{
+	Use:   "on <name>",
+	Short: "Install a chaos policy that expires automatically",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		latency, _ := cmd.Flags().GetDuration("latency")
+		jitter, _ := cmd.Flags().GetDuration("jitter")
+		errorRate, _ := cmd.Flags().GetFloat64("error-rate")
+		errorStatus, _ := cmd.Flags().GetInt("error-status")
+		dropRate, _ := cmd.Flags().GetFloat64("drop-rate")
+		bandwidth, _ := cmd.Flags().GetInt("bandwidth")
+		paths, _ := cmd.Flags().GetStringSlice("path")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		return handleTunnelChaosOn(args[0], tunnel.ChaosOptions{
+			Latency:       latency,
+			Jitter:        jitter,
+			ErrorPercent:  errorRate,
+			ErrorStatus:   errorStatus,
+			DropPercent:   dropRate,
+			BandwidthKBps: bandwidth,
+			Paths:         paths,
+			TTL:           ttl,
+		})
+	},
+}
+
+var tunnelChaosOffCmd = &cobra.Command{
+	Use:   "off <name>",
+	Short: "Remove the chaos policy",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelChaosOff(args[0])
+	},
+}
+
+var tunnelMirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Send a sampled copy of traffic to a second local target",
+}
+
+var tunnelMirrorSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Mirror a sample of requests to another local address (applies on next start)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, _ := cmd.Flags().GetString("target")
+		sample, _ := cmd.Flags().GetFloat64("sample")
+		if target == "" {
+			return output.PrintError("--target is required")
+		}
+		return handleTunnelMirrorSet(args[0], target, sample)
+	},
+}
+
+var tunnelMirrorOffCmd = &cobra.Command{
+	Use:   "off <name>",
+	Short: "Stop mirroring requests (applies on next start)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelMirrorOff(args[0])
+	},
+}
+
+var tunnelCaptureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "Record request and response payloads for the admin UI inspector",
+}
+
+var tunnelCaptureOnCmd = &cobra.Command{
+	Use:   "on <name>",
+	Short: "Start capturing headers and bodies of proxied requests",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelCapture(args[0], true)
+	},
+}
+
+var tunnelCaptureOffCmd = &cobra.Command{
+	Use:   "off <name>",
+	Short: "Stop capturing requests",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return handleTunnelCapture(args[0], false)
+	},
+}
+
 func init() {
 	// tunnel create flags
 	tunnelCreateCmd.Flags().StringP("local", "l", "", "Local service address (e.g., localhost:5000)")
 	tunnelCreateCmd.Flags().StringP("subdomain", "s", "", "Subdomain under root domain")
 	tunnelCreateCmd.Flags().StringP("url", "u", "", "Custom domain")
 	tunnelCreateCmd.Flags().String("name", "", "Custom tunnel name")
+	tunnelCreateCmd.Flags().Duration("ttl", 0, "Expire the tunnel after this duration (e.g. 2h)")
+	tunnelCreateCmd.Flags().String("on-expire", "stop", "What happens on expiry (stop, delete)")
+
+	// tunnel extend flags
+	tunnelExtendCmd.Flags().Duration("ttl", 0, "Extend the expiry by this duration")
+	tunnelExtendCmd.Flags().Bool("clear", false, "Remove the expiry")
 
 	// tunnel start flags
 	tunnelStartCmd.Flags().BoolP("watch", "w", false, "Run in foreground and stream logs (default behavior)")
 	tunnelStartCmd.Flags().Bool("detach", false, "Run tunnel in background and persist runtime state")
-	tunnelStartCmd.Flags().BoolP("debug", "d", false, "Run in foreground with debug logs")
+	tunnelStartCmd.Flags().BoolP("debug", "d", false, "Run in foreground with debug logs (same as --log-level debug)")
+	tunnelStartCmd.Flags().String("inspect", "", "Serve a local request inspector on this port or address (e.g. 4040)")
+	tunnelStartCmd.Flags().String("metrics", "", "Serve Prometheus metrics for the local app at /metrics on this port or address (e.g. 9091)")
+	tunnelStartCmd.Flags().String("otlp-endpoint", "", "Export OpenTelemetry traces to this OTLP/HTTP collector, e.g. http://localhost:4318 (or FWDX_OTLP_ENDPOINT)")
+	addLogFlags(tunnelStartCmd)
 
 	// tunnel list flags
 	tunnelListCmd.Flags().StringP("format", "f", "table", "Output format (table, json, yaml)")
@@ -103,17 +345,71 @@ func init() {
 	// tunnel delete flags
 	tunnelDeleteCmd.Flags().BoolP("force", "f", false, "Force delete without confirmation")
 
+	// tunnel credentials flags
+	tunnelCredentialsAddCmd.Flags().String("kind", "basic_auth", "Credential kind (basic_auth, shared_secret)")
+	tunnelCredentialsAddCmd.Flags().String("username", "", "Basic auth username (defaults to the credential name)")
+	tunnelCredentialsAddCmd.Flags().String("secret", "", "Password or shared secret (generated when empty)")
+	tunnelCredentialsAddCmd.Flags().Duration("ttl", 0, "Expire the credential after this duration (e.g. 720h)")
+
+	// tunnel share-link flags
+	tunnelShareLinkCmd.Flags().Duration("ttl", 24*time.Hour, "How long the link stays valid")
+	tunnelShareLinkCmd.Flags().String("path", "", "Limit the link to this path prefix (e.g. /docs)")
+	tunnelShareLinkCmd.Flags().String("host", "", "Host the link opens; required for wildcard tunnels (e.g. shop.acme.tunnel.example.com)")
+	tunnelShareLinkCmd.Flags().Bool("revoke-all", false, "Rotate the signing key, revoking every issued link")
+
+	// tunnel maintenance flags
+	tunnelMaintenanceOnCmd.Flags().String("message", "", "Message shown on the maintenance page")
+	tunnelMaintenanceOnCmd.Flags().Duration("retry-after", 0, "Retry-After hint sent to visitors (e.g. 5m)")
+	tunnelMaintenanceOnCmd.Flags().StringSlice("bypass-ip", nil, "CIDR that bypasses maintenance (repeatable)")
+	tunnelMaintenanceOnCmd.Flags().String("bypass-secret", "", "Secret accepted in the X-Fwdx-Maintenance-Bypass header")
+	tunnelMaintenanceOnCmd.Flags().Bool("clear-bypass", false, "Remove the current bypass CIDRs and secret")
+
+	tunnelMaintenanceCmd.AddCommand(tunnelMaintenanceOnCmd)
+	tunnelMaintenanceCmd.AddCommand(tunnelMaintenanceOffCmd)
+	// tunnel chaos flags
+	tunnelChaosOnCmd.Flags().Duration("latency", 0, "Delay added before forwarding each request (e.g. 300ms)")
+	tunnelChaosOnCmd.Flags().Duration("jitter", 0, "Random variation applied to the latency (e.g. 100ms)")
+	tunnelChaosOnCmd.Flags().Float64("error-rate", 0, "Percentage of requests answered with an injected error")
+	tunnelChaosOnCmd.Flags().Int("error-status", 503, "5xx status used for injected errors")
+	tunnelChaosOnCmd.Flags().Float64("drop-rate", 0, "Percentage of connections closed without a response")
+	tunnelChaosOnCmd.Flags().Int("bandwidth", 0, "Throttle responses to this many KB/s")
+	tunnelChaosOnCmd.Flags().StringSlice("path", nil, "Only affect matching paths, e.g. /api/* (repeatable)")
+	tunnelChaosOnCmd.Flags().Duration("ttl", 0, "How long the policy stays on (default 15m, max 24h)")
+
+	tunnelChaosCmd.AddCommand(tunnelChaosOnCmd)
+	tunnelChaosCmd.AddCommand(tunnelChaosOffCmd)
+	// tunnel mirror flags
+	tunnelMirrorSetCmd.Flags().String("target", "", "Local address that receives mirrored requests (e.g. localhost:4000)")
+	tunnelMirrorSetCmd.Flags().Float64("sample", 100, "Percentage of requests to mirror")
+
+	tunnelMirrorCmd.AddCommand(tunnelMirrorSetCmd)
+	tunnelMirrorCmd.AddCommand(tunnelMirrorOffCmd)
+	tunnelCaptureCmd.AddCommand(tunnelCaptureOnCmd)
+	tunnelCaptureCmd.AddCommand(tunnelCaptureOffCmd)
+
+	tunnelCredentialsCmd.AddCommand(tunnelCredentialsListCmd)
+	tunnelCredentialsCmd.AddCommand(tunnelCredentialsAddCmd)
+	tunnelCredentialsCmd.AddCommand(tunnelCredentialsRevokeCmd)
+	tunnelCredentialsCmd.AddCommand(tunnelCredentialsClearPrimaryCmd)
+
 	tunnelCmd.AddCommand(tunnelCreateCmd)
 	tunnelCmd.AddCommand(tunnelStartCmd)
 	tunnelCmd.AddCommand(tunnelStopCmd)
+	tunnelCmd.AddCommand(tunnelExtendCmd)
 	tunnelCmd.AddCommand(tunnelListCmd)
 	tunnelCmd.AddCommand(tunnelShowCmd)
 	tunnelCmd.AddCommand(tunnelDeleteCmd)
+	tunnelCmd.AddCommand(tunnelCredentialsCmd)
+	tunnelCmd.AddCommand(tunnelShareLinkCmd)
+	tunnelCmd.AddCommand(tunnelMaintenanceCmd)
+	tunnelCmd.AddCommand(tunnelCaptureCmd)
+	tunnelCmd.AddCommand(tunnelChaosCmd)
+	tunnelCmd.AddCommand(tunnelMirrorCmd)
 }
 
-func handleTunnelCreate(local, subdomain, url string, name string) error {
+func handleTunnelCreate(local, subdomain, url string, name string, opts tunnel.CreateOptions) error {
 	manager := tunnel.NewManager()
-	t, err := manager.Create(local, subdomain, url, name)
+	t, err := manager.Create(local, subdomain, url, name, opts)
 	if err != nil {
 		return output.PrintError(fmt.Sprintf("Failed to create tunnel: %v", err))
 	}
@@ -122,17 +418,20 @@ func handleTunnelCreate(local, subdomain, url string, name string) error {
 	fmt.Printf("   Hostname: https://%s\n", t.Hostname)
 	fmt.Printf("   Local:    http://%s\n", t.Local)
 	fmt.Printf("   Status:   Not running (use 'fwdx tunnel start %s' to start)\n", t.Name)
+	if !t.ExpiresAt.IsZero() {
+		fmt.Printf("   Expires:  %s (then %s)\n", t.ExpiresAt.Local().Format("2006-01-02 15:04:05"), t.ExpiryAction)
+	}
 
 	return nil
 }
 
-func handleTunnelStart(name string, watch, detach, debug bool) error {
+func handleTunnelStart(name string, watch, detach bool, opts tunnel.StartOptions) error {
 	manager := tunnel.NewManager()
 	if detach && watch {
 		return output.PrintError("use either --detach or --watch, not both")
 	}
 	if detach {
-		st, err := manager.StartDetached(name, debug)
+		st, err := manager.StartDetached(name, opts)
 		if err != nil {
 			return output.PrintError(fmt.Sprintf("Failed to start tunnel: %v", err))
 		}
@@ -141,9 +440,12 @@ func handleTunnelStart(name string, watch, detach, debug bool) error {
 		fmt.Printf("   Hostname: https://%s\n", st.Hostname)
 		fmt.Printf("   Local:    http://%s\n", st.Local)
 		fmt.Printf("   Logs:     %s\n", st.LogPath)
+		if st.Inspector != "" {
+			fmt.Printf("   Inspect:  %s\n", st.Inspector)
+		}
 		return nil
 	}
-	if err := manager.Start(name, debug); err != nil {
+	if err := manager.Start(name, opts); err != nil {
 		return output.PrintError(fmt.Sprintf("Failed to start tunnel: %v", err))
 	}
 	return nil
@@ -160,6 +462,20 @@ func handleTunnelStop(name string) error {
 	return nil
 }
 
+func handleTunnelExtend(name string, ttl time.Duration, clearExpiry bool) error {
+	manager := tunnel.NewManager()
+	t, err := manager.Extend(name, ttl, clearExpiry)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to update expiry: %v", err))
+	}
+	if t.ExpiresAt.IsZero() {
+		output.PrintSuccess(fmt.Sprintf("✅ Tunnel '%s' no longer expires", t.Name))
+		return nil
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Tunnel '%s' now expires at %s", t.Name, t.ExpiresAt.Local().Format("2006-01-02 15:04:05")))
+	return nil
+}
+
 func handleTunnelList(format string) error {
 	manager := tunnel.NewManager()
 	tunnels, err := manager.List()
@@ -192,3 +508,138 @@ func handleTunnelDelete(name string, force bool) error {
 	output.PrintSuccess(fmt.Sprintf("✅ Tunnel '%s' deleted", name))
 	return nil
 }
+
+func handleTunnelCredentialsList(name string) error {
+	manager := tunnel.NewManager()
+	creds, err := manager.ListCredentials(name)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to list credentials: %v", err))
+	}
+	output.PrintCredentialList(creds)
+	return nil
+}
+
+func handleTunnelCredentialsAdd(name string, input tunnel.CredentialInput) error {
+	manager := tunnel.NewManager()
+	cred, secret, err := manager.AddCredential(name, input)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to add credential: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Credential '%s' added to tunnel '%s'", cred.Name, name))
+	if cred.Username != "" {
+		fmt.Printf("   Username: %s\n", cred.Username)
+	}
+	fmt.Printf("   Secret:   %s\n", secret)
+	if !cred.ExpiresAt.IsZero() {
+		fmt.Printf("   Expires:  %s\n", cred.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	fmt.Println("   The secret is not stored by the server and will not be shown again.")
+	return nil
+}
+
+func handleTunnelCredentialsRevoke(name, credential string) error {
+	manager := tunnel.NewManager()
+	if err := manager.RevokeCredential(name, credential); err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to revoke credential: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Credential '%s' revoked", credential))
+	return nil
+}
+
+func handleTunnelCredentialsClearPrimary(name string) error {
+	manager := tunnel.NewManager()
+	if err := manager.ClearPrimarySecret(name); err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to clear primary secret: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Tunnel '%s' now accepts only named credentials", name))
+	return nil
+}
+
+func handleTunnelShareLink(name string, ttl time.Duration, path, host string) error {
+	manager := tunnel.NewManager()
+	link, err := manager.CreateShareLink(name, ttl, path, host)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to create share link: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Share link for tunnel '%s'", name))
+	fmt.Printf("   URL:      %s\n", link.URL)
+	fmt.Printf("   Path:     %s\n", link.Path)
+	fmt.Printf("   Expires:  %s\n", link.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+func handleTunnelShareLinkRevoke(name string) error {
+	manager := tunnel.NewManager()
+	if err := manager.RevokeShareLinks(name); err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to revoke share links: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ All share links for tunnel '%s' revoked", name))
+	return nil
+}
+
+func handleTunnelMaintenance(name string, enabled bool, opts tunnel.MaintenanceOptions) error {
+	manager := tunnel.NewManager()
+	t, err := manager.SetMaintenance(name, enabled, opts)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to update maintenance mode: %v", err))
+	}
+	if t.Maintenance {
+		output.PrintSuccess(fmt.Sprintf("🚧 Tunnel '%s' is in maintenance mode", t.Name))
+		return nil
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Tunnel '%s' is out of maintenance mode", t.Name))
+	return nil
+}
+
+func handleTunnelCapture(name string, enabled bool) error {
+	manager := tunnel.NewManager()
+	t, err := manager.SetCapture(name, enabled)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to update request capture: %v", err))
+	}
+	if t.Capture {
+		output.PrintSuccess(fmt.Sprintf("🔍 Capturing requests for tunnel '%s'; inspect them in the admin UI", t.Name))
+		return nil
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Request capture disabled for tunnel '%s'", t.Name))
+	return nil
+}
+
+func handleTunnelChaosOn(name string, opts tunnel.ChaosOptions) error {
+	manager := tunnel.NewManager()
+	policy, err := manager.SetChaos(name, opts)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to enable chaos: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("💥 Chaos enabled for tunnel '%s' until %s", name, policy.ExpiresAt.Local().Format("2006-01-02 15:04:05")))
+	return nil
+}
+
+func handleTunnelChaosOff(name string) error {
+	manager := tunnel.NewManager()
+	if err := manager.ClearChaos(name); err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to disable chaos: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Chaos disabled for tunnel '%s'", name))
+	return nil
+}
+
+func handleTunnelMirrorSet(name, target string, sample float64) error {
+	manager := tunnel.NewManager()
+	mirror, err := manager.SetMirror(name, target, sample)
+	if err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to configure mirror: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("🪞 Tunnel '%s' will mirror %g%% of requests to %s", name, mirror.SamplePercent, mirror.Target))
+	fmt.Printf("   Restart the tunnel to apply; compare results on its admin UI page.\n")
+	return nil
+}
+
+func handleTunnelMirrorOff(name string) error {
+	manager := tunnel.NewManager()
+	if err := manager.ClearMirror(name); err != nil {
+		return output.PrintError(fmt.Sprintf("Failed to remove mirror: %v", err))
+	}
+	output.PrintSuccess(fmt.Sprintf("✅ Mirror removed for tunnel '%s' (restart the tunnel to apply)", name))
+	return nil
+}
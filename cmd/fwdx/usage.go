@@ -0,0 +1,79 @@
+package fwdx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show monthly traffic per tunnel, or export it as CSV",
+	RunE:  runUsage,
+}
+
+func init() {
+	usageCmd.Flags().String("server", "", "fwdx server URL (or FWDX_SERVER)")
+	usageCmd.Flags().String("month", "", "Month as YYYY-MM (default: current month, UTC)")
+	usageCmd.Flags().String("user", "", "Only this user's tunnels (admin)")
+	usageCmd.Flags().Bool("csv", false, "Write CSV to stdout")
+	usageCmd.Flags().Bool("daily", false, "With --csv, one row per day")
+}
+
+func runUsage(cmd *cobra.Command, args []string) error {
+	month, _ := cmd.Flags().GetString("month")
+	user, _ := cmd.Flags().GetString("user")
+	asCSV, _ := cmd.Flags().GetBool("csv")
+	daily, _ := cmd.Flags().GetBool("daily")
+	q := url.Values{}
+	if month != "" {
+		q.Set("month", month)
+	}
+	if user != "" {
+		q.Set("user", user)
+	}
+	if asCSV {
+		q.Set("format", "csv")
+		if daily {
+			q.Set("by", "day")
+		}
+		if _, err := apiRequest(cmd, http.MethodGet, "/api/usage?"+q.Encode(), nil, http.StatusOK, os.Stdout); err != nil {
+			return fmt.Errorf("usage report: %w", err)
+		}
+		return nil
+	}
+	var report struct {
+		Month string `json:"month"`
+		Users []struct {
+			OwnerEmail string `json:"owner_email"`
+			Tunnels    []struct {
+				TunnelName string `json:"tunnel_name"`
+				Hostname   string `json:"hostname"`
+				Totals     struct {
+					Requests int64 `json:"requests"`
+					BytesIn  int64 `json:"bytes_in"`
+					BytesOut int64 `json:"bytes_out"`
+					Errors   int64 `json:"errors"`
+				} `json:"totals"`
+			} `json:"tunnels"`
+		} `json:"users"`
+	}
+	if _, err := apiRequest(cmd, http.MethodGet, "/api/usage?"+q.Encode(), nil, http.StatusOK, &report); err != nil {
+		return fmt.Errorf("usage report: %w", err)
+	}
+	if len(report.Users) == 0 {
+		fmt.Printf("No usage in %s.\n", report.Month)
+		return nil
+	}
+	fmt.Printf("Usage for %s\n", report.Month)
+	for _, u := range report.Users {
+		for _, t := range u.Tunnels {
+			fmt.Printf("%s\t%s\t%s\trequests=%d\tin=%d\tout=%d\terrors=%d\n", u.OwnerEmail, t.TunnelName, t.Hostname,
+				t.Totals.Requests, t.Totals.BytesIn, t.Totals.BytesOut, t.Totals.Errors)
+		}
+	}
+	return nil
+}
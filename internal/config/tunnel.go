@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// TunnelConfig holds client-side settings for one tunnel, stored in ~/.fwdx/tunnels/<name>.json.
+type TunnelConfig struct {
+	Mirror *MirrorConfig `json:"mirror,omitempty"`
+}
+
+// MirrorConfig sends a sampled copy of proxied requests to a second local target.
+type MirrorConfig struct {
+	Target        string  `json:"target"`
+	SamplePercent float64 `json:"sample_percent"`
+}
+
+// LoadTunnelConfig reads the tunnel's config. A missing file yields an empty config.
+func LoadTunnelConfig(name string) (*TunnelConfig, error) {
+	cfg := &TunnelConfig{}
+	data, err := os.ReadFile(filepath.Join(TunnelsDir(), name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SaveTunnelConfig writes the tunnel's config to ~/.fwdx/tunnels/<name>.json.
+func SaveTunnelConfig(name string, cfg *TunnelConfig) error {
+	dir := TunnelsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+".json"), data, 0600)
+}
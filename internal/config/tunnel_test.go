@@ -0,0 +1,22 @@
+package config
+
+import "testing"
+
+func TestTunnelConfig_RoundTrip(t *testing.T) {
+	old := configDir
+	configDir = t.TempDir()
+	defer func() { configDir = old }()
+
+	cfg, err := LoadTunnelConfig("app")
+	if err != nil || cfg.Mirror != nil {
+		t.Fatalf("missing file: cfg=%+v err=%v", cfg, err)
+	}
+	cfg.Mirror = &MirrorConfig{Target: "http://localhost:4000", SamplePercent: 25}
+	if err := SaveTunnelConfig("app", cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadTunnelConfig("app")
+	if err != nil || got.Mirror == nil || *got.Mirror != *cfg.Mirror {
+		t.Fatalf("loaded %+v err=%v", got, err)
+	}
+}
@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{"": slog.LevelInfo, "DEBUG": slog.LevelDebug, "warning": slog.LevelWarn, "error": slog.LevelError}
+	for in, want := range cases {
+		if got, err := ParseLevel(in); err != nil || got != want {
+			t.Fatalf("%q: got %v err=%v want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
+
+func TestNew_FormatsAndLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Info("tunnel registered", "tunnel", "app", "peer", "10.0.0.1:5000")
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("want exactly one JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "tunnel registered" || rec["tunnel"] != "app" || rec["level"] != "INFO" {
+		t.Fatalf("record=%v", rec)
+	}
+
+	buf.Reset()
+	logger, err = New(&buf, "debug", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("local retry", "request_id", "r1")
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "request_id=r1") {
+		t.Fatalf("text=%q", buf.String())
+	}
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("app_requests_total", "Requests served.", "route", "code")
+	requests.Inc("/a", "2xx")
+	requests.Add(2, "/a", "2xx")
+	requests.Inc(`/say "hi"`, "5xx")
+	requests.Add(-1, "/a", "2xx")
+	up := r.Gauge("app_up", "Whether the app is up.")
+	up.Set(1)
+	latency := r.Histogram("app_latency_seconds", "Request latency.", []float64{0.5, 0.1}, "route")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.3, "/a")
+	latency.Observe(7, "/a")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP app_requests_total Requests served.
+# TYPE app_requests_total counter
+app_requests_total{route="/a",code="2xx"} 3
+app_requests_total{route="/say \"hi\"",code="5xx"} 1
+# HELP app_up Whether the app is up.
+# TYPE app_up gauge
+app_up 1
+# HELP app_latency_seconds Request latency.
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{route="/a",le="0.1"} 1
+app_latency_seconds_bucket{route="/a",le="0.5"} 2
+app_latency_seconds_bucket{route="/a",le="+Inf"} 3
+app_latency_seconds_sum{route="/a"} 7.35
+app_latency_seconds_count{route="/a"} 3
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestGaugeVec_ResetAndHandler(t *testing.T) {
+	r := NewRegistry()
+	pending := r.Gauge("pending", "Pending requests.", "tunnel")
+	pending.Set(3, "a")
+	pending.Reset()
+	pending.Add(2, "b")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("content type=%q", rec.Header().Get("Content-Type"))
+	}
+	if strings.Contains(body, `tunnel="a"`) || !strings.Contains(body, `pending{tunnel="b"} 2`) {
+		t.Fatalf("body=%s", body)
+	}
+}
+
+func TestRegistry_DuplicatePanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("x_total", "x")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on duplicate name")
+		}
+	}()
+	r.Gauge("x_total", "x")
+}
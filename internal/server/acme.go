@@ -0,0 +1,537 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// LetsEncryptDirectory is the default ACME directory.
+	LetsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+	acmeRenewBefore     = 30 * 24 * time.Hour
+	acmeRenewInterval   = 12 * time.Hour
+	acmeIssueTimeout    = 5 * time.Minute
+	// acmeRetryAfter throttles new orders for a certificate whose last order failed, so repeated
+	// handshakes cannot hammer the CA.
+	acmeRetryAfter = time.Minute
+)
+
+// DNSProvider publishes the TXT records used by ACME DNS-01 challenges. fqdn is the full record
+// name (e.g. _acme-challenge.tunnel.example.com); Present should return once the record is
+// visible to the CA's resolvers.
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// ExecDNSProvider runs Command with the arguments "present" or "cleanup", the record name and
+// the record value. It lets any DNS API be used through a small script.
+type ExecDNSProvider struct {
+	Command string
+}
+
+func (p ExecDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p ExecDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p ExecDNSProvider) run(ctx context.Context, action, fqdn, value string) error {
+	out, err := exec.CommandContext(ctx, p.Command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dns %s %s: %w: %s", action, fqdn, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ACMEConfig configures automatic certificates.
+type ACMEConfig struct {
+	Email        string
+	DirectoryURL string // defaults to LetsEncryptDirectory
+	CAFile       string // extra roots trusted for the directory, e.g. Pebble's test CA
+	DNS          DNSProvider
+}
+
+// acmeTarget is one certificate the manager keeps: its cache key, the names it covers and
+// whether it is validated with DNS-01 instead of HTTP-01.
+type acmeTarget struct {
+	key   string
+	names []string
+	dns   bool
+}
+
+type acmeIssue struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+	at   time.Time
+}
+
+// ACMEManager obtains, caches and renews certificates for the server hostname, allowed custom
+// domains and tunnel hostnames, and selects them by SNI.
+type ACMEManager struct {
+	cfg      ACMEConfig
+	hostname string
+	domains  func() []string
+	store    *Store
+	dir      string
+
+	clientMu sync.Mutex
+	client   *acme.Client
+
+	mu       sync.Mutex
+	certs    map[string]*tls.Certificate
+	tokens   map[string]string
+	inflight map[string]*acmeIssue
+	failed   map[string]*acmeIssue
+
+	// issue obtains a certificate; replaced in tests.
+	issue func(ctx context.Context, t acmeTarget) (*tls.Certificate, error)
+}
+
+// NewACMEManager loads cached certificates from dataDir/acme.
+func NewACMEManager(cfg ACMEConfig, hostname, dataDir string, domains func() []string, store *Store) (*ACMEManager, error) {
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = LetsEncryptDirectory
+	}
+	m := &ACMEManager{
+		cfg:      cfg,
+		hostname: strings.ToLower(strings.TrimSpace(hostname)),
+		domains:  domains,
+		store:    store,
+		dir:      filepath.Join(dataDir, "acme"),
+		certs:    make(map[string]*tls.Certificate),
+		tokens:   make(map[string]string),
+		inflight: make(map[string]*acmeIssue),
+		failed:   make(map[string]*acmeIssue),
+	}
+	m.issue = m.obtain
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return nil, err
+	}
+	paths, _ := filepath.Glob(filepath.Join(m.dir, "*.crt.pem"))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(data, data)
+		if err != nil {
+			slog.Warn("acme skipping unreadable cache", "file", p, "error", err)
+			continue
+		}
+		m.certs[acmeKeyFromFile(filepath.Base(p))] = &cert
+	}
+	return m, nil
+}
+
+// TLSConfig returns a server TLS config that serves ACME certificates.
+func (m *ACMEManager) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: m.GetCertificate, NextProtos: []string{"h2", "http/1.1"}}
+}
+
+// GetCertificate returns the certificate for the SNI name, obtaining it on first use.
+func (m *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name == "" {
+		name = m.hostname
+	}
+	ctx := context.Background()
+	if c := hello.Context(); c != nil {
+		ctx = c
+	}
+	t, err := m.target(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return m.ensure(ctx, t, false)
+}
+
+// target decides which certificate serves name. Hosts one level below the server hostname share
+// a wildcard certificate when a DNS provider is configured; the server hostname, allowed domains
+// and exact tunnel hostnames get their own HTTP-01 certificate. Names served only through a
+// wildcard tunnel get that tunnel's wildcard certificate over DNS-01, never one of their own, so
+// arbitrary SNI names cannot start new orders.
+func (m *ACMEManager) target(ctx context.Context, name string) (acmeTarget, error) {
+	if net.ParseIP(name) != nil {
+		return acmeTarget{}, fmt.Errorf("acme: no certificate for IP address %s", name)
+	}
+	wildcard := acmeTarget{key: "*." + m.hostname, names: []string{m.hostname, "*." + m.hostname}, dns: true}
+	if name == m.hostname {
+		if m.cfg.DNS != nil {
+			return wildcard, nil
+		}
+		return acmeTarget{key: name, names: []string{name}}, nil
+	}
+	if label, ok := strings.CutSuffix(name, "."+m.hostname); ok && m.cfg.DNS != nil && !strings.Contains(label, ".") {
+		return wildcard, nil
+	}
+	if m.hostAllowed(ctx, name) {
+		return acmeTarget{key: name, names: []string{name}}, nil
+	}
+	if m.cfg.DNS != nil && m.store != nil {
+		if rec, err := m.store.GetTunnelByHostname(ctx, name); err == nil && strings.HasPrefix(rec.Hostname, "*.") {
+			return acmeTarget{key: rec.Hostname, names: []string{rec.Hostname}, dns: true}, nil
+		}
+	}
+	return acmeTarget{}, fmt.Errorf("acme: host %q is not served here", name)
+}
+
+// hostAllowed reports whether name may get its own HTTP-01 certificate: it must be an allowed
+// domain or an exact tunnel hostname.
+func (m *ACMEManager) hostAllowed(ctx context.Context, name string) bool {
+	if m.domains != nil {
+		for _, d := range m.domains() {
+			if strings.EqualFold(strings.TrimSpace(d), name) {
+				return true
+			}
+		}
+	}
+	if m.store != nil {
+		if _, err := m.store.getTunnelByExactHostname(ctx, name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ensure returns a valid cached certificate for t or obtains one. Concurrent callers for the
+// same certificate share one issuance. force skips the cache for renewals.
+func (m *ACMEManager) ensure(ctx context.Context, t acmeTarget, force bool) (*tls.Certificate, error) {
+	m.mu.Lock()
+	if cert := m.certs[t.key]; cert != nil && !force && time.Now().Before(cert.Leaf.NotAfter) {
+		m.mu.Unlock()
+		return cert, nil
+	}
+	if last := m.failed[t.key]; last != nil && time.Since(last.at) < acmeRetryAfter {
+		m.mu.Unlock()
+		return nil, last.err
+	}
+	in, ok := m.inflight[t.key]
+	if !ok {
+		in = &acmeIssue{done: make(chan struct{})}
+		m.inflight[t.key] = in
+		go m.runIssue(t, in)
+	}
+	m.mu.Unlock()
+	select {
+	case <-in.done:
+		return in.cert, in.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runIssue obtains t detached from the handshake that asked for it, so an impatient client does
+// not abort an order other handshakes are waiting on.
+func (m *ACMEManager) runIssue(t acmeTarget, in *acmeIssue) {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeIssueTimeout)
+	defer cancel()
+	cert, err := m.issue(ctx, t)
+	if err == nil {
+		err = m.save(t.key, cert)
+	}
+	in.cert, in.err, in.at = cert, err, time.Now()
+	m.mu.Lock()
+	if err == nil {
+		m.certs[t.key] = cert
+		delete(m.failed, t.key)
+	} else {
+		m.failed[t.key] = in
+	}
+	delete(m.inflight, t.key)
+	m.mu.Unlock()
+	if err != nil {
+		slog.Error("acme certificate failed", "names", strings.Join(t.names, ","), "error", err)
+	} else {
+		slog.Info("acme certificate issued", "names", strings.Join(t.names, ","), "expires", cert.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	close(in.done)
+}
+
+// RunRenewal renews cached certificates that are close to expiry until ctx is cancelled.
+func (m *ACMEManager) RunRenewal(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.renewDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *ACMEManager) renewDue(ctx context.Context, now time.Time) {
+	var due []acmeTarget
+	m.mu.Lock()
+	for key, cert := range m.certs {
+		if !now.Before(acmeRenewAt(cert.Leaf)) {
+			due = append(due, acmeTarget{key: key, names: cert.Leaf.DNSNames, dns: strings.HasPrefix(key, "*.")})
+		}
+	}
+	m.mu.Unlock()
+	for _, t := range due {
+		_, _ = m.ensure(ctx, t, true)
+	}
+}
+
+// acmeRenewAt is when a certificate should be renewed: 30 days before expiry, or once two
+// thirds of its lifetime have passed for short-lived certificates.
+func acmeRenewAt(leaf *x509.Certificate) time.Time {
+	window := min(acmeRenewBefore, leaf.NotAfter.Sub(leaf.NotBefore)/3)
+	return leaf.NotAfter.Add(-window)
+}
+
+// HTTPHandler answers HTTP-01 challenges and redirects every other request to HTTPS.
+func (m *ACMEManager) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, acmeChallengePrefix)
+		if !ok {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+		m.mu.Lock()
+		keyAuth, found := m.tokens[token]
+		m.mu.Unlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth))
+	})
+}
+
+// acmeClient returns the registered ACME client, creating the account key and account on first use.
+func (m *ACMEManager) acmeClient(ctx context.Context) (*acme.Client, error) {
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	httpClient := http.DefaultClient
+	if m.cfg.CAFile != "" {
+		pemData, err := os.ReadFile(m.cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("acme: no certificates in %s", m.cfg.CAFile)
+		}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.cfg.DirectoryURL, HTTPClient: httpClient, UserAgent: "fwdx"}
+	acct := &acme.Account{}
+	if m.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("acme register: %w", err)
+	}
+	m.client = client
+	return client, nil
+}
+
+func (m *ACMEManager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.dir, "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("acme: invalid account key %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// obtain runs an ACME order for t, answering each authorization with HTTP-01 or DNS-01.
+func (m *ACMEManager) obtain(ctx context.Context, t acmeTarget) (*tls.Certificate, error) {
+	client, err := m.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(t.names...))
+	if err != nil {
+		return nil, fmt.Errorf("acme order: %w", err)
+	}
+	// Orders returned by WaitOrder lack URI, so keep the one from AuthorizeOrder.
+	orderURL := order.URI
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, u, t.dns); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, orderURL); err != nil {
+		return nil, fmt.Errorf("acme order: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: t.names[0]},
+		DNSNames: t.names,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// CAs that finalize asynchronously may omit the order Location header CreateOrderCert
+		// polls, so fall back to the order URL we already have.
+		done, werr := client.WaitOrder(ctx, orderURL)
+		if werr != nil || done.CertURL == "" {
+			return nil, fmt.Errorf("acme finalize: %w", err)
+		}
+		if chain, err = client.FetchCert(ctx, done.CertURL, true); err != nil {
+			return nil, fmt.Errorf("acme fetch certificate: %w", err)
+		}
+	}
+	return acmeCertificate(key, chain)
+}
+
+func (m *ACMEManager) authorize(ctx context.Context, client *acme.Client, authzURL string, useDNS bool) error {
+	z, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("acme authorization: %w", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	want := "http-01"
+	if useDNS {
+		want = "dns-01"
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == want {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no %s challenge offered for %s", want, z.Identifier.Value)
+	}
+	if useDNS {
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		fqdn := "_acme-challenge." + z.Identifier.Value
+		if err := m.cfg.DNS.Present(ctx, fqdn, value); err != nil {
+			return err
+		}
+		defer func() {
+			if err := m.cfg.DNS.CleanUp(context.Background(), fqdn, value); err != nil {
+				slog.Warn("acme dns cleanup failed", "record", fqdn, "error", err)
+			}
+		}()
+	} else {
+		keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.tokens[chal.Token] = keyAuth
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.tokens, chal.Token)
+			m.mu.Unlock()
+		}()
+	}
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("acme accept %s: %w", z.Identifier.Value, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("acme %s for %s: %w", want, z.Identifier.Value, err)
+	}
+	return nil
+}
+
+// acmeCertificate builds a tls.Certificate from a private key and a DER chain.
+func acmeCertificate(key *ecdsa.PrivateKey, chain [][]byte) (*tls.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("acme: empty certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// save writes the key and chain as one PEM file in the cache directory.
+func (m *ACMEManager) save(key string, cert *tls.Certificate) error {
+	ecKey, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("acme: unsupported key type %T", cert.PrivateKey)
+	}
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, c := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	return os.WriteFile(filepath.Join(m.dir, acmeCacheFile(key)), data, 0600)
+}
+
+// acmeCacheFile maps a certificate key to its file name; "*" is not portable in file names.
+func acmeCacheFile(key string) string {
+	return strings.Replace(key, "*", "_wildcard", 1) + ".crt.pem"
+}
+
+func acmeKeyFromFile(name string) string {
+	return strings.Replace(strings.TrimSuffix(name, ".crt.pem"), "_wildcard", "*", 1)
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, names []string, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := acmeCertificate(key, [][]byte{der})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+type fakeDNS struct{}
+
+func (fakeDNS) Present(context.Context, string, string) error { return nil }
+func (fakeDNS) CleanUp(context.Context, string, string) error { return nil }
+
+// fakeIssuer records the targets it was asked for and returns a self-signed certificate.
+type fakeIssuer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	issued  []acmeTarget
+	fail    error
+	expires time.Duration
+}
+
+func (f *fakeIssuer) issue(_ context.Context, target acmeTarget) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.issued = append(f.issued, target)
+	if f.fail != nil {
+		return nil, f.fail
+	}
+	return testCertificate(f.t, target.names, time.Now().Add(f.expires)), nil
+}
+
+func newTestACME(t *testing.T, dataDir string, cfg ACMEConfig, domains []string, store *Store) (*ACMEManager, *fakeIssuer) {
+	t.Helper()
+	m, err := NewACMEManager(cfg, "tunnel.example.com", dataDir, func() []string { return domains }, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{t: t, expires: 90 * 24 * time.Hour}
+	m.issue = f.issue
+	return m, f
+}
+
+func TestACMEManager_SelectsBySNI(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.CreateTunnel(context.Background(), 1, "app", "app.tunnel.example.com", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	m, issuer := newTestACME(t, dataDir, ACMEConfig{}, []string{"shop.example.org"}, store)
+	get := func(name string) (*tls.Certificate, error) {
+		return m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	}
+
+	for _, name := range []string{"tunnel.example.com", "app.tunnel.example.com", "shop.example.org", "APP.tunnel.example.com."} {
+		cert, err := get(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := cert.Leaf.VerifyHostname(strings.TrimSuffix(strings.ToLower(name), ".")); err != nil {
+			t.Fatalf("%s: wrong certificate: %v", name, err)
+		}
+	}
+	if len(issuer.issued) != 3 {
+		t.Fatalf("issued %d certificates, want 3 (cached on reuse)", len(issuer.issued))
+	}
+	for _, name := range []string{"other.tunnel.example.com", "evil.example.net", "127.0.0.1"} {
+		if _, err := get(name); err == nil {
+			t.Fatalf("%s: expected unknown host to be refused", name)
+		}
+	}
+
+	reloaded, reissuer := newTestACME(t, dataDir, ACMEConfig{}, nil, store)
+	if _, err := reloaded.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.tunnel.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reissuer.issued) != 0 {
+		t.Fatal("cached certificate was not loaded from the data dir")
+	}
+}
+
+func TestACMEManager_WildcardWithDNSProvider(t *testing.T) {
+	m, issuer := newTestACME(t, t.TempDir(), ACMEConfig{DNS: fakeDNS{}}, nil, nil)
+	for _, name := range []string{"a.tunnel.example.com", "b.tunnel.example.com", "tunnel.example.com"} {
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := cert.Leaf.VerifyHostname(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(issuer.issued) != 1 || !issuer.issued[0].dns || issuer.issued[0].key != "*.tunnel.example.com" {
+		t.Fatalf("issued %+v, want one DNS-01 wildcard", issuer.issued)
+	}
+	if _, err := os.Stat(filepath.Join(m.dir, "_wildcard.tunnel.example.com.crt.pem")); err != nil {
+		t.Fatalf("wildcard not cached: %v", err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "x.y.tunnel.example.com"}); err == nil {
+		t.Fatal("wildcard must not cover two levels")
+	}
+}
+
+func TestACMEManager_WildcardTunnel(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.CreateTunnel(context.Background(), 1, "acme", "*.acme.tunnel.example.com", "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	m, issuer := newTestACME(t, t.TempDir(), ACMEConfig{}, nil, store)
+	for _, name := range []string{"a.acme.tunnel.example.com", "b.acme.tunnel.example.com"} {
+		if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Fatalf("%s: wildcard tunnel names must not get HTTP-01 certificates", name)
+		}
+	}
+	if len(issuer.issued) != 0 {
+		t.Fatalf("issued %+v, want none without a DNS provider", issuer.issued)
+	}
+
+	m, issuer = newTestACME(t, t.TempDir(), ACMEConfig{DNS: fakeDNS{}}, nil, store)
+	for _, name := range []string{"a.acme.tunnel.example.com", "b.acme.tunnel.example.com"} {
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := cert.Leaf.VerifyHostname(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(issuer.issued) != 1 || !issuer.issued[0].dns || issuer.issued[0].key != "*.acme.tunnel.example.com" {
+		t.Fatalf("issued %+v, want one DNS-01 certificate for the tunnel wildcard", issuer.issued)
+	}
+}
+
+func TestACMEManager_RenewalAndBackoff(t *testing.T) {
+	m, issuer := newTestACME(t, t.TempDir(), ACMEConfig{}, []string{"shop.example.org"}, nil)
+	issuer.expires = 10 * 24 * time.Hour
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	issuer.expires = 90 * 24 * time.Hour
+	m.renewDue(context.Background(), time.Now())
+	if len(issuer.issued) != 1 {
+		t.Fatal("renewed before the last third of the certificate's lifetime")
+	}
+	m.renewDue(context.Background(), time.Now().Add(8*24*time.Hour))
+	if len(issuer.issued) != 2 {
+		t.Fatalf("issued %d, want renewal of the expiring certificate", len(issuer.issued))
+	}
+	cert, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.org"})
+	if time.Until(cert.Leaf.NotAfter) < 80*24*time.Hour {
+		t.Fatal("renewed certificate not served")
+	}
+	m.renewDue(context.Background(), time.Now())
+	if len(issuer.issued) != 2 {
+		t.Fatal("fresh certificate renewed again")
+	}
+
+	issuer.fail = errors.New("rate limited")
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "tunnel.example.com"}); err == nil {
+		t.Fatal("expected issuance error")
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "tunnel.example.com"}); err == nil || len(issuer.issued) != 3 {
+		t.Fatalf("failed order retried immediately: issued=%d err=%v", len(issuer.issued), err)
+	}
+}
+
+func TestACMEManager_HTTPHandler(t *testing.T) {
+	m, _ := newTestACME(t, t.TempDir(), ACMEConfig{}, nil, nil)
+	m.tokens["tok"] = "tok.thumbprint"
+	h := m.HTTPHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.tunnel.example.com/.well-known/acme-challenge/tok", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "tok.thumbprint" {
+		t.Fatalf("challenge status=%d body=%q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.tunnel.example.com/.well-known/acme-challenge/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown token status=%d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.tunnel.example.com:80/docs?x=1", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://app.tunnel.example.com/docs?x=1" {
+		t.Fatalf("redirect status=%d location=%q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestExecDNSProvider(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "dns.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $2 $3\" >> "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := ExecDNSProvider{Command: script}
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.tunnel.example.com", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.tunnel.example.com", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	if string(data) != "present _acme-challenge.tunnel.example.com v1\ncleanup _acme-challenge.tunnel.example.com v1\n" {
+		t.Fatalf("calls=%q", data)
+	}
+	if err := (ExecDNSProvider{Command: filepath.Join(dir, "missing")}).Present(ctx, "x", "y"); err == nil {
+		t.Fatal("expected error for missing command")
+	}
+}
+
+// TestACMEManager_Pebble issues a real certificate from a local Pebble server. Run Pebble with
+// PEBBLE_VA_ALWAYS_VALID=1 (or a resolver pointing at this host) and set
+// FWDX_ACME_PEBBLE_DIRECTORY (e.g. https://localhost:14000/dir) and FWDX_ACME_PEBBLE_CA to
+// Pebble's minica root.
+func TestACMEManager_Pebble(t *testing.T) {
+	directory := os.Getenv("FWDX_ACME_PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("FWDX_ACME_PEBBLE_DIRECTORY not set")
+	}
+	httpAddr := os.Getenv("FWDX_ACME_PEBBLE_HTTP_ADDR")
+	if httpAddr == "" {
+		httpAddr = ":5002"
+	}
+	m, err := NewACMEManager(ACMEConfig{DirectoryURL: directory, CAFile: os.Getenv("FWDX_ACME_PEBBLE_CA"), Email: "test@example.com"},
+		"fwdx.test", t.TempDir(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Addr: httpAddr, Handler: m.HTTPHandler()}
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "fwdx.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Leaf.VerifyHostname("fwdx.test"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -103,8 +103,28 @@ func AdminRouter(hostname string, registry *Registry, domains *DomainStore, auth
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			rec, _ := domains.Get(body.Domain)
 			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "domain": body.Domain})
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "domain": body.Domain, "verification": newDomainView(rec)})
+			return
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/admin/domains/"):
+			rec, ok := domains.Get(strings.TrimPrefix(r.URL.Path, "/admin/domains/"))
+			if !ok {
+				http.Error(w, "domain not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(newDomainView(rec))
+			return
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/admin/domains/") && strings.HasSuffix(r.URL.Path, "/verify"):
+			domain := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/domains/"), "/verify")
+			if _, ok := domains.Get(domain); !ok {
+				http.Error(w, "domain not found", http.StatusNotFound)
+				return
+			}
+			rec, _ := domains.Verify(r.Context(), domain)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(newDomainView(rec))
 			return
 		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/admin/domains/"):
 			domain := strings.TrimPrefix(r.URL.Path, "/admin/domains/")
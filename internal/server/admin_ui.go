@@ -1,10 +1,13 @@
 package server
 
 import (
+	"database/sql"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -61,8 +64,15 @@ type healthData struct {
 }
 
 type logsData struct {
-	Hostname string
-	Logs     []RequestLogRecord
+	Tunnel       string
+	Hostname     string
+	Tunnels      []TunnelRecord
+	Filters      url.Values
+	Logs         []RequestLogRecord
+	OlderURL     string
+	ExportCSV    string
+	ExportNDJSON string
+	Error        string
 }
 
 // AdminUIRouter provides OIDC-authenticated micro frontend under /admin/ui.
@@ -78,7 +88,7 @@ func AdminUIRouter(cfg Config, registry *Registry, domains *DomainStore, stats *
 		store:    store,
 		auth:     auth,
 		started:  started,
-		tpl:      template.Must(template.New("ui").Parse(adminUITemplates + adminUITunnelTemplates)),
+		tpl:      template.Must(template.New("ui").Parse(adminUITemplates + adminUITunnelTemplates + adminUICaptureTemplates + adminUICertificateTemplates + adminUIQuotaTemplates + adminUIStatsTemplates)),
 	}
 
 	mux := http.NewServeMux()
@@ -89,8 +99,16 @@ func AdminUIRouter(cfg Config, registry *Registry, domains *DomainStore, stats *
 	mux.HandleFunc("/admin/ui/tunnels/disconnect", s.requireAdmin(s.disconnectHandler))
 	mux.HandleFunc("/admin/ui/domains/add", s.requireAdmin(s.domainsAddHandler))
 	mux.HandleFunc("/admin/ui/domains/remove", s.requireAdmin(s.domainsRemoveHandler))
+	mux.HandleFunc("/admin/ui/domains/verify", s.requireAdmin(s.domainsVerifyHandler))
+	mux.HandleFunc("/admin/ui/domains/approval", s.requireAdmin(s.domainsApprovalHandler))
+	mux.HandleFunc("/admin/ui/certificates/add", s.requireAdmin(s.certificatesAddHandler))
+	mux.HandleFunc("/admin/ui/certificates/remove", s.requireAdmin(s.certificatesRemoveHandler))
+	mux.HandleFunc("/admin/ui/usage", s.requireUser(s.usagePageHandler))
+	mux.HandleFunc("/admin/ui/quotas/set", s.requireAdmin(s.quotasSetHandler))
+	mux.HandleFunc("/admin/ui/quotas/remove", s.requireAdmin(s.quotasRemoveHandler))
 	mux.HandleFunc("/admin/ui/config", s.requireAdmin(s.configPageHandler))
 	mux.HandleFunc("/admin/ui/domains", s.requireAdmin(s.domainsPageHandler))
+	mux.HandleFunc("/admin/ui/certificates", s.requireAdmin(s.certificatesPageHandler))
 	mux.HandleFunc("/admin/ui/health", s.requireAdmin(s.healthPageHandler))
 	mux.HandleFunc("/admin/ui/logs", s.requireAdmin(s.logsPageHandler))
 	mux.HandleFunc("/admin/ui", s.requireAdmin(s.dashboardHandler))
@@ -107,6 +125,15 @@ func (s *adminUIServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return s.auth.requireAdmin(next)
 }
 
+func (s *adminUIServer) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	if s.auth == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "oidc not configured", http.StatusServiceUnavailable)
+		}
+	}
+	return s.auth.requireUser(next)
+}
+
 func (s *adminUIServer) currentAdmin(r *http.Request) *UserRecord {
 	if s.auth == nil {
 		return nil
@@ -177,7 +204,7 @@ func (s *adminUIServer) domainsPageHandler(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	d := s.domains.List()
+	d := s.domainViews()
 	if isHX(r) {
 		s.render(w, "domains_content", d)
 		return
@@ -197,11 +224,33 @@ func (s *adminUIServer) domainsAddHandler(w http.ResponseWriter, r *http.Request
 		http.Error(w, "domain required", http.StatusBadRequest)
 		return
 	}
-	if err := s.domains.Add(domain); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var ownerID int64
+	if user := s.currentAdmin(r); user != nil {
+		ownerID = user.ID
+	}
+	_, err := s.domains.Register(r.Context(), ownerID, domain, r.FormValue("description"), r.FormValue("shared") == "on", true)
+	if err != nil && err != errDomainExists {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.render(w, "domains_list", s.domainViews())
+}
+
+func (s *adminUIServer) domainsApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	s.render(w, "domains_list", s.domains.List())
+	_ = r.ParseForm()
+	var actorID int64
+	if user := s.currentAdmin(r); user != nil {
+		actorID = user.ID
+	}
+	if _, err := s.domains.SetApproval(r.Context(), actorID, r.FormValue("domain"), r.FormValue("approve") == "true"); err != nil {
+		http.Error(w, "domain not found", http.StatusNotFound)
+		return
+	}
+	s.render(w, "domains_list", s.domainViews())
 }
 
 func (s *adminUIServer) domainsRemoveHandler(w http.ResponseWriter, r *http.Request) {
@@ -215,11 +264,34 @@ func (s *adminUIServer) domainsRemoveHandler(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "domain required", http.StatusBadRequest)
 		return
 	}
-	if err := s.domains.Remove(domain); err != nil {
+	var actorID int64
+	if user := s.currentAdmin(r); user != nil {
+		actorID = user.ID
+	}
+	if err := s.domains.Delete(r.Context(), actorID, domain); err != nil && err != sql.ErrNoRows {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	s.render(w, "domains_list", s.domains.List())
+	s.render(w, "domains_list", s.domainViews())
+}
+
+func (s *adminUIServer) domainsVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = r.ParseForm()
+	domain := strings.TrimSpace(strings.ToLower(r.FormValue("domain")))
+	if _, ok := s.domains.Get(domain); !ok {
+		http.Error(w, "domain not found", http.StatusNotFound)
+		return
+	}
+	_, _ = s.domains.Verify(r.Context(), domain)
+	s.render(w, "domains_list", s.domainViews())
+}
+
+func (s *adminUIServer) domainViews() []domainView {
+	return newDomainViews(s.domains.Records())
 }
 
 func (s *adminUIServer) healthPageHandler(w http.ResponseWriter, r *http.Request) {
@@ -230,7 +302,7 @@ func (s *adminUIServer) healthPageHandler(w http.ResponseWriter, r *http.Request
 	d := healthData{
 		Uptime:         time.Since(s.started).Round(time.Second).String(),
 		ActiveTunnels:  len(s.registry.List()),
-		AllowedDomains: len(s.domains.List()),
+		AllowedDomains: len(s.domains.Allowed()),
 		RecentErrors:   s.stats.RecentErrors(5 * time.Minute),
 		SSEInterval:    "1s",
 	}
@@ -251,19 +323,66 @@ func (s *adminUIServer) logsPageHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "store unavailable", http.StatusServiceUnavailable)
 		return
 	}
-	hostname := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("hostname")))
-	if hostname == "" {
+	ctx := r.Context()
+	values := r.URL.Query()
+	tunnels, err := s.store.ListTunnels(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d := logsData{Tunnels: tunnels, Filters: values}
+	q, err := parseRequestLogQuery(values)
+	if err != nil {
+		d.Error = err.Error()
+		q = RequestLogQuery{Limit: defaultRequestLogPage}
+	}
+	name := strings.TrimSpace(values.Get("tunnel"))
+	hostname := strings.TrimSpace(strings.ToLower(values.Get("hostname")))
+	if name == "" && hostname == "" {
 		for h := range s.registry.List() {
 			hostname = h
 			break
 		}
 	}
-	logs, err := s.store.ListRequestLogs(r.Context(), hostname, 100)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var tun TunnelRecord
+	if name != "" {
+		tun, err = s.store.GetTunnelByName(ctx, name)
+	} else if hostname != "" {
+		tun, err = s.store.GetTunnelByHostname(ctx, hostname)
+	}
+	switch {
+	case err == nil && tun.ID != 0:
+		q.TunnelID = tun.ID
+		d.Tunnel, d.Hostname = tun.Name, tun.Hostname
+	case name != "":
+		http.Error(w, "tunnel not found", http.StatusNotFound)
 		return
+	default:
+		q.Hostname = hostname
+		d.Hostname = hostname
+	}
+	if d.Error == "" {
+		if d.Logs, err = s.store.QueryRequestLogs(ctx, q); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if cursor := nextRequestLogCursor(d.Logs, q.Limit); cursor != "" {
+		next := cloneFilterValues(values, "cursor")
+		if d.Tunnel != "" {
+			next.Set("tunnel", d.Tunnel)
+		}
+		next.Set("cursor", cursor)
+		d.OlderURL = "/admin/ui/logs?" + next.Encode()
+	}
+	if d.Tunnel != "" {
+		export := cloneFilterValues(values, "tunnel", "hostname", "cursor", "limit")
+		base := "/api/tunnels/" + url.PathEscape(d.Tunnel) + "/logs?"
+		export.Set("format", "csv")
+		d.ExportCSV = base + export.Encode()
+		export.Set("format", "ndjson")
+		d.ExportNDJSON = base + export.Encode()
 	}
-	d := logsData{Hostname: hostname, Logs: logs}
 	if isHX(r) {
 		s.render(w, "logs_content", d)
 		return
@@ -272,6 +391,18 @@ func (s *adminUIServer) logsPageHandler(w http.ResponseWriter, r *http.Request)
 	s.render(w, "layout", s.viewData("Logs", "logs", user, d))
 }
 
+// cloneFilterValues copies the non-empty query values except the dropped keys.
+func cloneFilterValues(v url.Values, drop ...string) url.Values {
+	out := url.Values{}
+	for key, vals := range v {
+		if slices.Contains(drop, key) || len(vals) == 0 || strings.TrimSpace(vals[0]) == "" {
+			continue
+		}
+		out.Set(key, vals[0])
+	}
+	return out
+}
+
 func (s *adminUIServer) tunnelsTableHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -407,11 +538,15 @@ var adminUITemplates = `
   <div class="wrap">
     <nav>
       <h3>fwdx admin</h3>
+      {{if eq .UserRole "admin"}}
       <a href="/admin/ui" class="{{if eq .Page "dashboard"}}active{{end}}" hx-get="/admin/ui" hx-target="#content" hx-push-url="true">Dashboard</a>
       <a href="/admin/ui/config" class="{{if eq .Page "config"}}active{{end}}" hx-get="/admin/ui/config" hx-target="#content" hx-push-url="true">Config</a>
       <a href="/admin/ui/domains" class="{{if eq .Page "domains"}}active{{end}}" hx-get="/admin/ui/domains" hx-target="#content" hx-push-url="true">Domains</a>
+      <a href="/admin/ui/certificates" class="{{if eq .Page "certificates"}}active{{end}}" hx-get="/admin/ui/certificates" hx-target="#content" hx-push-url="true">Certificates</a>
       <a href="/admin/ui/health" class="{{if eq .Page "health"}}active{{end}}" hx-get="/admin/ui/health" hx-target="#content" hx-push-url="true">Health</a>
       <a href="/admin/ui/logs" class="{{if eq .Page "logs"}}active{{end}}" hx-get="/admin/ui/logs" hx-target="#content" hx-push-url="true">Logs</a>
+      {{end}}
+      <a href="/admin/ui/usage" class="{{if eq .Page "usage"}}active{{end}}" hx-get="/admin/ui/usage" hx-target="#content" hx-push-url="true">Usage</a>
       <form method="post" action="/auth/oidc/logout" style="margin-top:16px;">
         <button class="btn red" type="submit">Logout</button>
       </form>
@@ -425,9 +560,12 @@ var adminUITemplates = `
         {{if eq .Page "dashboard"}}{{template "dashboard_content" .Content}}{{end}}
         {{if eq .Page "config"}}{{template "config_content" .Content}}{{end}}
         {{if eq .Page "domains"}}{{template "domains_content" .Content}}{{end}}
+        {{if eq .Page "certificates"}}{{template "certificates_content" .Content}}{{end}}
         {{if eq .Page "health"}}{{template "health_content" .Content}}{{end}}
         {{if eq .Page "logs"}}{{template "logs_content" .Content}}{{end}}
+        {{if eq .Page "usage"}}{{template "usage_content" .Content}}{{end}}
         {{if eq .Page "tunnel_detail"}}{{template "tunnel_detail_content" .Content}}{{end}}
+        {{if eq .Page "capture_detail"}}{{template "capture_detail_content" .Content}}{{end}}
       </div>
     </main>
   </div>
@@ -458,6 +596,18 @@ var adminUITemplates = `
       es.addEventListener('logs_update', refresh);
       es.onerror = function(){};
     })();
+    (function(){
+      function fmt(ms) {
+        var s = Math.floor(ms / 1000), h = Math.floor(s / 3600), m = Math.floor((s % 3600) / 60);
+        return (h > 0 ? h + 'h ' : '') + (h > 0 || m > 0 ? m + 'm ' : '') + (s % 60) + 's';
+      }
+      setInterval(function(){
+        document.querySelectorAll('[data-expires-at]').forEach(function(el){
+          var ms = Date.parse(el.getAttribute('data-expires-at')) - Date.now();
+          el.textContent = ms > 0 ? 'in ' + fmt(ms) : 'expired';
+        });
+      }, 1000);
+    })();
     function toggleToken(fullId, shownId) {
       var shown = document.getElementById(shownId);
       var full = document.getElementById(fullId);
@@ -561,8 +711,11 @@ var adminUITemplates = `
 {{define "domains_content"}}
 <div class="card">
   <h2>Domains</h2>
+  <p class="muted">Domains need admin approval and ownership verification before tunnels can use them. Verification stays pending until the TXT record or well-known file below is published; verified domains are re-checked daily. Members can use their own domains and shared ones.</p>
   <form hx-post="/admin/ui/domains/add" hx-target="#domains-list" hx-swap="innerHTML">
     <input name="domain" placeholder="mycompany.com" />
+    <input name="description" placeholder="Description (optional)" />
+    <label><input type="checkbox" name="shared" checked /> Shared with all users</label>
     <button class="btn" type="submit">Add</button>
   </form>
   <div id="domains-list">{{template "domains_list" .}}</div>
@@ -571,20 +724,43 @@ var adminUITemplates = `
 
 {{define "domains_list"}}
 <table>
-  <thead><tr><th>Domain</th><th>Action</th></tr></thead>
+  <thead><tr><th>Domain</th><th>Owner</th><th>Approval</th><th>Status</th><th>Verification</th><th>Action</th></tr></thead>
   <tbody>
   {{range .}}
     <tr>
-      <td>{{.}}</td>
+      <td>{{.Domain}}{{if .Description}}<div class="muted">{{.Description}}</div>{{end}}</td>
+      <td>{{if .OwnerEmail}}{{.OwnerEmail}}{{else}}server{{end}}{{if .Shared}} <span class="muted">(shared)</span>{{end}}</td>
+      <td>{{if eq .Approval "approved"}}approved{{else}}<b>{{.Approval}}</b>{{end}}
+        {{if ne .Approval "approved"}}
+        <form hx-post="/admin/ui/domains/approval" hx-target="#domains-list" hx-swap="innerHTML">
+          <input type="hidden" name="domain" value="{{.Domain}}" />
+          <input type="hidden" name="approve" value="true" />
+          <button class="btn" type="submit">Approve</button>
+        </form>
+        {{end}}
+        {{if eq .Approval "pending"}}
+        <form hx-post="/admin/ui/domains/approval" hx-target="#domains-list" hx-swap="innerHTML">
+          <input type="hidden" name="domain" value="{{.Domain}}" />
+          <input type="hidden" name="approve" value="false" />
+          <button class="btn red" type="submit">Reject</button>
+        </form>
+        {{end}}
+      </td>
+      <td>{{if eq .Status "verified"}}verified{{else}}<b>pending</b>{{end}}{{if .LastError}}<div class="muted">{{.LastError}}</div>{{end}}</td>
+      <td class="muted">TXT <code>{{.TXTName}}</code> = <code>{{.TXTValue}}</code><br/>or serve <code>{{.Token}}</code> at <code>{{.HTTPURL}}</code></td>
       <td>
-        <form hx-post="/admin/ui/domains/remove" hx-target="#domains-list" hx-swap="innerHTML" onsubmit="return confirm('Remove {{.}}?')">
-          <input type="hidden" name="domain" value="{{.}}" />
+        <form hx-post="/admin/ui/domains/verify" hx-target="#domains-list" hx-swap="innerHTML">
+          <input type="hidden" name="domain" value="{{.Domain}}" />
+          <button class="btn" type="submit">Verify</button>
+        </form>
+        <form hx-post="/admin/ui/domains/remove" hx-target="#domains-list" hx-swap="innerHTML" onsubmit="return confirm('Remove {{.Domain}}?')">
+          <input type="hidden" name="domain" value="{{.Domain}}" />
           <button class="btn red" type="submit">Remove</button>
         </form>
       </td>
     </tr>
   {{else}}
-    <tr><td colspan="2" class="muted">No allowed domains.</td></tr>
+    <tr><td colspan="6" class="muted">No custom domains.</td></tr>
   {{end}}
   </tbody>
 </table>
@@ -604,28 +780,45 @@ var adminUITemplates = `
 {{define "logs_content"}}
 <div class="card">
   <h2>Request Logs</h2>
-  <p class="muted">Showing recent persisted logs{{if .Hostname}} for {{.Hostname}}{{end}}</p>
+  <form method="get" action="/admin/ui/logs" hx-get="/admin/ui/logs" hx-target="#content" hx-push-url="true">
+    <select name="tunnel">
+      {{range .Tunnels}}<option value="{{.Name}}"{{if eq .Name $.Tunnel}} selected{{end}}>{{.Name}} ({{.Hostname}})</option>{{end}}
+    </select>
+    <input type="datetime-local" name="from" value="{{.Filters.Get "from"}}" title="From (UTC)">
+    <input type="datetime-local" name="to" value="{{.Filters.Get "to"}}" title="To (UTC)">
+    <input name="status" placeholder="500,4xx" value="{{.Filters.Get "status"}}" size="8">
+    <input name="method" placeholder="Method" value="{{.Filters.Get "method"}}" size="6">
+    <input name="path" placeholder="/api/* or prefix" value="{{.Filters.Get "path"}}">
+    <input name="client_ip" placeholder="Client IP" value="{{.Filters.Get "client_ip"}}" size="12">
+    <input type="number" min="0" name="min_latency_ms" placeholder="Min ms" value="{{.Filters.Get "min_latency_ms"}}" style="width:90px;">
+    <button type="submit">Filter</button>
+  </form>
+  {{if .Error}}<p style="color:#b91c1c;">{{.Error}}</p>{{end}}
+  <p class="muted">Newest first{{if .Hostname}} for {{.Hostname}}{{end}}. Times are UTC; a path with * or ? is a glob, otherwise a prefix.
+  {{if .ExportCSV}}<a href="{{.ExportCSV}}" download>Export CSV</a> &middot; <a href="{{.ExportNDJSON}}" download>NDJSON</a>{{end}}</p>
   <table>
     <thead>
-      <tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Latency ms</th><th>Bytes In</th><th>Bytes Out</th><th>Error</th></tr>
+      <tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Latency ms</th><th>Bytes In</th><th>Bytes Out</th><th>Client IP</th><th>Error</th></tr>
     </thead>
     <tbody>
     {{range .Logs}}
       <tr>
-        <td>{{.Timestamp.Format "2006-01-02 15:04:05"}}</td>
+        <td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td>
         <td>{{.Method}}</td>
         <td>{{.Path}}</td>
         <td>{{.Status}}</td>
         <td>{{.LatencyMS}}</td>
         <td>{{.BytesIn}}</td>
         <td>{{.BytesOut}}</td>
+        <td>{{.ClientIP}}</td>
         <td>{{.ErrorText}}</td>
       </tr>
     {{else}}
-      <tr><td colspan="8" class="muted">No persisted request logs yet.</td></tr>
+      <tr><td colspan="9" class="muted">No request logs match.</td></tr>
     {{end}}
     </tbody>
   </table>
+  {{if .OlderURL}}<p><a href="{{.OlderURL}}">Older &rarr;</a></p>{{end}}
 </div>
 {{end}}
 `
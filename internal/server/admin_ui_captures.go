@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type captureDetailData struct {
+	Tunnel          TunnelRecord
+	Capture         RequestCaptureRecord
+	RequestHeaders  string
+	RequestBody     string
+	ResponseHeaders string
+	ResponseBody    string
+	Active          bool
+}
+
+func newCaptureDetailData(tun TunnelRecord, rec RequestCaptureRecord, active bool) captureDetailData {
+	return captureDetailData{
+		Tunnel:          tun,
+		Capture:         rec,
+		RequestHeaders:  formatHeaderLines(rec.RequestHeader),
+		RequestBody:     bodyPreview(rec.RequestBody),
+		ResponseHeaders: formatHeaderLines(rec.ResponseHeader),
+		ResponseBody:    bodyPreview(rec.ResponseBody),
+		Active:          active,
+	}
+}
+
+func (s *adminUIServer) tunnelCaptureToggleHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	enabled := r.FormValue("enabled") == "true"
+	if err := s.store.SetTunnelCapture(r.Context(), name, enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enabled != data.Tunnel.Capture {
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "capture_changed", captureEventMessage(enabled))
+	}
+	updated, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.render(w, "tunnel_capture_card", updated)
+}
+
+// tunnelCapturesHandler serves the capture inspector page and its replay form.
+func (s *adminUIServer) tunnelCapturesHandler(w http.ResponseWriter, r *http.Request, name string, rest []string) {
+	if len(rest) == 0 || len(rest) > 2 || (len(rest) == 2 && rest[1] != "replay") {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	rec, err := s.store.GetRequestCapture(r.Context(), data.Tunnel.ID, id)
+	if err != nil {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	if len(rest) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		title := fmt.Sprintf("Capture %d - %s", rec.ID, data.Tunnel.Name)
+		s.render(w, "layout", s.viewData(title, "capture_detail", user, newCaptureDetailData(data.Tunnel, rec, data.Active)))
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	replayed, err := replayCapture(r.Context(), s.store, s.registry, data.Tunnel, rec, captureReplayForm(r, newCaptureDetailData(data.Tunnel, rec, data.Active)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/ui/tunnels/%s/captures/%d", data.Tunnel.Name, replayed.ID), http.StatusSeeOther)
+}
+
+// captureReplayForm turns the replay form into edits. Fields left as they were rendered keep the
+// captured value, so an untouched truncated or binary body is not replaced by its preview.
+func captureReplayForm(r *http.Request, orig captureDetailData) CaptureReplayInput {
+	var in CaptureReplayInput
+	if v := strings.TrimSpace(r.FormValue("method")); v != "" && v != orig.Capture.Method {
+		in.Method = &v
+	}
+	if v := strings.TrimSpace(r.FormValue("path")); v != "" && v != orig.Capture.Path {
+		in.Path = &v
+	}
+	if v := strings.TrimSpace(r.FormValue("query")); v != orig.Capture.Query {
+		in.Query = &v
+	}
+	if v := normalizeTextarea(r.FormValue("headers")); v != normalizeTextarea(orig.RequestHeaders) {
+		in.Header = parseHeaderLines(v)
+	}
+	if v := normalizeTextarea(r.FormValue("body")); v != normalizeTextarea(orig.RequestBody) {
+		in.Body = &v
+	}
+	return in
+}
+
+// normalizeTextarea undoes the CRLF line endings browsers submit for textareas.
+func normalizeTextarea(v string) string {
+	return strings.TrimRight(strings.ReplaceAll(v, "\r\n", "\n"), "\n")
+}
+
+var adminUICaptureTemplates = `
+{{define "tunnel_capture_card"}}
+<div class="card">
+  <h3>Request Capture</h3>
+  <p><b>Capture:</b> {{if .Tunnel.Capture}}on - headers and bodies of recent requests are stored{{else}}off{{end}}</p>
+  <p class="muted">Bodies are capped at 64 KiB and the most recent 200 exchanges are kept. Authorization, cookies and the tunnel's shared secret header are redacted.</p>
+  <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/capture" hx-target="#tunnel-capture" hx-swap="innerHTML">
+    <input type="hidden" name="enabled" value="{{if .Tunnel.Capture}}false{{else}}true{{end}}" />
+    <button class="btn{{if .Tunnel.Capture}} red{{end}}" type="submit">{{if .Tunnel.Capture}}Stop Capturing{{else}}Start Capturing{{end}}</button>
+  </form>
+  <table>
+    <thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Latency</th><th>Replay Of</th><th></th></tr></thead>
+    <tbody>
+    {{range .Captures}}
+      <tr>
+        <td>{{.Timestamp.Format "2006-01-02 15:04:05"}}</td>
+        <td>{{.Method}}</td>
+        <td>{{.Path}}{{if .Query}}?{{.Query}}{{end}}</td>
+        <td>{{if .Status}}{{.Status}}{{else}}-{{end}}</td>
+        <td>{{.LatencyMS}} ms</td>
+        <td>{{if .ReplayOf}}#{{.ReplayOf}}{{else}}-{{end}}</td>
+        <td><a href="/admin/ui/tunnels/{{$.Tunnel.Name}}/captures/{{.ID}}">Inspect</a></td>
+      </tr>
+    {{else}}
+      <tr><td colspan="7" class="muted">No captured requests.</td></tr>
+    {{end}}
+    </tbody>
+  </table>
+</div>
+{{end}}
+
+{{define "capture_detail_content"}}
+<div class="card">
+  <h2>Capture #{{.Capture.ID}}</h2>
+  <p><a href="/admin/ui/tunnels/{{.Tunnel.Name}}">Back to {{.Tunnel.Name}}</a></p>
+  <p><b>Time:</b> {{.Capture.Timestamp.Format "2006-01-02 15:04:05"}}</p>
+  <p><b>Request:</b> <code>{{.Capture.Method}} {{.Capture.Path}}{{if .Capture.Query}}?{{.Capture.Query}}{{end}}</code></p>
+  <p><b>Status:</b> {{if .Capture.Status}}{{.Capture.Status}}{{else}}-{{end}} in {{.Capture.LatencyMS}} ms</p>
+  {{if .Capture.ReplayOf}}<p><b>Replay of:</b> <a href="/admin/ui/tunnels/{{.Tunnel.Name}}/captures/{{.Capture.ReplayOf}}">#{{.Capture.ReplayOf}}</a></p>{{end}}
+  {{if .Capture.ErrorText}}<p><b>Error:</b> {{.Capture.ErrorText}}</p>{{end}}
+</div>
+<div class="card">
+  <h3>Request</h3>
+  <pre>{{.RequestHeaders}}</pre>
+  {{if .RequestBody}}<pre>{{.RequestBody}}</pre>{{end}}
+  {{if .Capture.RequestTruncated}}<p class="muted">Body truncated to 64 KiB.</p>{{end}}
+</div>
+<div class="card">
+  <h3>Response</h3>
+  <pre>{{.ResponseHeaders}}</pre>
+  {{if .ResponseBody}}<pre>{{.ResponseBody}}</pre>{{end}}
+  {{if .Capture.ResponseTruncated}}<p class="muted">Body truncated to 64 KiB.</p>{{end}}
+</div>
+<div class="card">
+  <h3>Replay</h3>
+  {{if not .Active}}<p class="muted">The tunnel is not connected; replay needs a connected agent.</p>{{end}}
+  <p class="muted">Redacted headers are not replayed. Edit any field before sending; untouched fields keep the captured value.</p>
+  <form method="post" action="/admin/ui/tunnels/{{.Tunnel.Name}}/captures/{{.Capture.ID}}/replay">
+    <p>
+      <input name="method" value="{{.Capture.Method}}" size="8" />
+      <input name="path" value="{{.Capture.Path}}" />
+      <input name="query" value="{{.Capture.Query}}" placeholder="query string" />
+    </p>
+    <p>
+      <label><b>Headers</b></label><br/>
+      <textarea name="headers" rows="8" cols="80">{{.RequestHeaders}}</textarea>
+    </p>
+    <p>
+      <label><b>Body</b></label><br/>
+      <textarea name="body" rows="8" cols="80">{{.RequestBody}}</textarea>
+    </p>
+    <button class="btn" type="submit">Replay</button>
+  </form>
+</div>
+{{end}}
+`
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type usageData struct {
+	Usage   QuotaUsage
+	Rows    []usageRow
+	Tunnels []usageTunnelRow
+	IsAdmin bool
+	Quotas  []quotaRow
+	Error   string
+}
+
+// usageRow is one limit next to its current use, preformatted for the template.
+type usageRow struct {
+	Name  string
+	Used  string
+	Limit string
+	Over  bool
+}
+
+type usageTunnelRow struct {
+	OwnerEmail string
+	TunnelUsage
+	In, Out string
+}
+
+type quotaRow struct {
+	QuotaRecord
+	Transfer string
+}
+
+func newUsageRows(u QuotaUsage) []usageRow {
+	limit := func(n int64, format func(int64) string) string {
+		if n == 0 {
+			return "unlimited"
+		}
+		return format(n)
+	}
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+	l := u.Limits
+	return []usageRow{
+		{"Tunnels", count(int64(u.Tunnels)), limit(int64(l.MaxTunnels), count), l.MaxTunnels > 0 && u.Tunnels >= l.MaxTunnels},
+		{"Agents", count(int64(u.Agents)), limit(int64(l.MaxAgents), count), l.MaxAgents > 0 && u.Agents >= l.MaxAgents},
+		{"Concurrent connections", count(int64(u.Connections)), limit(int64(l.MaxConnections), count), l.MaxConnections > 0 && u.Connections >= l.MaxConnections},
+		{"Transfer in " + u.Month, formatBytes(u.TransferBytes), limit(l.MonthlyTransferBytes, formatBytes), l.MonthlyTransferBytes > 0 && u.TransferBytes >= l.MonthlyTransferBytes},
+	}
+}
+
+// formatBytes renders n in binary units, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (s *adminUIServer) loadUsage(ctx context.Context, user *UserRecord, errMsg string) (usageData, error) {
+	usage, err := s.store.Quotas().Usage(ctx, *user)
+	if err != nil {
+		return usageData{}, err
+	}
+	d := usageData{Usage: usage, Rows: newUsageRows(usage), IsAdmin: user.Role == "admin", Error: errMsg}
+	rows, err := s.store.ListUsage(ctx, usage.Month, user.ID, d.IsAdmin)
+	if err != nil {
+		return usageData{}, err
+	}
+	for _, u := range buildUsageReport(usage.Month, rows).Users {
+		for _, t := range u.Tunnels {
+			d.Tunnels = append(d.Tunnels, usageTunnelRow{OwnerEmail: u.OwnerEmail, TunnelUsage: t,
+				In: formatBytes(t.Totals.BytesIn), Out: formatBytes(t.Totals.BytesOut)})
+		}
+	}
+	if d.IsAdmin {
+		list, err := s.store.ListQuotas(ctx)
+		if err != nil {
+			return usageData{}, err
+		}
+		for _, rec := range list {
+			transfer := "unlimited"
+			if rec.MonthlyTransferBytes > 0 {
+				transfer = formatBytes(rec.MonthlyTransferBytes)
+			}
+			d.Quotas = append(d.Quotas, quotaRow{QuotaRecord: rec, Transfer: transfer})
+		}
+	}
+	return d, nil
+}
+
+func (s *adminUIServer) usagePageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	d, err := s.loadUsage(r.Context(), user, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isHX(r) {
+		s.render(w, "usage_content", d)
+		return
+	}
+	s.render(w, "layout", s.viewData("Usage", "usage", user, d))
+}
+
+func (s *adminUIServer) quotasSetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = r.ParseForm()
+	atoi := func(name string) int {
+		n, _ := strconv.Atoi(r.FormValue(name))
+		return n
+	}
+	gib, _ := strconv.ParseFloat(r.FormValue("monthly_transfer_gib"), 64)
+	rec, err := normalizeQuota(QuotaRecord{
+		Scope:   r.FormValue("scope"),
+		Subject: r.FormValue("subject"),
+		QuotaLimits: QuotaLimits{
+			MaxTunnels:           atoi("max_tunnels"),
+			MaxAgents:            atoi("max_agents"),
+			MaxConnections:       atoi("max_connections"),
+			MonthlyTransferBytes: int64(gib * (1 << 30)),
+		},
+	})
+	if err == nil {
+		_, err = s.store.UpsertQuota(r.Context(), rec)
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	s.renderQuotas(w, r, errMsg)
+}
+
+func (s *adminUIServer) quotasRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = r.ParseForm()
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id required", http.StatusBadRequest)
+		return
+	}
+	errMsg := ""
+	if err := s.store.DeleteQuota(r.Context(), id); err != nil {
+		errMsg = "quota not found"
+	}
+	s.renderQuotas(w, r, errMsg)
+}
+
+func (s *adminUIServer) renderQuotas(w http.ResponseWriter, r *http.Request, errMsg string) {
+	d, err := s.loadUsage(r.Context(), s.currentAdmin(r), errMsg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.render(w, "quotas_list", d)
+}
+
+var adminUIQuotaTemplates = `
+{{define "usage_content"}}
+<div class="card">
+  <h2>Your usage</h2>
+  <p class="muted">Limits from: {{.Usage.Source}}. Requests over the connection limit get 429; once the monthly transfer is used up, tunnels answer 403 until the next month (UTC).</p>
+  <table>
+    <thead><tr><th>Resource</th><th>Used</th><th>Limit</th></tr></thead>
+    <tbody>
+    {{range .Rows}}
+      <tr><td>{{.Name}}</td><td>{{if .Over}}<b style="color:#b91c1c;">{{.Used}}</b>{{else}}{{.Used}}{{end}}</td><td>{{.Limit}}</td></tr>
+    {{end}}
+    </tbody>
+  </table>
+</div>
+<div class="card">
+  <h2>Traffic in {{.Usage.Month}}</h2>
+  <p class="muted">Kept per tunnel and day for reports. <a href="/api/usage?format=csv" download>Download CSV</a> &middot; <a href="/api/usage?format=csv&amp;by=day" download>daily</a></p>
+  <table>
+    <thead><tr>{{if .IsAdmin}}<th>Owner</th>{{end}}<th>Tunnel</th><th>Hostname</th><th>Requests</th><th>In</th><th>Out</th><th>Errors</th></tr></thead>
+    <tbody>
+    {{range .Tunnels}}
+      <tr>{{if $.IsAdmin}}<td>{{.OwnerEmail}}</td>{{end}}<td>{{.TunnelName}}</td><td>{{.Hostname}}</td><td>{{.Totals.Requests}}</td><td>{{.In}}</td><td>{{.Out}}</td><td>{{.Totals.Errors}}</td></tr>
+    {{else}}
+      <tr><td colspan="7" class="muted">No traffic this month.</td></tr>
+    {{end}}
+    </tbody>
+  </table>
+</div>
+{{if .IsAdmin}}
+<div class="card">
+  <h2>Quotas</h2>
+  <p class="muted">A user quota overrides group quotas; a user in several groups gets the most generous value of each limit; everyone else gets the default. 0 means unlimited. Admins are never limited.</p>
+  <form hx-post="/admin/ui/quotas/set" hx-target="#quotas-list" hx-swap="innerHTML">
+    <select name="scope"><option value="user">user</option><option value="group">group</option><option value="default">default</option></select>
+    <input name="subject" placeholder="email or group" />
+    <input name="max_tunnels" type="number" min="0" placeholder="tunnels" />
+    <input name="max_agents" type="number" min="0" placeholder="agents" />
+    <input name="max_connections" type="number" min="0" placeholder="connections" />
+    <input name="monthly_transfer_gib" type="number" min="0" step="0.1" placeholder="GiB / month" />
+    <button class="btn" type="submit">Save</button>
+  </form>
+  <div id="quotas-list">{{template "quotas_list" .}}</div>
+</div>
+{{end}}
+{{end}}
+
+{{define "quotas_list"}}
+{{if .Error}}<p style="color:#b91c1c;">{{.Error}}</p>{{end}}
+<table>
+  <thead><tr><th>Scope</th><th>Subject</th><th>Tunnels</th><th>Agents</th><th>Connections</th><th>Transfer / month</th><th>Action</th></tr></thead>
+  <tbody>
+  {{range .Quotas}}
+    <tr>
+      <td>{{.Scope}}</td>
+      <td>{{.Subject}}</td>
+      <td>{{if .MaxTunnels}}{{.MaxTunnels}}{{else}}unlimited{{end}}</td>
+      <td>{{if .MaxAgents}}{{.MaxAgents}}{{else}}unlimited{{end}}</td>
+      <td>{{if .MaxConnections}}{{.MaxConnections}}{{else}}unlimited{{end}}</td>
+      <td>{{.Transfer}}</td>
+      <td>
+        <form hx-post="/admin/ui/quotas/remove" hx-target="#quotas-list" hx-swap="innerHTML">
+          <input type="hidden" name="id" value="{{.ID}}" />
+          <button class="btn red" type="submit">Remove</button>
+        </form>
+      </td>
+    </tr>
+  {{else}}
+    <tr><td colspan="7" class="muted">No quotas; everyone is unlimited.</td></tr>
+  {{end}}
+  </tbody>
+</table>
+{{end}}
+`
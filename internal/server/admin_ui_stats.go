@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statsChartRanges are the ranges offered on the tunnel traffic card.
+var statsChartRanges = []struct {
+	Label string
+	Span  time.Duration
+}{
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+type tunnelTrafficData struct {
+	Tunnel       TunnelRecord
+	Range        string
+	Ranges       []string
+	Series       StatsSeries
+	Requests     int64
+	Errors       int64
+	BytesIn      string
+	BytesOut     string
+	LatencyMaxMs int64
+	TrafficChart template.HTML
+	LatencyChart template.HTML
+	Error        string
+}
+
+func (s *adminUIServer) tunnelTrafficHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	detail, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data := tunnelTrafficData{Tunnel: detail.Tunnel, Range: "24h"}
+	span := defaultStatsChartRange
+	for _, c := range statsChartRanges {
+		data.Ranges = append(data.Ranges, c.Label)
+		if c.Label == r.URL.Query().Get("range") {
+			data.Range, span = c.Label, c.Span
+		}
+	}
+	// Whole seconds keep a 24h range inside the minute window after the RFC3339 round trip.
+	now := time.Now().Truncate(time.Second)
+	from, to, step, err := statsRange(now.Add(-span).Format(time.RFC3339), "", "", now)
+	if err == nil {
+		data.Series, err = loadStatsSeries(r.Context(), s.store, detail.Tunnel, from, to, step)
+	}
+	if err != nil {
+		data.Error = err.Error()
+		s.render(w, "tunnel_traffic_card", data)
+		return
+	}
+	var in, out int64
+	for _, p := range data.Series.Points {
+		data.Requests += p.Requests
+		data.Errors += p.Errors
+		in += p.BytesIn
+		out += p.BytesOut
+		data.LatencyMaxMs = max(data.LatencyMaxMs, p.LatencyMaxMs)
+	}
+	data.BytesIn, data.BytesOut = formatBytes(in), formatBytes(out)
+	data.TrafficChart = statsChart(data.Series, "req", []statsChartLine{
+		{"requests", "#1d4ed8", func(p StatsPoint) float64 { return float64(p.Requests) }},
+		{"errors", "#b91c1c", func(p StatsPoint) float64 { return float64(p.Errors) }},
+	})
+	data.LatencyChart = statsChart(data.Series, "ms", []statsChartLine{
+		{"p50", "#16a34a", func(p StatsPoint) float64 { return float64(p.LatencyP50Ms) }},
+		{"p90", "#d97706", func(p StatsPoint) float64 { return float64(p.LatencyP90Ms) }},
+		{"p99", "#b91c1c", func(p StatsPoint) float64 { return float64(p.LatencyP99Ms) }},
+	})
+	s.render(w, "tunnel_traffic_card", data)
+}
+
+type statsChartLine struct {
+	Label string
+	Color string
+	Value func(StatsPoint) float64
+}
+
+// statsChart draws lines over a series as an inline SVG, so the page needs no chart library.
+// Every label is generated here from numbers and fixed strings, never from user input.
+func statsChart(series StatsSeries, unit string, lines []statsChartLine) template.HTML {
+	const width, height, left, bottom, top = 640.0, 180.0, 48.0, 20.0, 8.0
+	points := series.Points
+	peak := 0.0
+	for _, p := range points {
+		for _, l := range lines {
+			peak = max(peak, l.Value(p))
+		}
+	}
+	if peak == 0 {
+		peak = 1
+	}
+	plotW, plotH := width-left-8, height-top-bottom
+	x := func(i int) float64 {
+		if len(points) < 2 {
+			return left
+		}
+		return left + plotW*float64(i)/float64(len(points)-1)
+	}
+	y := func(v float64) float64 { return top + plotH*(1-v/peak) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %.0f %.0f" width="100%%" role="img" style="max-width:%.0fpx">`, width, height, width)
+	fmt.Fprintf(&b, `<line x1="%.0f" y1="%.1f" x2="%.0f" y2="%.1f" stroke="#cbd5e1"/>`, left, y(0), width-8, y(0))
+	fmt.Fprintf(&b, `<line x1="%.0f" y1="%.1f" x2="%.0f" y2="%.1f" stroke="#e2e8f0" stroke-dasharray="4 4"/>`, left, y(peak), width-8, y(peak))
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.1f" font-size="11" fill="#64748b" text-anchor="end">%s %s</text>`, left-4, y(peak)+4, formatChartValue(peak), unit)
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.1f" font-size="11" fill="#64748b" text-anchor="end">0</text>`, left-4, y(0)+4)
+	for _, l := range lines {
+		coords := make([]string, 0, len(points))
+		for i, p := range points {
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", x(i), y(l.Value(p))))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, l.Color, strings.Join(coords, " "))
+	}
+	if len(points) > 0 {
+		layout := "15:04"
+		if series.To.Sub(series.From) > 24*time.Hour {
+			layout = "01-02 15:04"
+		}
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" font-size="11" fill="#64748b">%s</text>`, left, height-4, series.From.Local().Format(layout))
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" font-size="11" fill="#64748b" text-anchor="end">%s</text>`, width-8, height-4, series.To.Local().Format(layout))
+	}
+	legendX := width / 2
+	for _, l := range lines {
+		fmt.Fprintf(&b, `<rect x="%.0f" y="%.0f" width="10" height="3" fill="%s"/>`, legendX, height-9, l.Color)
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" font-size="11" fill="#334155">%s</text>`, legendX+14, height-4, l.Label)
+		legendX += 24 + 7*float64(len(l.Label))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func formatChartValue(v float64) string {
+	if v >= 100 || v == float64(int64(v)) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+var adminUIStatsTemplates = `
+{{define "tunnel_traffic_card"}}
+<div class="card">
+  <h3>Traffic</h3>
+  <p class="muted">
+    {{range .Ranges}}<a href="#" hx-get="/admin/ui/tunnels/{{$.Tunnel.Name}}/traffic?range={{.}}" hx-target="#tunnel-traffic" hx-swap="innerHTML">{{if eq . $.Range}}<b>{{.}}</b>{{else}}{{.}}{{end}}</a> {{end}}
+    | <a href="/api/tunnels/{{.Tunnel.Name}}/stats?from={{.Series.From.Format "2006-01-02T15:04:05Z07:00"}}&step={{.Series.Step}}">JSON</a>
+  </p>
+  {{if .Error}}<p class="muted">{{.Error}}</p>{{else}}
+  <p><b>Requests:</b> {{.Requests}} | <b>Errors:</b> {{.Errors}} | <b>In:</b> {{.BytesIn}} | <b>Out:</b> {{.BytesOut}} | <b>Slowest:</b> {{.LatencyMaxMs}} ms</p>
+  <div>{{.TrafficChart}}</div>
+  <div>{{.LatencyChart}}</div>
+  <p class="muted">One point per {{.Series.Step}}s. Latency lines are percentiles per step.</p>
+  {{end}}
+</div>
+{{end}}
+`
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -41,13 +42,13 @@ func TestAdminUI_LoginPage(t *testing.T) {
 		OIDCRedirectURL: "https://tunnel.myweb.site/auth/oidc/callback",
 	}
 	reg := NewRegistry()
-	domains := NewDomainStore(t.TempDir())
 	stats := NewStatsStore()
 	store, err := NewStore(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer store.Close()
+	domains := NewDomainStore(store, t.TempDir())
 	ui := AdminUIRouter(cfg, reg, domains, stats, store, nil, time.Now().Add(-2*time.Minute), false)
 	srv := httptest.NewServer(ui)
 	defer srv.Close()
@@ -73,13 +74,13 @@ func TestAdminUI_ConfigWithSession(t *testing.T) {
 		GrpcPort: 4440,
 	}
 	reg := NewRegistry()
-	domains := NewDomainStore(t.TempDir())
 	stats := NewStatsStore()
 	store, err := NewStore(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer store.Close()
+	domains := NewDomainStore(store, t.TempDir())
 	auth, err := NewAuthManager(context.Background(), cfg, store, false)
 	if err != nil {
 		t.Fatal(err)
@@ -116,13 +117,13 @@ func TestAdminUI_DisconnectAndDomains(t *testing.T) {
 	}
 	reg := NewRegistry()
 	reg.Register("app.tunnel.myweb.site", &mockConn{remoteAddr: "127.0.0.1:9999"})
-	domains := NewDomainStore(t.TempDir())
 	stats := NewStatsStore()
 	store, err := NewStore(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer store.Close()
+	domains := NewDomainStore(store, t.TempDir())
 	auth, err := NewAuthManager(context.Background(), cfg, store, false)
 	if err != nil {
 		t.Fatal(err)
@@ -173,13 +174,13 @@ func TestAdminUI_EventsSSE(t *testing.T) {
 		GrpcPort: 4440,
 	}
 	reg := NewRegistry()
-	domains := NewDomainStore(t.TempDir())
 	stats := NewStatsStore()
 	store, err := NewStore(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer store.Close()
+	domains := NewDomainStore(store, t.TempDir())
 	auth, err := NewAuthManager(context.Background(), cfg, store, false)
 	if err != nil {
 		t.Fatal(err)
@@ -220,13 +221,13 @@ func TestAdminUI_TunnelDetailAndAccessUpdate(t *testing.T) {
 	}
 	reg := NewRegistry()
 	reg.Register("app.tunnel.myweb.site", &mockConn{remoteAddr: "127.0.0.1:9999"})
-	domains := NewDomainStore(t.TempDir())
 	stats := NewStatsStore()
 	store, err := NewStore(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer store.Close()
+	domains := NewDomainStore(store, t.TempDir())
 	auth, err := NewAuthManager(context.Background(), cfg, store, false)
 	if err != nil {
 		t.Fatal(err)
@@ -291,3 +292,108 @@ func TestAdminUI_TunnelDetailAndAccessUpdate(t *testing.T) {
 		t.Fatalf("unexpected rule: %+v", rule)
 	}
 }
+
+func TestAdminUI_Certificates(t *testing.T) {
+	cfg := Config{Hostname: "tunnel.myweb.site", WebPort: 8080, GrpcPort: 4440}
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	auth, err := NewAuthManager(context.Background(), cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := AdminUIRouter(cfg, NewRegistry(), NewDomainStore(store, t.TempDir()), NewStatsStore(), store, auth, time.Now(), false)
+	srv := httptest.NewServer(ui)
+	defer srv.Close()
+	cookie := issueAdminCookie(t, auth)
+
+	post := func(form url.Values) string {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/admin/ui/certificates/add", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload status=%d", resp.StatusCode)
+		}
+		return string(body)
+	}
+	certPEM, keyPEM := testCertificatePEM(t, []string{"shop.example.org"}, time.Now().Add(5*24*time.Hour))
+	body := post(url.Values{"cert_pem": {certPEM}, "key_pem": {keyPEM}})
+	if !strings.Contains(body, "shop.example.org") || !strings.Contains(body, "expires in 4 days") {
+		t.Fatalf("upload list missing certificate or warning: %s", body)
+	}
+	if body = post(url.Values{"cert_pem": {certPEM}, "key_pem": {"bad"}}); !strings.Contains(body, "invalid certificate") {
+		t.Fatalf("expected inline error: %s", body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/ui/certificates", nil)
+	req.AddCookie(cookie)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "does not terminate TLS") {
+		t.Fatal("expected notice that uploads are not served without TLS")
+	}
+}
+
+func TestAdminUI_UsageAndQuotas(t *testing.T) {
+	cfg := Config{Hostname: "tunnel.myweb.site", WebPort: 8080, GrpcPort: 4440}
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	auth, err := NewAuthManager(context.Background(), cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := AdminUIRouter(cfg, NewRegistry(), NewDomainStore(store, t.TempDir()), NewStatsStore(), store, auth, time.Now(), false)
+	srv := httptest.NewServer(ui)
+	defer srv.Close()
+	admin := issueAdminCookie(t, auth)
+	raw, expiresAt, _, err := auth.IssueSessionForClaims(context.Background(), OIDCClaims{Subject: "bob", Email: "bob@example.com"}, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := &http.Cookie{Name: userSessionCookieName, Value: raw, Path: "/", Expires: expiresAt}
+
+	do := func(cookie *http.Cookie, method, path string, form url.Values) (int, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	code, body := do(admin, http.MethodPost, "/admin/ui/quotas/set", url.Values{"scope": {"default"}, "max_tunnels": {"3"}, "monthly_transfer_gib": {"1.5"}})
+	if code != http.StatusOK || !strings.Contains(body, "1.5 GiB") {
+		t.Fatalf("set quota status=%d body=%s", code, body)
+	}
+	if _, body = do(admin, http.MethodPost, "/admin/ui/quotas/set", url.Values{"scope": {"user"}}); !strings.Contains(body, "subject required") {
+		t.Fatalf("expected inline error: %s", body)
+	}
+	if code, _ = do(member, http.MethodPost, "/admin/ui/quotas/set", url.Values{"scope": {"default"}}); code != http.StatusForbidden {
+		t.Fatalf("member set quota status=%d", code)
+	}
+	code, body = do(member, http.MethodGet, "/admin/ui/usage", nil)
+	if code != http.StatusOK || !strings.Contains(body, "Limits from: default") || strings.Contains(body, "/admin/ui/config") {
+		t.Fatalf("member usage page status=%d body=%s", code, body)
+	}
+	if strings.Contains(body, "quotas-list") {
+		t.Fatal("members must not see the quota editor")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type uiTunnelRow struct {
@@ -32,6 +34,15 @@ type tunnelDetailData struct {
 	ConnectedRemote    string
 	SecretConfigured   bool
 	PasswordConfigured bool
+	ExpiresIn          string
+	Credentials        []TunnelCredentialRecord
+	NewCredentialName  string
+	NewCredentialValue string
+	NewShareLink       *ShareLink
+	WildcardSuffix     string
+	Captures           []RequestCaptureRecord
+	Chaos              *ChaosPolicy
+	Mirror             MirrorSummary
 }
 
 func (s *adminUIServer) dashboardData(ctx context.Context, user *UserRecord) (dashboardData, error) {
@@ -113,14 +124,30 @@ func (s *adminUIServer) tunnelRouteHandler(w http.ResponseWriter, r *http.Reques
 		} else {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
+	case "credentials":
+		s.tunnelCredentialsHandler(w, r, name, parts[2:])
+	case "share-links":
+		s.tunnelShareLinksHandler(w, r, name, parts[2:])
 	case "logs":
 		s.tunnelLogsHandler(w, r, name)
+	case "traffic":
+		s.tunnelTrafficHandler(w, r, name)
 	case "events":
 		s.tunnelEventsHandler(w, r, name)
 	case "assign":
 		s.tunnelAssignHandler(w, r, name)
 	case "state":
 		s.tunnelStateHandler(w, r, name)
+	case "expiry":
+		s.tunnelExpiryHandler(w, r, name)
+	case "maintenance":
+		s.tunnelMaintenanceHandler(w, r, name)
+	case "chaos":
+		s.tunnelChaosDisableHandler(w, r, name)
+	case "capture":
+		s.tunnelCaptureToggleHandler(w, r, name)
+	case "captures":
+		s.tunnelCapturesHandler(w, r, name, parts[2:])
 	case "delete":
 		s.tunnelDeleteHandler(w, r, name)
 	default:
@@ -280,6 +307,17 @@ func (s *adminUIServer) tunnelAccessUpdateHandler(w http.ResponseWriter, r *http
 		SharedSecretHeaderName: strings.TrimSpace(r.FormValue("shared_secret_header_name")),
 		SharedSecretValue:      r.FormValue("shared_secret_value"),
 		AllowedIPs:             allowedIPs,
+		OIDCEmailDomains:       formList(r.FormValue("oidc_email_domains")),
+		OIDCEmails:             formList(r.FormValue("oidc_emails")),
+		OIDCGroups:             formList(r.FormValue("oidc_groups")),
+		JWTIssuer:              r.FormValue("jwt_issuer"),
+		JWTAudience:            r.FormValue("jwt_audience"),
+		JWTRequiredClaims:      formClaims(r.FormValue("jwt_required_claims")),
+		ClearPrimarySecret:     r.FormValue("clear_primary_secret") != "",
+	}
+	if err := s.auth.checkJWTIssuer(input.JWTIssuer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	if err := s.store.UpsertTunnelAccessRule(r.Context(), data.Tunnel.ID, input); err != nil {
 		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "access_rule_invalid", err.Error())
@@ -295,6 +333,151 @@ func (s *adminUIServer) tunnelAccessUpdateHandler(w http.ResponseWriter, r *http
 	s.render(w, "tunnel_access_card", updated)
 }
 
+// tunnelCredentialsHandler lists and adds named credentials, and revokes one via
+// POST /admin/ui/tunnels/{name}/credentials/{credential}/revoke.
+func (s *adminUIServer) tunnelCredentialsHandler(w http.ResponseWriter, r *http.Request, name string, rest []string) {
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		var expiresAt time.Time
+		if ttl := strings.TrimSpace(r.FormValue("ttl")); ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			expiresAt = time.Now().Add(d)
+		}
+		secret := strings.TrimSpace(r.FormValue("secret"))
+		if secret == "" {
+			if secret, err = randomString(24); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		cred, err := s.store.CreateTunnelCredential(r.Context(), data.Tunnel.ID, r.FormValue("name"), r.FormValue("kind"), r.FormValue("username"), hashSecret(secret), expiresAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "credential_added", "credential "+cred.Name+" added")
+		data.NewCredentialName = cred.Name
+		data.NewCredentialValue = secret
+	case len(rest) == 2 && rest[1] == "revoke" && r.Method == http.MethodPost:
+		credName := normalizeName(rest[0])
+		if err := s.store.DeleteTunnelCredential(r.Context(), data.Tunnel.ID, credName); err != nil {
+			http.Error(w, "credential not found", http.StatusNotFound)
+			return
+		}
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "credential_revoked", "credential "+credName+" revoked")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	creds, err := s.store.ListTunnelCredentials(r.Context(), data.Tunnel.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Credentials = creds
+	s.render(w, "tunnel_credentials_card", data)
+}
+
+// tunnelShareLinksHandler issues a signed link on POST /admin/ui/tunnels/{name}/share-links and
+// revokes all links on POST /admin/ui/tunnels/{name}/share-links/rotate.
+func (s *adminUIServer) tunnelShareLinksHandler(w http.ResponseWriter, r *http.Request, name string, rest []string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(rest) == 0:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if v := strings.TrimSpace(r.FormValue("ttl")); v != "" {
+			if ttl, err = parseTunnelTTL(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		link, err := issueShareLink(r.Context(), s.store, s.auth, data.Tunnel, ttl, r.FormValue("path"), r.FormValue("host"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "share_link_issued", "share link for "+link.Path+" issued, expires "+link.ExpiresAt.UTC().Format(time.RFC3339))
+		data.NewShareLink = &link
+	case len(rest) == 1 && rest[0] == "rotate":
+		if _, err := s.store.RotateShareLinkKey(r.Context(), data.Tunnel.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "share_links_revoked", "share link signing key rotated")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "tunnel_share_links_card", data)
+}
+
+// wildcardSuffix returns ".acme.example.com" for "*.acme.example.com" and "" for exact hostnames.
+func wildcardSuffix(hostname string) string {
+	if suffix, ok := strings.CutPrefix(hostname, "*"); ok {
+		return suffix
+	}
+	return ""
+}
+
+func formatExpiresIn(expiresAt, now time.Time) string {
+	if expiresAt.IsZero() {
+		return ""
+	}
+	if !now.Before(expiresAt) {
+		return "expired"
+	}
+	return "in " + expiresAt.Sub(now).Truncate(time.Second).String()
+}
+
+// formList splits a comma or newline separated form value.
+func formList(v string) []string {
+	out := []string{}
+	for _, item := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// formClaims parses name=value pairs separated by commas or newlines.
+func formClaims(v string) map[string]string {
+	out := map[string]string{}
+	for _, item := range formList(v) {
+		name, value, _ := strings.Cut(item, "=")
+		out[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return out
+}
+
 func (s *adminUIServer) tunnelStateHandler(w http.ResponseWriter, r *http.Request, name string) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -319,6 +502,10 @@ func (s *adminUIServer) tunnelStateHandler(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "tunnel has no assigned agent", http.StatusConflict)
 		return
 	}
+	if desired == "running" && tunnelExpired(data.Tunnel, time.Now()) {
+		http.Error(w, "tunnel expired; extend it first", http.StatusConflict)
+		return
+	}
 	if err := s.store.SetTunnelDesiredState(r.Context(), name, desired); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -335,6 +522,105 @@ func (s *adminUIServer) tunnelStateHandler(w http.ResponseWriter, r *http.Reques
 	s.render(w, "tunnel_status_card", updated)
 }
 
+func (s *adminUIServer) tunnelExpiryHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	expiresAt, msg, err := nextTunnelExpiry(data.Tunnel, r.FormValue("ttl"), time.Time{}, r.FormValue("clear") != "", time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.store.SetTunnelExpiry(r.Context(), name, expiresAt, r.FormValue("expiry_action")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "expiry_changed", msg)
+	updated, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.render(w, "tunnel_status_card", updated)
+}
+
+func (s *adminUIServer) tunnelMaintenanceHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	retryAfter := 0
+	if v := strings.TrimSpace(r.FormValue("retry_after")); v != "" {
+		if retryAfter, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid retry_after", http.StatusBadRequest)
+			return
+		}
+	}
+	input := MaintenanceInput{
+		Enabled:           r.FormValue("enabled") == "true",
+		Message:           r.FormValue("message"),
+		RetryAfter:        retryAfter,
+		BypassIPs:         formList(r.FormValue("bypass_ips")),
+		BypassSecret:      r.FormValue("bypass_secret"),
+		ClearBypassSecret: r.FormValue("clear_bypass_secret") != "",
+	}
+	if err := s.store.SetTunnelMaintenance(r.Context(), name, input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.Enabled != data.Tunnel.Maintenance {
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "maintenance_changed", maintenanceEventMessage(input.Enabled))
+	}
+	updated, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.render(w, "tunnel_maintenance_card", updated)
+}
+
+func (s *adminUIServer) tunnelChaosDisableHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := s.currentAdmin(r)
+	data, err := s.loadTunnelDetail(r.Context(), user, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := s.store.ClearTunnelChaos(r.Context(), data.Tunnel.ID); err == nil {
+		_ = s.store.AddTunnelEvent(r.Context(), data.Tunnel.Hostname, "chaos_disabled", "chaos policy removed")
+	} else if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Chaos = nil
+	s.render(w, "tunnel_chaos_card", data)
+}
+
 func (s *adminUIServer) tunnelDeleteHandler(w http.ResponseWriter, r *http.Request, name string) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -386,6 +672,22 @@ func (s *adminUIServer) loadTunnelDetail(ctx context.Context, user *UserRecord,
 	if err != nil {
 		return tunnelDetailData{}, err
 	}
+	creds, err := s.store.ListTunnelCredentials(ctx, tun.ID)
+	if err != nil {
+		return tunnelDetailData{}, err
+	}
+	captures, err := s.store.ListRequestCaptures(ctx, tun.ID, 25)
+	if err != nil {
+		return tunnelDetailData{}, err
+	}
+	var chaos *ChaosPolicy
+	if policy, err := s.store.GetTunnelChaos(ctx, tun.ID); err == nil && policy.ExpiresAt.After(time.Now()) {
+		chaos = &policy
+	}
+	mirror, err := s.store.GetMirrorSummary(ctx, tun.ID, 10)
+	if err != nil {
+		return tunnelDetailData{}, err
+	}
 	remote := ""
 	active := false
 	if conn := s.registry.Get(tun.Hostname); conn != nil {
@@ -402,6 +704,12 @@ func (s *adminUIServer) loadTunnelDetail(ctx context.Context, user *UserRecord,
 		ConnectedRemote:    remote,
 		SecretConfigured:   rule.SharedSecretHash != "",
 		PasswordConfigured: rule.BasicAuthPasswordHash != "",
+		ExpiresIn:          formatExpiresIn(tun.ExpiresAt, time.Now()),
+		Credentials:        creds,
+		Captures:           captures,
+		Chaos:              chaos,
+		Mirror:             mirror,
+		WildcardSuffix:     wildcardSuffix(tun.Hostname),
 	}, nil
 }
 
@@ -415,8 +723,15 @@ var adminUITunnelTemplates = `
   <p><b>Created:</b> {{.Tunnel.CreatedAt.Format "2006-01-02 15:04:05"}}</p>
 </div>
 <div id="tunnel-status">{{template "tunnel_status_card" .}}</div>
+<div id="tunnel-traffic" hx-get="/admin/ui/tunnels/{{.Tunnel.Name}}/traffic" hx-trigger="load" hx-swap="innerHTML"></div>
+<div id="tunnel-maintenance">{{template "tunnel_maintenance_card" .}}</div>
+<div id="tunnel-chaos">{{template "tunnel_chaos_card" .}}</div>
 <div id="tunnel-assignment">{{template "tunnel_assignment_card" .}}</div>
 <div id="tunnel-access">{{template "tunnel_access_card" .}}</div>
+<div id="tunnel-credentials">{{template "tunnel_credentials_card" .}}</div>
+<div id="tunnel-share-links">{{template "tunnel_share_links_card" .}}</div>
+<div id="tunnel-capture">{{template "tunnel_capture_card" .}}</div>
+<div id="tunnel-mirror">{{template "tunnel_mirror_card" .}}</div>
 <div id="tunnel-events">{{template "tunnel_events_list" .}}</div>
 <div id="tunnel-request-logs">{{template "tunnel_request_logs_table" .}}</div>
 <div class="card">
@@ -436,10 +751,20 @@ var adminUITunnelTemplates = `
   <p><b>Last Seen:</b> {{if .Tunnel.LastSeenAt.IsZero}}-{{else}}{{.Tunnel.LastSeenAt.Format "2006-01-02 15:04:05"}}{{end}}</p>
   <p><b>Last Error:</b> {{if .Tunnel.LastError}}{{.Tunnel.LastError}}{{else}}-{{end}}</p>
   <p><b>Connected Remote:</b> {{if .ConnectedRemote}}{{.ConnectedRemote}}{{else}}-{{end}}</p>
+  <p><b>Expires:</b> {{if .Tunnel.ExpiresAt.IsZero}}never{{else}}{{.Tunnel.ExpiresAt.Local.Format "2006-01-02 15:04:05"}} (<span data-expires-at="{{.Tunnel.ExpiresAt.UTC.Format "2006-01-02T15:04:05Z07:00"}}">{{.ExpiresIn}}</span>, then {{.Tunnel.ExpiryAction}}){{end}}</p>
   <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/state" hx-target="#tunnel-status" hx-swap="innerHTML">
     <input type="hidden" name="desired_state" value="{{if eq .Tunnel.DesiredState "running"}}stopped{{else}}running{{end}}" />
     <button class="btn" type="submit">Set {{if eq .Tunnel.DesiredState "running"}}Stopped{{else}}Running{{end}}</button>
   </form>
+  <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/expiry" hx-target="#tunnel-status" hx-swap="innerHTML">
+    <input name="ttl" placeholder="extend by, e.g. 2h" />
+    <select name="expiry_action">
+      <option value="stop" {{if ne .Tunnel.ExpiryAction "delete"}}selected{{end}}>stop on expiry</option>
+      <option value="delete" {{if eq .Tunnel.ExpiryAction "delete"}}selected{{end}}>delete on expiry</option>
+    </select>
+    <button class="btn" type="submit">{{if .Tunnel.ExpiresAt.IsZero}}Set Expiry{{else}}Extend{{end}}</button>
+    {{if not .Tunnel.ExpiresAt.IsZero}}<button class="btn" type="submit" name="clear" value="1">Clear Expiry</button>{{end}}
+  </form>
 </div>
 {{end}}
 
@@ -469,6 +794,9 @@ var adminUITunnelTemplates = `
         <option value="public" {{if eq .AccessRule.AuthMode "public"}}selected{{end}}>public</option>
         <option value="basic_auth" {{if eq .AccessRule.AuthMode "basic_auth"}}selected{{end}}>basic_auth</option>
         <option value="shared_secret_header" {{if eq .AccessRule.AuthMode "shared_secret_header"}}selected{{end}}>shared_secret_header</option>
+        <option value="oidc" {{if eq .AccessRule.AuthMode "oidc"}}selected{{end}}>oidc</option>
+        <option value="jwt" {{if eq .AccessRule.AuthMode "jwt"}}selected{{end}}>jwt</option>
+        <option value="signed_link" {{if eq .AccessRule.AuthMode "signed_link"}}selected{{end}}>signed_link</option>
       </select>
     </p>
     <p>
@@ -478,6 +806,7 @@ var adminUITunnelTemplates = `
     <p>
       <label><b>Basic Auth Password</b></label><br/>
       <input type="password" name="basic_auth_password" placeholder="{{if .PasswordConfigured}}configured - leave blank to keep{{else}}set password{{end}}" />
+      {{if .PasswordConfigured}}<label><input type="checkbox" name="clear_primary_secret" value="1" /> clear</label>{{end}}
     </p>
     <p>
       <label><b>Shared Secret Header Name</b></label><br/>
@@ -486,6 +815,33 @@ var adminUITunnelTemplates = `
     <p>
       <label><b>Shared Secret Value</b></label><br/>
       <input type="password" name="shared_secret_value" placeholder="{{if .SecretConfigured}}configured - leave blank to keep{{else}}set secret{{end}}" />
+      {{if .SecretConfigured}}<label><input type="checkbox" name="clear_primary_secret" value="1" /> clear</label>{{end}}
+    </p>
+    <p>
+      <label><b>OIDC Email Domains</b></label><br/>
+      <input name="oidc_email_domains" value="{{range $i, $d := .AccessRule.OIDCEmailDomains}}{{if $i}}, {{end}}{{$d}}{{end}}" placeholder="example.com" />
+    </p>
+    <p>
+      <label><b>OIDC Emails</b></label><br/>
+      <input name="oidc_emails" value="{{range $i, $e := .AccessRule.OIDCEmails}}{{if $i}}, {{end}}{{$e}}{{end}}" placeholder="alice@example.com" />
+    </p>
+    <p>
+      <label><b>OIDC Groups</b></label><br/>
+      <input name="oidc_groups" value="{{range $i, $g := .AccessRule.OIDCGroups}}{{if $i}}, {{end}}{{$g}}{{end}}" placeholder="engineering" />
+      <br/><span class="muted">OIDC mode sends visitors through server login; leave all three empty to admit any signed-in user.</span>
+    </p>
+    <p>
+      <label><b>JWT Issuer</b></label><br/>
+      <input name="jwt_issuer" value="{{.AccessRule.JWTIssuer}}" placeholder="server OIDC issuer" />
+    </p>
+    <p>
+      <label><b>JWT Audience</b></label><br/>
+      <input name="jwt_audience" value="{{.AccessRule.JWTAudience}}" placeholder="api://my-service" />
+    </p>
+    <p>
+      <label><b>JWT Required Claims</b></label><br/>
+      <textarea name="jwt_required_claims" rows="3" placeholder="scope=tunnel:read">{{range $k, $v := .AccessRule.JWTRequiredClaims}}{{$k}}={{$v}}
+{{end}}</textarea>
     </p>
     <p>
       <label><b>IP Allowlist</b></label><br/>
@@ -497,6 +853,153 @@ var adminUITunnelTemplates = `
 </div>
 {{end}}
 
+{{define "tunnel_maintenance_card"}}
+<div class="card">
+  <h3>Maintenance</h3>
+  <p><b>Mode:</b> {{if .Tunnel.Maintenance}}on - visitors get a 503 maintenance page{{else}}off{{end}}</p>
+  <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/maintenance" hx-target="#tunnel-maintenance" hx-swap="innerHTML">
+    <input type="hidden" name="enabled" value="{{if .Tunnel.Maintenance}}false{{else}}true{{end}}" />
+    <p>
+      <label><b>Message</b></label><br/>
+      <input name="message" value="{{.Tunnel.MaintenanceMessage}}" placeholder="tunnel is under maintenance" />
+    </p>
+    <p>
+      <label><b>Retry-After (seconds)</b></label><br/>
+      <input name="retry_after" value="{{if .Tunnel.MaintenanceRetryAfter}}{{.Tunnel.MaintenanceRetryAfter}}{{end}}" placeholder="120" />
+    </p>
+    <p>
+      <label><b>Bypass IPs</b></label><br/>
+      <input name="bypass_ips" value="{{range $i, $c := .Tunnel.MaintenanceBypassIPs}}{{if $i}}, {{end}}{{$c}}{{end}}" placeholder="203.0.113.0/24" />
+    </p>
+    <p>
+      <label><b>Bypass Secret</b></label><br/>
+      <input type="password" name="bypass_secret" placeholder="{{if .Tunnel.MaintenanceBypassSecretHash}}configured - leave blank to keep{{else}}sent as X-Fwdx-Maintenance-Bypass{{end}}" />
+      {{if .Tunnel.MaintenanceBypassSecretHash}}<label><input type="checkbox" name="clear_bypass_secret" value="1" /> clear</label>{{end}}
+    </p>
+    <button class="btn{{if not .Tunnel.Maintenance}} red{{end}}" type="submit">{{if .Tunnel.Maintenance}}End Maintenance{{else}}Start Maintenance{{end}}</button>
+  </form>
+</div>
+{{end}}
+
+{{define "tunnel_chaos_card"}}
+<div class="card">
+  <h3>Chaos</h3>
+  {{with .Chaos}}
+  <p><b>Policy:</b> active until {{.ExpiresAt.Local.Format "2006-01-02 15:04:05"}}</p>
+  <p>
+    {{if or .LatencyMS .JitterMS}}<b>Latency:</b> {{.LatencyMS}} ms ± {{.JitterMS}} ms<br/>{{end}}
+    {{if .ErrorPercent}}<b>Errors:</b> {{.ErrorPercent}}% answered with {{.ErrorStatus}}<br/>{{end}}
+    {{if .DropPercent}}<b>Drops:</b> {{.DropPercent}}% of connections closed<br/>{{end}}
+    {{if .BandwidthKBps}}<b>Bandwidth:</b> {{.BandwidthKBps}} KB/s<br/>{{end}}
+    <b>Paths:</b> {{if .Paths}}{{range $i, $p := .Paths}}{{if $i}}, {{end}}<code>{{$p}}</code>{{end}}{{else}}all{{end}}
+  </p>
+  <form hx-post="/admin/ui/tunnels/{{$.Tunnel.Name}}/chaos" hx-target="#tunnel-chaos" hx-swap="innerHTML">
+    <button class="btn red" type="submit">Disable Chaos</button>
+  </form>
+  {{else}}
+  <p class="muted">No fault injection. Enable it with <code>fwdx tunnel chaos on {{.Tunnel.Name}}</code>.</p>
+  {{end}}
+</div>
+{{end}}
+
+{{define "tunnel_mirror_card"}}
+<div class="card">
+  <h3>Traffic Mirror</h3>
+  {{with .Mirror}}{{if .Total}}
+  <p><b>Reports:</b> {{.Total}} (last {{.LastReportAt.Local.Format "2006-01-02 15:04:05"}})</p>
+  <p><b>Status mismatches:</b> {{.StatusMismatches}} &nbsp; <b>Mirror errors:</b> {{.MirrorErrors}}</p>
+  <p><b>Avg latency:</b> primary {{.AvgPrimaryLatencyMS}} ms, mirror {{.AvgMirrorLatencyMS}} ms</p>
+  <table>
+    <thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Primary</th><th>Mirror</th></tr></thead>
+    <tbody>
+    {{range .RecentDiffs}}
+      <tr>
+        <td>{{.Timestamp.Local.Format "15:04:05"}}</td>
+        <td>{{.Method}}</td>
+        <td>{{.Path}}</td>
+        <td>{{.PrimaryStatus}} / {{.PrimaryLatencyMS}} ms</td>
+        <td>{{if .MirrorError}}<span class="muted">{{.MirrorError}}</span>{{else}}{{.MirrorStatus}} / {{.MirrorLatencyMS}} ms{{end}}</td>
+      </tr>
+    {{else}}
+      <tr><td colspan="5" class="muted">Mirror matched every reported status.</td></tr>
+    {{end}}
+    </tbody>
+  </table>
+  {{else}}
+  <p class="muted">No mirror reports. Configure one with <code>fwdx tunnel mirror set {{$.Tunnel.Name}} --target localhost:4000</code> and restart the tunnel.</p>
+  {{end}}{{end}}
+</div>
+{{end}}
+
+{{define "tunnel_credentials_card"}}
+<div class="card">
+  <h3>Credentials</h3>
+  <p class="muted">Named credentials are accepted alongside the primary basic auth password or shared secret, if one is set, and can be revoked individually. Clear the primary to accept only named credentials.</p>
+  {{if .NewCredentialValue}}
+  <p><b>Secret for {{.NewCredentialName}}:</b> <code>{{.NewCredentialValue}}</code><br/><span class="muted">Copy it now; it is not shown again.</span></p>
+  {{end}}
+  <table>
+    <thead><tr><th>Name</th><th>Kind</th><th>Username</th><th>Expires</th><th>Last Used</th><th></th></tr></thead>
+    <tbody>
+    {{range .Credentials}}
+      <tr>
+        <td>{{.Name}}</td>
+        <td>{{.Kind}}</td>
+        <td>{{if .Username}}{{.Username}}{{else}}-{{end}}</td>
+        <td>{{if .ExpiresAt.IsZero}}never{{else}}{{.ExpiresAt.Local.Format "2006-01-02 15:04:05"}}{{end}}</td>
+        <td>{{if .LastUsedAt.IsZero}}-{{else}}{{.LastUsedAt.Local.Format "2006-01-02 15:04:05"}}{{end}}</td>
+        <td>
+          <form hx-post="/admin/ui/tunnels/{{$.Tunnel.Name}}/credentials/{{.Name}}/revoke" hx-target="#tunnel-credentials" hx-swap="innerHTML" hx-confirm="Revoke credential {{.Name}}?">
+            <button class="btn red" type="submit">Revoke</button>
+          </form>
+        </td>
+      </tr>
+    {{else}}
+      <tr><td colspan="6" class="muted">No named credentials.</td></tr>
+    {{end}}
+    </tbody>
+  </table>
+  <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/credentials" hx-target="#tunnel-credentials" hx-swap="innerHTML">
+    <p>
+      <input name="name" placeholder="partner-a" required />
+      <select name="kind">
+        <option value="basic_auth">basic_auth</option>
+        <option value="shared_secret">shared_secret</option>
+      </select>
+      <input name="username" placeholder="username (basic auth)" />
+      <input type="password" name="secret" placeholder="secret - blank to generate" />
+      <input name="ttl" placeholder="ttl, e.g. 720h" />
+      <button class="btn" type="submit">Add Credential</button>
+    </p>
+  </form>
+</div>
+{{end}}
+
+{{define "tunnel_share_links_card"}}
+<div class="card">
+  <h3>Share Links</h3>
+  {{if ne .AccessRule.AuthMode "signed_link"}}
+  <p class="muted">Set the access mode to signed_link to share this tunnel with time-limited links.</p>
+  {{else}}
+  <p class="muted">Signed links grant access until they expire. Rotating the signing key revokes every link issued so far.</p>
+  {{if .NewShareLink}}
+  <p><b>Link:</b> <code>{{.NewShareLink.URL}}</code><br/><span class="muted">Valid for {{.NewShareLink.Path}} until {{.NewShareLink.ExpiresAt.Local.Format "2006-01-02 15:04:05"}}.</span></p>
+  {{end}}
+  <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/share-links" hx-target="#tunnel-share-links" hx-swap="innerHTML">
+    <p>
+      <input name="ttl" placeholder="ttl, default 24h" />
+      <input name="path" placeholder="path scope, e.g. /docs" />
+      {{if .WildcardSuffix}}<input name="host" placeholder="host, e.g. shop{{.WildcardSuffix}}" required />{{end}}
+      <button class="btn" type="submit">Generate Link</button>
+    </p>
+  </form>
+  <form hx-post="/admin/ui/tunnels/{{.Tunnel.Name}}/share-links/rotate" hx-target="#tunnel-share-links" hx-swap="innerHTML" hx-confirm="Revoke all share links for {{.Tunnel.Name}}?">
+    <button class="btn red" type="submit">Revoke All Links</button>
+  </form>
+  {{end}}
+</div>
+{{end}}
+
 {{define "tunnel_events_list"}}
 <div class="card">
   <h3>Recent Events</h3>
@@ -521,7 +1024,7 @@ var adminUITunnelTemplates = `
 <div class="card">
   <h3>Recent Request Logs</h3>
   <table>
-    <thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Latency</th><th>Client IP</th><th>Error</th></tr></thead>
+    <thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Latency</th><th>Client IP</th><th>Credential</th><th>Fault</th><th>Error</th></tr></thead>
     <tbody>
     {{range .Logs}}
       <tr>
@@ -531,10 +1034,12 @@ var adminUITunnelTemplates = `
         <td>{{.Status}}</td>
         <td>{{.LatencyMS}} ms</td>
         <td>{{.ClientIP}}</td>
+        <td>{{if .Credential}}{{.Credential}}{{else}}-{{end}}</td>
+        <td>{{if .Fault}}{{.Fault}}{{else}}-{{end}}</td>
         <td>{{if .ErrorText}}{{.ErrorText}}{{else}}-{{end}}</td>
       </tr>
     {{else}}
-      <tr><td colspan="7" class="muted">No request logs yet.</td></tr>
+      <tr><td colspan="9" class="muted">No request logs yet.</td></tr>
     {{end}}
     </tbody>
   </table>
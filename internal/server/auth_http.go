@@ -3,7 +3,7 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -176,5 +176,5 @@ func (a *AuthManager) requireOIDCConfigured() error {
 }
 
 func (a *AuthManager) logAuthEvent(msg string, args ...any) {
-	log.Printf("[fwdx] "+msg, args...)
+	slog.Info(msg, args...)
 }
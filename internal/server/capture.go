@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// captureMaxBodyBytes caps each stored request and response body.
+	captureMaxBodyBytes = 64 << 10
+	// captureRingSize is how many captures are kept per tunnel.
+	captureRingSize = 200
+	captureRedacted = "[redacted]"
+	replayTimeout   = 65 * time.Second
+)
+
+// captureSensitiveHeaders are always redacted before a capture is stored.
+var captureSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	maintenanceBypassHeader,
+}
+
+// CaptureReplayInput edits a captured request before it is replayed. Nil fields keep the
+// captured value.
+type CaptureReplayInput struct {
+	Method *string     `json:"method"`
+	Path   *string     `json:"path"`
+	Query  *string     `json:"query"`
+	Header http.Header `json:"headers"`
+	Body   *string     `json:"body"`
+}
+
+// captureRedactions lists the headers a tunnel's access rule makes secret, on top of
+// captureSensitiveHeaders.
+func captureRedactions(rule TunnelAccessRuleRecord) []string {
+	if rule.SharedSecretHeaderName == "" {
+		return nil
+	}
+	return []string{rule.SharedSecretHeaderName}
+}
+
+func redactHeaders(h http.Header, extra ...string) http.Header {
+	out := h.Clone()
+	if out == nil {
+		return http.Header{}
+	}
+	for _, name := range append(captureSensitiveHeaders, extra...) {
+		if name == "" {
+			continue
+		}
+		if vv := out.Values(name); len(vv) > 0 {
+			redacted := make([]string, len(vv))
+			for i := range redacted {
+				redacted[i] = captureRedacted
+			}
+			out[http.CanonicalHeaderKey(name)] = redacted
+		}
+	}
+	return out
+}
+
+func capBody(b []byte) ([]byte, bool) {
+	if len(b) > captureMaxBodyBytes {
+		return append([]byte(nil), b[:captureMaxBodyBytes]...), true
+	}
+	return b, false
+}
+
+// captureExchange stores pr and its response, if any, in the tunnel's capture ring.
+func captureExchange(ctx context.Context, store *Store, tunnelID int64, pr *ProxyRequest, resp *ProxyResponse, latency time.Duration, replayOf int64, errText string, redact ...string) (int64, error) {
+	rec := RequestCaptureRecord{
+		TunnelID:      tunnelID,
+		Timestamp:     time.Now(),
+		Method:        pr.Method,
+		Path:          pr.Path,
+		Query:         pr.Query,
+		RequestHeader: redactHeaders(pr.Header, redact...),
+		LatencyMS:     latency.Milliseconds(),
+		ErrorText:     errText,
+		ReplayOf:      replayOf,
+	}
+	rec.RequestBody, rec.RequestTruncated = capBody(pr.Body)
+	if resp != nil {
+		rec.Status = resp.Status
+		rec.ResponseHeader = redactHeaders(resp.Header, redact...)
+		rec.ResponseBody, rec.ResponseTruncated = capBody(resp.Body)
+	}
+	id, err := store.InsertRequestCapture(ctx, rec, captureRingSize)
+	if err != nil {
+		slog.Error("capture store failed", "tunnel_id", tunnelID, "error", err)
+	}
+	return id, err
+}
+
+// replayRequest rebuilds a captured request with edits applied. Redacted headers are dropped
+// because their original values were never stored.
+func replayRequest(rec RequestCaptureRecord, in CaptureReplayInput) (*ProxyRequest, error) {
+	pr := &ProxyRequest{Method: rec.Method, Path: rec.Path, Query: rec.Query, Header: http.Header{}, Body: rec.RequestBody}
+	header := rec.RequestHeader
+	if in.Header != nil {
+		header = in.Header
+	}
+	for k, vv := range header {
+		for _, v := range vv {
+			if v != captureRedacted {
+				pr.Header.Add(k, v)
+			}
+		}
+	}
+	if in.Method != nil {
+		pr.Method = strings.ToUpper(strings.TrimSpace(*in.Method))
+	}
+	if in.Path != nil {
+		pr.Path = strings.TrimSpace(*in.Path)
+	}
+	if in.Query != nil {
+		pr.Query = strings.TrimPrefix(strings.TrimSpace(*in.Query), "?")
+	}
+	if in.Body != nil {
+		pr.Body = []byte(*in.Body)
+	} else if rec.RequestTruncated {
+		return nil, fmt.Errorf("captured body was truncated; supply a body to replay")
+	}
+	if pr.Method == "" || !strings.HasPrefix(pr.Path, "/") {
+		return nil, fmt.Errorf("method and an absolute path are required")
+	}
+	pr.Header.Del("Content-Length")
+	return pr, nil
+}
+
+// replayCapture sends a captured (and optionally edited) request through the tunnel to the agent
+// and stores the exchange as a new capture.
+func replayCapture(ctx context.Context, store *Store, registry *Registry, tun TunnelRecord, rec RequestCaptureRecord, in CaptureReplayInput) (RequestCaptureRecord, error) {
+	pr, err := replayRequest(rec, in)
+	if err != nil {
+		return RequestCaptureRecord{}, err
+	}
+	conn := registry.Get(tun.Hostname)
+	if conn == nil {
+		return RequestCaptureRecord{}, fmt.Errorf("tunnel is not connected")
+	}
+	// Edited headers may carry the tunnel's secret, so redact it as the proxy does.
+	rule, err := store.GetTunnelAccessRule(ctx, tun.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return RequestCaptureRecord{}, err
+	}
+	redact := captureRedactions(rule)
+	ctx, cancel := context.WithTimeout(ctx, replayTimeout)
+	defer cancel()
+	start := time.Now()
+	resp, closed := conn.EnqueueRequest(ctx, pr)
+	errText := ""
+	if closed || resp == nil {
+		resp = nil
+		errText = "tunnel unavailable"
+	}
+	id, err := captureExchange(ctx, store, tun.ID, pr, resp, time.Since(start), rec.ID, errText, redact...)
+	if err != nil {
+		return RequestCaptureRecord{}, err
+	}
+	_ = store.AddTunnelEvent(ctx, tun.Hostname, "capture_replayed", fmt.Sprintf("capture %d replayed as %d", rec.ID, id))
+	return store.GetRequestCapture(ctx, tun.ID, id)
+}
+
+// bodyPreview renders a body for the inspector, falling back to a size note for binary data.
+func bodyPreview(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if !utf8.Valid(b) {
+		return fmt.Sprintf("[%d bytes of binary data]", len(b))
+	}
+	return string(b)
+}
+
+// formatHeaderLines renders headers one "Name: value" per line, the format parseHeaderLines reads.
+func formatHeaderLines(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b.WriteString(k + ": " + v + "\n")
+		}
+	}
+	return b.String()
+}
+
+func parseHeaderLines(v string) http.Header {
+	out := http.Header{}
+	for _, line := range strings.Split(v, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		out.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return out
+}
+
+func captureEventMessage(enabled bool) string {
+	if enabled {
+		return "request capture enabled"
+	}
+	return "request capture disabled"
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyHandler_CaptureRedactsAndReplays(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(ctx, tun.ID, AccessRuleInput{
+		AuthMode:               "shared_secret_header",
+		SharedSecretHeaderName: "X-Test-Secret",
+		SharedSecretValue:      "secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelCapture(ctx, "app", true); err != nil {
+		t.Fatal(err)
+	}
+	conn := &captureConn{}
+	reg := NewRegistry()
+	reg.Register("app.example.com", conn)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "https://app.example.com/hooks?source=ci", strings.NewReader(`{"event":"push"}`))
+	req.Host = "app.example.com"
+	req.Header.Set("X-Test-Secret", "secret")
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("X-Event", "push")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d want 200", rec.Code)
+	}
+
+	captures, err := store.ListRequestCaptures(ctx, tun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 1 {
+		t.Fatalf("captures=%d want 1", len(captures))
+	}
+	got := captures[0]
+	if got.Method != http.MethodPost || got.Path != "/hooks" || got.Query != "source=ci" || string(got.RequestBody) != `{"event":"push"}` {
+		t.Fatalf("unexpected capture %+v", got)
+	}
+	if got.RequestHeader.Get("Authorization") != captureRedacted || got.RequestHeader.Get("X-Test-Secret") != captureRedacted {
+		t.Fatalf("sensitive headers not redacted: %v", got.RequestHeader)
+	}
+	if got.Status != http.StatusOK || string(got.ResponseBody) != "ok" {
+		t.Fatalf("unexpected response capture status=%d body=%q", got.Status, got.ResponseBody)
+	}
+
+	body := `{"event":"tag"}`
+	replayed, err := replayCapture(ctx, store, reg, tun, got, CaptureReplayInput{Body: &body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ReplayOf != got.ID || string(replayed.RequestBody) != body {
+		t.Fatalf("unexpected replay %+v", replayed)
+	}
+	if string(conn.last.Body) != body || conn.last.Header.Get("X-Event") != "push" {
+		t.Fatalf("agent got body=%q headers=%v", conn.last.Body, conn.last.Header)
+	}
+	if conn.last.Header.Get("Authorization") != "" || conn.last.Header.Get("X-Test-Secret") != "" {
+		t.Fatalf("redacted headers replayed: %v", conn.last.Header)
+	}
+
+	edited, err := replayCapture(ctx, store, reg, tun, got, CaptureReplayInput{Header: http.Header{"X-Test-Secret": {"secret"}, "X-Event": {"push"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.last.Header.Get("X-Test-Secret") != "secret" {
+		t.Fatalf("edited header not sent: %v", conn.last.Header)
+	}
+	if edited.RequestHeader.Get("X-Test-Secret") != captureRedacted {
+		t.Fatalf("replayed secret stored in the clear: %v", edited.RequestHeader)
+	}
+
+	if err := store.SetTunnelCapture(ctx, "app", false); err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if captures, _ := store.ListRequestCaptures(ctx, tun.ID, 10); len(captures) != 3 {
+		t.Fatalf("captures=%d want 3 after disabling", len(captures))
+	}
+}
+
+func TestCaptureRingAndTruncation(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := store.InsertRequestCapture(ctx, RequestCaptureRecord{TunnelID: tun.ID, Timestamp: time.Now(), Method: http.MethodGet, Path: "/"}, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	captures, err := store.ListRequestCaptures(ctx, tun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 3 {
+		t.Fatalf("captures=%d want ring of 3", len(captures))
+	}
+
+	pr := &ProxyRequest{Method: http.MethodPost, Path: "/upload", Body: make([]byte, captureMaxBodyBytes+1)}
+	id, err := captureExchange(ctx, store, tun.ID, pr, nil, 0, 0, "tunnel unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	big, err := store.GetRequestCapture(ctx, tun.ID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !big.RequestTruncated || len(big.RequestBody) != captureMaxBodyBytes {
+		t.Fatalf("truncated=%v len=%d", big.RequestTruncated, len(big.RequestBody))
+	}
+	if _, err := replayRequest(big, CaptureReplayInput{}); err == nil {
+		t.Fatal("expected replay of truncated body to be refused")
+	}
+	body := "small"
+	if pr, err := replayRequest(big, CaptureReplayInput{Body: &body}); err != nil || string(pr.Body) != body {
+		t.Fatalf("replay with new body: pr=%v err=%v", pr, err)
+	}
+}
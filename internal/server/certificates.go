@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCertificatePEMBytes bounds each uploaded PEM block (chain or key).
+	maxCertificatePEMBytes = 64 << 10
+	// certExpiryWarning is how far ahead of expiry an uploaded certificate is flagged.
+	certExpiryWarning = 30 * 24 * time.Hour
+	// certExpiryCheckInterval is how often expiring certificates are logged.
+	certExpiryCheckInterval = 12 * time.Hour
+)
+
+// certificateView is an uploaded certificate with its expiry warning, as shown by the API and UI.
+type certificateView struct {
+	TLSCertificateRecord
+	Warning string `json:"warning,omitempty"`
+}
+
+func newCertificateViews(list []TLSCertificateRecord, now time.Time) []certificateView {
+	out := make([]certificateView, 0, len(list))
+	for _, rec := range list {
+		out = append(out, certificateView{TLSCertificateRecord: rec, Warning: certificateWarning(rec, now)})
+	}
+	return out
+}
+
+// certificateWarning describes an expired or soon-expiring certificate, or returns "".
+func certificateWarning(rec TLSCertificateRecord, now time.Time) string {
+	left := rec.NotAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return "expired " + rec.NotAfter.UTC().Format("2006-01-02")
+	case left < certExpiryWarning:
+		days := int(left.Hours() / 24)
+		if days == 1 {
+			return "expires in 1 day"
+		}
+		return fmt.Sprintf("expires in %d days", days)
+	}
+	return ""
+}
+
+// parseUploadedCertificate validates a PEM chain and private key and returns the record to store.
+func parseUploadedCertificate(certPEM, keyPEM string, now time.Time) (TLSCertificateRecord, error) {
+	if strings.TrimSpace(certPEM) == "" || strings.TrimSpace(keyPEM) == "" {
+		return TLSCertificateRecord{}, fmt.Errorf("certificate and key PEM required")
+	}
+	if len(certPEM) > maxCertificatePEMBytes || len(keyPEM) > maxCertificatePEMBytes {
+		return TLSCertificateRecord{}, fmt.Errorf("certificate or key too large (max %d bytes)", maxCertificatePEMBytes)
+	}
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return TLSCertificateRecord{}, fmt.Errorf("invalid certificate: %w", err)
+	}
+	leaf, err := certificateLeaf(&pair)
+	if err != nil {
+		return TLSCertificateRecord{}, fmt.Errorf("invalid certificate: %w", err)
+	}
+	if !now.Before(leaf.NotAfter) {
+		return TLSCertificateRecord{}, fmt.Errorf("certificate expired on %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	var names []string
+	for _, n := range leaf.DNSNames {
+		if n = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(n)), "."); n != "" {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return TLSCertificateRecord{}, fmt.Errorf("certificate has no DNS names (subjectAltName)")
+	}
+	return TLSCertificateRecord{
+		Names:     names,
+		Issuer:    leaf.Issuer.String(),
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		CertPEM:   certPEM,
+		KeyPEM:    keyPEM,
+	}, nil
+}
+
+func certificateLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("empty certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+	return leaf, nil
+}
+
+// CertificateSelector serves uploaded certificates by SNI and falls back to the server's own
+// certificate (static file or ACME) for names none of them cover. It reloads from the store
+// whenever certificates are added or removed, so uploads take effect without a restart.
+type CertificateSelector struct {
+	store    *Store
+	fallback func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	mu      sync.RWMutex
+	loaded  bool
+	version uint64
+	byName  map[string][]*tls.Certificate
+}
+
+// NewCertificateSelector returns a selector over store's uploaded certificates. fallback may be nil.
+func NewCertificateSelector(store *Store, fallback func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *CertificateSelector {
+	return &CertificateSelector{store: store, fallback: fallback}
+}
+
+// StaticCertificate returns a fallback that always serves cert.
+func StaticCertificate(cert *tls.Certificate) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil }
+}
+
+// GetCertificate picks the most specific valid uploaded certificate for the SNI name: an exact
+// name beats a wildcard, and among equals the one expiring last wins.
+func (c *CertificateSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name != "" {
+		c.refresh(context.Background())
+		if cert := c.lookup(name, time.Now()); cert != nil {
+			return cert, nil
+		}
+	}
+	if c.fallback != nil {
+		return c.fallback(hello)
+	}
+	return nil, fmt.Errorf("no certificate for %q", name)
+}
+
+func (c *CertificateSelector) lookup(name string, now time.Time) *tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if cert := newestValid(c.byName[name], now); cert != nil {
+		return cert
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok && strings.Contains(parent, ".") {
+		return newestValid(c.byName["*."+parent], now)
+	}
+	return nil
+}
+
+func newestValid(certs []*tls.Certificate, now time.Time) *tls.Certificate {
+	var best *tls.Certificate
+	for _, cert := range certs {
+		if now.Before(cert.Leaf.NotBefore) || !now.Before(cert.Leaf.NotAfter) {
+			continue
+		}
+		if best == nil || cert.Leaf.NotAfter.After(best.Leaf.NotAfter) {
+			best = cert
+		}
+	}
+	return best
+}
+
+// refresh reloads certificates when the store reports a change. A failed load keeps the
+// previous set so a database hiccup never drops TLS for uploaded names.
+func (c *CertificateSelector) refresh(ctx context.Context) {
+	if c.store == nil {
+		return
+	}
+	version := c.store.TLSCertificatesVersion()
+	c.mu.RLock()
+	current := c.loaded && c.version == version
+	c.mu.RUnlock()
+	if current {
+		return
+	}
+	list, err := c.store.ListTLSCertificates(ctx)
+	if err != nil {
+		slog.Error("tls certificates load failed", "error", err)
+		return
+	}
+	byName := make(map[string][]*tls.Certificate)
+	for _, rec := range list {
+		pair, err := tls.X509KeyPair([]byte(rec.CertPEM), []byte(rec.KeyPEM))
+		if err != nil {
+			slog.Warn("tls certificate skipped", "id", rec.ID, "error", err)
+			continue
+		}
+		if _, err := certificateLeaf(&pair); err != nil {
+			slog.Warn("tls certificate skipped", "id", rec.ID, "error", err)
+			continue
+		}
+		for _, name := range rec.Names {
+			byName[name] = append(byName[name], &pair)
+		}
+	}
+	c.mu.Lock()
+	c.byName = byName
+	c.version = version
+	c.loaded = true
+	c.mu.Unlock()
+}
+
+// runCertificateExpiryWarnings logs uploaded certificates that are expired or close to expiry
+// until ctx is cancelled.
+func runCertificateExpiryWarnings(ctx context.Context, store *Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		warnExpiringCertificates(ctx, store, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func warnExpiringCertificates(ctx context.Context, store *Store, now time.Time) int {
+	list, err := store.ListTLSCertificates(ctx)
+	if err != nil {
+		slog.Error("tls certificates check failed", "error", err)
+		return 0
+	}
+	n := 0
+	for _, rec := range list {
+		if warning := certificateWarning(rec, now); warning != "" {
+			slog.Warn("tls certificate "+warning, "id", rec.ID, "names", strings.Join(rec.Names, ","))
+			n++
+		}
+	}
+	return n
+}
+
+// handleCertificates serves /api/certificates: GET lists, POST uploads {cert_pem, key_pem} and
+// DELETE /{id} removes a certificate.
+func handleCertificates(w http.ResponseWriter, r *http.Request, store *Store, rest []string) {
+	if len(rest) == 0 || rest[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := store.ListTLSCertificates(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, newCertificateViews(list, time.Now()))
+		case http.MethodPost:
+			var body struct {
+				CertPEM string `json:"cert_pem"`
+				KeyPEM  string `json:"key_pem"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 3*maxCertificatePEMBytes)).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			now := time.Now()
+			rec, err := parseUploadedCertificate(body.CertPEM, body.KeyPEM, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rec, err = store.AddTLSCertificate(r.Context(), rec)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusCreated, certificateView{TLSCertificateRecord: rec, Warning: certificateWarning(rec, now)})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil || len(rest) != 1 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := store.DeleteTLSCertificate(r.Context(), id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "certificate not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
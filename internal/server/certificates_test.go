@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificatePEM(t *testing.T, names []string, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test", Organization: []string{"fwdx test"}},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+func uploadTestCertificate(t *testing.T, store *Store, names []string, validFor time.Duration) TLSCertificateRecord {
+	t.Helper()
+	certPEM, keyPEM := testCertificatePEM(t, names, time.Now().Add(validFor))
+	rec, err := parseUploadedCertificate(certPEM, keyPEM, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err = store.AddTLSCertificate(context.Background(), rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestCertificateSelector_SNI(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	fallback := testCertificate(t, []string{"tunnel.example.com"}, time.Now().Add(90*24*time.Hour))
+	sel := NewCertificateSelector(store, StaticCertificate(fallback))
+	served := func(name string) *tls.Certificate {
+		t.Helper()
+		cert, err := sel.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return cert
+	}
+
+	if served("app.example.com") != fallback {
+		t.Fatal("expected fallback before any upload")
+	}
+	wildcard := uploadTestCertificate(t, store, []string{"*.example.com"}, 90*24*time.Hour)
+	uploadTestCertificate(t, store, []string{"app.example.com"}, 40*24*time.Hour)
+	newest := uploadTestCertificate(t, store, []string{"app.example.com", "www.example.com"}, 60*24*time.Hour)
+
+	if got := served("APP.example.com."); !got.Leaf.NotAfter.Equal(newest.NotAfter) {
+		t.Fatalf("exact match served cert expiring %s, want the newest exact cert", got.Leaf.NotAfter)
+	}
+	if got := served("shop.example.com"); !got.Leaf.NotAfter.Equal(wildcard.NotAfter) {
+		t.Fatal("expected wildcard for a one-level subdomain")
+	}
+	for _, name := range []string{"a.b.example.com", "example.com", "tunnel.example.net", ""} {
+		if served(name) != fallback {
+			t.Fatalf("%q: expected fallback", name)
+		}
+	}
+
+	list, err := store.ListTLSCertificates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range list {
+		if rec.ID != wildcard.ID {
+			if err := store.DeleteTLSCertificate(context.Background(), rec.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := served("app.example.com"); !got.Leaf.NotAfter.Equal(wildcard.NotAfter) {
+		t.Fatal("removed certificate still served")
+	}
+	if err := store.DeleteTLSCertificate(context.Background(), wildcard.ID); err != nil {
+		t.Fatal(err)
+	}
+	if served("shop.example.com") != fallback {
+		t.Fatal("expected fallback after removing every upload")
+	}
+	if _, err := NewCertificateSelector(store, nil).GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"}); err == nil {
+		t.Fatal("expected error without fallback")
+	}
+}
+
+func TestParseUploadedCertificate_Validation(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM := testCertificatePEM(t, []string{"app.example.com"}, now.Add(24*time.Hour))
+	_, otherKey := testCertificatePEM(t, []string{"app.example.com"}, now.Add(24*time.Hour))
+	expiredCert, expiredKey := testCertificatePEM(t, []string{"app.example.com"}, now.Add(-time.Minute))
+	noNamesCert, noNamesKey := testCertificatePEM(t, nil, now.Add(24*time.Hour))
+
+	rec, err := parseUploadedCertificate(certPEM, keyPEM, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Names) != 1 || rec.Names[0] != "app.example.com" || !strings.Contains(rec.Issuer, "fwdx test") {
+		t.Fatalf("rec=%+v", rec)
+	}
+	cases := map[string][2]string{
+		"empty":        {"", keyPEM},
+		"key mismatch": {certPEM, otherKey},
+		"expired":      {expiredCert, expiredKey},
+		"no names":     {noNamesCert, noNamesKey},
+		"garbage":      {"not pem", "not pem"},
+	}
+	for name, c := range cases {
+		if _, err := parseUploadedCertificate(c[0], c[1], now); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCertificateWarning(t *testing.T) {
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[time.Duration]string{
+		60 * 24 * time.Hour:         "",
+		10*24*time.Hour + time.Hour: "expires in 10 days",
+		24*time.Hour + time.Minute:  "expires in 1 day",
+		-time.Hour:                  "expired 2026-04-30",
+	}
+	for left, want := range cases {
+		if got := certificateWarning(TLSCertificateRecord{NotAfter: now.Add(left)}, now); got != want {
+			t.Fatalf("left=%s warning=%q want %q", left, got, want)
+		}
+	}
+}
+
+func TestHandleCertificates_API(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/certificates"), "/"), "/")
+		handleCertificates(rec, req, store, rest)
+		return rec
+	}
+
+	certPEM, keyPEM := testCertificatePEM(t, []string{"shop.example.org"}, time.Now().Add(10*24*time.Hour))
+	body, _ := json.Marshal(map[string]string{"cert_pem": certPEM, "key_pem": keyPEM})
+	rec := call(http.MethodPost, "/api/certificates", string(body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var created certificateView
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 0 || !strings.HasPrefix(created.Warning, "expires in 9 days") {
+		t.Fatalf("created=%+v", created)
+	}
+
+	rec = call(http.MethodPost, "/api/certificates", `{"cert_pem":"x","key_pem":"y"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid upload status=%d", rec.Code)
+	}
+
+	rec = call(http.MethodGet, "/api/certificates", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "PRIVATE KEY") || !strings.Contains(rec.Body.String(), "shop.example.org") {
+		t.Fatalf("list status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	path := "/api/certificates/" + strconv.FormatInt(created.ID, 10)
+	if rec = call(http.MethodDelete, path, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d", rec.Code)
+	}
+	if rec = call(http.MethodDelete, path, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete status=%d", rec.Code)
+	}
+}
+
+func TestCertificateSelector_Handshake(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	fallback := testCertificate(t, []string{"tunnel.example.com"}, time.Now().Add(90*24*time.Hour))
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{GetCertificate: NewCertificateSelector(store, StaticCertificate(fallback)).GetCertificate}
+	srv.StartTLS()
+	defer srv.Close()
+
+	servedNames := func(sni string) []string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].DNSNames
+	}
+	if got := servedNames("shop.example.org"); got[0] != "tunnel.example.com" {
+		t.Fatalf("served %v before upload", got)
+	}
+	uploadTestCertificate(t, store, []string{"shop.example.org"}, 30*24*time.Hour)
+	if got := servedNames("shop.example.org"); got[0] != "shop.example.org" {
+		t.Fatalf("served %v after upload, want hot-swapped certificate", got)
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	chaosDefaultTTL = 15 * time.Minute
+	chaosMaxTTL     = 24 * time.Hour
+	chaosMaxDelayMS = 60_000
+	// chaosThrottleTick is how often a throttled response writes its next chunk.
+	chaosThrottleTick = 100 * time.Millisecond
+)
+
+// ChaosPolicy injects latency and faults into a tunnel's proxied requests until ExpiresAt.
+type ChaosPolicy struct {
+	LatencyMS     int       `json:"latency_ms"`
+	JitterMS      int       `json:"jitter_ms"`
+	ErrorPercent  float64   `json:"error_percent"`
+	ErrorStatus   int       `json:"error_status"`
+	DropPercent   float64   `json:"drop_percent"`
+	BandwidthKBps int       `json:"bandwidth_kbps"`
+	Paths         []string  `json:"paths"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// chaosPlan is what the proxy does to one request.
+type chaosPlan struct {
+	delay       time.Duration
+	status      int
+	drop        bool
+	bytesPerSec int
+}
+
+// normalizeChaosPolicy validates p, fills defaults and sets its expiry from ttl.
+func normalizeChaosPolicy(p ChaosPolicy, ttl string, now time.Time) (ChaosPolicy, error) {
+	if p.LatencyMS < 0 || p.LatencyMS > chaosMaxDelayMS || p.JitterMS < 0 || p.JitterMS > chaosMaxDelayMS {
+		return ChaosPolicy{}, fmt.Errorf("latency_ms and jitter_ms must be between 0 and %d", chaosMaxDelayMS)
+	}
+	if p.ErrorPercent < 0 || p.ErrorPercent > 100 || p.DropPercent < 0 || p.DropPercent > 100 || p.ErrorPercent+p.DropPercent > 100 {
+		return ChaosPolicy{}, fmt.Errorf("error_percent and drop_percent must be between 0 and 100 and add up to at most 100")
+	}
+	if p.ErrorStatus == 0 {
+		p.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if p.ErrorStatus < 500 || p.ErrorStatus > 599 {
+		return ChaosPolicy{}, fmt.Errorf("error_status must be a 5xx status")
+	}
+	if p.BandwidthKBps < 0 {
+		return ChaosPolicy{}, fmt.Errorf("bandwidth_kbps must not be negative")
+	}
+	if p.LatencyMS == 0 && p.JitterMS == 0 && p.ErrorPercent == 0 && p.DropPercent == 0 && p.BandwidthKBps == 0 {
+		return ChaosPolicy{}, fmt.Errorf("policy has no effect; set latency, errors, drops or bandwidth")
+	}
+	paths := make([]string, 0, len(p.Paths))
+	for _, raw := range p.Paths {
+		pattern := strings.TrimSpace(raw)
+		if pattern == "" {
+			continue
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			return ChaosPolicy{}, fmt.Errorf("path %q must start with /", pattern)
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return ChaosPolicy{}, fmt.Errorf("invalid path pattern %q", pattern)
+		}
+		paths = append(paths, pattern)
+	}
+	p.Paths = paths
+	d := chaosDefaultTTL
+	if strings.TrimSpace(ttl) != "" {
+		var err error
+		if d, err = parseTunnelTTL(ttl); err != nil {
+			return ChaosPolicy{}, err
+		}
+	}
+	if d > chaosMaxTTL {
+		return ChaosPolicy{}, fmt.Errorf("ttl must be at most %s", chaosMaxTTL)
+	}
+	p.ExpiresAt = now.Add(d)
+	return p, nil
+}
+
+// matchesPath reports whether reqPath is covered by the policy. A pattern ending in * matches
+// any path with that prefix; other patterns use path.Match. No patterns means every path.
+func (p ChaosPolicy) matchesPath(reqPath string) bool {
+	if len(p.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range p.Paths {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(reqPath, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, reqPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// plan decides the faults for one request. roll returns a number in [0, 100).
+func (p ChaosPolicy) plan(reqPath string, now time.Time, roll func() float64) (chaosPlan, bool) {
+	if !p.ExpiresAt.After(now) || !p.matchesPath(reqPath) {
+		return chaosPlan{}, false
+	}
+	var c chaosPlan
+	delay := p.LatencyMS
+	if p.JitterMS > 0 {
+		delay += int(roll()/100*float64(2*p.JitterMS+1)) - p.JitterMS
+	}
+	if delay > 0 {
+		c.delay = time.Duration(delay) * time.Millisecond
+	}
+	r := roll()
+	switch {
+	case r < p.DropPercent:
+		c.drop = true
+	case r < p.DropPercent+p.ErrorPercent:
+		c.status = p.ErrorStatus
+	}
+	c.bytesPerSec = p.BandwidthKBps * 1024
+	return c, c.delay > 0 || c.drop || c.status != 0 || c.bytesPerSec > 0
+}
+
+// String describes the injected faults for request_logs.
+func (c chaosPlan) String() string {
+	var parts []string
+	if c.delay > 0 {
+		parts = append(parts, "latency="+c.delay.String())
+	}
+	if c.drop {
+		parts = append(parts, "drop")
+	}
+	if c.status != 0 {
+		parts = append(parts, "error="+strconv.Itoa(c.status))
+	}
+	if c.bytesPerSec > 0 {
+		parts = append(parts, "throttle="+strconv.Itoa(c.bytesPerSec/1024)+"KB/s")
+	}
+	return strings.Join(parts, " ")
+}
+
+func chaosRoll() float64 {
+	return rand.Float64() * 100
+}
+
+// sleepContext waits for d and reports false if ctx ended first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// dropConnection closes the client connection without a response.
+func dropConnection(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			_ = conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// writeThrottled writes body at roughly bytesPerSec, flushing after each chunk.
+func writeThrottled(ctx context.Context, w http.ResponseWriter, body []byte, bytesPerSec int) {
+	chunk := int(int64(bytesPerSec) * int64(chaosThrottleTick) / int64(time.Second))
+	if chunk < 1 {
+		chunk = 1
+	}
+	flusher, _ := w.(http.Flusher)
+	for len(body) > 0 {
+		n := min(chunk, len(body))
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		body = body[n:]
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(body) > 0 && !sleepContext(ctx, chaosThrottleTick) {
+			return
+		}
+	}
+}
+
+// expireChaosPolicies removes chaos policies that have run out so they cannot be left on.
+func expireChaosPolicies(ctx context.Context, store *Store, now time.Time) {
+	hostnames, err := store.ExpireTunnelChaos(ctx, now)
+	if err != nil {
+		slog.Error("chaos janitor failed", "error", err)
+	}
+	for _, hostname := range hostnames {
+		_ = store.AddTunnelEvent(ctx, hostname, "chaos_expired", "chaos policy expired")
+		slog.Info("chaos policy expired", "hostname", hostname)
+	}
+}
+
+func chaosEventMessage(p ChaosPolicy) string {
+	var parts []string
+	if p.LatencyMS > 0 || p.JitterMS > 0 {
+		parts = append(parts, fmt.Sprintf("latency %dms±%dms", p.LatencyMS, p.JitterMS))
+	}
+	if p.ErrorPercent > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% %d errors", p.ErrorPercent, p.ErrorStatus))
+	}
+	if p.DropPercent > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% drops", p.DropPercent))
+	}
+	if p.BandwidthKBps > 0 {
+		parts = append(parts, fmt.Sprintf("%d KB/s", p.BandwidthKBps))
+	}
+	if len(p.Paths) > 0 {
+		parts = append(parts, "paths "+strings.Join(p.Paths, ","))
+	}
+	return "chaos enabled: " + strings.Join(parts, ", ") + " until " + p.ExpiresAt.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeChaosPolicy(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name   string
+		policy ChaosPolicy
+		ttl    string
+		ok     bool
+	}{
+		{"no effect", ChaosPolicy{}, "", false},
+		{"latency", ChaosPolicy{LatencyMS: 200}, "", true},
+		{"too many failures", ChaosPolicy{ErrorPercent: 60, DropPercent: 50}, "", false},
+		{"non 5xx", ChaosPolicy{ErrorPercent: 10, ErrorStatus: 404}, "", false},
+		{"relative path", ChaosPolicy{LatencyMS: 1, Paths: []string{"api/*"}}, "", false},
+		{"ttl too long", ChaosPolicy{LatencyMS: 1}, "48h", false},
+		{"bad ttl", ChaosPolicy{LatencyMS: 1}, "soon", false},
+	}
+	for _, tc := range cases {
+		got, err := normalizeChaosPolicy(tc.policy, tc.ttl, now)
+		if (err == nil) != tc.ok {
+			t.Fatalf("%s: err=%v want ok=%v", tc.name, err, tc.ok)
+		}
+		if tc.ok && !got.ExpiresAt.Equal(now.Add(chaosDefaultTTL)) {
+			t.Fatalf("%s: expires_at=%v want default ttl", tc.name, got.ExpiresAt)
+		}
+	}
+	got, err := normalizeChaosPolicy(ChaosPolicy{ErrorPercent: 5}, "", now)
+	if err != nil || got.ErrorStatus != http.StatusServiceUnavailable {
+		t.Fatalf("default error status=%d err=%v", got.ErrorStatus, err)
+	}
+}
+
+func TestChaosPolicyPlan(t *testing.T) {
+	now := time.Now()
+	policy := ChaosPolicy{LatencyMS: 100, JitterMS: 50, ErrorPercent: 20, ErrorStatus: 502, DropPercent: 10, Paths: []string{"/api/*", "/health"}, ExpiresAt: now.Add(time.Minute)}
+	fixed := func(v float64) func() float64 { return func() float64 { return v } }
+
+	if _, ok := policy.plan("/static/app.js", now, fixed(0)); ok {
+		t.Fatal("unmatched path should not be affected")
+	}
+	plan, ok := policy.plan("/api/orders/1", now, fixed(5))
+	if !ok || !plan.drop || plan.delay != 55*time.Millisecond {
+		t.Fatalf("low roll plan=%+v", plan)
+	}
+	plan, _ = policy.plan("/health", now, fixed(25))
+	if plan.drop || plan.status != 502 {
+		t.Fatalf("mid roll plan=%+v", plan)
+	}
+	plan, _ = policy.plan("/api/x", now, fixed(99.99))
+	if plan.drop || plan.status != 0 || plan.delay != 150*time.Millisecond {
+		t.Fatalf("high roll plan=%+v", plan)
+	}
+	if plan.String() != "latency=150ms" {
+		t.Fatalf("fault=%q", plan.String())
+	}
+	if _, ok := policy.plan("/api/x", now.Add(2*time.Minute), fixed(0)); ok {
+		t.Fatal("expired policy should not apply")
+	}
+}
+
+func TestProxyHandler_ChaosFaults(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &captureConn{}
+	reg := NewRegistry()
+	reg.Register("app.example.com", conn)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://app.example.com"+path, nil)
+		req.Host = "app.example.com"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if err := store.SetTunnelChaos(ctx, tun.ID, ChaosPolicy{ErrorPercent: 100, ErrorStatus: 503, Paths: []string{"/api/*"}, ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do("/api/orders"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status=%d want injected 503", rec.Code)
+	}
+	if conn.last != nil {
+		t.Fatal("injected error reached the agent")
+	}
+	if rec := do("/index.html"); rec.Code != http.StatusOK {
+		t.Fatalf("unmatched path status=%d want 200", rec.Code)
+	}
+
+	if err := store.SetTunnelChaos(ctx, tun.ID, ChaosPolicy{LatencyMS: 30, BandwidthKBps: 1, ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if rec := do("/slow"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("status=%d body=%q", rec.Code, rec.Body.String())
+	}
+	if took := time.Since(start); took < 30*time.Millisecond {
+		t.Fatalf("latency not injected, took %s", took)
+	}
+
+	logs, err := store.ListRequestLogsByTunnel(ctx, tun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults := map[string]string{}
+	for _, l := range logs {
+		faults[l.Path] = l.Fault
+	}
+	if faults["/api/orders"] != "error=503" || faults["/index.html"] != "" || faults["/slow"] != "latency=30ms throttle=1KB/s" {
+		t.Fatalf("unexpected faults %v", faults)
+	}
+
+	if hosts, err := store.ExpireTunnelChaos(ctx, time.Now().Add(2*time.Minute)); err != nil || len(hosts) != 1 || hosts[0] != "app.example.com" {
+		t.Fatalf("expire hosts=%v err=%v", hosts, err)
+	}
+	if _, err := store.GetTunnelChaos(ctx, tun.ID); err == nil {
+		t.Fatal("expected expired policy to be removed")
+	}
+}
+
+func TestProxyHandler_ChaosDrop(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelChaos(ctx, tun.ID, ChaosPolicy{DropPercent: 100, ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	reg.Register("app.example.com", &captureConn{})
+	srv := httptest.NewServer(ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	req.Host = "app.example.com"
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected dropped connection, got status %d", resp.StatusCode)
+	}
+	logs, err := store.ListRequestLogsByTunnel(ctx, tun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Fault != "drop" {
+		t.Fatalf("unexpected logs %+v", logs)
+	}
+}
@@ -1,15 +1,20 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *AuthManager) http.Handler {
+func ControlPlaneRouter(cfg Config, registry *Registry, domains *DomainStore, store *Store, auth *AuthManager) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/agents", func(w http.ResponseWriter, r *http.Request) {
 		user, ok := requireSessionUser(auth, w, r)
@@ -37,6 +42,10 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 				http.Error(w, "name required", http.StatusBadRequest)
 				return
 			}
+			if err := store.Quotas().CheckCreate(r.Context(), *user, "agent"); err != nil {
+				http.Error(w, err.Error(), quotaStatus(err))
+				return
+			}
 			raw, err := randomString(32)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -78,6 +87,81 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
 	})
+	serverErrorPages := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		if user.Role != "admin" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/error-pages"), "/"), "/")
+		handleErrorPages(w, r, store, 0, rest, nil)
+	}
+	mux.HandleFunc("/api/error-pages", serverErrorPages)
+	mux.HandleFunc("/api/error-pages/", serverErrorPages)
+	domainsAPI := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/domains"), "/"), "/")
+		handleDomainsAPI(w, r, domains, user, rest)
+	}
+	mux.HandleFunc("/api/domains", domainsAPI)
+	mux.HandleFunc("/api/domains/", domainsAPI)
+	certificates := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		if user.Role != "admin" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/certificates"), "/"), "/")
+		handleCertificates(w, r, store, rest)
+	}
+	mux.HandleFunc("/api/certificates", certificates)
+	mux.HandleFunc("/api/certificates/", certificates)
+	reservations := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/reservations"), "/"), "/")
+		handleReservations(w, r, cfg, store, domains, user, rest)
+	}
+	quotas := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/quotas"), "/"), "/")
+		handleQuotas(w, r, store, user, rest)
+	}
+	mux.HandleFunc("/api/quotas", quotas)
+	mux.HandleFunc("/api/quotas/", quotas)
+	mux.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		handleUsage(w, r, store, user)
+	})
+	mux.HandleFunc("/api/reservations", reservations)
+	mux.HandleFunc("/api/reservations/", reservations)
+	hostnamePolicy := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireSessionUser(auth, w, r)
+		if !ok {
+			return
+		}
+		rest := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/hostname-policy"), "/"), "/")
+		handleHostnamePolicy(w, r, cfg, store, user, rest)
+	}
+	mux.HandleFunc("/api/hostname-policy", hostnamePolicy)
+	mux.HandleFunc("/api/hostname-policy/", hostnamePolicy)
 	mux.HandleFunc("/api/tunnels", func(w http.ResponseWriter, r *http.Request) {
 		user, ok := requireSessionUser(auth, w, r)
 		if !ok {
@@ -93,11 +177,13 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 			writeJSON(w, http.StatusOK, list)
 		case http.MethodPost:
 			var body struct {
-				Name      string `json:"name"`
-				Subdomain string `json:"subdomain"`
-				URL       string `json:"url"`
-				Local     string `json:"local"`
-				AgentName string `json:"agent_name"`
+				Name         string `json:"name"`
+				Subdomain    string `json:"subdomain"`
+				URL          string `json:"url"`
+				Local        string `json:"local"`
+				AgentName    string `json:"agent_name"`
+				TTL          string `json:"ttl"`
+				ExpiryAction string `json:"expiry_action"`
 			}
 			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 				http.Error(w, "invalid json", http.StatusBadRequest)
@@ -114,9 +200,27 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 				http.Error(w, "use exactly one of subdomain or url", http.StatusBadRequest)
 				return
 			}
-			hostname, err := resolveTunnelHostname(cfg.Hostname, domains.List(), body.Subdomain, body.URL)
+			var ttl time.Duration
+			if strings.TrimSpace(body.TTL) != "" {
+				d, err := parseTunnelTTL(body.TTL)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				ttl = d
+			}
+			body.ExpiryAction = strings.TrimSpace(strings.ToLower(body.ExpiryAction))
+			if body.ExpiryAction != "" && body.ExpiryAction != "stop" && body.ExpiryAction != "delete" {
+				http.Error(w, "expiry_action must be stop or delete", http.StatusBadRequest)
+				return
+			}
+			hostname, err := claimHostname(r.Context(), cfg, store, domains, user, body.Subdomain, body.URL)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				http.Error(w, err.Error(), hostnameClaimStatus(err))
+				return
+			}
+			if err := store.Quotas().CheckCreate(r.Context(), *user, "tunnel"); err != nil {
+				http.Error(w, err.Error(), quotaStatus(err))
 				return
 			}
 			var agentID int64
@@ -137,6 +241,20 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 				http.Error(w, err.Error(), http.StatusConflict)
 				return
 			}
+			if ttl > 0 || body.ExpiryAction != "" {
+				var expiresAt time.Time
+				if ttl > 0 {
+					expiresAt = time.Now().Add(ttl)
+				}
+				if err := store.SetTunnelExpiry(r.Context(), tun.Name, expiresAt, body.ExpiryAction); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if tun, err = store.GetTunnelByName(r.Context(), tun.Name); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
 			writeJSON(w, http.StatusCreated, tun)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -177,7 +295,11 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 			}
 			return
 		}
-		if len(parts) != 2 {
+		switch {
+		case len(parts) == 2:
+		case len(parts) == 3 && (parts[1] == "credentials" || parts[1] == "error-pages" || parts[1] == "captures"):
+		case len(parts) == 4 && parts[1] == "captures":
+		default:
 			http.NotFound(w, r)
 			return
 		}
@@ -220,16 +342,9 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 			_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "assignment_changed", "assigned to "+agentName)
 			writeJSON(w, http.StatusOK, map[string]string{"status": "assigned"})
 		case "logs":
-			if r.Method != http.MethodGet {
-				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-				return
-			}
-			logs, err := store.ListRequestLogsByTunnel(r.Context(), tun.ID, 100)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, http.StatusOK, logs)
+			handleRequestLogs(w, r, store, tun)
+		case "stats":
+			handleTunnelStats(w, r, store, tun)
 		case "events":
 			if r.Method != http.MethodGet {
 				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -256,6 +371,10 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 					http.Error(w, "invalid json", http.StatusBadRequest)
 					return
 				}
+				if err := auth.checkJWTIssuer(body.JWTIssuer); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
 				if err := store.UpsertTunnelAccessRule(r.Context(), tun.ID, body); err != nil {
 					_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "access_rule_invalid", err.Error())
 					http.Error(w, err.Error(), http.StatusBadRequest)
@@ -271,6 +390,181 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 			default:
 				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			}
+		case "credentials":
+			handleTunnelCredentials(w, r, store, tun, parts[2:])
+		case "chaos":
+			switch r.Method {
+			case http.MethodGet:
+				policy, err := store.GetTunnelChaos(r.Context(), tun.ID)
+				if err != nil || !policy.ExpiresAt.After(time.Now()) {
+					http.Error(w, "no chaos policy", http.StatusNotFound)
+					return
+				}
+				writeJSON(w, http.StatusOK, policy)
+			case http.MethodPut:
+				var body struct {
+					ChaosPolicy
+					TTL string `json:"ttl"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "invalid json", http.StatusBadRequest)
+					return
+				}
+				policy, err := normalizeChaosPolicy(body.ChaosPolicy, body.TTL, time.Now())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if err := store.SetTunnelChaos(r.Context(), tun.ID, policy); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "chaos_enabled", chaosEventMessage(policy))
+				writeJSON(w, http.StatusOK, policy)
+			case http.MethodDelete:
+				if err := store.ClearTunnelChaos(r.Context(), tun.ID); err != nil {
+					if err == sql.ErrNoRows {
+						http.Error(w, "no chaos policy", http.StatusNotFound)
+						return
+					}
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "chaos_disabled", "chaos policy removed")
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		case "mirror":
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			summary, err := store.GetMirrorSummary(r.Context(), tun.ID, 25)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, summary)
+		case "capture":
+			if r.Method != http.MethodPatch {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var body struct {
+				Enabled bool `json:"enabled"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			if err := store.SetTunnelCapture(r.Context(), name, body.Enabled); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if body.Enabled != tun.Capture {
+				_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "capture_changed", captureEventMessage(body.Enabled))
+			}
+			updated, err := store.GetTunnelByName(r.Context(), name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case "captures":
+			handleTunnelCaptures(w, r, store, registry, tun, parts[2:])
+		case "error-pages":
+			handleErrorPages(w, r, store, tun.ID, parts[2:], func(ctx context.Context, msg string) {
+				_ = store.AddTunnelEvent(ctx, tun.Hostname, "error_page_changed", msg)
+			})
+		case "share-links":
+			switch r.Method {
+			case http.MethodPost:
+				var body struct {
+					TTL  string `json:"ttl"`
+					Path string `json:"path"`
+					Host string `json:"host"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "invalid json", http.StatusBadRequest)
+					return
+				}
+				var ttl time.Duration
+				if strings.TrimSpace(body.TTL) != "" {
+					if ttl, err = parseTunnelTTL(body.TTL); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+				}
+				link, err := issueShareLink(r.Context(), store, auth, tun, ttl, body.Path, body.Host)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "share_link_issued", "share link for "+link.Path+" issued, expires "+link.ExpiresAt.UTC().Format(time.RFC3339))
+				writeJSON(w, http.StatusCreated, link)
+			case http.MethodDelete:
+				if _, err := store.RotateShareLinkKey(r.Context(), tun.ID); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "share_links_revoked", "share link signing key rotated")
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		case "expiry":
+			if r.Method != http.MethodPatch {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var body struct {
+				TTL          string    `json:"ttl"`
+				ExpiresAt    time.Time `json:"expires_at"`
+				ExpiryAction string    `json:"expiry_action"`
+				Clear        bool      `json:"clear"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			expiresAt, msg, err := nextTunnelExpiry(tun, body.TTL, body.ExpiresAt, body.Clear, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := store.SetTunnelExpiry(r.Context(), name, expiresAt, strings.TrimSpace(strings.ToLower(body.ExpiryAction))); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "expiry_changed", msg)
+			updated, err := store.GetTunnelByName(r.Context(), name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case "maintenance":
+			if r.Method != http.MethodPatch {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var body MaintenanceInput
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			if err := store.SetTunnelMaintenance(r.Context(), name, body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "maintenance_changed", maintenanceEventMessage(body.Enabled))
+			updated, err := store.GetTunnelByName(r.Context(), name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
 		case "state":
 			if r.Method != http.MethodPatch {
 				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -292,6 +586,10 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 				http.Error(w, "tunnel has no assigned agent", http.StatusConflict)
 				return
 			}
+			if body.DesiredState == "running" && tunnelExpired(tun, time.Now()) {
+				http.Error(w, "tunnel expired; extend it first", http.StatusConflict)
+				return
+			}
 			if err := store.SetTunnelDesiredState(r.Context(), name, body.DesiredState); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -310,6 +608,10 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 				http.Error(w, "tunnel has no assigned agent", http.StatusConflict)
 				return
 			}
+			if tunnelExpired(tun, time.Now()) {
+				http.Error(w, "tunnel expired; extend it first", http.StatusConflict)
+				return
+			}
 			if err := store.SetTunnelDesiredState(r.Context(), name, "running"); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -335,6 +637,131 @@ func ControlPlaneRouter(cfg Config, domains *DomainStore, store *Store, auth *Au
 	return mux
 }
 
+// handleTunnelCaptures serves /api/tunnels/{name}/captures[/{id}[/replay]].
+func handleTunnelCaptures(w http.ResponseWriter, r *http.Request, store *Store, registry *Registry, tun TunnelRecord, rest []string) {
+	if len(rest) == 0 || rest[0] == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		captures, err := store.ListRequestCaptures(r.Context(), tun.ID, captureRingSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, captures)
+		return
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rec, err := store.GetRequestCapture(r.Context(), tun.ID, id)
+	if err != nil {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(rest) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, rec)
+	case len(rest) == 2 && rest[1] == "replay" && r.Method == http.MethodPost:
+		var body CaptureReplayInput
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+		}
+		replayed, err := replayCapture(r.Context(), store, registry, tun, rec, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusCreated, replayed)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type tunnelCredentialInput struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Username  string    `json:"username"`
+	Secret    string    `json:"secret"`
+	TTL       string    `json:"ttl"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleTunnelCredentials serves /api/tunnels/{name}/credentials[/{credential}].
+// A blank secret on create is generated and returned once.
+func handleTunnelCredentials(w http.ResponseWriter, r *http.Request, store *Store, tun TunnelRecord, rest []string) {
+	if len(rest) == 1 {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		credName := normalizeName(rest[0])
+		if err := store.DeleteTunnelCredential(r.Context(), tun.ID, credName); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "credential not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "credential_revoked", "credential "+credName+" revoked")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		creds, err := store.ListTunnelCredentials(r.Context(), tun.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, creds)
+	case http.MethodPost:
+		var body tunnelCredentialInput
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		expiresAt := body.ExpiresAt
+		if strings.TrimSpace(body.TTL) != "" {
+			ttl, err := time.ParseDuration(strings.TrimSpace(body.TTL))
+			if err != nil || ttl <= 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			expiresAt = time.Now().Add(ttl)
+		}
+		kind := strings.TrimSpace(strings.ToLower(body.Kind))
+		if kind == "" {
+			kind = "basic_auth"
+		}
+		secret := strings.TrimSpace(body.Secret)
+		if secret == "" {
+			generated, err := randomString(24)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			secret = generated
+		}
+		cred, err := store.CreateTunnelCredential(r.Context(), tun.ID, body.Name, kind, body.Username, hashSecret(secret), expiresAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = store.AddTunnelEvent(r.Context(), tun.Hostname, "credential_added", "credential "+cred.Name+" added")
+		writeJSON(w, http.StatusCreated, map[string]any{"credential": cred, "secret": secret})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func requireSessionUser(auth *AuthManager, w http.ResponseWriter, r *http.Request) (*UserRecord, bool) {
 	if auth == nil {
 		http.Error(w, "auth not configured", http.StatusServiceUnavailable)
@@ -342,6 +769,7 @@ func requireSessionUser(auth *AuthManager, w http.ResponseWriter, r *http.Reques
 	}
 	user, status, _ := auth.requestUser(r.Context(), r)
 	if status != http.StatusOK || user == nil {
+		metricAuthFailures.Inc("api", "session")
 		http.Error(w, http.StatusText(status), status)
 		return nil, false
 	}
@@ -359,24 +787,58 @@ func normalizeName(v string) string {
 	return v
 }
 
+// errHostnameOverlap is returned when a hostname would serve names another user's tunnel or
+// reservation already covers.
+var errHostnameOverlap = errors.New("hostname overlaps another user's tunnel or reservation")
+
+// resolveTunnelHostname builds and validates a tunnel hostname. A leading "*." label makes it a
+// wildcard that serves every name below it; it must sit below the server hostname or an allowed
+// domain. Overlaps with other users' claims are checked by claimHostname.
 func resolveTunnelHostname(serverHostname string, allowedDomains []string, subdomain, customURL string) (string, error) {
+	serverHostname = strings.ToLower(strings.TrimSpace(serverHostname))
+	hostname := strings.TrimSpace(strings.ToLower(customURL))
 	if subdomain != "" {
-		return subdomain + "." + strings.ToLower(strings.TrimSpace(serverHostname)), nil
+		hostname = subdomain + "." + serverHostname
 	}
-	hostname := strings.TrimSpace(strings.ToLower(customURL))
 	if hostname == "" {
 		return "", fmt.Errorf("hostname required")
 	}
+	if base, wildcard := strings.CutPrefix(hostname, "*."); wildcard || strings.Contains(hostname, "*") {
+		if !wildcard || base == "" || strings.Contains(base, "*") {
+			return "", fmt.Errorf("wildcard must be the whole first label, as in *.app.example.com")
+		}
+		if base == serverHostname {
+			return "", fmt.Errorf("wildcard must be below a subdomain of %s", serverHostname)
+		}
+	}
+	if !hostnameAllowed(serverHostname, allowedDomains, hostname) {
+		return "", fmt.Errorf("domain not allowed")
+	}
+	return hostname, nil
+}
+
+// checkHostnameClaims refuses hostname when it overlaps a claim owned by someone other than
+// ownerUserID.
+func checkHostnameClaims(hostname string, ownerUserID int64, claims []hostnameClaim) error {
+	for _, c := range claims {
+		if c.OwnerUserID != ownerUserID && hostnamesOverlap(hostname, c.Hostname) {
+			return fmt.Errorf("%w (%s)", errHostnameOverlap, c.Hostname)
+		}
+	}
+	return nil
+}
+
+func hostnameAllowed(serverHostname string, allowedDomains []string, hostname string) bool {
 	if hostname == serverHostname || strings.HasSuffix(hostname, "."+serverHostname) {
-		return hostname, nil
+		return true
 	}
 	for _, d := range allowedDomains {
 		d = strings.TrimSpace(strings.ToLower(d))
 		if d != "" && (hostname == d || strings.HasSuffix(hostname, "."+d)) {
-			return hostname, nil
+			return true
 		}
 	}
-	return "", fmt.Errorf("domain not allowed")
+	return false
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
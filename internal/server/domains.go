@@ -1,82 +1,620 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
-// DomainStore persists and loads the allowed domains list.
+const (
+	// Verification states.
+	DomainPending  = "pending"
+	DomainVerified = "verified"
+
+	// Approval states. Member-registered domains wait for an admin.
+	DomainApprovalPending = "pending"
+	DomainApproved        = "approved"
+	DomainRejected        = "rejected"
+
+	// domainChallengePrefix is the TXT record label checked for the verification token.
+	domainChallengePrefix = "_fwdx-challenge."
+	// domainChallengeTXTPrefix precedes the token in the TXT record value.
+	domainChallengeTXTPrefix = "fwdx-verification="
+	// DomainChallengePath is the well-known file that may hold the token instead of a TXT record.
+	DomainChallengePath = "/.well-known/fwdx-verification.txt"
+
+	// domainCheckInterval is how often pending domains are retried.
+	domainCheckInterval = time.Hour
+	// domainReverifyAfter is how long a verification is trusted before it is checked again.
+	domainReverifyAfter = 24 * time.Hour
+	// domainReverifyGrace is how long a verified domain may keep failing re-verification before
+	// it goes back to pending.
+	domainReverifyGrace = 72 * time.Hour
+)
+
+// TXTName is the DNS name that must carry TXTValue to prove ownership.
+func (r DomainRecord) TXTName() string { return domainChallengePrefix + r.Domain }
+
+// TXTValue is the TXT record value that proves ownership.
+func (r DomainRecord) TXTValue() string { return domainChallengeTXTPrefix + r.Token }
+
+// HTTPURL is the URL that may serve the token instead of a TXT record.
+func (r DomainRecord) HTTPURL() string { return "http://" + r.Domain + DomainChallengePath }
+
+// Usable reports whether tunnels may use hostnames under the domain.
+func (r DomainRecord) Usable() bool {
+	return r.Approval == DomainApproved && r.Status == DomainVerified
+}
+
+var errDomainExists = errors.New("domain already registered")
+
+// DomainResolver looks up TXT records. *net.Resolver implements it; tests inject fakes.
+type DomainResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DomainStore manages custom domains in the Store: registration, admin approval and ownership
+// verification.
 type DomainStore struct {
-	mu      sync.RWMutex
-	domains []string
-	path    string
+	store *Store
+
+	mu       sync.RWMutex
+	resolver DomainResolver
+	client   *http.Client
+	hostname string
 }
 
-func NewDomainStore(dataDir string) *DomainStore {
-	path := filepath.Join(dataDir, "allowed_domains.json")
-	ds := &DomainStore{path: path}
-	_ = ds.Load()
+// NewDomainStore returns a DomainStore backed by store. A legacy allowed_domains.json in dataDir
+// is imported once and renamed to allowed_domains.json.imported.
+func NewDomainStore(store *Store, dataDir string) *DomainStore {
+	ds := &DomainStore{
+		store:    store,
+		resolver: net.DefaultResolver,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := ds.importLegacy(context.Background(), filepath.Join(dataDir, "allowed_domains.json")); err != nil {
+		slog.Error("domain import failed", "error", err)
+	}
 	return ds
 }
 
+// SetVerifier replaces the TXT resolver and HTTP client used to verify domains.
+func (d *DomainStore) SetVerifier(resolver DomainResolver, client *http.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if resolver != nil {
+		d.resolver = resolver
+	}
+	if client != nil {
+		d.client = client
+	}
+}
+
+// SetServerHostname sets the server's own hostname. Names equal to or under it can't be
+// registered: any member could prove them by serving the token from a tunnel.
+func (d *DomainStore) SetServerHostname(hostname string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hostname = normalizeDomain(hostname)
+}
+
+// List returns every domain name, whatever its state.
 func (d *DomainStore) List() []string {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-	out := make([]string, len(d.domains))
-	copy(out, d.domains)
+	var out []string
+	for _, rec := range d.Records() {
+		out = append(out, rec.Domain)
+	}
+	return out
+}
+
+// Allowed returns the approved and verified domains. Any tunnel may run on them once created.
+func (d *DomainStore) Allowed() []string {
+	return d.AllowedFor(0, true)
+}
+
+// AllowedFor returns the usable domains a user may create tunnels under: their own and shared
+// ones, or every usable domain for admins.
+func (d *DomainStore) AllowedFor(userID int64, isAdmin bool) []string {
+	list, err := d.store.ListDomains(context.Background(), userID, isAdmin)
+	if err != nil {
+		slog.Error("list domains failed", "error", err)
+		return nil
+	}
+	var out []string
+	for _, rec := range list {
+		if rec.Usable() {
+			out = append(out, rec.Domain)
+		}
+	}
 	return out
 }
 
+// Records returns every domain with its owner and state.
+func (d *DomainStore) Records() []DomainRecord {
+	list, err := d.store.ListDomains(context.Background(), 0, true)
+	if err != nil {
+		slog.Error("list domains failed", "error", err)
+		return nil
+	}
+	return list
+}
+
+func (d *DomainStore) Get(domain string) (DomainRecord, bool) {
+	rec, err := d.store.GetDomain(context.Background(), normalizeDomain(domain))
+	return rec, err == nil
+}
+
+// Register creates a pending-verification domain with a fresh token. Admin registrations are
+// approved immediately; member registrations wait for an admin.
+func (d *DomainStore) Register(ctx context.Context, ownerUserID int64, domain, description string, shared, approved bool) (DomainRecord, error) {
+	domain = normalizeDomain(domain)
+	if !validDomainName(domain) {
+		return DomainRecord{}, fmt.Errorf("invalid domain %q", domain)
+	}
+	d.mu.RLock()
+	hostname := d.hostname
+	d.mu.RUnlock()
+	if hostname != "" && (domain == hostname || strings.HasSuffix(domain, "."+hostname)) {
+		return DomainRecord{}, fmt.Errorf("domain %q is served by this server", domain)
+	}
+	if _, err := d.store.GetDomain(ctx, domain); err == nil {
+		return DomainRecord{}, errDomainExists
+	}
+	token, err := newDomainToken()
+	if err != nil {
+		return DomainRecord{}, err
+	}
+	approval := DomainApprovalPending
+	if approved {
+		approval = DomainApproved
+	}
+	rec, err := d.store.CreateDomain(ctx, DomainRecord{
+		Domain:      domain,
+		OwnerUserID: ownerUserID,
+		Description: strings.TrimSpace(description),
+		Shared:      shared,
+		Approval:    approval,
+		Status:      DomainPending,
+		Token:       token,
+	})
+	if err != nil {
+		return DomainRecord{}, err
+	}
+	_ = d.store.AddDomainEvent(ctx, domain, ownerUserID, "domain_added", "approval "+approval)
+	return rec, nil
+}
+
+// Add registers a server-owned, shared and approved domain. Adding an existing domain is a no-op.
 func (d *DomainStore) Add(domain string) error {
-	domain = strings.TrimSpace(strings.ToLower(domain))
+	domain = normalizeDomain(domain)
 	if domain == "" {
 		return nil
 	}
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	for _, e := range d.domains {
-		if e == domain {
-			return nil
-		}
+	_, err := d.Register(context.Background(), 0, domain, "", true, true)
+	if err == errDomainExists {
+		return nil
 	}
-	d.domains = append(d.domains, domain)
-	return d.saveLocked()
+	return err
 }
 
+// Remove deletes a domain. Removing an unknown domain is a no-op.
 func (d *DomainStore) Remove(domain string) error {
-	domain = strings.TrimSpace(strings.ToLower(domain))
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	for i, e := range d.domains {
-		if e == domain {
-			d.domains = append(d.domains[:i], d.domains[i+1:]...)
-			return d.saveLocked()
-		}
+	if err := d.Delete(context.Background(), 0, domain); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// Delete removes a domain on behalf of actorUserID. It returns sql.ErrNoRows for unknown domains.
+func (d *DomainStore) Delete(ctx context.Context, actorUserID int64, domain string) error {
+	domain = normalizeDomain(domain)
+	if err := d.store.DeleteDomain(ctx, domain); err != nil {
+		return err
 	}
+	_ = d.store.AddDomainEvent(ctx, domain, actorUserID, "domain_removed", "domain removed")
 	return nil
 }
 
-func (d *DomainStore) saveLocked() error {
-	data, err := json.MarshalIndent(d.domains, "", "  ")
+// SetApproval approves or rejects a member's domain.
+func (d *DomainStore) SetApproval(ctx context.Context, actorUserID int64, domain string, approved bool) (DomainRecord, error) {
+	domain = normalizeDomain(domain)
+	approval := DomainRejected
+	if approved {
+		approval = DomainApproved
+	}
+	if err := d.store.SetDomainApproval(ctx, domain, approval); err != nil {
+		return DomainRecord{}, err
+	}
+	_ = d.store.AddDomainEvent(ctx, domain, actorUserID, "domain_"+approval, "domain "+approval)
+	return d.store.GetDomain(ctx, domain)
+}
+
+// Update changes a domain's description and shared flag.
+func (d *DomainStore) Update(ctx context.Context, actorUserID int64, domain, description string, shared bool) (DomainRecord, error) {
+	domain = normalizeDomain(domain)
+	if err := d.store.UpdateDomainDetails(ctx, domain, strings.TrimSpace(description), shared); err != nil {
+		return DomainRecord{}, err
+	}
+	_ = d.store.AddDomainEvent(ctx, domain, actorUserID, "domain_updated", fmt.Sprintf("shared=%t", shared))
+	return d.store.GetDomain(ctx, domain)
+}
+
+// Events returns the domain's audit log, newest first.
+func (d *DomainStore) Events(ctx context.Context, domain string) ([]DomainEventRecord, error) {
+	return d.store.ListDomainEvents(ctx, normalizeDomain(domain), 100)
+}
+
+// Verify checks the domain's TXT record, then its well-known file, and records the result.
+// A verified domain that fails keeps working until it has failed for domainReverifyGrace.
+func (d *DomainStore) Verify(ctx context.Context, domain string) (DomainRecord, error) {
+	rec, err := d.store.GetDomain(ctx, normalizeDomain(domain))
+	if err != nil {
+		return DomainRecord{}, fmt.Errorf("domain %s not found", domain)
+	}
+	d.mu.RLock()
+	resolver, client := d.resolver, d.client
+	d.mu.RUnlock()
+	checkErr := checkDomainOwnership(ctx, resolver, client, rec)
+	return d.recordCheck(ctx, rec, checkErr, time.Now().UTC())
+}
+
+func (d *DomainStore) recordCheck(ctx context.Context, rec DomainRecord, checkErr error, now time.Time) (DomainRecord, error) {
+	before := rec.Status
+	rec.LastCheckedAt = now
+	if checkErr == nil {
+		rec.Status = DomainVerified
+		rec.VerifiedAt = now
+		rec.FailingSince = time.Time{}
+		rec.LastError = ""
+	} else {
+		rec.LastError = checkErr.Error()
+		if rec.FailingSince.IsZero() {
+			rec.FailingSince = now
+		}
+		if rec.Status == DomainVerified && now.Sub(rec.FailingSince) >= domainReverifyGrace {
+			rec.Status = DomainPending
+			slog.Warn("domain lost verification", "domain", rec.Domain, "error", checkErr)
+		}
+	}
+	if err := d.store.UpdateDomainVerification(ctx, rec); err != nil {
+		return rec, err
+	}
+	if rec.Status != before {
+		if rec.Status == DomainVerified {
+			_ = d.store.AddDomainEvent(ctx, rec.Domain, 0, "domain_verified", "ownership verified")
+		} else {
+			_ = d.store.AddDomainEvent(ctx, rec.Domain, 0, "domain_unverified", rec.LastError)
+		}
+	}
+	return rec, checkErr
+}
+
+// verifyDue checks pending domains and verified domains whose last check is older than
+// domainReverifyAfter. It returns the number of domains checked.
+func (d *DomainStore) verifyDue(ctx context.Context, now time.Time) int {
+	n := 0
+	for _, rec := range d.Records() {
+		if rec.Approval == DomainRejected || (rec.Status == DomainVerified && now.Sub(rec.LastCheckedAt) < domainReverifyAfter) {
+			continue
+		}
+		n++
+		if _, err := d.Verify(ctx, rec.Domain); err != nil && rec.Status == DomainVerified {
+			slog.Error("domain re-verification failed", "domain", rec.Domain, "error", err)
+		}
+	}
+	return n
+}
+
+// RunVerification periodically verifies pending domains and re-verifies verified ones until
+// ctx is cancelled.
+func (d *DomainStore) RunVerification(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		d.verifyDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDomainOwnership returns nil when either the TXT record or the well-known file carries
+// the domain's token.
+func checkDomainOwnership(ctx context.Context, resolver DomainResolver, client *http.Client, rec DomainRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	txtErr := checkDomainTXT(ctx, resolver, rec)
+	if txtErr == nil {
+		return nil
+	}
+	httpErr := checkDomainHTTP(ctx, client, rec)
+	if httpErr == nil {
+		return nil
+	}
+	return fmt.Errorf("TXT %s: %v; HTTP %s: %v", rec.TXTName(), txtErr, rec.HTTPURL(), httpErr)
+}
+
+func checkDomainTXT(ctx context.Context, resolver DomainResolver, rec DomainRecord) error {
+	if resolver == nil {
+		return errors.New("no resolver")
+	}
+	values, err := resolver.LookupTXT(ctx, rec.TXTName())
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(d.path, data, 0600)
+	for _, v := range values {
+		if strings.TrimSpace(v) == rec.TXTValue() {
+			return nil
+		}
+	}
+	return errors.New("token not found")
 }
 
-func (d *DomainStore) Load() error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	data, err := os.ReadFile(d.path)
+func checkDomainHTTP(ctx context.Context, client *http.Client, rec DomainRecord) error {
+	if client == nil {
+		return errors.New("no http client")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rec.HTTPURL(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != rec.Token {
+		return errors.New("token mismatch")
+	}
+	return nil
+}
+
+func newDomainToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.TrimSpace(strings.ToLower(domain)), ".")
+}
+
+// validDomainName accepts lowercase DNS names with at least two labels. IP literals and names
+// with an all-numeric top-level label are refused so verification never fetches from an address.
+func validDomainName(domain string) bool {
+	if len(domain) > 253 || !strings.Contains(domain, ".") {
+		return false
+	}
+	if _, err := netip.ParseAddr(domain); err == nil {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// importLegacy moves domains from the old JSON file into the store. Both file formats are read:
+// a list of bare names and the list of records with verification state. Imported domains are
+// server-owned, shared and approved so existing tunnels keep working; bare names start out
+// verified and are re-verified on the next check.
+func (d *DomainStore) importLegacy(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			d.domains = nil
 			return nil
 		}
 		return err
 	}
-	return json.Unmarshal(data, &d.domains)
+	var records []DomainRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		var names []string
+		if err := json.Unmarshal(data, &names); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		records = nil
+		for _, name := range names {
+			records = append(records, DomainRecord{Domain: name, Status: DomainVerified})
+		}
+	}
+	for _, rec := range records {
+		rec.Domain = normalizeDomain(rec.Domain)
+		if rec.Domain == "" {
+			continue
+		}
+		if _, err := d.store.GetDomain(ctx, rec.Domain); err == nil {
+			continue
+		}
+		if rec.Token == "" {
+			if rec.Token, err = newDomainToken(); err != nil {
+				return err
+			}
+		}
+		if rec.Status == "" {
+			rec.Status = DomainVerified
+		}
+		rec.OwnerUserID, rec.Shared, rec.Approval = 0, true, DomainApproved
+		if _, err := d.store.CreateDomain(ctx, rec); err != nil {
+			return err
+		}
+		_ = d.store.AddDomainEvent(ctx, rec.Domain, 0, "domain_imported", "imported from allowed_domains.json")
+	}
+	return os.Rename(path, path+".imported")
+}
+
+// domainView is a domain with the records its owner must publish to verify it.
+type domainView struct {
+	DomainRecord
+	TXTName  string `json:"txt_name"`
+	TXTValue string `json:"txt_value"`
+	HTTPURL  string `json:"http_url"`
+}
+
+func newDomainView(rec DomainRecord) domainView {
+	return domainView{DomainRecord: rec, TXTName: rec.TXTName(), TXTValue: rec.TXTValue(), HTTPURL: rec.HTTPURL()}
+}
+
+func newDomainViews(list []DomainRecord) []domainView {
+	out := make([]domainView, 0, len(list))
+	for _, rec := range list {
+		out = append(out, newDomainView(rec))
+	}
+	return out
+}
+
+// handleDomainsAPI serves /api/domains. Members see and manage their own domains and see shared
+// ones; registering, verifying and deleting needs ownership, approving and sharing needs admin.
+func handleDomainsAPI(w http.ResponseWriter, r *http.Request, domains *DomainStore, user *UserRecord, rest []string) {
+	isAdmin := user.Role == "admin"
+	if len(rest) == 0 || rest[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := domains.store.ListDomains(r.Context(), user.ID, isAdmin)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			views := newDomainViews(list)
+			for i := range views {
+				if !isAdmin && views[i].OwnerUserID != user.ID {
+					views[i].Token, views[i].TXTValue = "", ""
+				}
+			}
+			writeJSON(w, http.StatusOK, views)
+		case http.MethodPost:
+			var body struct {
+				Domain      string `json:"domain"`
+				Description string `json:"description"`
+				Shared      bool   `json:"shared"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			if body.Shared && !isAdmin {
+				http.Error(w, "only admins can share domains", http.StatusForbidden)
+				return
+			}
+			rec, err := domains.Register(r.Context(), user.ID, body.Domain, body.Description, body.Shared, isAdmin)
+			if err == errDomainExists {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, newDomainView(rec))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	rec, err := domains.store.GetDomain(r.Context(), normalizeDomain(rest[0]))
+	if err != nil || (!isAdmin && rec.OwnerUserID != user.ID) {
+		http.Error(w, "domain not found", http.StatusNotFound)
+		return
+	}
+	action := ""
+	if len(rest) > 1 {
+		action = rest[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, newDomainView(rec))
+	case action == "" && r.Method == http.MethodPatch:
+		var body struct {
+			Description *string `json:"description"`
+			Shared      *bool   `json:"shared"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		description, shared := rec.Description, rec.Shared
+		if body.Description != nil {
+			description = *body.Description
+		}
+		if body.Shared != nil {
+			if !isAdmin {
+				http.Error(w, "only admins can share domains", http.StatusForbidden)
+				return
+			}
+			shared = *body.Shared
+		}
+		updated, err := domains.Update(r.Context(), user.ID, rec.Domain, description, shared)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, newDomainView(updated))
+	case action == "" && r.Method == http.MethodDelete:
+		if err := domains.Delete(r.Context(), user.ID, rec.Domain); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "verify" && r.Method == http.MethodPost:
+		updated, _ := domains.Verify(r.Context(), rec.Domain)
+		writeJSON(w, http.StatusOK, newDomainView(updated))
+	case (action == "approve" || action == "reject") && r.Method == http.MethodPost:
+		if !isAdmin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		updated, err := domains.SetApproval(r.Context(), user.ID, rec.Domain, action == "approve")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, newDomainView(updated))
+	case action == "events" && r.Method == http.MethodGet:
+		events, err := domains.Events(r.Context(), rec.Domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	case action == "" || action == "verify" || action == "approve" || action == "reject" || action == "events":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
 }
@@ -1,15 +1,22 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewDomainStore_Load_Empty(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 	list := ds.List()
 	if len(list) != 0 {
 		t.Errorf("List() = %v, want []", list)
@@ -18,7 +25,7 @@ func TestNewDomainStore_Load_Empty(t *testing.T) {
 
 func TestDomainStore_Add_List(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 
 	err := ds.Add("my.domain")
 	if err != nil {
@@ -32,7 +39,7 @@ func TestDomainStore_Add_List(t *testing.T) {
 
 func TestDomainStore_Add_Normalize(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 
 	_ = ds.Add("  MY.DOMAIN  ")
 	list := ds.List()
@@ -43,7 +50,7 @@ func TestDomainStore_Add_Normalize(t *testing.T) {
 
 func TestDomainStore_Add_Idempotent(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 
 	_ = ds.Add("my.domain")
 	_ = ds.Add("my.domain")
@@ -55,7 +62,7 @@ func TestDomainStore_Add_Idempotent(t *testing.T) {
 
 func TestDomainStore_Add_EmptyNoOp(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 
 	err := ds.Add("")
 	if err != nil {
@@ -72,7 +79,7 @@ func TestDomainStore_Add_EmptyNoOp(t *testing.T) {
 
 func TestDomainStore_Remove(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 	_ = ds.Add("a.domain")
 	_ = ds.Add("b.domain")
 
@@ -88,7 +95,7 @@ func TestDomainStore_Remove(t *testing.T) {
 
 func TestDomainStore_Remove_Nonexistent(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 	err := ds.Remove("nonexistent.domain")
 	if err != nil {
 		t.Fatal(err)
@@ -97,12 +104,12 @@ func TestDomainStore_Remove_Nonexistent(t *testing.T) {
 
 func TestDomainStore_Persistence(t *testing.T) {
 	dir := t.TempDir()
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 	_ = ds.Add("persist.domain")
 	_ = ds.Add("other.domain")
 
 	// New store loading from same path
-	ds2 := NewDomainStore(dir)
+	ds2 := newTestDomainStore(t, dir)
 	list := ds2.List()
 	if len(list) != 2 {
 		t.Fatalf("after reload List() = %v", list)
@@ -123,8 +130,471 @@ func TestDomainStore_Load_NoFile(t *testing.T) {
 	if _, err := os.Stat(path); err == nil {
 		t.Fatal("file should not exist yet")
 	}
-	ds := NewDomainStore(dir)
+	ds := newTestDomainStore(t, dir)
 	if len(ds.List()) != 0 {
 		t.Error("Load with no file should leave list empty")
 	}
 }
+
+func newTestDomainStore(t *testing.T, dir string) *DomainStore {
+	t.Helper()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return NewDomainStore(store, dir)
+}
+
+type fakeTXT map[string][]string
+
+func (f fakeTXT) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if v, ok := f[name]; ok {
+		return v, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestDomainStore_AddIsPending(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	if err := ds.Add("shop.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := ds.Get("shop.example.org")
+	if !ok || rec.Status != DomainPending || len(rec.Token) != 32 {
+		t.Fatalf("rec=%+v", rec)
+	}
+	if len(ds.Allowed()) != 0 {
+		t.Fatal("pending domain must not be allowed")
+	}
+	if _, err := resolveTunnelHostname("tunnel.example.com", ds.Allowed(), "", "app.shop.example.org"); err == nil {
+		t.Fatal("expected pending domain to be refused for tunnel hostnames")
+	}
+}
+
+func TestDomainStore_RefusesAddresses(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	for _, domain := range []string{"10.0.0.1", "127.0.0.1", "169.254.169.254", "1.2.3.4.5", "shop.123", "::1"} {
+		if _, err := ds.Register(context.Background(), 0, domain, "", false, true); err == nil {
+			t.Fatalf("%s: expected error", domain)
+		}
+	}
+	if _, err := ds.Register(context.Background(), 0, "123.example.org", "", false, true); err != nil {
+		t.Fatalf("numeric leading label: %v", err)
+	}
+}
+
+func TestDomainStore_RefusesServerHostname(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	ds.SetServerHostname("Tunnel.Example.com")
+	for _, domain := range []string{"tunnel.example.com", "app.tunnel.example.com", "a.b.tunnel.example.com"} {
+		if _, err := ds.Register(context.Background(), 0, domain, "", false, true); err == nil {
+			t.Fatalf("%s: expected error", domain)
+		}
+	}
+	for _, domain := range []string{"example.com", "mytunnel.example.com"} {
+		if _, err := ds.Register(context.Background(), 0, domain, "", false, true); err != nil {
+			t.Fatalf("%s: %v", domain, err)
+		}
+	}
+}
+
+func TestDomainStore_VerifyTXT(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDomainStore(t, dir)
+	_ = ds.Add("shop.example.org")
+	rec, _ := ds.Get("shop.example.org")
+	txt := fakeTXT{"_fwdx-challenge.shop.example.org": {"fwdx-verification=wrong"}}
+	ds.SetVerifier(txt, &http.Client{Transport: failingTransport{}})
+
+	got, err := ds.Verify(context.Background(), "shop.example.org")
+	if err == nil || got.Status != DomainPending || got.LastError == "" {
+		t.Fatalf("wrong token verified: rec=%+v err=%v", got, err)
+	}
+	txt["_fwdx-challenge.shop.example.org"] = []string{"other", rec.TXTValue()}
+	got, err = ds.Verify(context.Background(), "shop.example.org")
+	if err != nil || got.Status != DomainVerified || got.VerifiedAt.IsZero() || got.LastError != "" {
+		t.Fatalf("rec=%+v err=%v", got, err)
+	}
+	if !reflect.DeepEqual(ds.Allowed(), []string{"shop.example.org"}) {
+		t.Fatalf("Allowed() = %v", ds.Allowed())
+	}
+	if _, err := resolveTunnelHostname("tunnel.example.com", ds.Allowed(), "", "app.shop.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newTestDomainStore(t, dir)
+	if r, _ := reloaded.Get("shop.example.org"); r.Status != DomainVerified || r.Token != rec.Token {
+		t.Fatalf("state not persisted: %+v", r)
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestDomainStore_VerifyHTTP(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	_ = ds.Add("shop.example.org")
+	rec, _ := ds.Get("shop.example.org")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "shop.example.org" || r.URL.Path != DomainChallengePath {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(rec.Token + "\n"))
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}}
+	ds.SetVerifier(fakeTXT{}, client)
+	got, err := ds.Verify(context.Background(), "shop.example.org")
+	if err != nil || got.Status != DomainVerified {
+		t.Fatalf("rec=%+v err=%v", got, err)
+	}
+}
+
+func TestDomainStore_ReverificationGrace(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	_ = ds.Add("shop.example.org")
+	rec, _ := ds.Get("shop.example.org")
+	txt := fakeTXT{rec.TXTName(): {rec.TXTValue()}}
+	ds.SetVerifier(txt, &http.Client{Transport: failingTransport{}})
+	if _, err := ds.Verify(context.Background(), "shop.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if n := ds.verifyDue(context.Background(), now); n != 0 {
+		t.Fatalf("checked %d freshly verified domains", n)
+	}
+
+	delete(txt, rec.TXTName())
+	start := now.Add(domainReverifyAfter)
+	rec, _ = ds.Get("shop.example.org")
+	got, err := ds.recordCheck(context.Background(), rec, errors.New("token not found"), start)
+	if err == nil || got.Status != DomainVerified {
+		t.Fatalf("verification dropped before the grace period: %+v", got)
+	}
+	if got, _ := ds.recordCheck(context.Background(), got, errors.New("token not found"), start.Add(domainReverifyGrace)); got.Status != DomainPending {
+		t.Fatalf("verification kept after the grace period: %+v", got)
+	}
+	if len(ds.Allowed()) != 0 {
+		t.Fatal("expired verification still allowed")
+	}
+	txt[rec.TXTName()] = []string{rec.TXTValue()}
+	if n := ds.verifyDue(context.Background(), time.Now()); n != 1 || len(ds.Allowed()) != 1 {
+		t.Fatalf("pending domain not re-verified (checked %d)", n)
+	}
+}
+
+func TestDomainStore_ImportLegacyFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"names":   `["Legacy.Example.com"]`,
+		"records": `[{"domain":"legacy.example.com","status":"verified","token":"abc","created_at":"2026-01-01T00:00:00Z"}]`,
+	} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "allowed_domains.json")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		ds := newTestDomainStore(t, dir)
+		rec, ok := ds.Get("legacy.example.com")
+		if !ok || rec.Status != DomainVerified || rec.Token == "" || rec.Approval != DomainApproved || !rec.Shared || rec.OwnerUserID != 0 {
+			t.Fatalf("%s: legacy import rec=%+v", name, rec)
+		}
+		if name == "records" && rec.Token != "abc" {
+			t.Fatalf("records: token not kept: %+v", rec)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s: legacy file not renamed", name)
+		}
+		if _, err := os.Stat(path + ".imported"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		events, _ := ds.Events(context.Background(), "legacy.example.com")
+		if len(events) != 1 || events[0].EventType != "domain_imported" {
+			t.Fatalf("%s: events=%+v", name, events)
+		}
+	}
+}
+
+func TestDomainStore_OwnershipAndApproval(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	ctx := context.Background()
+	store := ds.store
+	alice, err := store.UpsertUserFromOIDC(ctx, "alice", "alice@example.com", "Alice", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.UpsertUserFromOIDC(ctx, "bob", "bob@example.com", "Bob", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := ds.Register(ctx, alice.ID, "alice.example.org", "Alice's shop", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own.Approval != DomainApprovalPending || own.OwnerEmail != "alice@example.com" || own.Description != "Alice's shop" {
+		t.Fatalf("own=%+v", own)
+	}
+	if _, err := ds.Register(ctx, bob.ID, "ALICE.example.org", "", false, false); err != errDomainExists {
+		t.Fatalf("duplicate registration err=%v", err)
+	}
+	if _, err := ds.Register(ctx, bob.ID, "not a domain", "", false, false); err == nil {
+		t.Fatal("expected invalid domain error")
+	}
+	_ = ds.Add("shared.example.org")
+
+	txt := fakeTXT{}
+	for _, rec := range ds.Records() {
+		txt[rec.TXTName()] = []string{rec.TXTValue()}
+	}
+	ds.SetVerifier(txt, &http.Client{Transport: failingTransport{}})
+	for _, d := range []string{"alice.example.org", "shared.example.org"} {
+		if _, err := ds.Verify(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := ds.AllowedFor(alice.ID, false); !reflect.DeepEqual(got, []string{"shared.example.org"}) {
+		t.Fatalf("unapproved domain usable: %v", got)
+	}
+	if _, err := ds.SetApproval(ctx, 0, "alice.example.org", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := ds.AllowedFor(alice.ID, false); !reflect.DeepEqual(got, []string{"alice.example.org", "shared.example.org"}) {
+		t.Fatalf("alice AllowedFor = %v", got)
+	}
+	if got := ds.AllowedFor(bob.ID, false); !reflect.DeepEqual(got, []string{"shared.example.org"}) {
+		t.Fatalf("bob can use alice's domain: %v", got)
+	}
+	if _, err := resolveTunnelHostname("tunnel.example.com", ds.AllowedFor(bob.ID, false), "", "www.alice.example.org"); err == nil {
+		t.Fatal("expected bob to be refused alice's domain")
+	}
+	if _, err := ds.Update(ctx, alice.ID, "alice.example.org", "shared now", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := ds.AllowedFor(bob.ID, false); len(got) != 2 {
+		t.Fatalf("shared domain not usable by bob: %v", got)
+	}
+	events, err := ds.Events(ctx, "alice.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.EventType)
+	}
+	if !reflect.DeepEqual(types, []string{"domain_updated", "domain_approved", "domain_verified", "domain_added"}) {
+		t.Fatalf("events=%v", types)
+	}
+}
+
+func TestDomainsAPI_MemberRegistrationAndTunnels(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDomainStore(t, dir)
+	cfg := Config{Hostname: "tunnel.example.com"}
+	auth, err := NewAuthManager(context.Background(), cfg, ds.store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(subject, role string) string {
+		raw, _, _, err := auth.IssueSessionForClaims(context.Background(), OIDCClaims{Subject: subject, Email: subject + "@example.com"}, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	admin, alice, bob := session("admin", "admin"), session("alice", "member"), session("bob", "member")
+	srv := httptest.NewServer(ControlPlaneRouter(cfg, NewRegistry(), ds, ds.store, auth))
+	defer srv.Close()
+	call := func(token, method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := call(alice, http.MethodPost, "/api/domains", `{"domain":"alice.example.org","shared":true}`); code != http.StatusForbidden {
+		t.Fatalf("member share status=%d", code)
+	}
+	if code := call(alice, http.MethodPost, "/api/domains", `{"domain":"alice.example.org","description":"shop"}`); code != http.StatusCreated {
+		t.Fatalf("register status=%d", code)
+	}
+	if code := call(bob, http.MethodPost, "/api/domains", `{"domain":"alice.example.org"}`); code != http.StatusConflict {
+		t.Fatalf("duplicate status=%d", code)
+	}
+	if code := call(bob, http.MethodGet, "/api/domains/alice.example.org", ""); code != http.StatusNotFound {
+		t.Fatalf("other member read status=%d", code)
+	}
+	rec, _ := ds.Get("alice.example.org")
+	ds.SetVerifier(fakeTXT{rec.TXTName(): {rec.TXTValue()}}, &http.Client{Transport: failingTransport{}})
+	if code := call(alice, http.MethodPost, "/api/domains/alice.example.org/verify", ""); code != http.StatusOK {
+		t.Fatalf("verify status=%d", code)
+	}
+	tunnelBody := `{"name":"shop","local":"localhost:3000","url":"www.alice.example.org"}`
+	if code := call(alice, http.MethodPost, "/api/tunnels", tunnelBody); code != http.StatusBadRequest {
+		t.Fatalf("tunnel on unapproved domain status=%d", code)
+	}
+	if code := call(alice, http.MethodPost, "/api/domains/alice.example.org/approve", ""); code != http.StatusForbidden {
+		t.Fatalf("member approve status=%d", code)
+	}
+	if code := call(admin, http.MethodPost, "/api/domains/alice.example.org/approve", ""); code != http.StatusOK {
+		t.Fatalf("approve status=%d", code)
+	}
+	if code := call(bob, http.MethodPost, "/api/tunnels", `{"name":"bob-shop","local":"localhost:3000","url":"bob.alice.example.org"}`); code != http.StatusBadRequest {
+		t.Fatalf("tunnel on someone else's domain status=%d", code)
+	}
+	if code := call(alice, http.MethodPost, "/api/tunnels", tunnelBody); code != http.StatusCreated {
+		t.Fatalf("tunnel on own domain status=%d", code)
+	}
+	if code := call(alice, http.MethodDelete, "/api/domains/alice.example.org", ""); code != http.StatusNoContent {
+		t.Fatalf("delete status=%d", code)
+	}
+	events, _ := ds.Events(context.Background(), "alice.example.org")
+	if len(events) != 4 || events[0].EventType != "domain_removed" {
+		t.Fatalf("events=%+v", events)
+	}
+}
+
+func TestResolveTunnelHostname_Wildcard(t *testing.T) {
+	existing := []hostnameClaim{
+		{OwnerUserID: 1, Hostname: "*.acme.tunnel.example.com"},
+		{OwnerUserID: 2, Hostname: "api.beta.tunnel.example.com"},
+	}
+	resolve := func(owner int64, subdomain, url string) (string, error) {
+		hostname, err := resolveTunnelHostname("tunnel.example.com", []string{"shop.example.org"}, subdomain, url)
+		if err != nil {
+			return "", err
+		}
+		return hostname, checkHostnameClaims(hostname, owner, existing)
+	}
+
+	if got, err := resolve(3, "*.gamma", ""); err != nil || got != "*.gamma.tunnel.example.com" {
+		t.Fatalf("got %q err=%v", got, err)
+	}
+	if _, err := resolve(3, "", "*.app.shop.example.org"); err != nil {
+		t.Fatalf("custom domain wildcard: %v", err)
+	}
+	// The owner may add exact or narrower tunnels under their own wildcard.
+	if _, err := resolve(1, "www.acme", ""); err != nil {
+		t.Fatalf("owner exact under own wildcard: %v", err)
+	}
+	// A wildcard covers one label, so deeper names belong to nobody yet.
+	if _, err := resolve(2, "*.eu.acme", ""); err != nil {
+		t.Fatalf("wildcard two labels under another wildcard: %v", err)
+	}
+	for _, c := range []struct {
+		owner          int64
+		subdomain, url string
+	}{
+		{2, "www.acme", ""},
+		{1, "*.beta", ""},
+	} {
+		if _, err := resolve(c.owner, c.subdomain, c.url); !errors.Is(err, errHostnameOverlap) {
+			t.Fatalf("%+v: err=%v want overlap", c, err)
+		}
+	}
+	for _, bad := range []string{"*.tunnel.example.com", "app.*.tunnel.example.com", "**.tunnel.example.com", "*.other.example.net"} {
+		if _, err := resolve(3, "", bad); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestClaimHostname_DomainWildcard(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	ctx := context.Background()
+	store := ds.store
+	alice, err := store.UpsertUserFromOIDC(ctx, "alice", "alice@example.com", "Alice", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.UpsertUserFromOIDC(ctx, "bob", "bob@example.com", "Bob", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := store.UpsertUserFromOIDC(ctx, "root", "root@example.com", "Root", nil, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Register(ctx, alice.ID, "alice.example.org", "", true, true); err != nil {
+		t.Fatal(err)
+	}
+	_ = ds.Add("shared.example.org")
+	txt := fakeTXT{}
+	for _, rec := range ds.Records() {
+		txt[rec.TXTName()] = []string{rec.TXTValue()}
+	}
+	ds.SetVerifier(txt, &http.Client{Transport: failingTransport{}})
+	for _, d := range []string{"alice.example.org", "shared.example.org"} {
+		if _, err := ds.Verify(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ds.Update(ctx, 0, "shared.example.org", "", true); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Hostname: "tunnel.example.com"}
+	claim := func(user UserRecord, url string) error {
+		_, err := claimHostname(ctx, cfg, store, ds, &user, "", url)
+		return err
+	}
+
+	for _, url := range []string{"*.shared.example.org", "*.alice.example.org"} {
+		if err := claim(bob, url); !errors.Is(err, errHostnamePolicy) {
+			t.Fatalf("bob %s: err=%v want policy refusal", url, err)
+		}
+	}
+	for _, c := range []struct {
+		user UserRecord
+		url  string
+	}{
+		{bob, "*.bob.shared.example.org"},
+		{alice, "*.alice.example.org"},
+		{admin, "*.shared.example.org"},
+	} {
+		if err := claim(c.user, c.url); err != nil {
+			t.Fatalf("%s %s: %v", c.user.Email, c.url, err)
+		}
+	}
+}
+
+func TestClaimHostname_WildcardOverlap(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	ctx := context.Background()
+	store := ds.store
+	alice, err := store.UpsertUserFromOIDC(ctx, "alice", "alice@example.com", "Alice", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.UpsertUserFromOIDC(ctx, "bob", "bob@example.com", "Bob", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnel(ctx, alice.ID, "acme", "*.acme.tunnel.example.com", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Hostname: "tunnel.example.com"}
+	if _, err := claimHostname(ctx, cfg, store, ds, &alice, "www.acme", ""); err != nil {
+		t.Fatalf("owner exact under own wildcard: %v", err)
+	}
+	if _, err := claimHostname(ctx, cfg, store, ds, &bob, "www.acme", ""); !errors.Is(err, errHostnameOverlap) {
+		t.Fatalf("www.acme: err=%v want overlap", err)
+	}
+	// The wildcard covers one label only, so names two labels deeper are free.
+	for _, subdomain := range []string{"a.b.acme", "*.eu.acme"} {
+		if _, err := claimHostname(ctx, cfg, store, ds, &bob, subdomain, ""); err != nil {
+			t.Fatalf("%s: %v", subdomain, err)
+		}
+	}
+}
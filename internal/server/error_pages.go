@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxErrorPageBytes bounds uploaded error page templates.
+const maxErrorPageBytes = 256 << 10
+
+// errorPageStatuses are the proxy responses that can be replaced with a custom page.
+var errorPageStatuses = []int{
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+// proxyErrorFunc writes a proxy error response and returns the number of body bytes written.
+type proxyErrorFunc func(status int, msg string) int
+
+// errorPageData is the template context for error pages.
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	Hostname   string
+	Path       string
+	RequestID  string
+}
+
+func errorPageStatusAllowed(status int) bool {
+	for _, s := range errorPageStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func parseErrorPage(status int, raw string) (*template.Template, error) {
+	if !errorPageStatusAllowed(status) {
+		return nil, fmt.Errorf("status must be one of 401, 403, 404, 429, 502, 503")
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil, fmt.Errorf("template is empty")
+	}
+	tpl, err := template.New("error_page").Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return tpl, nil
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// wantsJSONError reports whether the client asked for JSON rather than a page.
+func wantsJSONError(r *http.Request) bool {
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, "+json")
+}
+
+// writeProxyError writes an error response for the public proxy. API clients get JSON; browsers get
+// the tunnel's or the server's custom page when one exists, and plain text otherwise. It returns
+// the number of body bytes written.
+func writeProxyError(w http.ResponseWriter, r *http.Request, store *Store, tunnelID int64, status int, msg, requestID string) int {
+	if requestID != "" {
+		w.Header().Set("X-Request-Id", requestID)
+	}
+	if wantsJSONError(r) {
+		body, _ := json.Marshal(map[string]any{"error": msg, "status": status, "request_id": requestID})
+		body = append(body, '\n')
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+		return len(body)
+	}
+	if page := renderErrorPage(r, store, tunnelID, status, msg, requestID); page != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		_, _ = w.Write(page)
+		return len(page)
+	}
+	http.Error(w, msg, status)
+	return len(msg) + 1
+}
+
+func renderErrorPage(r *http.Request, store *Store, tunnelID int64, status int, msg, requestID string) []byte {
+	if store == nil || !errorPageStatusAllowed(status) {
+		return nil
+	}
+	raw, err := store.ResolveErrorPage(r.Context(), tunnelID, status)
+	if err != nil {
+		return nil
+	}
+	tpl, err := parseErrorPage(status, raw)
+	if err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    msg,
+		Hostname:   hostWithoutPort(r.Host),
+		Path:       r.URL.Path,
+		RequestID:  requestID,
+	}); err != nil {
+		slog.Error("error page render failed", "tunnel_id", tunnelID, "status", status, "error", err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// handleErrorPages serves GET on the collection and PUT/DELETE on {status} for either a tunnel's
+// pages or, with tunnelID 0, the server-wide pages. PUT takes the raw HTML template as the body.
+func handleErrorPages(w http.ResponseWriter, r *http.Request, store *Store, tunnelID int64, rest []string, onChange func(ctx context.Context, msg string)) {
+	if len(rest) == 0 || rest[0] == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pages, err := store.ListErrorPages(r.Context(), tunnelID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, pages)
+		return
+	}
+	status, err := strconv.Atoi(rest[0])
+	if err != nil || len(rest) != 1 || !errorPageStatusAllowed(status) {
+		http.Error(w, "error page status must be one of 401, 403, 404, 429, 502, 503", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		raw, err := io.ReadAll(io.LimitReader(r.Body, maxErrorPageBytes+1))
+		if err != nil {
+			http.Error(w, "read body failed", http.StatusBadRequest)
+			return
+		}
+		if len(raw) > maxErrorPageBytes {
+			http.Error(w, fmt.Sprintf("template too large (max %d bytes)", maxErrorPageBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if _, err := parseErrorPage(status, string(raw)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := store.SetErrorPage(r.Context(), tunnelID, status, string(raw)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if onChange != nil {
+			onChange(r.Context(), fmt.Sprintf("error page %d updated", status))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := store.DeleteErrorPage(r.Context(), tunnelID, status); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "error page not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if onChange != nil {
+			onChange(r.Context(), fmt.Sprintf("error page %d removed", status))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyHandler_ErrorPages(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetErrorPage(ctx, 0, http.StatusNotFound, `<h1>nothing at {{.Hostname}}</h1>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetErrorPage(ctx, 0, http.StatusBadGateway, `<h1>server offline</h1>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetErrorPage(ctx, tun.ID, http.StatusBadGateway, `<h1>{{.Hostname}} is offline</h1><p>ref {{.RequestID}}</p>`); err != nil {
+		t.Fatal(err)
+	}
+	handler := ProxyHandlerWithConfig(NewRegistry(), Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+	do := func(host, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		req.Host = host
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("app.example.com", "text/html")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status=%d want 502", rec.Code)
+	}
+	id := rec.Header().Get("X-Request-Id")
+	if id == "" || !strings.Contains(rec.Body.String(), "app.example.com is offline") || !strings.Contains(rec.Body.String(), id) {
+		t.Fatalf("unexpected tunnel page id=%q body=%q", id, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("content-type=%q", ct)
+	}
+
+	rec = do("missing.example.com", "")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "nothing at missing.example.com") {
+		t.Fatalf("server-wide 404 page: status=%d body=%q", rec.Code, rec.Body.String())
+	}
+
+	rec = do("app.example.com", "application/json")
+	var body struct {
+		Error     string `json:"error"`
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json error body: %v (%q)", err, rec.Body.String())
+	}
+	if body.Status != http.StatusBadGateway || body.Error != "tunnel unavailable" || body.RequestID == "" {
+		t.Fatalf("unexpected json error %+v", body)
+	}
+
+	if err := store.DeleteTunnelByName(ctx, "app"); err != nil {
+		t.Fatal(err)
+	}
+	pages, err := store.ListErrorPages(ctx, tun.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 0 {
+		t.Fatalf("expected tunnel pages removed with tunnel, got %d", len(pages))
+	}
+}
+
+func TestHandleErrorPages_Validation(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	put := func(status, body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/error-pages/"+status, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handleErrorPages(rec, req, store, 0, []string{status}, nil)
+		return rec.Code
+	}
+	if code := put("500", "<p>x</p>"); code != http.StatusNotFound {
+		t.Fatalf("unsupported status code=%d want 404", code)
+	}
+	if code := put("403", "{{.Broken"); code != http.StatusBadRequest {
+		t.Fatalf("bad template code=%d want 400", code)
+	}
+	if code := put("403", "<p>{{.Message}}</p>"); code != http.StatusNoContent {
+		t.Fatalf("valid template code=%d want 204", code)
+	}
+	pages, err := store.ListErrorPages(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Status != http.StatusForbidden {
+		t.Fatalf("unexpected pages %+v", pages)
+	}
+}
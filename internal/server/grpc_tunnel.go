@@ -2,7 +2,8 @@ package server
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -12,7 +13,11 @@ import (
 	"time"
 
 	tunnelv1 "github.com/BRAVO68WEB/fwdx/api/tunnel/v1"
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
@@ -46,6 +51,13 @@ type GrpcTunnelConn struct {
 // GetRemoteAddr implements TunnelConnection.
 func (c *GrpcTunnelConn) GetRemoteAddr() string { return c.remoteAddr }
 
+// Pending reports how many requests are waiting for the agent to respond.
+func (c *GrpcTunnelConn) Pending() int {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	return len(c.pending)
+}
+
 // EnqueueRequest implements TunnelConnection. Sends the request on the gRPC stream and waits for the response.
 func (c *GrpcTunnelConn) EnqueueRequest(ctx context.Context, pr *ProxyRequest) (resp *ProxyResponse, closed bool) {
 	c.closedMu.Lock()
@@ -69,39 +81,55 @@ func (c *GrpcTunnelConn) EnqueueRequest(ctx context.Context, pr *ProxyRequest) (
 		c.pendingMu.Unlock()
 	}()
 
+	spanAttrs := trace.WithAttributes(attribute.String("fwdx.tunnel.hostname", c.hostname), attribute.String("fwdx.tunnel.request_id", pr.ID))
+	enqueueCtx, enqueueSpan := tracing.Tracer().Start(ctx, "tunnel enqueue", trace.WithSpanKind(trace.SpanKindProducer), spanAttrs)
 	headers := make(map[string]string)
 	for k, vv := range pr.Header {
 		if len(vv) > 0 {
 			headers[k] = strings.Join(vv, ", ")
 		}
 	}
+	// The agent continues the trace from the enqueue span; app headers are left as sent.
+	meta := make(map[string]string)
+	tracing.Inject(enqueueCtx, meta)
 	msg := &tunnelv1.ServerMessage{
 		Message: &tunnelv1.ServerMessage_ProxyRequest{
 			ProxyRequest: &tunnelv1.ProxyRequest{
-				Id:      pr.ID,
-				Method:  pr.Method,
-				Path:    pr.Path,
-				Query:   pr.Query,
-				Headers: headers,
-				Body:    pr.Body,
+				Id:       pr.ID,
+				Method:   pr.Method,
+				Path:     pr.Path,
+				Query:    pr.Query,
+				Headers:  headers,
+				Body:     pr.Body,
+				Metadata: meta,
 			},
 		},
 	}
 
 	select {
 	case c.sendCh <- msg:
+		enqueueSpan.End()
 	case <-ctx.Done():
+		enqueueSpan.SetStatus(codes.Error, "canceled before send")
+		enqueueSpan.End()
 		return nil, true
 	}
 
+	_, waitSpan := tracing.Tracer().Start(ctx, "tunnel wait", spanAttrs)
+	defer waitSpan.End()
 	timeout := time.NewTimer(60 * time.Second)
 	defer timeout.Stop()
 	select {
 	case r := <-respCh:
+		if r == nil {
+			waitSpan.SetStatus(codes.Error, "tunnel closed")
+		}
 		return r, r == nil
 	case <-ctx.Done():
+		waitSpan.SetStatus(codes.Error, "canceled")
 		return nil, true
 	case <-timeout.C:
+		waitSpan.SetStatus(codes.Error, "agent timeout")
 		return nil, true
 	}
 }
@@ -145,6 +173,8 @@ func newGrpcTunnelServer(registry *Registry, allowedDomains func() []string, ser
 }
 
 func (s *grpcTunnelServer) Connect(stream grpc.BidiStreamingServer[tunnelv1.ClientMessage, tunnelv1.ServerMessage]) error {
+	metricGrpcStreams.Add(1)
+	defer metricGrpcStreams.Add(-1)
 	msg, err := stream.Recv()
 	if err != nil {
 		return err
@@ -178,6 +208,7 @@ func (s *grpcTunnelServer) Connect(stream grpc.BidiStreamingServer[tunnelv1.Clie
 		token = strings.TrimSpace(v[0][7:])
 	}
 	if token == "" {
+		metricAuthFailures.Inc("grpc", "agent_credential")
 		_ = stream.Send(&tunnelv1.ServerMessage{
 			Message: &tunnelv1.ServerMessage_RegisterAck{RegisterAck: &tunnelv1.RegisterAck{Ok: false, Error: "unauthorized"}},
 		})
@@ -185,6 +216,7 @@ func (s *grpcTunnelServer) Connect(stream grpc.BidiStreamingServer[tunnelv1.Clie
 	}
 	agent, err := s.store.GetAgentByCredentialHash(stream.Context(), hashCredential(token))
 	if err != nil || agent.Status == "revoked" {
+		metricAuthFailures.Inc("grpc", "agent_credential")
 		_ = stream.Send(&tunnelv1.ServerMessage{
 			Message: &tunnelv1.ServerMessage_RegisterAck{RegisterAck: &tunnelv1.RegisterAck{Ok: false, Error: "unauthorized"}},
 		})
@@ -197,7 +229,21 @@ func (s *grpcTunnelServer) Connect(stream grpc.BidiStreamingServer[tunnelv1.Clie
 		})
 		return nil
 	}
+	if tunnelExpired(tunnelRec, time.Now()) {
+		_ = stream.Send(&tunnelv1.ServerMessage{
+			Message: &tunnelv1.ServerMessage_RegisterAck{RegisterAck: &tunnelv1.RegisterAck{Ok: false, Error: "tunnel expired"}},
+		})
+		return nil
+	}
 	hostname := strings.TrimSpace(strings.ToLower(tunnelRec.Hostname))
+	if s.allowedDomains != nil {
+		if _, err := resolveTunnelHostname(s.serverHostname, s.allowedDomains(), "", hostname); err != nil {
+			_ = stream.Send(&tunnelv1.ServerMessage{
+				Message: &tunnelv1.ServerMessage_RegisterAck{RegisterAck: &tunnelv1.RegisterAck{Ok: false, Error: "domain not verified"}},
+			})
+			return nil
+		}
+	}
 
 	peerAddr := "unknown"
 	if p, ok := peer.FromContext(stream.Context()); ok && p.Addr != nil {
@@ -227,10 +273,10 @@ func (s *grpcTunnelServer) Connect(stream grpc.BidiStreamingServer[tunnelv1.Clie
 		_ = s.store.TouchAgent(context.Background(), agent.ID, "offline")
 		_ = s.store.UpdateTunnelStateByName(context.Background(), tunnelName, "", "offline", "stream closed", time.Now())
 		_ = s.store.AddTunnelEvent(context.Background(), hostname, "disconnect", "tunnel stream closed")
-		log.Printf("[fwdx] tunnel closed tunnel=%s hostname=%s", tunnelName, hostname)
+		slog.Info("tunnel closed", "tunnel", tunnelName, "hostname", hostname, "agent", agent.Name)
 	}()
 
-	log.Printf("[fwdx] tunnel registered tunnel=%s hostname=%s local=%s agent=%s from=%s", tunnelName, hostname, localURL, agent.Name, peerAddr)
+	slog.Info("tunnel registered", "tunnel", tunnelName, "hostname", hostname, "local", localURL, "agent", agent.Name, "peer", peerAddr)
 	_ = s.store.TouchAgent(stream.Context(), agent.ID, "connected")
 	_ = s.store.SetTunnelDesiredState(stream.Context(), tunnelName, "running")
 	_ = s.store.UpdateTunnelStateByName(stream.Context(), tunnelName, localURL, "running", "", time.Now())
@@ -277,29 +323,43 @@ func (s *grpcTunnelServer) Connect(stream grpc.BidiStreamingServer[tunnelv1.Clie
 				}
 			}
 		}
+		if report := msg.GetMirrorReport(); report != nil {
+			if err := recordMirrorReport(stream.Context(), s.store, tunnelRec.ID, report); err != nil {
+				slog.Error("mirror report failed", "tunnel", tunnelName, "error", err)
+			}
+		}
 	}
 }
 
 // RunGrpcServer runs the gRPC tunnel server on the given listener (TLS or plain).
 // Exported for tests that need to run gRPC with a custom registry.
 func RunGrpcServer(ln net.Listener, registry *Registry, allowedDomains func() []string, serverHostname string, useTLS bool, certFile, keyFile string, stores ...*Store) error {
-	maxBody := maxProxyBodyBytes()
-	opts := []grpc.ServerOption{
-		grpc.MaxRecvMsgSize(maxBody + (1 << 20)),
-		grpc.MaxSendMsgSize(maxBody + (1 << 20)),
-	}
+	var tlsCfg *tls.Config
 	if useTLS && certFile != "" && keyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
 			return err
 		}
-		opts = append(opts, grpc.Creds(creds))
+		tlsCfg = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
-	srv := grpc.NewServer(opts...)
 	var store *Store
 	if len(stores) > 0 {
 		store = stores[0]
 	}
+	return newGrpcServer(registry, allowedDomains, serverHostname, tlsCfg, store).Serve(ln)
+}
+
+// newGrpcServer returns the gRPC tunnel server, with TLS when tlsCfg is non-nil.
+func newGrpcServer(registry *Registry, allowedDomains func() []string, serverHostname string, tlsCfg *tls.Config, store *Store) *grpc.Server {
+	maxBody := maxProxyBodyBytes()
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxBody + (1 << 20)),
+		grpc.MaxSendMsgSize(maxBody + (1 << 20)),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	srv := grpc.NewServer(opts...)
 	tunnelv1.RegisterTunnelServiceServer(srv, newGrpcTunnelServer(registry, allowedDomains, serverHostname, store))
-	return srv.Serve(ln)
+	return srv
 }
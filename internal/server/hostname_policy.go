@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultReservedSubdomains are the subdomains of the server hostname only admins may claim.
+var DefaultReservedSubdomains = []string{
+	"admin", "api", "app", "assets", "auth", "cdn", "console", "dashboard", "docs", "grpc",
+	"help", "login", "logout", "mail", "oauth", "smtp", "sso", "static", "status", "support", "www",
+}
+
+// errHostnamePolicy marks hostnames rejected by the hostname policy.
+var errHostnamePolicy = errors.New("hostname policy")
+
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// HostnamePolicy decides which tunnel hostnames members may claim. Every label must be a valid
+// DNS label; the label directly below the server hostname must also avoid Reserved words, fit
+// the length bounds, match LabelPattern and start with the member's required prefix, if any.
+// Admins are exempt from everything but DNS validity.
+type HostnamePolicy struct {
+	Reserved       []string
+	LabelPattern   string // full-match regular expression, empty allows any label
+	MinLabelLength int
+	MaxLabelLength int
+}
+
+// Validate reports configuration errors such as an invalid LabelPattern.
+func (p HostnamePolicy) Validate() error {
+	if _, err := p.labelPattern(); err != nil {
+		return err
+	}
+	if p.MaxLabelLength > 0 && p.MinLabelLength > p.MaxLabelLength {
+		return fmt.Errorf("hostname policy: min label length %d exceeds max %d", p.MinLabelLength, p.MaxLabelLength)
+	}
+	return nil
+}
+
+func (p HostnamePolicy) labelPattern() (*regexp.Regexp, error) {
+	if p.LabelPattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(`^(?:` + p.LabelPattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("hostname policy: invalid label pattern: %w", err)
+	}
+	return re, nil
+}
+
+// Check validates hostname for a user. prefix is the user's required subdomain prefix ("" for none).
+func (p HostnamePolicy) Check(serverHostname, hostname string, isAdmin bool, prefix string) error {
+	if len(hostname) > 253 {
+		return fmt.Errorf("%w: hostname is longer than 253 characters", errHostnamePolicy)
+	}
+	for i, label := range strings.Split(hostname, ".") {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if !dnsLabelPattern.MatchString(label) {
+			return fmt.Errorf("%w: %q is not a valid DNS label (a-z, 0-9 and inner hyphens, at most 63 characters)", errHostnamePolicy, label)
+		}
+	}
+	sub, ok := strings.CutSuffix(hostname, "."+strings.ToLower(serverHostname))
+	if !ok || isAdmin {
+		return nil
+	}
+	labels := strings.Split(sub, ".")
+	label := labels[len(labels)-1]
+	for _, word := range p.Reserved {
+		if strings.EqualFold(strings.TrimSpace(word), label) {
+			return fmt.Errorf("%w: subdomain %q is reserved", errHostnamePolicy, label)
+		}
+	}
+	if p.MinLabelLength > 0 && len(label) < p.MinLabelLength {
+		return fmt.Errorf("%w: subdomain %q is shorter than %d characters", errHostnamePolicy, label, p.MinLabelLength)
+	}
+	if p.MaxLabelLength > 0 && len(label) > p.MaxLabelLength {
+		return fmt.Errorf("%w: subdomain %q is longer than %d characters", errHostnamePolicy, label, p.MaxLabelLength)
+	}
+	re, err := p.labelPattern()
+	if err != nil {
+		return err
+	}
+	if re != nil && !re.MatchString(label) {
+		return fmt.Errorf("%w: subdomain %q does not match %s", errHostnamePolicy, label, p.LabelPattern)
+	}
+	if prefix != "" && !strings.HasPrefix(label, prefix) {
+		return fmt.Errorf("%w: your subdomains must start with %q", errHostnamePolicy, prefix)
+	}
+	return nil
+}
+
+// normalizeHostnamePrefix accepts "alice-" or "alice-*" and returns "alice-".
+func normalizeHostnamePrefix(prefix string) (string, error) {
+	prefix = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(prefix)), "*")
+	if prefix != "" && !regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`).MatchString(prefix) {
+		return "", fmt.Errorf("prefix must be letters, digits and hyphens")
+	}
+	return prefix, nil
+}
+
+// claimHostname resolves the hostname a user asked for and checks it against the allowed
+// domains, the hostname policy, and other users' tunnels and reservations.
+func claimHostname(ctx context.Context, cfg Config, store *Store, domains *DomainStore, user *UserRecord, subdomain, customURL string) (string, error) {
+	isAdmin := user.Role == "admin"
+	hostname, err := resolveTunnelHostname(cfg.Hostname, domains.AllowedFor(user.ID, isAdmin), subdomain, customURL)
+	if err != nil {
+		return "", err
+	}
+	// A wildcard over a whole custom domain would take every future name under it, so only the
+	// domain's owner may claim one.
+	if base, ok := strings.CutPrefix(hostname, "*."); ok && !isAdmin {
+		if rec, found := domains.Get(base); found && rec.OwnerUserID != user.ID {
+			return "", fmt.Errorf("%w: only the owner of %s may claim a wildcard over the whole domain", errHostnamePolicy, base)
+		}
+	}
+	prefix, err := store.GetHostnamePrefix(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := cfg.HostnamePolicy.Check(cfg.Hostname, hostname, isAdmin, prefix); err != nil {
+		return "", err
+	}
+	claims, err := store.ListHostnameClaims(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := checkHostnameClaims(hostname, user.ID, claims); err != nil {
+		return "", err
+	}
+	return hostname, nil
+}
+
+// hostnameClaimStatus maps claimHostname errors to HTTP status codes.
+func hostnameClaimStatus(err error) int {
+	switch {
+	case errors.Is(err, errHostnameOverlap):
+		return http.StatusConflict
+	case errors.Is(err, errHostnamePolicy):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
+// handleReservations serves /api/reservations: GET lists the caller's reservations (all for
+// admins), POST reserves {subdomain|url, note} and DELETE /{id} releases one.
+func handleReservations(w http.ResponseWriter, r *http.Request, cfg Config, store *Store, domains *DomainStore, user *UserRecord, rest []string) {
+	isAdmin := user.Role == "admin"
+	if len(rest) == 0 || rest[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := store.ListHostnameReservations(r.Context(), user.ID, isAdmin)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if list == nil {
+				list = []HostnameReservationRecord{}
+			}
+			writeJSON(w, http.StatusOK, list)
+		case http.MethodPost:
+			var body struct {
+				Subdomain string `json:"subdomain"`
+				URL       string `json:"url"`
+				Note      string `json:"note"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			body.Subdomain = normalizeName(body.Subdomain)
+			body.URL = strings.TrimSpace(strings.ToLower(body.URL))
+			if (body.Subdomain == "") == (body.URL == "") {
+				http.Error(w, "use exactly one of subdomain or url", http.StatusBadRequest)
+				return
+			}
+			hostname, err := claimHostname(r.Context(), cfg, store, domains, user, body.Subdomain, body.URL)
+			if err != nil {
+				http.Error(w, err.Error(), hostnameClaimStatus(err))
+				return
+			}
+			rec, err := store.CreateHostnameReservation(r.Context(), user.ID, hostname, strings.TrimSpace(body.Note))
+			if err != nil {
+				http.Error(w, "hostname already reserved", http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusCreated, rec)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil || len(rest) != 1 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := store.GetHostnameReservation(r.Context(), id)
+	if err != nil || (!isAdmin && rec.OwnerUserID != user.ID) {
+		http.Error(w, "reservation not found", http.StatusNotFound)
+		return
+	}
+	if err := store.DeleteHostnameReservation(r.Context(), id); err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleHostnamePolicy serves /api/hostname-policy: GET returns the policy and the caller's
+// prefix, GET /prefixes lists every prefix and PUT /prefixes sets {email, prefix} (admin only).
+func handleHostnamePolicy(w http.ResponseWriter, r *http.Request, cfg Config, store *Store, user *UserRecord, rest []string) {
+	if len(rest) == 0 || rest[0] == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		prefix, err := store.GetHostnamePrefix(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reserved := cfg.HostnamePolicy.Reserved
+		if reserved == nil {
+			reserved = []string{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"reserved":         reserved,
+			"label_pattern":    cfg.HostnamePolicy.LabelPattern,
+			"min_label_length": cfg.HostnamePolicy.MinLabelLength,
+			"max_label_length": cfg.HostnamePolicy.MaxLabelLength,
+			"prefix":           prefix,
+		})
+		return
+	}
+	if rest[0] != "prefixes" || len(rest) != 1 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if user.Role != "admin" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list, err := store.ListHostnamePrefixes(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []HostnamePrefixRecord{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPut:
+		var body struct {
+			Email  string `json:"email"`
+			Prefix string `json:"prefix"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Email) == "" {
+			http.Error(w, "email required", http.StatusBadRequest)
+			return
+		}
+		prefix, err := normalizeHostnamePrefix(body.Prefix)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec, err := store.SetHostnamePrefix(r.Context(), strings.TrimSpace(body.Email), prefix)
+		if err == sql.ErrNoRows {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHostnamePolicy_Check(t *testing.T) {
+	p := HostnamePolicy{Reserved: []string{"admin", "api"}, MinLabelLength: 3, MaxLabelLength: 20, LabelPattern: `[a-z][a-z0-9-]*`}
+	const server = "tunnel.example.com"
+	ok := []struct {
+		hostname string
+		admin    bool
+		prefix   string
+	}{
+		{"shop.tunnel.example.com", false, ""},
+		{"v1.shop.tunnel.example.com", false, ""},
+		{"*.shop.tunnel.example.com", false, ""},
+		{"admin.tunnel.example.com", true, ""},
+		{"ab.tunnel.example.com", true, ""},
+		{"alice-shop.tunnel.example.com", false, "alice-"},
+		{"admin.shop.example.org", false, "alice-"},
+	}
+	for _, c := range ok {
+		if err := p.Check(server, c.hostname, c.admin, c.prefix); err != nil {
+			t.Fatalf("%s: %v", c.hostname, err)
+		}
+	}
+	bad := map[string]string{
+		"admin.tunnel.example.com":                      "",
+		"x.api.tunnel.example.com":                      "",
+		"ab.tunnel.example.com":                         "",
+		"averyveryverylongsubdomain.tunnel.example.com": "",
+		"1shop.tunnel.example.com":                      "",
+		"-shop.tunnel.example.com":                      "",
+		"shop_1.tunnel.example.com":                     "",
+		"bob-shop.tunnel.example.com":                   "alice-",
+	}
+	for hostname, prefix := range bad {
+		err := p.Check(server, hostname, false, prefix)
+		if !errors.Is(err, errHostnamePolicy) {
+			t.Fatalf("%s: err=%v want policy error", hostname, err)
+		}
+	}
+	if err := (HostnamePolicy{LabelPattern: "("}).Validate(); err == nil {
+		t.Fatal("expected invalid pattern error")
+	}
+}
+
+func TestReservationsAPI(t *testing.T) {
+	dir := t.TempDir()
+	ds := newTestDomainStore(t, dir)
+	cfg := Config{Hostname: "tunnel.example.com", HostnamePolicy: HostnamePolicy{Reserved: []string{"admin"}}}
+	auth, err := NewAuthManager(context.Background(), cfg, ds.store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(subject, role string) string {
+		raw, _, _, err := auth.IssueSessionForClaims(context.Background(), OIDCClaims{Subject: subject, Email: subject + "@example.com"}, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	admin, alice, bob := session("admin", "admin"), session("alice", "member"), session("bob", "member")
+	srv := httptest.NewServer(ControlPlaneRouter(cfg, NewRegistry(), ds, ds.store, auth))
+	defer srv.Close()
+	call := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf := new(strings.Builder)
+		_, _ = io.Copy(buf, resp.Body)
+		return resp.StatusCode, buf.String()
+	}
+
+	if code, body := call(alice, http.MethodPost, "/api/reservations", `{"subdomain":"admin"}`); code != http.StatusUnprocessableEntity || !strings.Contains(body, "reserved") {
+		t.Fatalf("reserved word status=%d body=%s", code, body)
+	}
+	if code, body := call(alice, http.MethodPost, "/api/reservations", `{"subdomain":"shop","note":"launch"}`); code != http.StatusCreated {
+		t.Fatalf("reserve status=%d body=%s", code, body)
+	}
+	if code, _ := call(bob, http.MethodPost, "/api/tunnels", `{"name":"bobshop","subdomain":"shop","local":"http://localhost:3000"}`); code != http.StatusConflict {
+		t.Fatalf("tunnel on reserved hostname status=%d", code)
+	}
+	if code, _ := call(bob, http.MethodPost, "/api/reservations", `{"url":"shop.tunnel.example.com"}`); code != http.StatusConflict {
+		t.Fatalf("duplicate reservation status=%d", code)
+	}
+	if code, body := call(alice, http.MethodPost, "/api/tunnels", `{"name":"aliceshop","subdomain":"shop","local":"http://localhost:3000"}`); code != http.StatusCreated {
+		t.Fatalf("owner tunnel on reservation status=%d body=%s", code, body)
+	}
+
+	if code, _ := call(alice, http.MethodPut, "/api/hostname-policy/prefixes", `{"email":"bob@example.com","prefix":"bob-"}`); code != http.StatusForbidden {
+		t.Fatalf("member set prefix status=%d", code)
+	}
+	if code, body := call(admin, http.MethodPut, "/api/hostname-policy/prefixes", `{"email":"bob@example.com","prefix":"bob-*"}`); code != http.StatusOK || !strings.Contains(body, `"prefix":"bob-"`) {
+		t.Fatalf("set prefix status=%d body=%s", code, body)
+	}
+	if code, body := call(bob, http.MethodPost, "/api/reservations", `{"subdomain":"demo"}`); code != http.StatusUnprocessableEntity || !strings.Contains(body, `"bob-"`) {
+		t.Fatalf("prefix violation status=%d body=%s", code, body)
+	}
+	if code, _ := call(bob, http.MethodPost, "/api/reservations", `{"subdomain":"bob-demo"}`); code != http.StatusCreated {
+		t.Fatalf("prefixed reservation status=%d", code)
+	}
+	if code, body := call(bob, http.MethodGet, "/api/hostname-policy", ""); code != http.StatusOK || !strings.Contains(body, `"prefix":"bob-"`) {
+		t.Fatalf("policy status=%d body=%s", code, body)
+	}
+
+	if code, body := call(bob, http.MethodGet, "/api/reservations", ""); code != http.StatusOK || strings.Contains(body, "shop") {
+		t.Fatalf("bob list status=%d body=%s", code, body)
+	}
+	list, err := ds.store.ListHostnameReservations(context.Background(), 0, true)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list=%v err=%v", list, err)
+	}
+	aliceRes := "/api/reservations/" + strconv.FormatInt(list[1].ID, 10)
+	if list[1].Hostname != "shop.tunnel.example.com" {
+		aliceRes = "/api/reservations/" + strconv.FormatInt(list[0].ID, 10)
+	}
+	if code, _ := call(bob, http.MethodDelete, aliceRes, ""); code != http.StatusNotFound {
+		t.Fatalf("delete other's reservation status=%d", code)
+	}
+	if code, _ := call(alice, http.MethodDelete, aliceRes, ""); code != http.StatusNoContent {
+		t.Fatalf("delete status=%d", code)
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+const (
+	jwtDiscoveryRetry   = 30 * time.Second
+	jwtDiscoveryTimeout = 10 * time.Second
+	// jwtMaxIssuers bounds the discovered providers and remembered failures. Tunnel owners choose
+	// issuers, so neither map may grow without limit.
+	jwtMaxIssuers = 256
+)
+
+// jwtVerifierCache keeps one discovered provider per issuer. The provider's remote key set caches
+// the JWKS and refetches it when a token is signed by an unknown key, so rotation needs no restart.
+// Discovery runs outside the lock, once per issuer, so a slow issuer only delays its own tunnels.
+type jwtVerifierCache struct {
+	mu        sync.Mutex
+	providers map[string]*oidc.Provider
+	failures  map[string]time.Time
+	inflight  map[string]*jwtDiscovery
+
+	// discover fetches an issuer's configuration; replaced in tests.
+	discover func(ctx context.Context, issuer string) (*oidc.Provider, error)
+}
+
+type jwtDiscovery struct {
+	done     chan struct{}
+	provider *oidc.Provider
+	err      error
+}
+
+// newJWTVerifierCache returns a cache that discovers trusted issuers directly and every other
+// issuer through jwtIssuerClient, which refuses to connect to internal addresses.
+func newJWTVerifierCache(trusted ...string) *jwtVerifierCache {
+	return &jwtVerifierCache{
+		providers: map[string]*oidc.Provider{},
+		failures:  map[string]time.Time{},
+		inflight:  map[string]*jwtDiscovery{},
+		discover: func(ctx context.Context, issuer string) (*oidc.Provider, error) {
+			if !slices.Contains(trusted, issuer) {
+				// The provider keeps this client for its JWKS fetches too.
+				ctx = oidc.ClientContext(ctx, jwtIssuerClient)
+			}
+			return oidc.NewProvider(ctx, issuer)
+		},
+	}
+}
+
+// jwtIssuerClient fetches discovery documents and key sets for issuers chosen by tunnel owners.
+// The address is checked after DNS resolution so a public name can't point at the server's
+// network.
+var jwtIssuerClient = &http.Client{
+	Timeout: jwtDiscoveryTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: jwtDiscoveryTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				ap, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !publicAddr(ap.Addr()) {
+					return fmt.Errorf("jwt issuer address %s is not public", ap.Addr())
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: jwtDiscoveryTimeout,
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which netip does not treat as
+// private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether addr is a globally routable unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+func (c *jwtVerifierCache) provider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	c.mu.Lock()
+	if p, ok := c.providers[issuer]; ok {
+		c.mu.Unlock()
+		return p, nil
+	}
+	if at, ok := c.failures[issuer]; ok && time.Since(at) < jwtDiscoveryRetry {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("jwt issuer discovery failed recently")
+	}
+	in, ok := c.inflight[issuer]
+	if !ok {
+		if len(c.inflight) >= jwtMaxIssuers {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("jwt issuer discovery busy")
+		}
+		in = &jwtDiscovery{done: make(chan struct{})}
+		c.inflight[issuer] = in
+		go c.runDiscovery(issuer, in)
+	}
+	c.mu.Unlock()
+	select {
+	case <-in.done:
+		return in.provider, in.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runDiscovery fetches issuer detached from the request that asked for it, so the first caller
+// giving up does not fail the others waiting on the same discovery.
+func (c *jwtVerifierCache) runDiscovery(issuer string, in *jwtDiscovery) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwtDiscoveryTimeout)
+	defer cancel()
+	p, err := c.discover(ctx, issuer)
+	if err != nil {
+		err = fmt.Errorf("jwt issuer discovery: %w", err)
+	}
+	in.provider, in.err = p, err
+	c.mu.Lock()
+	if err != nil {
+		if len(c.failures) >= jwtMaxIssuers {
+			pruneJWTFailures(c.failures, time.Now())
+		}
+		c.failures[issuer] = time.Now()
+	} else {
+		if len(c.providers) >= jwtMaxIssuers {
+			// Evict an arbitrary provider; it is rediscovered on its next use.
+			for k := range c.providers {
+				delete(c.providers, k)
+				break
+			}
+		}
+		c.providers[issuer] = p
+		delete(c.failures, issuer)
+	}
+	delete(c.inflight, issuer)
+	c.mu.Unlock()
+	close(in.done)
+}
+
+// pruneJWTFailures drops failures old enough to be retried anyway, then arbitrary ones until
+// there is room for another.
+func pruneJWTFailures(failures map[string]time.Time, now time.Time) {
+	for k, at := range failures {
+		if now.Sub(at) >= jwtDiscoveryRetry {
+			delete(failures, k)
+		}
+	}
+	for k := range failures {
+		if len(failures) < jwtMaxIssuers {
+			return
+		}
+		delete(failures, k)
+	}
+}
+
+// checkJWTIssuer refuses issuers tunnel owners may not use. Discovery and JWKS fetches go to the
+// issuer, so plain http is only allowed when the server is started with JWTAllowHTTPIssuers, and
+// internal hosts only when the issuer is listed in JWTTrustedIssuers. Names resolving to internal
+// addresses are also refused when the server connects.
+func (a *AuthManager) checkJWTIssuer(issuer string) error {
+	issuer = strings.TrimSpace(issuer)
+	if issuer == "" || (a != nil && a.trustedJWTIssuer(issuer)) {
+		return nil
+	}
+	if !strings.HasPrefix(strings.ToLower(issuer), "https://") && (a == nil || !a.cfg.JWTAllowHTTPIssuers) {
+		return fmt.Errorf("jwt issuer must use https")
+	}
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return fmt.Errorf("invalid jwt issuer")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("jwt issuer must be a public host")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return fmt.Errorf("jwt issuer must be a public host")
+	}
+	return nil
+}
+
+// trustedJWTIssuer reports whether issuer is the server's own OIDC issuer or one the admin
+// listed in JWTTrustedIssuers.
+func (a *AuthManager) trustedJWTIssuer(issuer string) bool {
+	return issuer == strings.TrimSpace(a.cfg.OIDCIssuer) || slices.Contains(a.cfg.JWTTrustedIssuers, issuer)
+}
+
+// verifyTunnelJWT validates the request's bearer token against a jwt access rule.
+// A non-nil error with unavailable set means the issuer could not be reached.
+func (a *AuthManager) verifyTunnelJWT(ctx context.Context, r *http.Request, rule TunnelAccessRuleRecord) (unavailable bool, err error) {
+	issuer := strings.TrimSpace(rule.JWTIssuer)
+	if err := a.checkJWTIssuer(issuer); err != nil {
+		return true, err
+	}
+	if issuer == "" {
+		issuer = strings.TrimSpace(a.cfg.OIDCIssuer)
+	}
+	if issuer == "" {
+		return true, fmt.Errorf("jwt issuer not configured")
+	}
+	raw := bearerToken(r)
+	if raw == "" {
+		return false, fmt.Errorf("missing bearer token")
+	}
+	provider, err := a.jwt.provider(ctx, issuer)
+	if err != nil {
+		return true, err
+	}
+	token, err := provider.Verifier(&oidc.Config{ClientID: rule.JWTAudience}).Verify(ctx, raw)
+	if err != nil {
+		return false, err
+	}
+	if len(rule.JWTRequiredClaims) == 0 {
+		return false, nil
+	}
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return false, err
+	}
+	for name, want := range rule.JWTRequiredClaims {
+		if !claimMatches(claims[name], want) {
+			return false, fmt.Errorf("claim %s not satisfied", name)
+		}
+	}
+	return false, nil
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// claimMatches compares a decoded claim with a required value. An empty required value only
+// checks presence; array claims match when any element equals the required value.
+func claimMatches(got any, want string) bool {
+	if got == nil {
+		return false
+	}
+	if want == "" {
+		return true
+	}
+	switch v := got.(type) {
+	case []any:
+		for _, item := range v {
+			if fmt.Sprint(item) == want {
+				return true
+			}
+		}
+		return false
+	case string:
+		if v == want {
+			return true
+		}
+		// OAuth scope claims are space separated.
+		for _, part := range strings.Fields(v) {
+			if part == want {
+				return true
+			}
+		}
+		return false
+	default:
+		return fmt.Sprint(v) == want
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+type jwtIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	jwksHits  atomic.Int32
+	discovery atomic.Int32
+}
+
+func newJWTIssuer(t *testing.T) *jwtIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &jwtIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		iss.discovery.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 iss.server.URL,
+			"authorization_endpoint": iss.server.URL + "/authorize",
+			"token_endpoint":         iss.server.URL + "/token",
+			"jwks_uri":               iss.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.jwksHits.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{map[string]any{
+			"kty": "RSA",
+			"kid": "k1",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func (i *jwtIssuer) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]any{"alg": "RS256", "typ": "JWT", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestProxyHandler_JWTRule(t *testing.T) {
+	iss := newJWTIssuer(t)
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	auth, err := NewAuthManager(context.Background(), Config{Hostname: "tunnel.example.com", JWTTrustedIssuers: []string{iss.server.URL}}, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := store.CreateTunnel(context.Background(), 1, "api", "api.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(context.Background(), tun.ID, AccessRuleInput{
+		AuthMode:          "jwt",
+		JWTIssuer:         iss.server.URL,
+		JWTAudience:       "orders-api",
+		JWTRequiredClaims: map[string]string{"scope": "orders:read"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	conn := &captureConn{}
+	reg.Register("api.example.com", conn)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil, auth)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"garbage", "not-a-jwt", http.StatusUnauthorized},
+		{"wrong audience", iss.sign(t, map[string]any{"iss": iss.server.URL, "aud": "other", "exp": exp, "scope": "orders:read"}), http.StatusUnauthorized},
+		{"expired", iss.sign(t, map[string]any{"iss": iss.server.URL, "aud": "orders-api", "exp": time.Now().Add(-time.Hour).Unix(), "scope": "orders:read"}), http.StatusUnauthorized},
+		{"missing claim", iss.sign(t, map[string]any{"iss": iss.server.URL, "aud": "orders-api", "exp": exp, "scope": "orders:write"}), http.StatusUnauthorized},
+		{"valid", iss.sign(t, map[string]any{"iss": iss.server.URL, "aud": []string{"orders-api"}, "exp": exp, "scope": "openid orders:read"}), http.StatusOK},
+		{"valid again", iss.sign(t, map[string]any{"iss": iss.server.URL, "aud": "orders-api", "exp": exp, "scope": "orders:read"}), http.StatusOK},
+	}
+	for _, tc := range cases {
+		conn.last = nil
+		req := httptest.NewRequest(http.MethodGet, "https://api.example.com/orders", nil)
+		req.Host = "api.example.com"
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s: status=%d want %d body=%s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+		if tc.want == http.StatusUnauthorized && conn.last != nil {
+			t.Fatalf("%s: request reached the agent", tc.name)
+		}
+	}
+	if got := iss.discovery.Load(); got != 1 {
+		t.Fatalf("discovery fetched %d times, want 1", got)
+	}
+	if got := iss.jwksHits.Load(); got != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", got)
+	}
+}
+
+func TestJWTVerifierCache_SlowIssuer(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	c := newJWTVerifierCache()
+	c.discover = func(ctx context.Context, issuer string) (*oidc.Provider, error) {
+		calls.Add(1)
+		if issuer == "https://slow.example.com" {
+			<-release
+		}
+		return &oidc.Provider{}, nil
+	}
+	defer close(release)
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if _, err := c.provider(ctx, "https://slow.example.com"); err == nil {
+			t.Fatal("expected slow discovery to time out for the caller")
+		}
+		cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.provider(context.Background(), "https://fast.example.com")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow issuer blocked discovery of another issuer")
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("discovery ran %d times, want one per issuer", got)
+	}
+}
+
+func TestJWTVerifierCache_Bounded(t *testing.T) {
+	c := newJWTVerifierCache()
+	c.discover = func(ctx context.Context, issuer string) (*oidc.Provider, error) {
+		if strings.Contains(issuer, "bad") {
+			return nil, errors.New("unreachable")
+		}
+		return &oidc.Provider{}, nil
+	}
+	for i := range jwtMaxIssuers + 10 {
+		_, _ = c.provider(context.Background(), fmt.Sprintf("https://good%d.example.com", i))
+		_, _ = c.provider(context.Background(), fmt.Sprintf("https://bad%d.example.com", i))
+	}
+	if len(c.providers) > jwtMaxIssuers || len(c.failures) > jwtMaxIssuers {
+		t.Fatalf("providers=%d failures=%d, want at most %d", len(c.providers), len(c.failures), jwtMaxIssuers)
+	}
+}
+
+func TestCheckJWTIssuer(t *testing.T) {
+	strict := &AuthManager{}
+	if err := strict.checkJWTIssuer("https://issuer.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := strict.checkJWTIssuer(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := strict.checkJWTIssuer("http://169.254.169.254"); err == nil {
+		t.Fatal("expected http issuer to be refused")
+	}
+	for _, issuer := range []string{"https://10.0.0.5/", "https://127.0.0.1:8443/", "https://169.254.169.254", "https://[::1]/", "https://localhost/", "https://100.64.0.1/"} {
+		if err := strict.checkJWTIssuer(issuer); err == nil {
+			t.Fatalf("%s: expected internal issuer to be refused", issuer)
+		}
+	}
+	relaxed := &AuthManager{cfg: Config{JWTAllowHTTPIssuers: true}}
+	if err := relaxed.checkJWTIssuer("http://issuer.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := relaxed.checkJWTIssuer("http://localhost:9000"); err == nil {
+		t.Fatal("expected http issuers to still need a public host")
+	}
+	trusted := &AuthManager{cfg: Config{OIDCIssuer: "https://10.0.0.9", JWTTrustedIssuers: []string{"http://localhost:9000"}}}
+	for _, issuer := range []string{"http://localhost:9000", "https://10.0.0.9"} {
+		if err := trusted.checkJWTIssuer(issuer); err != nil {
+			t.Fatalf("%s: %v", issuer, err)
+		}
+	}
+}
+
+func TestJWTVerifierCache_RefusesInternalAddresses(t *testing.T) {
+	iss := newJWTIssuer(t)
+	if _, err := newJWTVerifierCache().provider(context.Background(), iss.server.URL); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Fatalf("err=%v, want discovery of a loopback issuer refused", err)
+	}
+	if iss.discovery.Load() != 0 {
+		t.Fatal("discovery reached the loopback issuer")
+	}
+	if _, err := newJWTVerifierCache(iss.server.URL).provider(context.Background(), iss.server.URL); err != nil {
+		t.Fatalf("trusted issuer: %v", err)
+	}
+}
+
+func TestValidateAccessRuleInput_JWT(t *testing.T) {
+	if _, err := validateAccessRuleInput(AccessRuleInput{AuthMode: "jwt"}, nil); err == nil {
+		t.Fatal("expected audience to be required")
+	}
+	if _, err := validateAccessRuleInput(AccessRuleInput{AuthMode: "jwt", JWTIssuer: "ftp://idp", JWTAudience: "api"}, nil); err == nil {
+		t.Fatal("expected invalid issuer error")
+	}
+	rule, err := validateAccessRuleInput(AccessRuleInput{AuthMode: "jwt", JWTAudience: " api ", JWTRequiredClaims: map[string]string{" role ": " admin "}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.JWTAudience != "api" || rule.JWTRequiredClaims["role"] != "admin" {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+}
@@ -0,0 +1,595 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSinkBuffer = 10000
+	defaultSinkBatch  = 100
+	defaultSinkFlush  = time.Second
+	sinkDialTimeout   = 5 * time.Second
+)
+
+// AccessLogEntry is one proxied request as shipped to log sinks: the stored request log plus
+// fields that are only useful outside the server.
+type AccessLogEntry struct {
+	RequestLogRecord
+	Tunnel    string `json:"tunnel,omitempty"`
+	RequestID string `json:"request_id"`
+	Proto     string `json:"proto"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Referer   string `json:"referer,omitempty"`
+}
+
+// line renders the entry in format json or combined (the Apache/nginx combined log format).
+func (e AccessLogEntry) line(format string) string {
+	if format == "json" {
+		raw, _ := json.Marshal(e)
+		return string(raw)
+	}
+	user, size := "-", "-"
+	if e.Credential != "" {
+		user = e.Credential
+	}
+	if e.BytesOut > 0 {
+		size = strconv.FormatInt(e.BytesOut, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s", orDash(e.ClientIP), user,
+		e.Timestamp.Format("02/Jan/2006:15:04:05 -0700"), strconv.Quote(e.Method+" "+e.Path+" "+e.Proto),
+		e.Status, size, strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// LogSink receives request logs in batches from its own goroutine, so Write may block; it must
+// not keep the slice after returning.
+type LogSink interface {
+	Write(entries []AccessLogEntry) error
+	Close() error
+}
+
+// LogSinkStats counts what happened to the entries offered to one sink.
+type LogSinkStats struct {
+	Name    string `json:"name"`
+	Sent    int64  `json:"sent"`
+	Dropped int64  `json:"dropped"`
+	Failed  int64  `json:"failed"`
+}
+
+// LogShipper fans request logs out to sinks. Each sink has its own queue and goroutine; when a
+// queue is full the entry is dropped and counted, so a slow sink never holds up the proxy.
+type LogShipper struct {
+	mu      sync.RWMutex
+	closed  bool
+	workers []*sinkWorker
+}
+
+// NewLogShipper starts a sink for each spec. A spec is a URL whose scheme picks the sink:
+//
+//	file:///var/log/fwdx/access.log?format=combined&max_size_mb=100&max_backups=5
+//	syslog+udp://127.0.0.1:514?facility=local0&tag=fwdx   (also syslog+tcp)
+//	https://loki.example.com/loki/api/v1/push?tenant=ops&job=fwdx
+//
+// Every sink also takes buffer (queued entries, default 10000), batch (entries per write) and
+// flush (longest wait before a partial batch is written, default 1s).
+func NewLogShipper(specs []string) (*LogShipper, error) {
+	s := &LogShipper{}
+	for _, spec := range specs {
+		sink, settings, err := newLogSink(spec)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		w := &sinkWorker{
+			name:  settings.name,
+			sink:  sink,
+			queue: make(chan AccessLogEntry, settings.buffer),
+			batch: settings.batch,
+			flush: settings.flush,
+			done:  make(chan struct{}),
+		}
+		go w.run()
+		s.workers = append(s.workers, w)
+	}
+	return s, nil
+}
+
+// Ship offers e to every sink without blocking. It is a no-op on a nil or closed shipper.
+func (s *LogShipper) Ship(e AccessLogEntry) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, w := range s.workers {
+		w.offer(e)
+	}
+}
+
+// Close writes what is still queued and closes every sink.
+func (s *LogShipper) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	var firstErr error
+	for _, w := range s.workers {
+		close(w.queue)
+		<-w.done
+		if err := w.sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the counters of each sink in spec order.
+func (s *LogShipper) Stats() []LogSinkStats {
+	if s == nil {
+		return nil
+	}
+	out := make([]LogSinkStats, 0, len(s.workers))
+	for _, w := range s.workers {
+		out = append(out, LogSinkStats{Name: w.name, Sent: w.sent.Load(), Dropped: w.dropped.Load(), Failed: w.failed.Load()})
+	}
+	return out
+}
+
+type sinkWorker struct {
+	name  string
+	sink  LogSink
+	queue chan AccessLogEntry
+	batch int
+	flush time.Duration
+	done  chan struct{}
+
+	sent, dropped, failed atomic.Int64
+	lastDropWarn          atomic.Int64 // unix seconds, to warn about drops at most once a minute
+}
+
+func (w *sinkWorker) offer(e AccessLogEntry) {
+	select {
+	case w.queue <- e:
+	default:
+		w.dropped.Add(1)
+		metricLogSinkEntries.Inc(w.name, "dropped")
+		now := time.Now().Unix()
+		if last := w.lastDropWarn.Load(); now-last >= 60 && w.lastDropWarn.CompareAndSwap(last, now) {
+			slog.Warn("log sink queue full, dropping entries", "sink", w.name, "dropped", w.dropped.Load())
+		}
+	}
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flush)
+	defer ticker.Stop()
+	batch := make([]AccessLogEntry, 0, w.batch)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.sink.Write(batch); err != nil {
+			w.failed.Add(int64(len(batch)))
+			metricLogSinkEntries.Add(float64(len(batch)), w.name, "failed")
+			slog.Warn("log sink write failed", "sink", w.name, "entries", len(batch), "error", err)
+		} else {
+			w.sent.Add(int64(len(batch)))
+			metricLogSinkEntries.Add(float64(len(batch)), w.name, "sent")
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case e, ok := <-w.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.batch {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+type sinkSettings struct {
+	name   string
+	buffer int
+	batch  int
+	flush  time.Duration
+}
+
+// sinkParams are consumed by fwdx and never forwarded to a webhook URL.
+var sinkParams = []string{"name", "buffer", "batch", "flush", "format", "tenant", "job"}
+
+func newLogSink(spec string) (LogSink, sinkSettings, error) {
+	u, err := url.Parse(strings.TrimSpace(spec))
+	if err != nil || u.Scheme == "" {
+		return nil, sinkSettings{}, fmt.Errorf("log sink %q: want a URL such as file:///var/log/fwdx/access.log", spec)
+	}
+	spec = u.Redacted()
+	q := u.Query()
+	settings := sinkSettings{name: q.Get("name"), buffer: defaultSinkBuffer, batch: defaultSinkBatch, flush: defaultSinkFlush}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		settings.batch = 500
+	}
+	if settings.buffer, err = intParam(q, "buffer", settings.buffer, 1); err != nil {
+		return nil, settings, fmt.Errorf("log sink %q: %w", spec, err)
+	}
+	if settings.batch, err = intParam(q, "batch", settings.batch, 1); err != nil {
+		return nil, settings, fmt.Errorf("log sink %q: %w", spec, err)
+	}
+	if raw := q.Get("flush"); raw != "" {
+		if settings.flush, err = time.ParseDuration(raw); err != nil || settings.flush <= 0 {
+			return nil, settings, fmt.Errorf("log sink %q: flush must be a positive duration such as 500ms", spec)
+		}
+	}
+	format := q.Get("format")
+	switch format {
+	case "", "combined", "json":
+	default:
+		return nil, settings, fmt.Errorf("log sink %q: format must be combined or json", spec)
+	}
+
+	var sink LogSink
+	switch u.Scheme {
+	case "file":
+		path := u.Path
+		if u.Opaque != "" {
+			path = u.Opaque
+		}
+		if u.Host != "" || path == "" {
+			return nil, settings, fmt.Errorf("log sink %q: want file:///absolute/path or file:relative/path", spec)
+		}
+		maxMB := 100.0
+		if raw := q.Get("max_size_mb"); raw != "" {
+			if maxMB, err = strconv.ParseFloat(raw, 64); err != nil || maxMB < 0 {
+				return nil, settings, fmt.Errorf("log sink %q: max_size_mb must be a non-negative number", spec)
+			}
+		}
+		backups, err := intParam(q, "max_backups", 5, 0)
+		if err != nil {
+			return nil, settings, fmt.Errorf("log sink %q: %w", spec, err)
+		}
+		if format == "" {
+			format = "combined"
+		}
+		if settings.name == "" {
+			settings.name = "file:" + path
+		}
+		sink, err = newFileSink(path, format, int64(maxMB*1024*1024), backups)
+		if err != nil {
+			return nil, settings, err
+		}
+	case "syslog", "syslog+udp", "syslog+tcp":
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		addr := u.Host
+		if addr == "" {
+			return nil, settings, fmt.Errorf("log sink %q: syslog needs host:port", spec)
+		}
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "514")
+		}
+		facility, err := syslogFacility(q.Get("facility"))
+		if err != nil {
+			return nil, settings, fmt.Errorf("log sink %q: %w", spec, err)
+		}
+		tag := q.Get("tag")
+		if tag == "" {
+			tag = "fwdx"
+		}
+		if format == "" {
+			format = "combined"
+		}
+		if settings.name == "" {
+			settings.name = network + "://" + addr
+		}
+		host, _ := os.Hostname()
+		sink = &syslogSink{network: network, addr: addr, format: format, facility: facility, tag: tag, hostname: orDash(host), pid: os.Getpid()}
+	case "http", "https":
+		if u.Host == "" {
+			return nil, settings, fmt.Errorf("log sink %q: webhook needs a host", spec)
+		}
+		ls := &lokiSink{client: &http.Client{Timeout: 10 * time.Second}, tenant: q.Get("tenant"), job: q.Get("job"), format: format}
+		if ls.job == "" {
+			ls.job = "fwdx"
+		}
+		if ls.format == "" {
+			ls.format = "json"
+		}
+		target := *u
+		if target.User != nil {
+			ls.username = target.User.Username()
+			ls.password, _ = target.User.Password()
+			target.User = nil
+		}
+		for _, key := range sinkParams {
+			q.Del(key)
+		}
+		target.RawQuery = q.Encode()
+		ls.url = target.String()
+		if settings.name == "" {
+			settings.name = target.Scheme + "://" + target.Host + target.Path
+		}
+		sink = ls
+	default:
+		return nil, settings, fmt.Errorf("log sink %q: unknown scheme %q (want file, syslog+udp, syslog+tcp, http or https)", spec, u.Scheme)
+	}
+	return sink, settings, nil
+}
+
+func intParam(q url.Values, key string, def, minimum int) (int, error) {
+	raw := q.Get(key)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < minimum {
+		return def, fmt.Errorf("%s must be an integer of at least %d", key, minimum)
+	}
+	return n, nil
+}
+
+// fileSink appends lines to an access log and rotates it to path.1, path.2, … once it would
+// grow past maxBytes.
+type fileSink struct {
+	path     string
+	format   string
+	maxBytes int64
+	backups  int
+	f        *os.File
+	size     int64
+}
+
+func newFileSink(path, format string, maxBytes int64, backups int) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("access log: %w", err)
+	}
+	s := &fileSink{path: path, format: format, maxBytes: maxBytes, backups: backups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("access log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("access log: %w", err)
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *fileSink) Write(entries []AccessLogEntry) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		line := e.line(s.format) + "\n"
+		if s.maxBytes > 0 && s.size+int64(buf.Len()) > 0 && s.size+int64(buf.Len()+len(line)) > s.maxBytes {
+			if err := s.flush(&buf); err != nil {
+				return err
+			}
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(line)
+	}
+	return s.flush(&buf)
+}
+
+func (s *fileSink) flush(buf *bytes.Buffer) error {
+	n, err := s.f.Write(buf.Bytes())
+	s.size += int64(n)
+	buf.Reset()
+	return err
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if s.backups == 0 {
+		_ = os.Remove(s.path)
+	} else {
+		for i := s.backups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("rotate access log: %w", err)
+		}
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error { return s.f.Close() }
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func syslogFacility(name string) (int, error) {
+	if name == "" {
+		return syslogFacilities["local0"], nil
+	}
+	f, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return f, nil
+}
+
+// syslogSink sends RFC 5424 messages, one datagram each over UDP and octet-counted (RFC 6587)
+// over TCP. A broken connection is redialed on the next batch.
+type syslogSink struct {
+	network, addr string
+	format        string
+	facility      int
+	tag, hostname string
+	pid           int
+	conn          net.Conn
+}
+
+// message formats e as <PRI>1 TIMESTAMP HOST APP PROCID MSGID - MSG. Severity follows the
+// status: err for 5xx and failed requests, warning for 4xx, info otherwise.
+func (s *syslogSink) message(e AccessLogEntry) string {
+	severity := 6
+	switch {
+	case e.Status == 0 || e.Status >= 500:
+		severity = 3
+	case e.Status >= 400:
+		severity = 4
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d access - %s", s.facility*8+severity,
+		e.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.tag, s.pid, e.line(s.format))
+}
+
+func (s *syslogSink) Write(entries []AccessLogEntry) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, sinkDialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(sinkDialTimeout))
+	var err error
+	if s.network == "tcp" {
+		var buf bytes.Buffer
+		for _, e := range entries {
+			msg := s.message(e)
+			fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+		}
+		_, err = s.conn.Write(buf.Bytes())
+	} else {
+		for _, e := range entries {
+			if _, err = s.conn.Write([]byte(s.message(e))); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// lokiSink posts batches in the Loki push format, one stream per tunnel labelled with job and
+// tunnel, to any HTTP endpoint that accepts it.
+type lokiSink struct {
+	url                string
+	client             *http.Client
+	username, password string
+	tenant             string
+	job                string
+	format             string
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) Write(entries []AccessLogEntry) error {
+	var push lokiPush
+	index := map[string]int{}
+	for _, e := range entries {
+		i, ok := index[e.Tunnel]
+		if !ok {
+			labels := map[string]string{"job": s.job}
+			if e.Tunnel != "" {
+				labels["tunnel"] = e.Tunnel
+			}
+			i = len(push.Streams)
+			index[e.Tunnel] = i
+			push.Streams = append(push.Streams, lokiStream{Stream: labels})
+		}
+		push.Streams[i].Values = append(push.Streams[i].Values,
+			[2]string{strconv.FormatInt(e.Timestamp.UnixNano(), 10), e.line(s.format)})
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", s.tenant)
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (s *lokiSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	// maintenanceBypassHeader carries the secret that lets a request through maintenance mode.
+	maintenanceBypassHeader      = "X-Fwdx-Maintenance-Bypass"
+	maintenanceDefaultMessage    = "tunnel is under maintenance"
+	maintenanceDefaultRetryAfter = 120
+)
+
+// maintenanceBypassed reports whether r may skip tun's maintenance page, either from a bypass
+// CIDR or by presenting the bypass secret.
+func maintenanceBypassed(tun TunnelRecord, r *http.Request, clientIP string) bool {
+	if len(tun.MaintenanceBypassIPs) > 0 && ipInPrefixes(tun.MaintenanceBypassIPs, clientIP) {
+		return true
+	}
+	if tun.MaintenanceBypassSecretHash == "" {
+		return false
+	}
+	v := r.Header.Get(maintenanceBypassHeader)
+	return v != "" && hashSecret(v) == tun.MaintenanceBypassSecretHash
+}
+
+// writeMaintenance answers with a 503 and Retry-After while tun is in maintenance.
+func writeMaintenance(w http.ResponseWriter, tun TunnelRecord, deny proxyErrorFunc) int {
+	retry := tun.MaintenanceRetryAfter
+	if retry <= 0 {
+		retry = maintenanceDefaultRetryAfter
+	}
+	msg := tun.MaintenanceMessage
+	if msg == "" {
+		msg = maintenanceDefaultMessage
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	w.Header().Set("Cache-Control", "no-store")
+	return deny(http.StatusServiceUnavailable, msg)
+}
+
+func maintenanceEventMessage(enabled bool) string {
+	if enabled {
+		return "maintenance mode enabled"
+	}
+	return "maintenance mode disabled"
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyHandler_Maintenance(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if _, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelMaintenance(ctx, "app", MaintenanceInput{
+		Enabled:      true,
+		Message:      "back soon",
+		RetryAfter:   300,
+		BypassIPs:    []string{"10.1.0.0/16"},
+		BypassSecret: "let-me-in",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	conn := &captureConn{}
+	reg := NewRegistry()
+	reg.Register("app.example.com", conn)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+	do := func(remote, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+		req.Host = "app.example.com"
+		req.RemoteAddr = remote
+		if secret != "" {
+			req.Header.Set(maintenanceBypassHeader, secret)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("198.51.100.7:1234", "")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "300" {
+		t.Fatalf("status=%d retry-after=%q want 503/300", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec.Body.String() != "back soon\n" {
+		t.Fatalf("body=%q", rec.Body.String())
+	}
+	if conn.last != nil {
+		t.Fatal("request reached the agent during maintenance")
+	}
+	if rec := do("198.51.100.7:1234", "wrong"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("wrong secret status=%d want 503", rec.Code)
+	}
+	if rec := do("10.1.2.3:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("bypass ip status=%d want 200", rec.Code)
+	}
+	if rec := do("198.51.100.7:1234", "let-me-in"); rec.Code != http.StatusOK {
+		t.Fatalf("bypass secret status=%d want 200", rec.Code)
+	}
+	if conn.last.Header.Get(maintenanceBypassHeader) != "" {
+		t.Fatal("bypass secret forwarded to agent")
+	}
+
+	// Turning maintenance off keeps the stored bypass secret.
+	if err := store.SetTunnelMaintenance(ctx, "app", MaintenanceInput{}); err != nil {
+		t.Fatal(err)
+	}
+	tun, err := store.GetTunnelByName(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tun.Maintenance || tun.MaintenanceBypassSecretHash == "" {
+		t.Fatalf("unexpected tunnel after disable: maintenance=%v secret=%q", tun.Maintenance, tun.MaintenanceBypassSecretHash)
+	}
+	if rec := do("198.51.100.7:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("after disable status=%d want 200", rec.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BRAVO68WEB/fwdx/internal/metrics"
+)
+
+// Server metrics are process-wide, like the registry of a single running server.
+var (
+	serverMetrics = metrics.NewRegistry()
+
+	metricProxyRequests = serverMetrics.Counter("fwdx_proxy_requests_total",
+		"Proxied requests by tunnel hostname and status class.", "tunnel", "code")
+	metricProxyDuration = serverMetrics.Histogram("fwdx_proxy_request_duration_seconds",
+		"Time to answer proxied requests, including the agent round trip.", metrics.DefaultBuckets, "tunnel")
+	metricProxyBytes = serverMetrics.Counter("fwdx_proxy_bytes_total",
+		"Proxied body bytes by tunnel hostname and direction (in, out).", "tunnel", "direction")
+	metricActiveTunnels = serverMetrics.Gauge("fwdx_active_tunnels",
+		"Tunnels with a connected agent.")
+	metricPendingRequests = serverMetrics.Gauge("fwdx_tunnel_pending_requests",
+		"Requests sent to an agent that have not been answered yet.", "tunnel")
+	metricGrpcStreams = serverMetrics.Gauge("fwdx_grpc_streams",
+		"Open gRPC tunnel streams, including ones that have not registered yet.")
+	metricAuthFailures = serverMetrics.Counter("fwdx_auth_failures_total",
+		"Rejected credentials by surface (proxy, api, grpc) and method.", "surface", "method")
+	metricLogSinkEntries = serverMetrics.Counter("fwdx_log_sink_entries_total",
+		"Request logs offered to external sinks by sink and result (sent, dropped, failed).", "sink", "result")
+	metricSQLiteWrite = serverMetrics.Histogram("fwdx_sqlite_write_duration_seconds",
+		"SQLite write statement latency by statement and table.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "op", "table")
+)
+
+// statusClass turns 404 into "4xx"; 0 (no response) is "none".
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "none"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// sqlWriteLabels extracts the statement and table of an INSERT, UPDATE or DELETE for metrics.
+func sqlWriteLabels(query string) (op, table string) {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "other", ""
+	}
+	op = fields[0]
+	var rest []string
+	switch op {
+	case "insert":
+		// INSERT [OR REPLACE|IGNORE] INTO table
+		for i, f := range fields {
+			if f == "into" {
+				rest = fields[i+1:]
+				break
+			}
+		}
+	case "update":
+		rest = fields[1:]
+	case "delete":
+		if len(fields) > 2 {
+			rest = fields[2:]
+		}
+	default:
+		return "other", ""
+	}
+	if len(rest) > 0 {
+		table = strings.TrimFunc(rest[0], func(r rune) bool { return r == '(' || r == '"' })
+		table, _, _ = strings.Cut(table, "(")
+	}
+	return op, table
+}
+
+// MetricsHandler serves Prometheus metrics, refreshing the gauges taken from registry first.
+func MetricsHandler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if registry != nil {
+			conns := registry.connections()
+			metricActiveTunnels.Set(float64(len(conns)))
+			metricPendingRequests.Reset()
+			for hostname, conn := range conns {
+				if c, ok := conn.(interface{ Pending() int }); ok {
+					metricPendingRequests.Set(float64(c.Pending()), hostname)
+				}
+			}
+		}
+		serverMetrics.Handler().ServeHTTP(w, r)
+	})
+}
+
+// serveMetrics listens on addr and serves /metrics until the listener fails.
+func serveMetrics(addr string, registry *Registry) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler(registry))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("metrics stopped", "addr", ln.Addr().String(), "error", err)
+		}
+	}()
+	slog.Info("metrics listening", "url", "http://"+ln.Addr().String()+"/metrics")
+	return srv, nil
+}
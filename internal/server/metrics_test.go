@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSQLWriteLabels(t *testing.T) {
+	cases := map[string][2]string{
+		"INSERT INTO usage_daily (day) VALUES (?)":      {"insert", "usage_daily"},
+		"\nINSERT OR REPLACE INTO quotas(scope) VALUES": {"insert", "quotas"},
+		"UPDATE tunnels SET state = ?":                  {"update", "tunnels"},
+		"DELETE FROM sessions WHERE id = ?":             {"delete", "sessions"},
+		"CREATE TABLE x (id)":                           {"other", ""},
+	}
+	for query, want := range cases {
+		if op, table := sqlWriteLabels(query); op != want[0] || table != want[1] {
+			t.Fatalf("%q: got %s/%s want %s/%s", query, op, table, want[0], want[1])
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tun, err := store.CreateTunnel(context.Background(), 1, "metrics", "metrics.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(context.Background(), tun.ID, AccessRuleInput{AuthMode: "basic_auth", BasicAuthUsername: "demo", BasicAuthPassword: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	reg.Register("metrics.example.com", &captureConn{})
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+	for _, authed := range []bool{true, false} {
+		req := httptest.NewRequest(http.MethodGet, "https://metrics.example.com/", nil)
+		req.Host = "metrics.example.com"
+		if authed {
+			req.SetBasicAuth("demo", "secret")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	stray := httptest.NewRequest(http.MethodGet, "https://stray.example.com/", nil)
+	stray.Host = "stray.example.com"
+	handler.ServeHTTP(httptest.NewRecorder(), stray)
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+	for _, want := range []string{
+		`fwdx_proxy_requests_total{tunnel="metrics.example.com",code="2xx"} 1`,
+		`fwdx_proxy_requests_total{tunnel="metrics.example.com",code="4xx"} 1`,
+		`fwdx_proxy_request_duration_seconds_count{tunnel="metrics.example.com"} 2`,
+		`fwdx_proxy_bytes_total{tunnel="metrics.example.com",direction="out"}`,
+		`fwdx_auth_failures_total{surface="proxy",method="basic_auth"}`,
+		"fwdx_active_tunnels 1\n",
+		"# TYPE fwdx_grpc_streams gauge",
+		`fwdx_sqlite_write_duration_seconds_count{op="insert",table="request_logs"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "stray.example.com") {
+		t.Fatal("unmatched hosts must not become metric labels")
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	tunnelv1 "github.com/BRAVO68WEB/fwdx/api/tunnel/v1"
+)
+
+// mirrorResultRingSize is how many mirror reports are kept per tunnel.
+const mirrorResultRingSize = 1000
+
+// recordMirrorReport stores a mirror report sent by the agent of tunnelID.
+func recordMirrorReport(ctx context.Context, store *Store, tunnelID int64, r *tunnelv1.MirrorReport) error {
+	return store.InsertMirrorResult(ctx, MirrorResultRecord{
+		TunnelID:         tunnelID,
+		Timestamp:        time.Now(),
+		RequestID:        r.GetRequestId(),
+		Method:           r.GetMethod(),
+		Path:             r.GetPath(),
+		PrimaryStatus:    int(r.GetPrimaryStatus()),
+		PrimaryLatencyMS: r.GetPrimaryLatencyMs(),
+		MirrorStatus:     int(r.GetMirrorStatus()),
+		MirrorLatencyMS:  r.GetMirrorLatencyMs(),
+		MirrorError:      r.GetMirrorError(),
+	}, mirrorResultRingSize)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	tunnelv1 "github.com/BRAVO68WEB/fwdx/api/tunnel/v1"
+)
+
+func TestMirrorSummary(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := []*tunnelv1.MirrorReport{
+		{Method: "GET", Path: "/a", PrimaryStatus: 200, PrimaryLatencyMs: 10, MirrorStatus: 200, MirrorLatencyMs: 30},
+		{Method: "GET", Path: "/b", PrimaryStatus: 200, PrimaryLatencyMs: 20, MirrorStatus: 500, MirrorLatencyMs: 50},
+		{Method: "POST", Path: "/c", PrimaryStatus: 201, PrimaryLatencyMs: 99, MirrorError: "local transport error: refused"},
+	}
+	for _, r := range reports {
+		if err := recordMirrorReport(ctx, store, tun.ID, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := store.GetMirrorSummary(ctx, tun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Total != 3 || sum.StatusMismatches != 1 || sum.MirrorErrors != 1 {
+		t.Fatalf("unexpected counts %+v", sum)
+	}
+	if sum.AvgPrimaryLatencyMS != 15 || sum.AvgMirrorLatencyMS != 40 || sum.LastReportAt.IsZero() {
+		t.Fatalf("unexpected latency %+v", sum)
+	}
+	if len(sum.RecentDiffs) != 2 || sum.RecentDiffs[0].Path != "/c" || sum.RecentDiffs[1].Path != "/b" {
+		t.Fatalf("unexpected diffs %+v", sum.RecentDiffs)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := store.InsertMirrorResult(ctx, MirrorResultRecord{TunnelID: tun.ID, Method: "GET", Path: "/", PrimaryStatus: 200, MirrorStatus: 200}, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sum, _ := store.GetMirrorSummary(ctx, tun.ID, 10); sum.Total != 4 || len(sum.RecentDiffs) != 0 {
+		t.Fatalf("ring not trimmed %+v", sum)
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/netip"
@@ -15,6 +15,11 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func maxRequestBodyBytes() int64 {
@@ -40,51 +45,126 @@ func isWebsocketUpgrade(r *http.Request) bool {
 // ProxyHandler handles incoming public HTTPS requests and forwards them to the appropriate tunnel.
 // When the request Host matches serverHostname exactly, a short info page is returned instead of 404.
 func ProxyHandler(registry *Registry, serverHostname string) http.HandlerFunc {
-	return ProxyHandlerWithConfig(registry, Config{Hostname: serverHostname}, nil, nil)
+	return ProxyHandlerWithConfig(registry, Config{Hostname: serverHostname}, nil, nil, nil)
 }
 
 // ProxyHandlerWithStats is ProxyHandler with optional in-memory stats collection.
 func ProxyHandlerWithStats(registry *Registry, serverHostname string, stats *StatsStore, store *Store) http.HandlerFunc {
-	return ProxyHandlerWithConfig(registry, Config{Hostname: serverHostname}, stats, store)
+	return ProxyHandlerWithConfig(registry, Config{Hostname: serverHostname}, stats, store, nil)
 }
 
-func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, store *Store) http.HandlerFunc {
+// ProxyHandlerWithConfig is the full proxy handler. Access log entries go to shipper when it is
+// non-nil. An optional AuthManager enables the oidc access mode.
+func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, store *Store, shipper *LogShipper, auths ...*AuthManager) http.HandlerFunc {
 	trustedPrefixes := parseTrustedProxyCIDRs(cfg.TrustedProxyCIDRs)
+	var auth *AuthManager
+	if len(auths) > 0 {
+		auth = auths[0]
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		hostname := hostWithoutPort(r.Host)
+		traceCtx, span := tracing.Tracer().Start(tracing.ExtractHeader(r.Context(), r.Header), "proxy "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("server.address", hostname),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+		r = r.WithContext(traceCtx)
 		clientIP := resolveClientIP(r, trustedPrefixes)
 		var tunnelRec TunnelRecord
+		credential := ""
+		fault := ""
+		requestID := newRequestID()
+		span.SetAttributes(attribute.String("fwdx.request_id", requestID))
 		record := func(status int, outBytes int, isErr bool, errText string) {
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if tunnelRec.Name != "" {
+				span.SetAttributes(attribute.String("fwdx.tunnel", tunnelRec.Name))
+			}
+			if status == 0 || status >= 500 {
+				span.SetStatus(codes.Error, errText)
+			}
 			inBytes := 0
 			if r.ContentLength > 0 {
 				inBytes = int(r.ContentLength)
 			}
 			if stats != nil {
-				stats.Record(hostname, clientIP, inBytes, outBytes, status, time.Since(start), isErr)
+				// Wildcard tunnels aggregate under their own hostname rather than each name served.
+				statsHost := hostname
+				if tunnelRec.Hostname != "" {
+					statsHost = tunnelRec.Hostname
+				}
+				stats.Record(statsHost, clientIP, inBytes, outBytes, status, time.Since(start), isErr)
 			}
-			if store == nil {
-				return
+			if metricHost := tunnelRec.Hostname; metricHost != "" || registry.Get(hostname) != nil {
+				// Unmatched hosts stay out of the labels so stray Host headers cannot blow up cardinality.
+				if metricHost == "" {
+					metricHost = hostname
+				}
+				metricProxyRequests.Inc(metricHost, statusClass(status))
+				metricProxyDuration.Observe(time.Since(start).Seconds(), metricHost)
+				metricProxyBytes.Add(float64(inBytes), metricHost, "in")
+				metricProxyBytes.Add(float64(outBytes), metricHost, "out")
 			}
 			inBytes64 := int64(0)
 			if r.ContentLength > 0 {
 				inBytes64 = r.ContentLength
 			}
-			_ = store.InsertRequestLog(r.Context(), RequestLogRecord{
-				TunnelID:  tunnelRec.ID,
-				Hostname:  hostname,
-				Timestamp: time.Now(),
-				Method:    r.Method,
-				Host:      r.Host,
-				Path:      r.URL.Path,
-				Status:    status,
-				LatencyMS: time.Since(start).Milliseconds(),
-				BytesIn:   inBytes64,
-				BytesOut:  int64(outBytes),
-				ClientIP:  clientIP,
-				ErrorText: errText,
-				WSUpgrade: isWebsocketUpgrade(r),
+			logRec := RequestLogRecord{
+				TunnelID:   tunnelRec.ID,
+				Hostname:   hostname,
+				Timestamp:  time.Now(),
+				Method:     r.Method,
+				Host:       r.Host,
+				Path:       r.URL.Path,
+				Status:     status,
+				LatencyMS:  time.Since(start).Milliseconds(),
+				BytesIn:    inBytes64,
+				BytesOut:   int64(outBytes),
+				ClientIP:   clientIP,
+				ErrorText:  errText,
+				WSUpgrade:  isWebsocketUpgrade(r),
+				Credential: credential,
+				Fault:      fault,
+			}
+			shipper.Ship(AccessLogEntry{
+				RequestLogRecord: logRec,
+				Tunnel:           tunnelRec.Name,
+				RequestID:        requestID,
+				Proto:            r.Proto,
+				UserAgent:        r.UserAgent(),
+				Referer:          r.Referer(),
 			})
+			if store == nil {
+				return
+			}
+			if tunnelRec.ID != 0 {
+				store.Quotas().AddTransfer(tunnelRec.OwnerUserID, inBytes64+int64(outBytes))
+				store.StatsHistory().Record(tunnelRec.ID, time.Now(), time.Since(start), inBytes64, int64(outBytes), isErr)
+				usage := UsageRecord{
+					Day:         usageDay(time.Now()),
+					OwnerUserID: tunnelRec.OwnerUserID,
+					TunnelName:  tunnelRec.Name,
+					Hostname:    tunnelRec.Hostname,
+					Requests:    1,
+					BytesIn:     inBytes64,
+					BytesOut:    int64(outBytes),
+				}
+				if isErr {
+					usage.Errors = 1
+				}
+				store.UsageBuffer().Add(usage)
+			}
+			_ = store.InsertRequestLog(r.Context(), logRec)
+		}
+		deny := func(status int, msg string) int {
+			return writeProxyError(w, r, store, tunnelRec.ID, status, msg, requestID)
+		}
+		fail := func(status int, msg, errText string) {
+			record(status, deny(status, msg), true, errText)
 		}
 
 		if store != nil {
@@ -92,12 +172,21 @@ func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, s
 			if err == nil {
 				tunnelRec = rec
 			} else if err != sql.ErrNoRows {
-				http.Error(w, "tunnel lookup failed", http.StatusInternalServerError)
-				record(http.StatusInternalServerError, len("tunnel lookup failed\n"), true, "tunnel lookup failed")
+				fail(http.StatusInternalServerError, "tunnel lookup failed", "tunnel lookup failed")
 				return
 			}
 		}
 
+		if tunnelExpired(tunnelRec, time.Now()) {
+			fail(http.StatusGone, "tunnel expired", "tunnel expired")
+			return
+		}
+
+		if tunnelRec.Maintenance && !maintenanceBypassed(tunnelRec, r, clientIP) {
+			record(http.StatusServiceUnavailable, writeMaintenance(w, tunnelRec, deny), true, "maintenance")
+			return
+		}
+
 		conn := registry.Get(hostname)
 		if conn == nil {
 			if hostname != "" && hostname == hostWithoutPort(cfg.Hostname) && tunnelRec.ID == 0 {
@@ -106,19 +195,17 @@ func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, s
 				_, _ = w.Write([]byte("fwdx server at " + cfg.Hostname + ".\n\n" +
 					"Use a subdomain to reach your tunnel (e.g. myapp." + cfg.Hostname + ").\n" +
 					"Create a tunnel from your machine: fwdx tunnel create -l localhost:8080 -s myapp --name myapp && fwdx tunnel start myapp\n"))
-				log.Printf("[fwdx] proxy host=%s method=%s path=%s status=200 (server info)", hostname, r.Method, r.URL.Path)
+				slog.Info("proxy server info", "hostname", hostname, "method", r.Method, "path", r.URL.Path, "status", http.StatusOK, "request_id", requestID)
 				record(http.StatusOK, 0, false, "")
 				return
 			}
 			if tunnelRec.ID > 0 {
-				log.Printf("[fwdx] proxy host=%s method=%s path=%s tunnel unavailable (502)", hostname, r.Method, r.URL.Path)
-				http.Error(w, "tunnel unavailable", http.StatusBadGateway)
-				record(http.StatusBadGateway, len("tunnel unavailable\n"), true, "tunnel unavailable")
+				slog.Warn("proxy tunnel unavailable", "tunnel", tunnelRec.Name, "hostname", hostname, "method", r.Method, "path", r.URL.Path, "status", http.StatusBadGateway, "request_id", requestID)
+				fail(http.StatusBadGateway, "tunnel unavailable", "tunnel unavailable")
 				return
 			}
-			log.Printf("[fwdx] proxy host=%s method=%s path=%s status=404 no tunnel for hostname", hostname, r.Method, r.URL.Path)
-			http.Error(w, "no tunnel for this hostname", http.StatusNotFound)
-			record(http.StatusNotFound, len("no tunnel for this hostname\n"), true, "no tunnel for this hostname")
+			slog.Info("proxy no tunnel for hostname", "hostname", hostname, "method", r.Method, "path", r.URL.Path, "status", http.StatusNotFound, "request_id", requestID)
+			fail(http.StatusNotFound, "no tunnel for this hostname", "no tunnel for this hostname")
 			return
 		}
 
@@ -129,32 +216,74 @@ func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, s
 			}
 		}
 
+		var redact []string
 		if tunnelRec.ID > 0 && store != nil {
 			rule, err := store.GetTunnelAccessRule(r.Context(), tunnelRec.ID)
 			if err != nil && err != sql.ErrNoRows {
-				http.Error(w, "tunnel access rule lookup failed", http.StatusInternalServerError)
-				record(http.StatusInternalServerError, len("tunnel access rule lookup failed\n"), true, "tunnel access rule lookup failed")
+				fail(http.StatusInternalServerError, "tunnel access rule lookup failed", "tunnel access rule lookup failed")
 				return
 			}
 			if err == nil {
+				redact = captureRedactions(rule)
 				if !allowedByIP(rule, clientIP) {
-					http.Error(w, "forbidden", http.StatusForbidden)
-					record(http.StatusForbidden, len("forbidden\n"), true, "ip not allowed")
+					metricAuthFailures.Inc("proxy", "ip_allowlist")
+					fail(http.StatusForbidden, "forbidden", "ip not allowed")
 					return
 				}
 				switch rule.AuthMode {
 				case "basic_auth":
 					user, pass, ok := r.BasicAuth()
-					if !ok || !verifyAccessRuleBasicAuth(rule, user, pass) {
+					if ok {
+						credential = matchAccessCredential(r.Context(), store, rule, "basic_auth", user, pass)
+					}
+					if credential == "" {
+						metricAuthFailures.Inc("proxy", rule.AuthMode)
 						w.Header().Set("WWW-Authenticate", `Basic realm="fwdx"`)
-						http.Error(w, "unauthorized", http.StatusUnauthorized)
-						record(http.StatusUnauthorized, len("unauthorized\n"), true, "basic auth failed")
+						fail(http.StatusUnauthorized, "unauthorized", "basic auth failed")
 						return
 					}
 				case "shared_secret_header":
-					if !verifyAccessRuleSharedSecret(rule, r.Header.Get(rule.SharedSecretHeaderName)) {
-						http.Error(w, "unauthorized", http.StatusUnauthorized)
-						record(http.StatusUnauthorized, len("unauthorized\n"), true, "shared secret failed")
+					if v := r.Header.Get(rule.SharedSecretHeaderName); v != "" {
+						credential = matchAccessCredential(r.Context(), store, rule, "shared_secret", "", v)
+					}
+					if credential == "" {
+						metricAuthFailures.Inc("proxy", rule.AuthMode)
+						fail(http.StatusUnauthorized, "unauthorized", "shared secret failed")
+						return
+					}
+				case "oidc":
+					if auth == nil {
+						fail(http.StatusServiceUnavailable, "oidc not configured", "oidc not configured")
+						return
+					}
+					if handled, status, errText := auth.checkTunnelOIDC(w, r, tunnelRec, rule, deny); handled {
+						if status == http.StatusUnauthorized || status == http.StatusForbidden {
+							metricAuthFailures.Inc("proxy", rule.AuthMode)
+						}
+						record(status, 0, status >= 400, errText)
+						return
+					}
+				case "signed_link":
+					if handled, status, errText := checkShareLink(w, r, rule, tunnelPublicScheme(auth) == "https", deny); handled {
+						if status == http.StatusUnauthorized || status == http.StatusForbidden {
+							metricAuthFailures.Inc("proxy", rule.AuthMode)
+						}
+						record(status, 0, status >= 400, errText)
+						return
+					}
+				case "jwt":
+					if auth == nil {
+						fail(http.StatusServiceUnavailable, "jwt validation unavailable", "jwt validation unavailable")
+						return
+					}
+					if unavailable, err := auth.verifyTunnelJWT(r.Context(), r, rule); err != nil {
+						if unavailable {
+							fail(http.StatusServiceUnavailable, "jwt validation unavailable", "jwt: "+err.Error())
+							return
+						}
+						metricAuthFailures.Inc("proxy", rule.AuthMode)
+						w.Header().Set("WWW-Authenticate", `Bearer realm="fwdx", error="invalid_token"`)
+						fail(http.StatusUnauthorized, "unauthorized", "jwt: "+err.Error())
 						return
 					}
 				}
@@ -162,25 +291,58 @@ func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, s
 		}
 
 		if isWebsocketUpgrade(r) {
-			http.Error(w, "websocket tunneling is not implemented in this release", http.StatusNotImplemented)
-			record(http.StatusNotImplemented, len("websocket tunneling is not implemented in this release\n"), true, "websocket tunneling is not implemented in this release")
+			fail(http.StatusNotImplemented, "websocket tunneling is not implemented in this release", "websocket tunneling is not implemented in this release")
 			return
 		}
 
+		if tunnelRec.OwnerUserID > 0 && store != nil {
+			release, status, msg := store.Quotas().Admit(r.Context(), tunnelRec.OwnerUserID)
+			if status != 0 {
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "1")
+				}
+				fail(status, msg, "quota: "+msg)
+				return
+			}
+			defer release()
+		}
+
 		maxBody := maxRequestBodyBytes()
 		if r.ContentLength > maxBody {
-			http.Error(w, fmt.Sprintf("request body too large (max %d bytes)", maxBody), http.StatusRequestEntityTooLarge)
-			record(http.StatusRequestEntityTooLarge, 0, true, "request body too large")
+			fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body too large (max %d bytes)", maxBody), "request body too large")
 			return
 		}
 		body, _ := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
 		_ = r.Body.Close()
 		if int64(len(body)) > maxBody {
-			http.Error(w, fmt.Sprintf("request body too large (max %d bytes)", maxBody), http.StatusRequestEntityTooLarge)
-			record(http.StatusRequestEntityTooLarge, 0, true, "request body too large")
+			fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body too large (max %d bytes)", maxBody), "request body too large")
 			return
 		}
 
+		throttle := 0
+		if tunnelRec.ID > 0 && store != nil {
+			if policy, err := store.GetTunnelChaos(r.Context(), tunnelRec.ID); err == nil {
+				if plan, ok := policy.plan(r.URL.Path, time.Now(), chaosRoll); ok {
+					fault = plan.String()
+					if !sleepContext(r.Context(), plan.delay) {
+						record(0, 0, true, "client canceled during injected latency")
+						return
+					}
+					if plan.drop {
+						slog.Info("proxy chaos dropped connection", "tunnel", tunnelRec.Name, "hostname", hostname, "method", r.Method, "path", r.URL.Path, "request_id", requestID)
+						record(0, 0, true, "chaos: connection dropped")
+						dropConnection(w)
+						return
+					}
+					if plan.status != 0 {
+						fail(plan.status, "injected fault", fmt.Sprintf("chaos: injected %d", plan.status))
+						return
+					}
+					throttle = plan.bytesPerSec
+				}
+			}
+		}
+
 		pr := &ProxyRequest{
 			Method: r.Method,
 			Path:   r.URL.Path,
@@ -188,15 +350,24 @@ func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, s
 			Header: r.Header.Clone(),
 			Body:   body,
 		}
+		stripCookie(pr.Header, tunnelSessionCookieName)
+		stripCookie(pr.Header, shareLinkCookieName)
+		pr.Header.Del(maintenanceBypassHeader)
 
 		ctx, cancel := context.WithTimeout(r.Context(), 65*time.Second)
 		defer cancel()
 
 		resp, closed := conn.EnqueueRequest(ctx, pr)
+		if tunnelRec.Capture && store != nil {
+			if closed || resp == nil {
+				_, _ = captureExchange(r.Context(), store, tunnelRec.ID, pr, nil, time.Since(start), 0, "tunnel unavailable", redact...)
+			} else {
+				_, _ = captureExchange(r.Context(), store, tunnelRec.ID, pr, resp, time.Since(start), 0, "", redact...)
+			}
+		}
 		if closed || resp == nil {
-			log.Printf("[fwdx] proxy host=%s method=%s path=%s tunnel unavailable (502)", hostname, r.Method, r.URL.Path)
-			http.Error(w, "tunnel unavailable", http.StatusBadGateway)
-			record(http.StatusBadGateway, len("tunnel unavailable\n"), true, "tunnel unavailable")
+			slog.Warn("proxy tunnel unavailable", "tunnel", tunnelRec.Name, "hostname", hostname, "method", r.Method, "path", r.URL.Path, "status", http.StatusBadGateway, "request_id", requestID)
+			fail(http.StatusBadGateway, "tunnel unavailable", "tunnel unavailable")
 			return
 		}
 
@@ -206,11 +377,13 @@ func ProxyHandlerWithConfig(registry *Registry, cfg Config, stats *StatsStore, s
 			}
 		}
 		w.WriteHeader(resp.Status)
-		if len(resp.Body) > 0 {
+		if throttle > 0 {
+			writeThrottled(r.Context(), w, resp.Body, throttle)
+		} else if len(resp.Body) > 0 {
 			_, _ = io.Copy(w, bytes.NewReader(resp.Body))
 		}
 		record(resp.Status, len(resp.Body), resp.Status >= 400, "")
-		log.Printf("[fwdx] proxy host=%s method=%s path=%s status=%d", hostname, r.Method, r.URL.Path, resp.Status)
+		slog.Info("proxy", "tunnel", tunnelRec.Name, "hostname", hostname, "method", r.Method, "path", r.URL.Path, "status", resp.Status, "request_id", requestID)
 	}
 }
 
@@ -277,15 +450,41 @@ func peerTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
 	return false
 }
 
+// matchAccessCredential returns the name of the credential that authenticates the request:
+// "primary" for the access rule's own secret, otherwise a named tunnel credential.
+// An empty result means authentication failed.
+func matchAccessCredential(ctx context.Context, store *Store, rule TunnelAccessRuleRecord, kind, username, secret string) string {
+	switch kind {
+	case "basic_auth":
+		if rule.BasicAuthPasswordHash != "" && verifyAccessRuleBasicAuth(rule, username, secret) {
+			return "primary"
+		}
+	case "shared_secret":
+		if rule.SharedSecretHash != "" && verifyAccessRuleSharedSecret(rule, secret) {
+			return "primary"
+		}
+	}
+	cred, err := store.MatchTunnelCredential(ctx, rule.TunnelID, kind, username, hashSecret(secret))
+	if err != nil {
+		return ""
+	}
+	return cred.Name
+}
+
 func allowedByIP(rule TunnelAccessRuleRecord, clientIP string) bool {
 	if len(rule.AllowedIPs) == 0 {
 		return true
 	}
+	return ipInPrefixes(rule.AllowedIPs, clientIP)
+}
+
+// ipInPrefixes reports whether clientIP falls inside any of the CIDRs.
+func ipInPrefixes(cidrs []string, clientIP string) bool {
 	ip, err := netip.ParseAddr(strings.TrimSpace(clientIP))
 	if err != nil {
 		return false
 	}
-	for _, raw := range rule.AllowedIPs {
+	for _, raw := range cidrs {
 		prefix, err := netip.ParsePrefix(strings.TrimSpace(raw))
 		if err == nil && prefix.Contains(ip) {
 			return true
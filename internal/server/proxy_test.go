@@ -223,7 +223,7 @@ func TestProxyHandler_BasicAuthRule(t *testing.T) {
 	}
 	reg := NewRegistry()
 	reg.Register("app.example.com", &captureConn{})
-	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
 	req.Host = "app.example.com"
@@ -269,7 +269,7 @@ func TestProxyHandler_SharedSecretRule(t *testing.T) {
 	}
 	reg := NewRegistry()
 	reg.Register("app.example.com", &captureConn{})
-	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
 	req.Host = "app.example.com"
@@ -307,7 +307,7 @@ func TestProxyHandler_IPAllowlist_TrustedProxy(t *testing.T) {
 	}
 	reg := NewRegistry()
 	reg.Register("app.example.com", &captureConn{})
-	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com", TrustedProxyCIDRs: []string{"10.0.0.0/8"}}, nil, store)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com", TrustedProxyCIDRs: []string{"10.0.0.0/8"}}, nil, store, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
 	req.Host = "app.example.com"
@@ -338,7 +338,7 @@ func TestProxyHandler_IPAllowlist_UntrustedProxy(t *testing.T) {
 	}
 	reg := NewRegistry()
 	reg.Register("app.example.com", &captureConn{})
-	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com", TrustedProxyCIDRs: []string{"10.0.0.0/8"}}, nil, store)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com", TrustedProxyCIDRs: []string{"10.0.0.0/8"}}, nil, store, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
 	req.Host = "app.example.com"
@@ -350,3 +350,78 @@ func TestProxyHandler_IPAllowlist_UntrustedProxy(t *testing.T) {
 		t.Fatalf("status=%d want 403", rec.Code)
 	}
 }
+
+func TestProxyHandler_NamedCredentials(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(ctx, tun.ID, AccessRuleInput{
+		AuthMode:          "basic_auth",
+		BasicAuthUsername: "demo",
+		BasicAuthPassword: "secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnelCredential(ctx, tun.ID, "partner-a", "basic_auth", "alice", hashSecret("alice-pw"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	reg.Register("app.example.com", &captureConn{})
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+
+	do := func(user, pass string) int {
+		req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+		req.Host = "app.example.com"
+		req.SetBasicAuth(user, pass)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := do("alice", "alice-pw"); code != http.StatusOK {
+		t.Fatalf("named credential status=%d want 200", code)
+	}
+	if code := do("demo", "secret"); code != http.StatusOK {
+		t.Fatalf("primary credential status=%d want 200", code)
+	}
+	if err := store.DeleteTunnelCredential(ctx, tun.ID, "partner-a"); err != nil {
+		t.Fatal(err)
+	}
+	if code := do("alice", "alice-pw"); code != http.StatusUnauthorized {
+		t.Fatalf("revoked credential status=%d want 401", code)
+	}
+
+	logs, err := store.ListRequestLogsByTunnel(ctx, tun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, l := range logs {
+		got[l.Credential]++
+	}
+	if got["partner-a"] != 1 || got["primary"] != 1 || got[""] != 1 {
+		t.Fatalf("unexpected credentials in logs: %v", got)
+	}
+	// Without a primary password only named credentials get in.
+	if _, err := store.CreateTunnelCredential(ctx, tun.ID, "partner-b", "basic_auth", "bob", hashSecret("bob-pw"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(ctx, tun.ID, AccessRuleInput{AuthMode: "basic_auth", ClearPrimarySecret: true}); err != nil {
+		t.Fatal(err)
+	}
+	if code := do("demo", "secret"); code != http.StatusUnauthorized {
+		t.Fatalf("cleared primary status=%d want 401", code)
+	}
+	if code := do("demo", ""); code != http.StatusUnauthorized {
+		t.Fatalf("empty password status=%d want 401", code)
+	}
+	if code := do("bob", "bob-pw"); code != http.StatusOK {
+		t.Fatalf("named credential without primary status=%d want 200", code)
+	}
+}
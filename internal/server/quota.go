@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	QuotaScopeUser    = "user"
+	QuotaScopeGroup   = "group"
+	QuotaScopeDefault = "default"
+
+	// quotaCacheTTL bounds how long a user's resolved limits are reused, so group changes picked
+	// up at login take effect without a quota edit.
+	quotaCacheTTL = 30 * time.Second
+)
+
+// errQuotaExceeded marks requests refused because the user is at a quota limit.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaUsage is a user's effective limits next to what they currently use.
+type QuotaUsage struct {
+	Limits        QuotaLimits `json:"limits"`
+	Source        string      `json:"source"`
+	Month         string      `json:"month"`
+	Tunnels       int         `json:"tunnels"`
+	Agents        int         `json:"agents"`
+	Connections   int         `json:"connections"`
+	TransferBytes int64       `json:"transfer_bytes"`
+}
+
+// QuotaManager resolves and enforces per-user quotas. It tracks in-flight proxied requests in
+// memory and reads monthly transfer totals from the usage accounting in the store.
+type QuotaManager struct {
+	store *Store
+
+	mu       sync.Mutex
+	active   map[int64]int
+	transfer map[int64]monthTransfer
+	limits   map[int64]cachedQuota
+}
+
+type monthTransfer struct {
+	month string
+	bytes int64
+}
+
+type cachedQuota struct {
+	limits  QuotaLimits
+	source  string
+	version uint64
+	loaded  time.Time
+}
+
+func newQuotaManager(store *Store) *QuotaManager {
+	return &QuotaManager{
+		store:    store,
+		active:   make(map[int64]int),
+		transfer: make(map[int64]monthTransfer),
+		limits:   make(map[int64]cachedQuota),
+	}
+}
+
+// effectiveQuota picks the quota that applies to user: their own, else the most generous of
+// their groups' quotas, else the default. Admins are never limited. source names the rule used.
+func effectiveQuota(user UserRecord, defs []QuotaRecord) (QuotaLimits, string) {
+	if user.Role == "admin" {
+		return QuotaLimits{}, "admin"
+	}
+	var def *QuotaRecord
+	var groups []QuotaRecord
+	for i, rec := range defs {
+		switch rec.Scope {
+		case QuotaScopeUser:
+			if strings.EqualFold(rec.Subject, user.Email) {
+				return rec.QuotaLimits, "user " + rec.Subject
+			}
+		case QuotaScopeGroup:
+			for _, g := range user.Groups {
+				if rec.Subject == g {
+					groups = append(groups, rec)
+					break
+				}
+			}
+		case QuotaScopeDefault:
+			def = &defs[i]
+		}
+	}
+	if len(groups) > 0 {
+		out := groups[0].QuotaLimits
+		names := []string{groups[0].Subject}
+		for _, rec := range groups[1:] {
+			out.MaxTunnels = generousLimit(out.MaxTunnels, rec.MaxTunnels)
+			out.MaxAgents = generousLimit(out.MaxAgents, rec.MaxAgents)
+			out.MaxConnections = generousLimit(out.MaxConnections, rec.MaxConnections)
+			out.MonthlyTransferBytes = generousLimit(out.MonthlyTransferBytes, rec.MonthlyTransferBytes)
+			names = append(names, rec.Subject)
+		}
+		return out, "group " + strings.Join(names, ", ")
+	}
+	if def != nil {
+		return def.QuotaLimits, "default"
+	}
+	return QuotaLimits{}, "none"
+}
+
+// generousLimit returns the larger limit, treating zero as unlimited.
+func generousLimit[T int | int64](a, b T) T {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+func quotaMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// Limits returns the user's effective limits and where they come from.
+func (q *QuotaManager) Limits(ctx context.Context, user UserRecord) (QuotaLimits, string, error) {
+	version := q.store.QuotasVersion()
+	q.mu.Lock()
+	c, ok := q.limits[user.ID]
+	q.mu.Unlock()
+	if ok && c.version == version && time.Since(c.loaded) < quotaCacheTTL {
+		return c.limits, c.source, nil
+	}
+	defs, err := q.store.ListQuotas(ctx)
+	if err != nil {
+		return QuotaLimits{}, "", err
+	}
+	limits, source := effectiveQuota(user, defs)
+	q.mu.Lock()
+	q.limits[user.ID] = cachedQuota{limits: limits, source: source, version: version, loaded: time.Now()}
+	q.mu.Unlock()
+	return limits, source, nil
+}
+
+func (q *QuotaManager) limitsForUserID(ctx context.Context, userID int64) (QuotaLimits, error) {
+	version := q.store.QuotasVersion()
+	q.mu.Lock()
+	c, ok := q.limits[userID]
+	q.mu.Unlock()
+	if ok && c.version == version && time.Since(c.loaded) < quotaCacheTTL {
+		return c.limits, nil
+	}
+	user, err := q.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return QuotaLimits{}, err
+	}
+	limits, _, err := q.Limits(ctx, user)
+	return limits, err
+}
+
+func (q *QuotaManager) transferUsed(ctx context.Context, userID int64, month string) (int64, error) {
+	q.mu.Lock()
+	t, ok := q.transfer[userID]
+	q.mu.Unlock()
+	if ok && t.month == month {
+		return t.bytes, nil
+	}
+	n, err := q.store.GetUserTransfer(ctx, userID, month)
+	if err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	if cur, ok := q.transfer[userID]; !ok || cur.month != month {
+		q.transfer[userID] = monthTransfer{month: month, bytes: n}
+	}
+	q.mu.Unlock()
+	return n, nil
+}
+
+// Admit checks the tunnel owner's transfer and connection quotas before a request is proxied.
+// On success it counts the request as in flight until release is called; otherwise it returns
+// the status (403 or 429) and message to send.
+func (q *QuotaManager) Admit(ctx context.Context, ownerUserID int64) (release func(), status int, msg string) {
+	limits, err := q.limitsForUserID(ctx, ownerUserID)
+	if err != nil {
+		// A quota lookup failure must not take the owner's traffic down with it.
+		if err != sql.ErrNoRows {
+			slog.Error("quota lookup failed", "user_id", ownerUserID, "error", err)
+		}
+		return func() {}, 0, ""
+	}
+	if limits.MonthlyTransferBytes > 0 {
+		used, err := q.transferUsed(ctx, ownerUserID, quotaMonth(time.Now()))
+		if err == nil && used >= limits.MonthlyTransferBytes {
+			return nil, http.StatusForbidden, "monthly transfer quota exceeded"
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if limits.MaxConnections > 0 && q.active[ownerUserID] >= limits.MaxConnections {
+		return nil, http.StatusTooManyRequests, "too many concurrent connections for this tunnel owner"
+	}
+	q.active[ownerUserID]++
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.active[ownerUserID]--; q.active[ownerUserID] <= 0 {
+			delete(q.active, ownerUserID)
+		}
+	}, 0, ""
+}
+
+// AddTransfer counts n proxied bytes against the owner's cached monthly transfer. The bytes are
+// persisted through Store.UsageBuffer; this keeps Admit from re-reading them on every request.
+func (q *QuotaManager) AddTransfer(ownerUserID int64, n int64) {
+	if ownerUserID == 0 || n <= 0 {
+		return
+	}
+	month := quotaMonth(time.Now())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, ok := q.transfer[ownerUserID]; ok && t.month == month {
+		t.bytes += n
+		q.transfer[ownerUserID] = t
+	}
+}
+
+// CheckCreate returns an errQuotaExceeded error when user may not create another tunnel or
+// agent. kind is "tunnel" or "agent".
+func (q *QuotaManager) CheckCreate(ctx context.Context, user UserRecord, kind string) error {
+	limits, _, err := q.Limits(ctx, user)
+	if err != nil {
+		return err
+	}
+	tunnels, agents, err := q.store.CountUserResources(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == "tunnel" && limits.MaxTunnels > 0 && tunnels >= limits.MaxTunnels:
+		return fmt.Errorf("%w: tunnel limit reached (%d of %d)", errQuotaExceeded, tunnels, limits.MaxTunnels)
+	case kind == "agent" && limits.MaxAgents > 0 && agents >= limits.MaxAgents:
+		return fmt.Errorf("%w: agent limit reached (%d of %d)", errQuotaExceeded, agents, limits.MaxAgents)
+	}
+	return nil
+}
+
+// Usage reports user's limits and current consumption.
+func (q *QuotaManager) Usage(ctx context.Context, user UserRecord) (QuotaUsage, error) {
+	limits, source, err := q.Limits(ctx, user)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	u := QuotaUsage{Limits: limits, Source: source, Month: quotaMonth(time.Now())}
+	if u.Tunnels, u.Agents, err = q.store.CountUserResources(ctx, user.ID); err != nil {
+		return QuotaUsage{}, err
+	}
+	if u.TransferBytes, err = q.transferUsed(ctx, user.ID, u.Month); err != nil {
+		return QuotaUsage{}, err
+	}
+	q.mu.Lock()
+	u.Connections = q.active[user.ID]
+	q.mu.Unlock()
+	return u, nil
+}
+
+// quotaStatus maps CheckCreate errors to HTTP status codes.
+func quotaStatus(err error) int {
+	if errors.Is(err, errQuotaExceeded) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// normalizeQuota validates a quota definition from the API or UI.
+func normalizeQuota(rec QuotaRecord) (QuotaRecord, error) {
+	rec.Scope = strings.TrimSpace(strings.ToLower(rec.Scope))
+	rec.Subject = strings.TrimSpace(rec.Subject)
+	switch rec.Scope {
+	case QuotaScopeUser:
+		rec.Subject = strings.ToLower(rec.Subject)
+	case QuotaScopeGroup:
+	case QuotaScopeDefault:
+		rec.Subject = ""
+	default:
+		return QuotaRecord{}, fmt.Errorf("scope must be user, group or default")
+	}
+	if rec.Scope != QuotaScopeDefault && rec.Subject == "" {
+		return QuotaRecord{}, fmt.Errorf("subject required for %s quotas", rec.Scope)
+	}
+	if rec.MaxTunnels < 0 || rec.MaxAgents < 0 || rec.MaxConnections < 0 || rec.MonthlyTransferBytes < 0 {
+		return QuotaRecord{}, fmt.Errorf("limits must be zero (unlimited) or positive")
+	}
+	return rec, nil
+}
+
+// handleQuotas serves /api/quotas: GET /me returns the caller's usage; admins also GET the
+// definitions, PUT one {scope, subject, max_tunnels, max_agents, max_connections,
+// monthly_transfer_bytes} and DELETE /{id}.
+func handleQuotas(w http.ResponseWriter, r *http.Request, store *Store, user *UserRecord, rest []string) {
+	if len(rest) == 1 && rest[0] == "me" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		usage, err := store.Quotas().Usage(r.Context(), *user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, usage)
+		return
+	}
+	if user.Role != "admin" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if len(rest) == 0 || rest[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := store.ListQuotas(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if list == nil {
+				list = []QuotaRecord{}
+			}
+			writeJSON(w, http.StatusOK, list)
+		case http.MethodPut:
+			var body QuotaRecord
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			rec, err := normalizeQuota(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if rec, err = store.UpsertQuota(r.Context(), rec); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, rec)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil || len(rest) != 1 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := store.DeleteQuota(r.Context(), id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "quota not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
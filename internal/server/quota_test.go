@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEffectiveQuota(t *testing.T) {
+	defs := []QuotaRecord{
+		{Scope: QuotaScopeDefault, QuotaLimits: QuotaLimits{MaxTunnels: 1, MaxAgents: 1}},
+		{Scope: QuotaScopeGroup, Subject: "eng", QuotaLimits: QuotaLimits{MaxTunnels: 5, MaxConnections: 10, MonthlyTransferBytes: 100}},
+		{Scope: QuotaScopeGroup, Subject: "ops", QuotaLimits: QuotaLimits{MaxTunnels: 3, MaxConnections: 0, MonthlyTransferBytes: 200}},
+		{Scope: QuotaScopeUser, Subject: "carol@example.com", QuotaLimits: QuotaLimits{MaxTunnels: 9}},
+	}
+	cases := []struct {
+		user   UserRecord
+		want   QuotaLimits
+		source string
+	}{
+		{UserRecord{Email: "root@example.com", Role: "admin"}, QuotaLimits{}, "admin"},
+		{UserRecord{Email: "bob@example.com"}, QuotaLimits{MaxTunnels: 1, MaxAgents: 1}, "default"},
+		{UserRecord{Email: "dan@example.com", Groups: []string{"eng"}}, QuotaLimits{MaxTunnels: 5, MaxConnections: 10, MonthlyTransferBytes: 100}, "group eng"},
+		{UserRecord{Email: "eve@example.com", Groups: []string{"eng", "ops"}}, QuotaLimits{MaxTunnels: 5, MonthlyTransferBytes: 200}, "group eng, ops"},
+		{UserRecord{Email: "Carol@example.com", Groups: []string{"eng"}}, QuotaLimits{MaxTunnels: 9}, "user carol@example.com"},
+	}
+	for _, c := range cases {
+		got, source := effectiveQuota(c.user, defs)
+		if got != c.want || source != c.source {
+			t.Fatalf("%s: got %+v (%s) want %+v (%s)", c.user.Email, got, source, c.want, c.source)
+		}
+	}
+	if got, source := effectiveQuota(UserRecord{Email: "x@example.com"}, nil); got != (QuotaLimits{}) || source != "none" {
+		t.Fatalf("no quotas: got %+v (%s)", got, source)
+	}
+}
+
+func TestQuotaManager_Admit(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	ctx := context.Background()
+	cfg := Config{Hostname: "tunnel.example.com"}
+	auth, err := NewAuthManager(ctx, cfg, ds.store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, user, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: "alice", Email: "alice@example.com"}, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.store.UpsertQuota(ctx, QuotaRecord{Scope: QuotaScopeDefault, QuotaLimits: QuotaLimits{MaxConnections: 1, MonthlyTransferBytes: 1000}}); err != nil {
+		t.Fatal(err)
+	}
+	q := ds.store.Quotas()
+	release, status, _ := q.Admit(ctx, user.ID)
+	if status != 0 {
+		t.Fatalf("first request status=%d", status)
+	}
+	if _, status, _ := q.Admit(ctx, user.ID); status != http.StatusTooManyRequests {
+		t.Fatalf("concurrent request status=%d want 429", status)
+	}
+	release()
+	release, status, _ = q.Admit(ctx, user.ID)
+	if status != 0 {
+		t.Fatalf("after release status=%d", status)
+	}
+	release()
+
+	if err := ds.store.RecordUsage(ctx, UsageRecord{Day: usageDay(time.Now()), OwnerUserID: user.ID, TunnelName: "app", Hostname: "app.tunnel.example.com", Requests: 1, BytesOut: 1500}); err != nil {
+		t.Fatal(err)
+	}
+	q.AddTransfer(user.ID, 1500)
+	if _, status, _ := q.Admit(ctx, user.ID); status != http.StatusForbidden {
+		t.Fatalf("over transfer status=%d want 403", status)
+	}
+	usage, err := q.Usage(ctx, user)
+	if err != nil || usage.TransferBytes != 1500 || usage.Source != "default" {
+		t.Fatalf("usage=%+v err=%v", usage, err)
+	}
+	if _, status, _ := q.Admit(ctx, 0); status != 0 {
+		t.Fatalf("unowned tunnel status=%d", status)
+	}
+}
+
+func TestQuotasAPI(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	ctx := context.Background()
+	cfg := Config{Hostname: "tunnel.example.com"}
+	auth, err := NewAuthManager(ctx, cfg, ds.store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(subject, role string, groups ...string) string {
+		raw, _, _, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: subject, Email: subject + "@example.com", Groups: groups}, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	admin, alice, bob := session("admin", "admin"), session("alice", "member", "eng"), session("bob", "member")
+	srv := httptest.NewServer(ControlPlaneRouter(cfg, NewRegistry(), ds, ds.store, auth))
+	defer srv.Close()
+	call := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf := new(strings.Builder)
+		_, _ = io.Copy(buf, resp.Body)
+		return resp.StatusCode, buf.String()
+	}
+
+	if code, _ := call(bob, http.MethodPut, "/api/quotas", `{"scope":"default","max_tunnels":1}`); code != http.StatusForbidden {
+		t.Fatalf("member put status=%d", code)
+	}
+	if code, body := call(admin, http.MethodPut, "/api/quotas", `{"scope":"bogus"}`); code != http.StatusBadRequest {
+		t.Fatalf("bad scope status=%d body=%s", code, body)
+	}
+	if code, body := call(admin, http.MethodPut, "/api/quotas", `{"scope":"default","max_tunnels":1,"max_agents":1}`); code != http.StatusOK {
+		t.Fatalf("put default status=%d body=%s", code, body)
+	}
+	if code, body := call(admin, http.MethodPut, "/api/quotas", `{"scope":"group","subject":"eng","max_tunnels":2}`); code != http.StatusOK {
+		t.Fatalf("put group status=%d body=%s", code, body)
+	}
+
+	tunnel := func(token, name string) int {
+		code, _ := call(token, http.MethodPost, "/api/tunnels", `{"name":"`+name+`","subdomain":"`+name+`","local":"http://localhost:3000"}`)
+		return code
+	}
+	if code := tunnel(bob, "bob1"); code != http.StatusCreated {
+		t.Fatalf("bob first tunnel status=%d", code)
+	}
+	if code := tunnel(bob, "bob2"); code != http.StatusForbidden {
+		t.Fatalf("bob second tunnel status=%d want 403", code)
+	}
+	if tunnel(alice, "alice1") != http.StatusCreated || tunnel(alice, "alice2") != http.StatusCreated {
+		t.Fatal("alice should get the eng group's two tunnels")
+	}
+	if code := tunnel(alice, "alice3"); code != http.StatusForbidden {
+		t.Fatalf("alice third tunnel status=%d want 403", code)
+	}
+	if code := tunnel(admin, "admin1"); code != http.StatusCreated {
+		t.Fatalf("admin tunnel status=%d", code)
+	}
+	if code, _ := call(bob, http.MethodPost, "/api/agents", `{"name":"laptop"}`); code != http.StatusCreated {
+		t.Fatalf("bob first agent status=%d", code)
+	}
+	if code, body := call(bob, http.MethodPost, "/api/agents", `{"name":"server"}`); code != http.StatusForbidden || !strings.Contains(body, "agent limit") {
+		t.Fatalf("bob second agent status=%d body=%s", code, body)
+	}
+
+	if code, body := call(bob, http.MethodGet, "/api/quotas/me", ""); code != http.StatusOK || !strings.Contains(body, `"tunnels":1`) || !strings.Contains(body, `"source":"default"`) {
+		t.Fatalf("usage status=%d body=%s", code, body)
+	}
+	list, err := ds.store.ListQuotas(ctx)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list=%v err=%v", list, err)
+	}
+	for _, rec := range list {
+		if code, _ := call(admin, http.MethodDelete, "/api/quotas/"+strconv.FormatInt(rec.ID, 10), ""); code != http.StatusNoContent {
+			t.Fatalf("delete status=%d", code)
+		}
+	}
+	if code := tunnel(bob, "bob2"); code != http.StatusCreated {
+		t.Fatalf("bob tunnel after quota removal status=%d", code)
+	}
+}
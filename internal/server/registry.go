@@ -1,10 +1,12 @@
 package server
 
 import (
+	"strings"
 	"sync"
 )
 
 // Registry maps hostname to the active tunnel connection (gRPC). In-memory only.
+// Hostnames may start with a "*." label to serve every name below it.
 type Registry struct {
 	mu      sync.RWMutex
 	tunnels map[string]TunnelConnection
@@ -56,10 +58,18 @@ func (r *Registry) Disconnect(hostname string) bool {
 	return false
 }
 
+// Get returns the tunnel for hostname: an exact registration first, then the wildcard covering
+// its leading label.
 func (r *Registry) Get(hostname string) TunnelConnection {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	return r.tunnels[hostname]
+	if conn := r.tunnels[hostname]; conn != nil {
+		return conn
+	}
+	if pattern := wildcardFor(hostname); pattern != "" {
+		return r.tunnels[pattern]
+	}
+	return nil
 }
 
 // List returns hostname -> client remote address for all registered tunnels.
@@ -72,3 +82,44 @@ func (r *Registry) List() map[string]string {
 	}
 	return out
 }
+
+// connections returns a snapshot of hostname -> connection.
+func (r *Registry) connections() map[string]TunnelConnection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]TunnelConnection, len(r.tunnels))
+	for h, c := range r.tunnels {
+		out[h] = c
+	}
+	return out
+}
+
+// wildcardFor returns the wildcard hostname that could serve hostname, or "" for a single-label
+// name. A wildcard covers exactly one label: a.b.example.com is served by *.b.example.com only.
+func wildcardFor(hostname string) string {
+	_, parent, ok := strings.Cut(hostname, ".")
+	if !ok || parent == "" {
+		return ""
+	}
+	return "*." + parent
+}
+
+// hostnameCovers reports whether pattern serves hostname. A wildcard pattern serves names one
+// label deeper than its suffix, matching what a wildcard TLS certificate covers; a different
+// wildcard hostname is never covered.
+func hostnameCovers(pattern, hostname string) bool {
+	if pattern == hostname {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(pattern, "*")
+	if !ok || !strings.HasSuffix(hostname, suffix) {
+		return false
+	}
+	label := strings.TrimSuffix(hostname, suffix)
+	return label != "" && label != "*" && !strings.Contains(label, ".")
+}
+
+// hostnamesOverlap reports whether some name would be served by both a and b.
+func hostnamesOverlap(a, b string) bool {
+	return hostnameCovers(a, b) || hostnameCovers(b, a)
+}
@@ -140,3 +140,53 @@ func TestRegistry_Disconnect(t *testing.T) {
 		t.Fatal("expected disconnect false for missing host")
 	}
 }
+
+func TestRegistry_WildcardLookup(t *testing.T) {
+	r := NewRegistry()
+	acme := &mockConn{remoteAddr: "acme"}
+	eu := &mockConn{remoteAddr: "eu"}
+	exact := &mockConn{remoteAddr: "exact"}
+	r.Register("*.acme.tunnel.example.com", acme)
+	r.Register("*.eu.acme.tunnel.example.com", eu)
+	r.Register("www.acme.tunnel.example.com", exact)
+
+	cases := map[string]TunnelConnection{
+		"www.acme.tunnel.example.com":     exact,
+		"shop.acme.tunnel.example.com":    acme,
+		"a.b.acme.tunnel.example.com":     nil,
+		"shop.eu.acme.tunnel.example.com": eu,
+		"eu.acme.tunnel.example.com":      acme,
+		"acme.tunnel.example.com":         nil,
+		"shop.other.tunnel.example.com":   nil,
+	}
+	for host, want := range cases {
+		if got := r.Get(host); got != want {
+			t.Errorf("Get(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestHostnamesOverlap(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"*.acme.example.com", "shop.acme.example.com", true},
+		{"*.acme.example.com", "a.b.acme.example.com", false},
+		{"*.acme.example.com", "*.eu.acme.example.com", false},
+		{"*.acme.example.com", "*.acme.example.com", true},
+		{"*.eu.acme.example.com", "shop.eu.acme.example.com", true},
+		{"*.acme.example.com", "acme.example.com", false},
+		{"*.acme.example.com", "*.beta.example.com", false},
+		{"app.example.com", "app.example.com", true},
+		{"app.example.com", "www.example.com", false},
+	}
+	for _, c := range cases {
+		if got := hostnamesOverlap(c.a, c.b); got != c.want {
+			t.Errorf("hostnamesOverlap(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+		if got := hostnamesOverlap(c.b, c.a); got != c.want {
+			t.Errorf("hostnamesOverlap(%q, %q) = %v, want %v", c.b, c.a, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultRequestLogPage = 100
+
+// RequestLogPage is one page of request logs; NextCursor is empty on the last page.
+type RequestLogPage struct {
+	Logs       []RequestLogRecord `json:"logs"`
+	NextCursor string             `json:"next_cursor"`
+}
+
+// parseRequestLogQuery reads the filters shared by the logs API and the admin logs page:
+// from and to (RFC3339, or 2006-01-02T15:04[:05] in UTC), status (comma-separated codes and
+// classes such as 5xx), method, path (prefix or glob), client_ip, min_latency_ms, cursor and
+// limit.
+func parseRequestLogQuery(v url.Values) (RequestLogQuery, error) {
+	q := RequestLogQuery{
+		Method:   strings.ToUpper(strings.TrimSpace(v.Get("method"))),
+		Path:     strings.TrimSpace(v.Get("path")),
+		ClientIP: strings.TrimSpace(v.Get("client_ip")),
+		Limit:    defaultRequestLogPage,
+	}
+	var err error
+	if q.From, err = parseLogTime(v.Get("from")); err != nil {
+		return q, fmt.Errorf("from: %w", err)
+	}
+	if q.To, err = parseLogTime(v.Get("to")); err != nil {
+		return q, fmt.Errorf("to: %w", err)
+	}
+	if q.Statuses, q.StatusClasses, err = parseStatusFilter(v.Get("status")); err != nil {
+		return q, err
+	}
+	for key, dst := range map[string]*int64{"min_latency_ms": &q.MinLatencyMS, "cursor": &q.Before} {
+		raw := strings.TrimSpace(v.Get(key))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("%s must be a non-negative integer", key)
+		}
+		*dst = n
+	}
+	if raw := strings.TrimSpace(v.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxRequestLogPage {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxRequestLogPage)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+func parseLogTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, raw, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("want RFC3339, e.g. 2024-05-01T14:00:00Z")
+}
+
+// parseStatusFilter splits "500,502,4xx" into exact codes and classes.
+func parseStatusFilter(raw string) (codes, classes []int, err error) {
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5' {
+			classes = append(classes, int(part[0]-'0'))
+			continue
+		}
+		code, convErr := strconv.Atoi(part)
+		if convErr != nil || code < 100 || code > 599 {
+			return nil, nil, fmt.Errorf("status %q: want a code such as 502 or a class such as 5xx", part)
+		}
+		codes = append(codes, code)
+	}
+	return codes, classes, nil
+}
+
+// nextRequestLogCursor is the cursor after a full page, or empty when there is nothing more.
+func nextRequestLogCursor(logs []RequestLogRecord, limit int) string {
+	if len(logs) == 0 || len(logs) < limit {
+		return ""
+	}
+	return strconv.FormatInt(logs[len(logs)-1].ID, 10)
+}
+
+// handleRequestLogs serves GET /api/tunnels/{name}/logs. JSON responses are one page;
+// format=ndjson or format=csv exports every match from the cursor on.
+func handleRequestLogs(w http.ResponseWriter, r *http.Request, store *Store, tun TunnelRecord) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseRequestLogQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.TunnelID = tun.ID
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+		logs, err := store.QueryRequestLogs(r.Context(), q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if logs == nil {
+			logs = []RequestLogRecord{}
+		}
+		writeJSON(w, http.StatusOK, RequestLogPage{Logs: logs, NextCursor: nextRequestLogCursor(logs, q.Limit)})
+	case "ndjson", "csv":
+		exportRequestLogs(w, r, store, q, tun.Name, format)
+	default:
+		http.Error(w, "format must be json, ndjson or csv", http.StatusBadRequest)
+	}
+}
+
+var requestLogCSVHeader = []string{"id", "timestamp", "method", "host", "path", "status", "latency_ms",
+	"bytes_in", "bytes_out", "client_ip", "error_text", "ws_upgrade", "credential", "fault"}
+
+// exportRequestLogs streams matches page by page so exports are not held in memory.
+func exportRequestLogs(w http.ResponseWriter, r *http.Request, store *Store, q RequestLogQuery, tunnelName, format string) {
+	q.Limit = maxRequestLogPage
+	first, err := store.QueryRequestLogs(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var cw *csv.Writer
+	enc := json.NewEncoder(w)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw = csv.NewWriter(w)
+		_ = cw.Write(requestLogCSVHeader)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fwdx-logs-%s.%s"`, tunnelName, format))
+	for page := first; len(page) > 0; {
+		for _, rec := range page {
+			if cw != nil {
+				_ = cw.Write([]string{
+					strconv.FormatInt(rec.ID, 10), rec.Timestamp.UTC().Format(time.RFC3339Nano), rec.Method, rec.Host, rec.Path,
+					strconv.Itoa(rec.Status), strconv.FormatInt(rec.LatencyMS, 10), strconv.FormatInt(rec.BytesIn, 10),
+					strconv.FormatInt(rec.BytesOut, 10), rec.ClientIP, rec.ErrorText, strconv.FormatBool(rec.WSUpgrade),
+					rec.Credential, rec.Fault,
+				})
+			} else if err := enc.Encode(rec); err != nil {
+				return
+			}
+		}
+		if cw != nil {
+			cw.Flush()
+		}
+		if len(page) < q.Limit {
+			return
+		}
+		q.Before = page[len(page)-1].ID
+		if page, err = store.QueryRequestLogs(r.Context(), q); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func seedRequestLogs(t *testing.T, store *Store, tun TunnelRecord) time.Time {
+	t.Helper()
+	base := time.Date(2024, 5, 1, 13, 30, 0, 0, time.UTC)
+	paths := []string{"/webhooks/stripe", "/api/users", "/webhooks/github", "/healthz"}
+	for i := 0; i < 12; i++ {
+		status := 200
+		if i%3 == 0 {
+			status = 502
+		} else if i%4 == 0 {
+			status = 404
+		}
+		method := http.MethodGet
+		if strings.HasPrefix(paths[i%4], "/webhooks/") {
+			method = http.MethodPost
+		}
+		if err := store.InsertRequestLog(context.Background(), RequestLogRecord{
+			TunnelID:  tun.ID,
+			Hostname:  tun.Hostname,
+			Timestamp: base.Add(time.Duration(i) * 10 * time.Minute),
+			Method:    method,
+			Host:      tun.Hostname,
+			Path:      paths[i%4],
+			Status:    status,
+			LatencyMS: int64(i * 100),
+			ClientIP:  fmt.Sprintf("10.0.0.%d", i%2+1),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func TestQueryRequestLogs_Filters(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 0, "hooks", "hooks.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := seedRequestLogs(t, store, tun)
+
+	query := func(raw string) []RequestLogRecord {
+		t.Helper()
+		v, _ := url.ParseQuery(raw)
+		q, err := parseRequestLogQuery(v)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		q.TunnelID = tun.ID
+		logs, err := store.QueryRequestLogs(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logs
+	}
+
+	// 14:00 to 15:00 covers entries 3..8; of those, 5xx on /webhooks/* is only entry 6.
+	logs := query("status=5xx&path=/webhooks/*&from=2024-05-01T14:00:00Z&to=2024-05-01T15:00:00Z")
+	if len(logs) != 1 || logs[0].Path != "/webhooks/github" || !logs[0].Timestamp.Equal(base.Add(60*time.Minute)) {
+		t.Fatalf("incident query=%+v", logs)
+	}
+	if logs := query("to=2024-05-01T13:40"); len(logs) != 1 || !logs[0].Timestamp.Equal(base) {
+		t.Fatalf("upper bound is exclusive: %+v", logs)
+	}
+	if logs := query("path=/webhooks"); len(logs) != 6 {
+		t.Fatalf("prefix matches=%d", len(logs))
+	}
+	if logs := query("status=404,502"); len(logs) != 6 {
+		t.Fatalf("status list matches=%d", len(logs))
+	}
+	if logs := query("status=5xx&method=get"); len(logs) != 2 {
+		t.Fatalf("status and method matches=%d", len(logs))
+	}
+	if logs := query("client_ip=10.0.0.2&min_latency_ms=700"); len(logs) != 3 {
+		t.Fatalf("client ip and latency matches=%d", len(logs))
+	}
+
+	first := query("limit=5")
+	if len(first) != 5 || first[0].LatencyMS != 1100 {
+		t.Fatalf("first page=%+v", first)
+	}
+	cursor := nextRequestLogCursor(first, 5)
+	second := query("limit=5&cursor=" + cursor)
+	third := query("limit=5&cursor=" + nextRequestLogCursor(second, 5))
+	if len(second) != 5 || second[0].ID >= first[4].ID || len(third) != 2 || nextRequestLogCursor(third, 5) != "" {
+		t.Fatalf("pages=%d,%d,%d", len(first), len(second), len(third))
+	}
+
+	for _, bad := range []string{"status=6xx", "status=abc", "from=yesterday", "limit=0", "limit=5000", "cursor=-1", "min_latency_ms=x"} {
+		v, _ := url.ParseQuery(bad)
+		if _, err := parseRequestLogQuery(v); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestRequestLogsAPI_PagesAndExports(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	cfg := Config{Hostname: "tunnel.example.com"}
+	auth, err := NewAuthManager(ctx, cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, user, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: "alice", Email: "alice@example.com"}, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, _, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: "bob", Email: "bob@example.com"}, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := store.CreateTunnel(ctx, user.ID, "hooks", "hooks.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedRequestLogs(t, store, tun)
+
+	srv := httptest.NewServer(ControlPlaneRouter(cfg, NewRegistry(), NewDomainStore(store, t.TempDir()), store, auth))
+	defer srv.Close()
+	get := func(token, path string) (int, http.Header, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, string(body)
+	}
+
+	code, _, body := get(token, "/api/tunnels/hooks/logs?status=5xx&limit=3")
+	if code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	var page RequestLogPage
+	if err := json.Unmarshal([]byte(body), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Logs) != 3 || page.NextCursor == "" {
+		t.Fatalf("page=%+v", page)
+	}
+	_, _, body = get(token, "/api/tunnels/hooks/logs?status=5xx&limit=3&cursor="+page.NextCursor)
+	page = RequestLogPage{}
+	if err := json.Unmarshal([]byte(body), &page); err != nil || len(page.Logs) != 1 || page.NextCursor != "" {
+		t.Fatalf("last page=%+v err=%v", page, err)
+	}
+
+	code, header, body := get(token, "/api/tunnels/hooks/logs?format=csv&path=/webhooks/*")
+	if code != http.StatusOK || !strings.HasPrefix(header.Get("Content-Type"), "text/csv") ||
+		!strings.Contains(header.Get("Content-Disposition"), "fwdx-logs-hooks.csv") {
+		t.Fatalf("csv status=%d header=%v", code, header)
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil || len(records) != 7 || records[0][0] != "id" || records[1][4] != "/webhooks/github" {
+		t.Fatalf("csv=%v err=%v", records, err)
+	}
+
+	code, _, body = get(token, "/api/tunnels/hooks/logs?format=ndjson&limit=2")
+	lines := 0
+	for sc := bufio.NewScanner(strings.NewReader(body)); sc.Scan(); lines++ {
+		var rec RequestLogRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil || rec.TunnelID != tun.ID {
+			t.Fatalf("ndjson line %q err=%v", sc.Text(), err)
+		}
+	}
+	if code != http.StatusOK || lines != 12 {
+		t.Fatalf("ndjson status=%d lines=%d", code, lines)
+	}
+
+	if code, _, _ := get(token, "/api/tunnels/hooks/logs?status=9xx"); code != http.StatusBadRequest {
+		t.Fatalf("bad filter status=%d", code)
+	}
+	if code, _, _ := get(token, "/api/tunnels/hooks/logs?format=xml"); code != http.StatusBadRequest {
+		t.Fatalf("bad format status=%d", code)
+	}
+	if code, _, _ := get(otherToken, "/api/tunnels/hooks/logs"); code == http.StatusOK {
+		t.Fatal("another user's tunnel logs must not be readable")
+	}
+}
+
+func TestAdminUI_LogsFilters(t *testing.T) {
+	cfg := Config{Hostname: "tunnel.example.com"}
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	auth, err := NewAuthManager(context.Background(), cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCookie := issueAdminCookie(t, auth)
+	tun, err := store.CreateTunnel(context.Background(), 0, "hooks", "hooks.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedRequestLogs(t, store, tun)
+	ui := AdminUIRouter(cfg, NewRegistry(), NewDomainStore(store, t.TempDir()), NewStatsStore(), store, auth, time.Now(), false)
+	srv := httptest.NewServer(ui)
+	defer srv.Close()
+	get := func(path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.AddCookie(adminCookie)
+		req.Header.Set("HX-Request", "true")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/admin/ui/logs?tunnel=hooks&status=5xx&limit=2")
+	if code != http.StatusOK || strings.Count(body, "<td>502</td>") != 2 || strings.Contains(body, "<td>200</td>") {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	if !strings.Contains(body, "cursor=") || !strings.Contains(body, "/api/tunnels/hooks/logs?format=csv&amp;status=5xx") {
+		t.Fatalf("missing older or export links: %s", body)
+	}
+	if _, body = get("/admin/ui/logs?tunnel=hooks&status=bogus"); !strings.Contains(body, "want a code such as 502") {
+		t.Fatalf("expected filter error: %s", body)
+	}
+	if code, _ = get("/admin/ui/logs?tunnel=missing"); code != http.StatusNotFound {
+		t.Fatalf("missing tunnel status=%d", code)
+	}
+}
@@ -4,16 +4,20 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
 )
 
 // Config holds server configuration.
 // Web: HTTP/HTTPS (public traffic). Grpc: tunnel connections (gRPC).
 // Behind nginx: no TLS, nginx forwards 443 -> WebPort and gRPC stream -> GrpcPort.
-// Direct: set TLSCertFile/TLSKeyFile; both Web and Grpc use TLS.
+// Direct: set TLSCertFile/TLSKeyFile, or ACME for automatic certificates; both Web and Grpc use TLS.
+// Uploaded per-domain certificates are served by SNI ahead of either.
 type Config struct {
 	Hostname           string
 	WebPort            int // HTTP/HTTPS for public and admin (e.g. 8080 behind nginx, 443 direct)
@@ -32,8 +36,22 @@ type Config struct {
 	OIDCSessionSecret  string
 	OIDCDeviceClientID string
 	TrustedProxyCIDRs  []string
+	// JWTAllowHTTPIssuers lets jwt access rules name plain http issuers, e.g. for local testing.
+	JWTAllowHTTPIssuers bool
+	// JWTTrustedIssuers may sit on internal addresses; other jwt issuers must be public hosts.
+	JWTTrustedIssuers []string
+	ACME              *ACMEConfig // nil disables automatic certificates
+	ACMEHTTPPort      int         // HTTP-01 challenge listener (default 80)
+	HostnamePolicy    HostnamePolicy
+	MetricsAddr       string        // Prometheus /metrics listener; empty disables it
+	OTLPEndpoint      string        // OTLP/HTTP trace collector; empty disables export
+	LogSinks          []string      // request log sink URLs, see NewLogShipper
+	StatsRetention    time.Duration // how long per-tunnel stats history is kept (default 30 days)
 }
 
+// shutdownTimeout bounds how long Shutdown waits for in-flight HTTP requests.
+const shutdownTimeout = 15 * time.Second
+
 // Server runs the fwdx server: web (proxy + admin) and gRPC (tunnels).
 type Server struct {
 	cfg      Config
@@ -41,6 +59,7 @@ type Server struct {
 	domains  *DomainStore
 	stats    *StatsStore
 	store    *Store
+	shipper  *LogShipper // started by New from LogSinks, closed when Run returns
 	auth     *AuthManager
 	started  time.Time
 
@@ -49,6 +68,10 @@ type Server struct {
 	webServer    *http.Server
 	grpcListener net.Listener
 	mu           sync.Mutex
+
+	stop     chan struct{} // closed by Shutdown
+	stopOnce sync.Once
+	done     chan struct{} // closed when Run has returned
 }
 
 // New creates a new Server.
@@ -59,14 +82,24 @@ func New(cfg Config) (*Server, error) {
 	if cfg.DataDir == "" {
 		cfg.DataDir = ".fwdx-server"
 	}
+	if err := cfg.HostnamePolicy.Validate(); err != nil {
+		return nil, err
+	}
+
+	shipper, err := NewLogShipper(cfg.LogSinks)
+	if err != nil {
+		return nil, err
+	}
 
 	registry := NewRegistry()
-	domains := NewDomainStore(cfg.DataDir)
 	stats := NewStatsStore()
 	store, err := NewStore(cfg.DataDir)
 	if err != nil {
+		shipper.Close()
 		return nil, fmt.Errorf("open store: %w", err)
 	}
+	domains := NewDomainStore(store, cfg.DataDir)
+	domains.SetServerHostname(cfg.Hostname)
 
 	return &Server{
 		cfg:          cfg,
@@ -74,32 +107,43 @@ func New(cfg Config) (*Server, error) {
 		domains:      domains,
 		stats:        stats,
 		store:        store,
+		shipper:      shipper,
 		started:      time.Now(),
-		proxyHandler: ProxyHandlerWithConfig(registry, cfg, stats, store),
+		proxyHandler: ProxyHandlerWithConfig(registry, cfg, stats, store, shipper),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
 	}, nil
 }
 
-// Run starts web and gRPC listeners. Use TLS on both if certs are set.
+// Run starts web and gRPC listeners. Use TLS on both if certs are set. It returns after Shutdown
+// once buffered stats and usage have been written.
 func (s *Server) Run() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer close(s.done)
 
-	useTLS := s.cfg.TLSCertFile != "" && s.cfg.TLSKeyFile != ""
+	staticTLS := s.cfg.TLSCertFile != "" && s.cfg.TLSKeyFile != ""
+	if staticTLS && s.cfg.ACME != nil {
+		return fmt.Errorf("use either static TLS files or ACME, not both")
+	}
+	useTLS := staticTLS || s.cfg.ACME != nil
 	auth, err := NewAuthManager(context.Background(), s.cfg, s.store, useTLS)
 	if err != nil {
 		return err
 	}
 	s.auth = auth
+	s.proxyHandler = ProxyHandlerWithConfig(s.registry, s.cfg, s.stats, s.store, s.shipper, auth)
 
 	mux := http.NewServeMux()
 	adminUI := AdminUIRouter(s.cfg, s.registry, s.domains, s.stats, s.store, auth, s.started, useTLS)
 	mux.Handle("/admin/ui/", adminUI)
 	mux.Handle("/admin/ui", adminUI)
 	mux.Handle("/admin/", AdminRouter(s.cfg.Hostname, s.registry, s.domains, auth, s.stats, s.store))
-	mux.Handle("/api/", ControlPlaneRouter(s.cfg, s.domains, s.store, auth))
+	mux.Handle("/api/", ControlPlaneRouter(s.cfg, s.registry, s.domains, s.store, auth))
 	mux.HandleFunc("/auth/oidc/login", auth.handleOIDCLogin)
 	mux.HandleFunc("/auth/oidc/callback", auth.handleOIDCCallback)
 	mux.HandleFunc("/auth/oidc/logout", auth.handleOIDCLogout)
+	mux.HandleFunc("/auth/tunnel/authorize", auth.handleTunnelAuthorize)
 	mux.HandleFunc("/auth/device/start", auth.handleDeviceStart)
 	mux.HandleFunc("/auth/device/poll", auth.handleDevicePoll)
 	mux.HandleFunc("/api/users/me", auth.handleWhoAmI)
@@ -109,13 +153,37 @@ func (s *Server) Run() error {
 		Addr:    fmt.Sprintf(":%d", s.cfg.WebPort),
 		Handler: mux,
 	}
-	if useTLS {
-		tlsConfig, err := s.loadTLS()
+	var tlsConfig *tls.Config
+	var acmeHTTP *http.Server
+	switch {
+	case staticTLS:
+		if tlsConfig, err = s.loadTLS(); err != nil {
+			return err
+		}
+	case s.cfg.ACME != nil:
+		acmeMgr, err := NewACMEManager(*s.cfg.ACME, s.cfg.Hostname, s.cfg.DataDir, s.domains.Allowed, s.store)
 		if err != nil {
 			return err
 		}
-		s.webServer.TLSConfig = tlsConfig
+		tlsConfig = acmeMgr.TLSConfig()
+		httpPort := s.cfg.ACMEHTTPPort
+		if httpPort == 0 {
+			httpPort = 80
+		}
+		acmeHTTP = &http.Server{Addr: fmt.Sprintf(":%d", httpPort), Handler: acmeMgr.HTTPHandler()}
+		renewCtx, stopRenewal := context.WithCancel(context.Background())
+		defer stopRenewal()
+		go acmeMgr.RunRenewal(renewCtx, acmeRenewInterval)
 	}
+	if tlsConfig != nil {
+		fallback := tlsConfig.GetCertificate
+		if fallback == nil && len(tlsConfig.Certificates) > 0 {
+			fallback = StaticCertificate(&tlsConfig.Certificates[0])
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = NewCertificateSelector(s.store, fallback).GetCertificate
+	}
+	s.webServer.TLSConfig = tlsConfig
 
 	grpcLn, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.GrpcPort))
 	if err != nil {
@@ -123,29 +191,100 @@ func (s *Server) Run() error {
 	}
 	s.grpcListener = grpcLn
 
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	defer stopJanitor()
+	go runTunnelJanitor(janitorCtx, s.store, s.registry, tunnelJanitorInterval)
+	go runCertificateExpiryWarnings(janitorCtx, s.store, certExpiryCheckInterval)
+	go s.domains.RunVerification(janitorCtx, domainCheckInterval)
+	historyDone := make(chan struct{})
+	go func() {
+		defer close(historyDone)
+		runStatsHistory(janitorCtx, s.store, s.cfg.StatsRetention)
+	}()
+
+	if s.cfg.MetricsAddr != "" {
+		metricsSrv, err := serveMetrics(s.cfg.MetricsAddr, s.registry)
+		if err != nil {
+			return err
+		}
+		defer metricsSrv.Close()
+	}
+	if s.cfg.OTLPEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(context.Background(), "fwdx-server", s.cfg.OTLPEndpoint)
+		if err != nil {
+			return err
+		}
+		defer shutdownTracing(context.Background())
+		slog.Info("tracing enabled", "otlp_endpoint", s.cfg.OTLPEndpoint)
+	}
+	defer s.shipper.Close()
+	for _, sink := range s.shipper.Stats() {
+		slog.Info("request log sink enabled", "sink", sink.Name)
+	}
+
 	var wg sync.WaitGroup
 	var runErr error
 
+	if acmeHTTP != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runErr = firstErr(runErr, acmeHTTP.ListenAndServe())
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		if useTLS {
-			runErr = firstErr(runErr, s.webServer.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile))
+			runErr = firstErr(runErr, s.webServer.ListenAndServeTLS("", ""))
 		} else {
 			runErr = firstErr(runErr, s.webServer.ListenAndServe())
 		}
 	}()
 
+	grpcSrv := newGrpcServer(s.registry, s.domains.Allowed, s.cfg.Hostname, tlsConfig, s.store)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runErr = firstErr(runErr, RunGrpcServer(grpcLn, s.registry, s.domains.List, s.cfg.Hostname, useTLS, s.cfg.TLSCertFile, s.cfg.TLSKeyFile, s.store))
+		runErr = firstErr(runErr, grpcSrv.Serve(grpcLn))
+	}()
+
+	go func() {
+		select {
+		case <-s.stop:
+		case <-s.done:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		// Drain in-flight proxy requests first so their usage is buffered before the final flush.
+		_ = s.webServer.Shutdown(ctx)
+		if acmeHTTP != nil {
+			_ = acmeHTTP.Shutdown(ctx)
+		}
+		// Tunnel streams never finish on their own, so they are cut rather than drained.
+		grpcSrv.Stop()
 	}()
 
 	wg.Wait()
+	stopJanitor()
+	<-historyDone
 	return runErr
 }
 
+// Shutdown stops the listeners and waits until Run has flushed buffered stats and usage, or
+// ctx ends. It must only be called once Run has started.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func firstErr(prev, err error) error {
 	if err != nil && err != http.ErrServerClosed {
 		return err
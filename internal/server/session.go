@@ -7,7 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -24,6 +24,7 @@ type AuthManager struct {
 	oidc       *oidcManager
 	secure     bool
 	sessionKey []byte
+	jwt        *jwtVerifierCache
 }
 
 func NewAuthManager(ctx context.Context, cfg Config, store *Store, secure bool) (*AuthManager, error) {
@@ -36,7 +37,7 @@ func NewAuthManager(ctx context.Context, cfg Config, store *Store, secure bool)
 		case strings.TrimSpace(cfg.Hostname) != "":
 			key = "fwdx-session::" + cfg.Hostname
 			if strings.TrimSpace(cfg.OIDCIssuer) != "" {
-				log.Printf("[fwdx] warning: deriving OIDC session secret from hostname; set --oidc-session-secret")
+				slog.Warn("deriving OIDC session secret from hostname; set --oidc-session-secret")
 			}
 		default:
 			key = "fwdx-session::default"
@@ -46,7 +47,7 @@ func NewAuthManager(ctx context.Context, cfg Config, store *Store, secure bool)
 	if err != nil {
 		return nil, err
 	}
-	return &AuthManager{cfg: cfg, store: store, oidc: oidcMgr, secure: secure, sessionKey: []byte(key)}, nil
+	return &AuthManager{cfg: cfg, store: store, oidc: oidcMgr, secure: secure, sessionKey: []byte(key), jwt: newJWTVerifierCache(append([]string{strings.TrimSpace(cfg.OIDCIssuer)}, cfg.JWTTrustedIssuers...)...)}, nil
 }
 
 func (a *AuthManager) OIDCEnabled() bool {
@@ -156,6 +157,27 @@ func (a *AuthManager) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// requireUser is requireAdmin for pages every signed-in user may see.
+func (a *AuthManager) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, status, _ := a.requestUser(r.Context(), r)
+		if status == http.StatusUnauthorized {
+			if strings.EqualFold(r.Header.Get("HX-Request"), "true") {
+				w.Header().Set("HX-Redirect", "/admin/ui/login")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/admin/ui/login", http.StatusFound)
+			return
+		}
+		if user == nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func (a *AuthManager) authorizeAdmin(r *http.Request) (bool, int) {
 	user, status, _ := a.requestUser(r.Context(), r)
 	if status != http.StatusOK {
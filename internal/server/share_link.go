@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	shareLinkCookieName = "fwdx_share"
+	shareLinkQueryParam = "fwdx_share"
+	shareLinkDefaultTTL = 24 * time.Hour
+)
+
+// shareLinkClaims is the signed payload of a share link token.
+type shareLinkClaims struct {
+	Host string `json:"h"`
+	Path string `json:"p,omitempty"`
+	Exp  int64  `json:"exp"`
+}
+
+// ShareLink is a freshly issued signed link.
+type ShareLink struct {
+	URL       string    `json:"url"`
+	Path      string    `json:"path"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signShareLink encodes claims as base64url(json) "." base64url(hmac-sha256).
+func signShareLink(key string, claims shareLinkClaims) string {
+	payload, _ := json.Marshal(claims)
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(body))
+	return body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyShareLink(key, host, token string, now time.Time) (shareLinkClaims, error) {
+	if key == "" {
+		return shareLinkClaims{}, fmt.Errorf("no signing key")
+	}
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return shareLinkClaims{}, fmt.Errorf("malformed token")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return shareLinkClaims{}, fmt.Errorf("malformed token")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(body))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return shareLinkClaims{}, fmt.Errorf("bad signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return shareLinkClaims{}, fmt.Errorf("malformed token")
+	}
+	var claims shareLinkClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return shareLinkClaims{}, fmt.Errorf("malformed token")
+	}
+	if !strings.EqualFold(claims.Host, hostWithoutPort(host)) {
+		return shareLinkClaims{}, fmt.Errorf("wrong host")
+	}
+	if now.Unix() >= claims.Exp {
+		return shareLinkClaims{}, fmt.Errorf("expired")
+	}
+	return claims, nil
+}
+
+// allowsPath reports whether p falls inside the link's path scope.
+func (c shareLinkClaims) allowsPath(p string) bool {
+	if c.Path == "" || c.Path == "/" {
+		return true
+	}
+	return p == c.Path || strings.HasPrefix(p, strings.TrimSuffix(c.Path, "/")+"/")
+}
+
+// normalizeShareLinkPath cleans an optional path scope. An empty scope covers the whole tunnel.
+func normalizeShareLinkPath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" || p == "/" {
+		return "", nil
+	}
+	if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, "?#") {
+		return "", fmt.Errorf("path must be an absolute path like /docs")
+	}
+	return path.Clean(p), nil
+}
+
+// shareLinkHost picks the host a link to tun is signed for. Wildcard tunnels serve many names, so
+// the caller must name one of them; other tunnels default to their hostname.
+func shareLinkHost(tun TunnelRecord, host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if host == "" {
+		if strings.HasPrefix(tun.Hostname, "*.") {
+			return "", fmt.Errorf("tunnel %s serves a wildcard hostname; pass the host the link is for", tun.Name)
+		}
+		return tun.Hostname, nil
+	}
+	if strings.Contains(host, "*") || !hostnameCovers(tun.Hostname, host) {
+		return "", fmt.Errorf("host %s is not served by tunnel %s", host, tun.Name)
+	}
+	return host, nil
+}
+
+// issueShareLink signs a link to host on tun valid for ttl. The tunnel must use the signed_link
+// access mode; host may be empty unless the tunnel serves a wildcard hostname.
+func issueShareLink(ctx context.Context, store *Store, auth *AuthManager, tun TunnelRecord, ttl time.Duration, scope, host string) (ShareLink, error) {
+	rule, err := store.GetTunnelAccessRule(ctx, tun.ID)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if rule.AuthMode != "signed_link" {
+		return ShareLink{}, fmt.Errorf("tunnel does not use signed_link access")
+	}
+	scope, err = normalizeShareLinkPath(scope)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	host, err = shareLinkHost(tun, host)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if ttl <= 0 {
+		ttl = shareLinkDefaultTTL
+	}
+	key, err := store.ShareLinkKey(ctx, tun.ID)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	token := signShareLink(key, shareLinkClaims{Host: host, Path: scope, Exp: expiresAt.Unix()})
+	target := scope
+	if target == "" {
+		target = "/"
+	}
+	return ShareLink{
+		URL:       tunnelPublicScheme(auth) + "://" + host + target + "?" + shareLinkQueryParam + "=" + url.QueryEscape(token),
+		Path:      target,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func tunnelPublicScheme(auth *AuthManager) string {
+	if auth == nil {
+		return "https"
+	}
+	return auth.publicScheme()
+}
+
+// checkShareLink enforces a signed_link access rule. A valid token in the query string is swapped
+// for a cookie scoped to the tunnel hostname; later visits must carry that cookie. When handled is
+// true the response has been written and the request must not be forwarded to the agent.
+func checkShareLink(w http.ResponseWriter, r *http.Request, rule TunnelAccessRuleRecord, secure bool, deny proxyErrorFunc) (handled bool, status int, errText string) {
+	now := time.Now()
+	query := r.URL.Query()
+	if token := query.Get(shareLinkQueryParam); token != "" {
+		claims, err := verifyShareLink(rule.ShareLinkKey, r.Host, token, now)
+		if err != nil || !claims.allowsPath(r.URL.Path) {
+			deny(http.StatusForbidden, "invalid or expired share link")
+			return true, http.StatusForbidden, "share link rejected"
+		}
+		cookiePath := claims.Path
+		if cookiePath == "" {
+			cookiePath = "/"
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareLinkCookieName,
+			Value:    token,
+			Path:     cookiePath,
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteLaxMode,
+			Expires:  time.Unix(claims.Exp, 0),
+		})
+		query.Del(shareLinkQueryParam)
+		r.URL.RawQuery = query.Encode()
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			http.Redirect(w, r, r.URL.RequestURI(), http.StatusFound)
+			return true, http.StatusFound, ""
+		}
+		return false, 0, ""
+	}
+	for _, c := range r.Cookies() {
+		if c.Name != shareLinkCookieName {
+			continue
+		}
+		if claims, err := verifyShareLink(rule.ShareLinkKey, r.Host, c.Value, now); err == nil && claims.allowsPath(r.URL.Path) {
+			return false, 0, ""
+		}
+	}
+	deny(http.StatusForbidden, "forbidden")
+	return true, http.StatusForbidden, "share link required"
+}
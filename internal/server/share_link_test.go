@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerifyShareLink(t *testing.T) {
+	now := time.Now()
+	token := signShareLink("k1", shareLinkClaims{Host: "app.example.com", Path: "/docs", Exp: now.Add(time.Hour).Unix()})
+
+	claims, err := verifyShareLink("k1", "app.example.com:443", token, now)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !claims.allowsPath("/docs") || !claims.allowsPath("/docs/a") || claims.allowsPath("/docsx") || claims.allowsPath("/") {
+		t.Fatalf("unexpected path scope for %q", claims.Path)
+	}
+	if _, err := verifyShareLink("k2", "app.example.com", token, now); err == nil {
+		t.Fatal("expected rotated key to reject token")
+	}
+	if _, err := verifyShareLink("k1", "other.example.com", token, now); err == nil {
+		t.Fatal("expected wrong host to reject token")
+	}
+	if _, err := verifyShareLink("k1", "app.example.com", token, now.Add(2*time.Hour)); err == nil {
+		t.Fatal("expected expired token to be rejected")
+	}
+	if _, err := verifyShareLink("k1", "app.example.com", token+"x", now); err == nil {
+		t.Fatal("expected tampered token to be rejected")
+	}
+}
+
+func TestProxyHandler_SignedLink(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issueShareLink(ctx, store, nil, tun, time.Hour, "", ""); err == nil {
+		t.Fatal("expected public tunnel to refuse share links")
+	}
+	if err := store.UpsertTunnelAccessRule(ctx, tun.ID, AccessRuleInput{AuthMode: "signed_link"}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := issueShareLink(ctx, store, nil, tun, time.Hour, "/docs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "app.example.com" || u.Path != "/docs" {
+		t.Fatalf("unexpected link %s", link.URL)
+	}
+
+	conn := &captureConn{}
+	reg := NewRegistry()
+	reg.Register("app.example.com", conn)
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+	do := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = "app.example.com"
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("https://app.example.com/docs"); rec.Code != http.StatusForbidden {
+		t.Fatalf("unsigned status=%d want 403", rec.Code)
+	}
+	rec := do(link.URL + "&x=1")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("signed status=%d want 302", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/docs?x=1" {
+		t.Fatalf("redirect=%q want token stripped", loc)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != shareLinkCookieName || cookies[0].Path != "/docs" {
+		t.Fatalf("unexpected cookies %+v", cookies)
+	}
+	if rec := do("https://app.example.com/docs/page", cookies[0]); rec.Code != http.StatusOK {
+		t.Fatalf("cookie status=%d want 200", rec.Code)
+	}
+	if got := conn.last.Header.Get("Cookie"); got != "" {
+		t.Fatalf("share cookie forwarded to agent: %q", got)
+	}
+	if rec := do("https://app.example.com/admin", cookies[0]); rec.Code != http.StatusForbidden {
+		t.Fatalf("out of scope status=%d want 403", rec.Code)
+	}
+
+	if _, err := store.RotateShareLinkKey(ctx, tun.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do("https://app.example.com/docs", cookies[0]); rec.Code != http.StatusForbidden {
+		t.Fatalf("after rotation status=%d want 403", rec.Code)
+	}
+	if rec := do(link.URL); rec.Code != http.StatusForbidden {
+		t.Fatalf("rotated link status=%d want 403", rec.Code)
+	}
+}
+
+func TestProxyHandler_SignedLinkWildcard(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "acme", "*.acme.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(ctx, tun.ID, AccessRuleInput{AuthMode: "signed_link"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"", "*.acme.example.com", "acme.example.com", "shop.other.example.com"} {
+		if _, err := issueShareLink(ctx, store, nil, tun, time.Hour, "", host); err == nil {
+			t.Fatalf("host %q: expected share link to be refused", host)
+		}
+	}
+	link, err := issueShareLink(ctx, store, nil, tun, time.Hour, "", "Shop.acme.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "shop.acme.example.com" {
+		t.Fatalf("unexpected link %s", link.URL)
+	}
+
+	reg := NewRegistry()
+	reg.Register("*.acme.example.com", &captureConn{})
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+	do := func(host, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = host
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do("shop.acme.example.com", link.URL)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("signed status=%d want 302", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if rec := do("shop.acme.example.com", "https://shop.acme.example.com/", cookies...); rec.Code != http.StatusOK {
+		t.Fatalf("cookie status=%d want 200", rec.Code)
+	}
+	if rec := do("other.acme.example.com", "https://other.acme.example.com/", cookies...); rec.Code != http.StatusForbidden {
+		t.Fatalf("other host status=%d want 403", rec.Code)
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsMinuteStep        = time.Minute
+	statsHourStep          = time.Hour
+	statsMinuteWindow      = 24 * time.Hour // minute buckets older than this are merged into hours
+	defaultStatsRetention  = 30 * 24 * time.Hour
+	statsFlushInterval     = 10 * time.Second
+	statsRollupInterval    = 10 * time.Minute
+	maxStatsPoints         = 1440
+	defaultStatsChartRange = 24 * time.Hour
+)
+
+// latencyBoundsMs are the upper bounds of the latency histogram kept in every stats bucket; one
+// more slot counts slower requests. Histograms merge exactly, so percentiles survive
+// downsampling.
+var latencyBoundsMs = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// StatsBucket is the traffic of one tunnel during [Start, Start+Step).
+type StatsBucket struct {
+	TunnelID     int64
+	Start        time.Time
+	Step         time.Duration
+	Requests     int64
+	Errors       int64
+	BytesIn      int64
+	BytesOut     int64
+	LatencySumMs int64
+	LatencyMaxMs int64
+	LatencyHist  []int64 // counts per latencyBoundsMs slot
+}
+
+func (b *StatsBucket) observe(latencyMs, inBytes, outBytes int64, isErr bool) {
+	if b.LatencyHist == nil {
+		b.LatencyHist = make([]int64, len(latencyBoundsMs)+1)
+	}
+	b.Requests++
+	if isErr {
+		b.Errors++
+	}
+	b.BytesIn += inBytes
+	b.BytesOut += outBytes
+	b.LatencySumMs += latencyMs
+	b.LatencyMaxMs = max(b.LatencyMaxMs, latencyMs)
+	slot := len(latencyBoundsMs)
+	for i, bound := range latencyBoundsMs {
+		if latencyMs <= bound {
+			slot = i
+			break
+		}
+	}
+	b.LatencyHist[slot]++
+}
+
+func (b *StatsBucket) merge(o StatsBucket) {
+	if b.LatencyHist == nil {
+		b.LatencyHist = make([]int64, len(latencyBoundsMs)+1)
+	}
+	b.Requests += o.Requests
+	b.Errors += o.Errors
+	b.BytesIn += o.BytesIn
+	b.BytesOut += o.BytesOut
+	b.LatencySumMs += o.LatencySumMs
+	b.LatencyMaxMs = max(b.LatencyMaxMs, o.LatencyMaxMs)
+	for i := 0; i < len(b.LatencyHist) && i < len(o.LatencyHist); i++ {
+		b.LatencyHist[i] += o.LatencyHist[i]
+	}
+}
+
+// percentile estimates the q-quantile (0..1) by interpolating inside the histogram slot that
+// holds it; the open last slot ends at the bucket's maximum.
+func (b StatsBucket) percentile(q float64) int64 {
+	var total int64
+	for _, c := range b.LatencyHist {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cum int64
+	for i, c := range b.LatencyHist {
+		if c == 0 {
+			continue
+		}
+		if float64(cum+c) >= rank {
+			lower, upper := int64(0), b.LatencyMaxMs
+			if i > 0 {
+				lower = latencyBoundsMs[i-1]
+			}
+			if i < len(latencyBoundsMs) {
+				upper = min(latencyBoundsMs[i], b.LatencyMaxMs)
+			}
+			if upper < lower {
+				return upper
+			}
+			frac := (rank - float64(cum)) / float64(c)
+			return int64(math.Round(float64(lower) + frac*float64(upper-lower)))
+		}
+		cum += c
+	}
+	return b.LatencyMaxMs
+}
+
+func encodeLatencyHist(hist []int64) string {
+	parts := make([]string, len(hist))
+	for i, c := range hist {
+		parts[i] = strconv.FormatInt(c, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func decodeLatencyHist(raw string) []int64 {
+	hist := make([]int64, len(latencyBoundsMs)+1)
+	for i, part := range strings.Split(raw, ",") {
+		if i < len(hist) {
+			hist[i], _ = strconv.ParseInt(part, 10, 64)
+		}
+	}
+	return hist
+}
+
+// StatsPoint is one step of a tunnel's stats series as served by the API.
+type StatsPoint struct {
+	Start        time.Time `json:"start"`
+	Requests     int64     `json:"requests"`
+	Errors       int64     `json:"errors"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+	LatencyAvgMs int64     `json:"latency_avg_ms"`
+	LatencyP50Ms int64     `json:"latency_p50_ms"`
+	LatencyP90Ms int64     `json:"latency_p90_ms"`
+	LatencyP99Ms int64     `json:"latency_p99_ms"`
+	LatencyMaxMs int64     `json:"latency_max_ms"`
+}
+
+// StatsSeries is a tunnel's stats between From and To in steps of Step seconds.
+type StatsSeries struct {
+	Tunnel string       `json:"tunnel"`
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Step   int64        `json:"step"`
+	Points []StatsPoint `json:"points"`
+}
+
+// buildStatsSeries sums stored buckets, ordered by start, into one point per step, including
+// empty steps so charts keep a time axis.
+func buildStatsSeries(name string, from, to time.Time, step time.Duration, buckets []StatsBucket) StatsSeries {
+	series := StatsSeries{Tunnel: name, From: from, To: to, Step: int64(step / time.Second), Points: []StatsPoint{}}
+	i := 0
+	for t := from; t.Before(to); t = t.Add(step) {
+		end := t.Add(step)
+		var sum StatsBucket
+		for ; i < len(buckets) && buckets[i].Start.Before(end); i++ {
+			if !buckets[i].Start.Before(t) {
+				sum.merge(buckets[i])
+			}
+		}
+		p := StatsPoint{Start: t, Requests: sum.Requests, Errors: sum.Errors, BytesIn: sum.BytesIn, BytesOut: sum.BytesOut, LatencyMaxMs: sum.LatencyMaxMs}
+		if sum.Requests > 0 {
+			p.LatencyAvgMs = sum.LatencySumMs / sum.Requests
+			p.LatencyP50Ms = sum.percentile(0.50)
+			p.LatencyP90Ms = sum.percentile(0.90)
+			p.LatencyP99Ms = sum.percentile(0.99)
+		}
+		series.Points = append(series.Points, p)
+	}
+	return series
+}
+
+type statsKey struct {
+	tunnelID int64
+	start    int64
+}
+
+// StatsHistory accumulates per-minute buckets in memory until the stats janitor flushes them,
+// so the proxy does not write to SQLite for them on every request.
+type StatsHistory struct {
+	mu      sync.Mutex
+	pending map[statsKey]*StatsBucket
+	flushMu sync.Mutex
+}
+
+func newStatsHistory() *StatsHistory {
+	return &StatsHistory{pending: make(map[statsKey]*StatsBucket)}
+}
+
+// Record adds one proxied request to the minute bucket of tunnelID that contains at.
+func (h *StatsHistory) Record(tunnelID int64, at time.Time, latency time.Duration, inBytes, outBytes int64, isErr bool) {
+	start := at.UTC().Truncate(statsMinuteStep)
+	key := statsKey{tunnelID, start.Unix()}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := h.pending[key]
+	if b == nil {
+		b = &StatsBucket{TunnelID: tunnelID, Start: start, Step: statsMinuteStep}
+		h.pending[key] = b
+	}
+	b.observe(latency.Milliseconds(), inBytes, outBytes, isErr)
+}
+
+func (h *StatsHistory) take() []StatsBucket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]StatsBucket, 0, len(h.pending))
+	for _, b := range h.pending {
+		out = append(out, *b)
+	}
+	clear(h.pending)
+	return out
+}
+
+// putBack returns buckets that could not be written, merging them with newer traffic.
+func (h *StatsHistory) putBack(buckets []StatsBucket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, b := range buckets {
+		key := statsKey{b.TunnelID, b.Start.Unix()}
+		if cur := h.pending[key]; cur != nil {
+			cur.merge(b)
+			continue
+		}
+		copied := b
+		h.pending[key] = &copied
+	}
+}
+
+// runStatsHistory flushes recorded stats and usage every statsFlushInterval, and every
+// statsRollupInterval merges minute buckets older than a day into hours and deletes buckets
+// older than retention. Pending stats and usage are flushed once more when ctx ends.
+func runStatsHistory(ctx context.Context, store *Store, retention time.Duration) {
+	if retention <= 0 {
+		retention = defaultStatsRetention
+	}
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+	var lastRollup time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			if err := store.FlushStats(context.Background()); err != nil {
+				slog.Error("stats flush failed", "error", err)
+			}
+			if err := store.FlushUsage(context.Background()); err != nil {
+				slog.Error("usage flush failed", "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+		if err := store.FlushStats(ctx); err != nil {
+			slog.Error("stats flush failed", "error", err)
+		}
+		if err := store.FlushUsage(ctx); err != nil {
+			slog.Error("usage flush failed", "error", err)
+		}
+		if now := time.Now(); now.Sub(lastRollup) >= statsRollupInterval {
+			lastRollup = now
+			if err := store.RollupStats(ctx, now.Add(-statsMinuteWindow).Truncate(statsHourStep), now.Add(-retention)); err != nil {
+				slog.Error("stats rollup failed", "error", err)
+			}
+		}
+	}
+}
+
+// statsStepChoices are the steps picked when the caller does not ask for one.
+var statsStepChoices = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// statsRange resolves from, to and step for a stats query. Steps are whole minutes; ranges that
+// reach back past the minute window use at least hourly steps, since only hours are kept there.
+func statsRange(fromRaw, toRaw, stepRaw string, now time.Time) (from, to time.Time, step time.Duration, err error) {
+	if to, err = parseLogTime(toRaw); err != nil {
+		return from, to, step, fmt.Errorf("to: %w", err)
+	}
+	if to.IsZero() {
+		to = now
+	}
+	if from, err = parseLogTime(fromRaw); err != nil {
+		return from, to, step, fmt.Errorf("from: %w", err)
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultStatsChartRange)
+	}
+	if !from.Before(to) {
+		return from, to, step, fmt.Errorf("from must be before to")
+	}
+	if stepRaw = strings.TrimSpace(stepRaw); stepRaw != "" {
+		if secs, convErr := strconv.ParseInt(stepRaw, 10, 64); convErr == nil {
+			step = time.Duration(secs) * time.Second
+		} else if step, err = time.ParseDuration(stepRaw); err != nil {
+			return from, to, step, fmt.Errorf("step: want a duration such as 5m or a number of seconds")
+		}
+		if step < statsMinuteStep || step%statsMinuteStep != 0 {
+			return from, to, step, fmt.Errorf("step must be a whole number of minutes")
+		}
+	} else {
+		span := to.Sub(from)
+		step = statsStepChoices[len(statsStepChoices)-1]
+		for _, choice := range statsStepChoices {
+			if span/choice <= 360 {
+				step = choice
+				break
+			}
+		}
+	}
+	if from.Before(now.Add(-statsMinuteWindow)) && step < statsHourStep {
+		step = statsHourStep
+	}
+	from = from.UTC().Truncate(step)
+	to = to.UTC()
+	if t := to.Truncate(step); t.Before(to) {
+		to = t.Add(step)
+	}
+	if to.Sub(from)/step > maxStatsPoints {
+		return from, to, step, fmt.Errorf("too many points: use a larger step or a shorter range (max %d)", maxStatsPoints)
+	}
+	return from, to, step, nil
+}
+
+// loadStatsSeries flushes pending stats so the series is current, then reads it.
+func loadStatsSeries(ctx context.Context, store *Store, tun TunnelRecord, from, to time.Time, step time.Duration) (StatsSeries, error) {
+	if err := store.FlushStats(ctx); err != nil {
+		return StatsSeries{}, err
+	}
+	buckets, err := store.ListStatsBuckets(ctx, tun.ID, from, to)
+	if err != nil {
+		return StatsSeries{}, err
+	}
+	return buildStatsSeries(tun.Name, from, to, step, buckets), nil
+}
+
+// handleTunnelStats serves GET /api/tunnels/{name}/stats?from=&to=&step=. from and to take the
+// same formats as the logs API (default: the last 24 hours); step is a duration such as 5m or a
+// number of seconds, chosen from the range when omitted.
+func handleTunnelStats(w http.ResponseWriter, r *http.Request, store *Store, tun TunnelRecord) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, to, step, err := statsRange(q.Get("from"), q.Get("to"), q.Get("step"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := loadStatsSeries(r.Context(), store, tun, from, to, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, series)
+}
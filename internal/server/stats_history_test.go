@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsBucket_Percentiles(t *testing.T) {
+	var b StatsBucket
+	for ms := int64(1); ms <= 100; ms++ {
+		b.observe(ms, 10, 20, ms%10 == 0)
+	}
+	if b.Requests != 100 || b.Errors != 10 || b.BytesIn != 1000 || b.BytesOut != 2000 || b.LatencyMaxMs != 100 {
+		t.Fatalf("bucket=%+v", b)
+	}
+	for q, want := range map[float64]int64{0.50: 50, 0.90: 90, 0.99: 99} {
+		if got := b.percentile(q); got != want {
+			t.Fatalf("p%.0f=%d want %d", q*100, got, want)
+		}
+	}
+
+	var merged StatsBucket
+	merged.merge(b)
+	merged.merge(b)
+	if merged.Requests != 200 || merged.percentile(0.90) != 90 {
+		t.Fatalf("merged=%+v p90=%d", merged, merged.percentile(0.90))
+	}
+	if got := decodeLatencyHist(encodeLatencyHist(merged.LatencyHist)); len(got) != len(merged.LatencyHist) || got[4] != merged.LatencyHist[4] {
+		t.Fatalf("hist round trip=%v", got)
+	}
+
+	var slow StatsBucket
+	slow.observe(40000, 0, 0, false)
+	if got := slow.percentile(0.99); got <= 30000 || got > 40000 {
+		t.Fatalf("overflow p99=%d", got)
+	}
+}
+
+func TestStore_FlushAndRollupStats(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 0, "hooks", "hooks.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	h := store.StatsHistory()
+	h.Record(tun.ID, base.Add(10*time.Second), 20*time.Millisecond, 100, 1000, false)
+	h.Record(tun.ID, base.Add(50*time.Second), 200*time.Millisecond, 100, 1000, true)
+	h.Record(tun.ID, base.Add(90*time.Second), 40*time.Millisecond, 100, 1000, false)
+	h.Record(9999, base, time.Millisecond, 0, 0, false) // a deleted tunnel must not block flushes
+	if err := store.FlushStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	h.Record(tun.ID, base.Add(20*time.Second), 30*time.Millisecond, 100, 1000, false)
+	if err := store.FlushStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pending := h.take(); len(pending) != 0 {
+		t.Fatalf("pending after flush=%+v", pending)
+	}
+
+	buckets, err := store.ListStatsBuckets(ctx, tun.ID, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 || buckets[0].Requests != 3 || buckets[0].Errors != 1 || buckets[0].LatencyMaxMs != 200 ||
+		buckets[0].BytesOut != 3000 || buckets[1].Requests != 1 || buckets[0].Step != time.Minute {
+		t.Fatalf("minute buckets=%+v", buckets)
+	}
+	series := buildStatsSeries(tun.Name, base, base.Add(3*time.Minute), time.Minute, buckets)
+	if len(series.Points) != 3 || series.Points[0].Requests != 3 || series.Points[2].Requests != 0 || series.Step != 60 {
+		t.Fatalf("series=%+v", series)
+	}
+
+	if err := store.RollupStats(ctx, base.Add(time.Hour), base.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	buckets, err = store.ListStatsBuckets(ctx, tun.ID, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Step != time.Hour || buckets[0].Requests != 4 || buckets[0].Errors != 1 {
+		t.Fatalf("hour buckets=%+v", buckets)
+	}
+	if p := buildStatsSeries(tun.Name, base, base.Add(time.Hour), time.Hour, buckets).Points; len(p) != 1 || p[0].LatencyP99Ms <= 100 || p[0].LatencyP99Ms > 200 {
+		t.Fatalf("hour points=%+v", p)
+	}
+
+	if err := store.RollupStats(ctx, base.Add(2*time.Hour), base.Add(90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if buckets, _ = store.ListStatsBuckets(ctx, tun.ID, base, base.Add(time.Hour)); len(buckets) != 0 {
+		t.Fatalf("expired buckets kept: %+v", buckets)
+	}
+}
+
+func TestStatsRange(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 34, 56, 0, time.UTC)
+	from, to, step, err := statsRange("", "", "", now)
+	if err != nil || step != 5*time.Minute || !from.Equal(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)) ||
+		!to.Equal(time.Date(2024, 5, 2, 12, 35, 0, 0, time.UTC)) {
+		t.Fatalf("default range=%v..%v step=%v err=%v", from, to, step, err)
+	}
+	if _, _, step, _ = statsRange("2024-05-02T11:34:56Z", "", "", now); step != time.Minute {
+		t.Fatalf("1h auto step=%v", step)
+	}
+	if _, _, step, _ = statsRange("2024-05-02T12:00:00Z", "", "300", now); step != 5*time.Minute {
+		t.Fatalf("step in seconds=%v", step)
+	}
+	if _, _, step, _ = statsRange("2024-04-30", "", "5m", now); step != time.Hour {
+		t.Fatalf("range past the minute window step=%v", step)
+	}
+	for _, bad := range [][3]string{
+		{"", "", "90s"},
+		{"", "", "30s"},
+		{"", "", "soon"},
+		{"2024-05-02T13:00:00Z", "2024-05-02T12:00:00Z", ""},
+		{"2024-01-01", "", "1h"},
+		{"yesterday", "", ""},
+	} {
+		if _, _, _, err := statsRange(bad[0], bad[1], bad[2], now); err == nil {
+			t.Fatalf("%v: expected error", bad)
+		}
+	}
+}
+
+func TestTunnelStatsAPIAndTrafficCard(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	cfg := Config{Hostname: "tunnel.example.com"}
+	auth, err := NewAuthManager(ctx, cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, user, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: "alice", Email: "alice@example.com"}, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, _, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: "bob", Email: "bob@example.com"}, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := store.CreateTunnel(ctx, user.ID, "hooks", "hooks.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	store.StatsHistory().Record(tun.ID, now.Add(-10*time.Minute), 15*time.Millisecond, 10, 100, false)
+	store.StatsHistory().Record(tun.ID, now.Add(-5*time.Minute), 700*time.Millisecond, 10, 100, true)
+
+	api := httptest.NewServer(ControlPlaneRouter(cfg, NewRegistry(), NewDomainStore(store, t.TempDir()), store, auth))
+	defer api.Close()
+	get := func(token, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, api.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	code, body := get(token, "/api/tunnels/hooks/stats?step=1m&from="+url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339)))
+	if code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	var series StatsSeries
+	if err := json.Unmarshal([]byte(body), &series); err != nil {
+		t.Fatal(err)
+	}
+	var requests, errors, slowest int64
+	for _, p := range series.Points {
+		requests += p.Requests
+		errors += p.Errors
+		slowest = max(slowest, p.LatencyMaxMs)
+	}
+	if series.Tunnel != "hooks" || series.Step != 60 || len(series.Points) < 60 || requests != 2 || errors != 1 || slowest != 700 {
+		t.Fatalf("series step=%d points=%d requests=%d errors=%d slowest=%d", series.Step, len(series.Points), requests, errors, slowest)
+	}
+	if code, _ := get(token, "/api/tunnels/hooks/stats?step=10s"); code != http.StatusBadRequest {
+		t.Fatalf("bad step status=%d", code)
+	}
+	if code, _ := get(otherToken, "/api/tunnels/hooks/stats"); code == http.StatusOK {
+		t.Fatal("another user's tunnel stats must not be readable")
+	}
+
+	ui := httptest.NewServer(AdminUIRouter(cfg, NewRegistry(), NewDomainStore(store, t.TempDir()), NewStatsStore(), store, auth, time.Now(), false))
+	defer ui.Close()
+	req, _ := http.NewRequest(http.MethodGet, ui.URL+"/admin/ui/tunnels/hooks/traffic?range=1h", nil)
+	req.AddCookie(issueAdminCookie(t, auth))
+	req.Header.Set("HX-Request", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	card := string(raw)
+	if resp.StatusCode != http.StatusOK || strings.Count(card, "<svg") != 2 || !strings.Contains(card, "<b>Requests:</b> 2") ||
+		!strings.Contains(card, "<b>1h</b>") || !strings.Contains(card, "<polyline") {
+		t.Fatalf("status=%d card=%s", resp.StatusCode, card)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,6 +22,7 @@ import (
 
 const (
 	defaultRequestLogLimit = 10000
+	maxRequestLogPage      = 1000
 	defaultRequestLogTTL   = 7 * 24 * time.Hour
 )
 
@@ -37,19 +41,210 @@ type TunnelRecord struct {
 	LastSeenAt      time.Time `json:"last_seen_at"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	ExpiryAction    string    `json:"expiry_action"`
+	ExpiredAt       time.Time `json:"expired_at"`
+
+	Maintenance                 bool     `json:"maintenance"`
+	MaintenanceMessage          string   `json:"maintenance_message"`
+	MaintenanceRetryAfter       int      `json:"maintenance_retry_after"`
+	MaintenanceBypassIPs        []string `json:"maintenance_bypass_ips"`
+	MaintenanceBypassSecretHash string   `json:"-"`
+
+	Capture bool `json:"capture"`
+}
+
+// MaintenanceInput configures maintenance mode. A blank BypassSecret keeps the current one.
+type MaintenanceInput struct {
+	Enabled           bool     `json:"enabled"`
+	Message           string   `json:"message"`
+	RetryAfter        int      `json:"retry_after"`
+	BypassIPs         []string `json:"bypass_ips"`
+	BypassSecret      string   `json:"bypass_secret"`
+	ClearBypassSecret bool     `json:"clear_bypass_secret"`
 }
 
 type TunnelAccessRuleRecord struct {
-	ID                     int64     `json:"id"`
-	TunnelID               int64     `json:"tunnel_id"`
-	AuthMode               string    `json:"auth_mode"`
-	BasicAuthUsername      string    `json:"basic_auth_username"`
-	BasicAuthPasswordHash  string    `json:"basic_auth_password_hash"`
-	SharedSecretHeaderName string    `json:"shared_secret_header_name"`
-	SharedSecretHash       string    `json:"shared_secret_hash"`
-	AllowedIPs             []string  `json:"allowed_ips"`
-	CreatedAt              time.Time `json:"created_at"`
-	UpdatedAt              time.Time `json:"updated_at"`
+	ID                     int64             `json:"id"`
+	TunnelID               int64             `json:"tunnel_id"`
+	AuthMode               string            `json:"auth_mode"`
+	BasicAuthUsername      string            `json:"basic_auth_username"`
+	BasicAuthPasswordHash  string            `json:"basic_auth_password_hash"`
+	SharedSecretHeaderName string            `json:"shared_secret_header_name"`
+	SharedSecretHash       string            `json:"shared_secret_hash"`
+	AllowedIPs             []string          `json:"allowed_ips"`
+	OIDCEmailDomains       []string          `json:"oidc_email_domains"`
+	OIDCEmails             []string          `json:"oidc_emails"`
+	OIDCGroups             []string          `json:"oidc_groups"`
+	JWTIssuer              string            `json:"jwt_issuer"`
+	JWTAudience            string            `json:"jwt_audience"`
+	JWTRequiredClaims      map[string]string `json:"jwt_required_claims"`
+	ShareLinkKey           string            `json:"-"`
+	CreatedAt              time.Time         `json:"created_at"`
+	UpdatedAt              time.Time         `json:"updated_at"`
+}
+
+// TunnelCredentialRecord is a named basic-auth or shared-secret credential that can be revoked
+// without touching the tunnel's primary access rule.
+type TunnelCredentialRecord struct {
+	ID         int64     `json:"id"`
+	TunnelID   int64     `json:"tunnel_id"`
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Username   string    `json:"username,omitempty"`
+	SecretHash string    `json:"-"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ErrorPageRecord is an HTML template shown for a proxy error status. TunnelID 0 is the
+// server-wide page used when a tunnel has none of its own.
+type ErrorPageRecord struct {
+	TunnelID  int64     `json:"tunnel_id"`
+	Status    int       `json:"status"`
+	Template  string    `json:"template"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DomainRecord is a custom domain with its owner, admin approval and ownership verification
+// state. OwnerUserID 0 means the domain belongs to the server (added through /admin/domains or
+// imported from allowed_domains.json). Tunnels can use it once it is approved and verified.
+type DomainRecord struct {
+	ID            int64     `json:"id"`
+	Domain        string    `json:"domain"`
+	OwnerUserID   int64     `json:"owner_user_id"`
+	OwnerEmail    string    `json:"owner_email,omitempty"`
+	Description   string    `json:"description"`
+	Shared        bool      `json:"shared"`
+	Approval      string    `json:"approval"`
+	Status        string    `json:"status"`
+	Token         string    `json:"token"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	VerifiedAt    time.Time `json:"verified_at,omitempty"`
+	LastCheckedAt time.Time `json:"last_checked_at,omitempty"`
+	FailingSince  time.Time `json:"failing_since,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// DomainEventRecord is an audit entry for a domain. It outlives the domain itself.
+type DomainEventRecord struct {
+	ID          int64     `json:"id"`
+	Domain      string    `json:"domain"`
+	ActorUserID int64     `json:"actor_user_id"`
+	EventType   string    `json:"event_type"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TLSCertificateRecord is an uploaded certificate chain and key served for the names it covers.
+// The PEM fields are never returned by the API.
+type TLSCertificateRecord struct {
+	ID        int64     `json:"id"`
+	Names     []string  `json:"names"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	CreatedAt time.Time `json:"created_at"`
+	CertPEM   string    `json:"-"`
+	KeyPEM    string    `json:"-"`
+}
+
+// HostnameReservationRecord holds a tunnel hostname for a user who has no tunnel on it yet.
+type HostnameReservationRecord struct {
+	ID          int64     `json:"id"`
+	Hostname    string    `json:"hostname"`
+	OwnerUserID int64     `json:"owner_user_id"`
+	OwnerEmail  string    `json:"owner_email,omitempty"`
+	Note        string    `json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// HostnamePrefixRecord is the prefix a user's subdomains under the server hostname must start with.
+type HostnamePrefixRecord struct {
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email"`
+	Prefix    string    `json:"prefix"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QuotaLimits caps what a user may consume. Zero means unlimited.
+type QuotaLimits struct {
+	MaxTunnels           int   `json:"max_tunnels"`
+	MaxAgents            int   `json:"max_agents"`
+	MaxConnections       int   `json:"max_connections"`
+	MonthlyTransferBytes int64 `json:"monthly_transfer_bytes"`
+}
+
+// QuotaRecord is a quota definition for one user (by email), one OIDC group, or the default.
+type QuotaRecord struct {
+	ID      int64  `json:"id"`
+	Scope   string `json:"scope"`
+	Subject string `json:"subject"`
+	QuotaLimits
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UsageRecord is one day's proxied traffic for a tunnel, kept after the tunnel and its request
+// logs are gone so monthly reports stay complete.
+type UsageRecord struct {
+	Day         string `json:"day"`
+	OwnerUserID int64  `json:"owner_user_id"`
+	OwnerEmail  string `json:"owner_email"`
+	TunnelName  string `json:"tunnel_name"`
+	Hostname    string `json:"hostname"`
+	Requests    int64  `json:"requests"`
+	BytesIn     int64  `json:"bytes_in"`
+	BytesOut    int64  `json:"bytes_out"`
+	Errors      int64  `json:"errors"`
+}
+
+// RequestCaptureRecord is a stored request/response exchange for the capture inspector. Bodies
+// are cut at the capture size cap and sensitive headers are redacted before storage.
+type RequestCaptureRecord struct {
+	ID                int64       `json:"id"`
+	TunnelID          int64       `json:"tunnel_id"`
+	Timestamp         time.Time   `json:"timestamp"`
+	Method            string      `json:"method"`
+	Path              string      `json:"path"`
+	Query             string      `json:"query"`
+	RequestHeader     http.Header `json:"request_headers"`
+	RequestBody       []byte      `json:"request_body"`
+	RequestTruncated  bool        `json:"request_truncated"`
+	Status            int         `json:"status"`
+	ResponseHeader    http.Header `json:"response_headers"`
+	ResponseBody      []byte      `json:"response_body"`
+	ResponseTruncated bool        `json:"response_truncated"`
+	LatencyMS         int64       `json:"latency_ms"`
+	ErrorText         string      `json:"error_text,omitempty"`
+	ReplayOf          int64       `json:"replay_of,omitempty"`
+}
+
+// MirrorResultRecord compares one primary response with the mirrored copy the agent sent.
+type MirrorResultRecord struct {
+	ID               int64     `json:"id"`
+	TunnelID         int64     `json:"tunnel_id"`
+	Timestamp        time.Time `json:"timestamp"`
+	RequestID        string    `json:"request_id"`
+	Method           string    `json:"method"`
+	Path             string    `json:"path"`
+	PrimaryStatus    int       `json:"primary_status"`
+	PrimaryLatencyMS int64     `json:"primary_latency_ms"`
+	MirrorStatus     int       `json:"mirror_status"`
+	MirrorLatencyMS  int64     `json:"mirror_latency_ms"`
+	MirrorError      string    `json:"mirror_error,omitempty"`
+}
+
+// MirrorSummary aggregates a tunnel's stored mirror results.
+type MirrorSummary struct {
+	Total               int                  `json:"total"`
+	StatusMismatches    int                  `json:"status_mismatches"`
+	MirrorErrors        int                  `json:"mirror_errors"`
+	AvgPrimaryLatencyMS int64                `json:"avg_primary_latency_ms"`
+	AvgMirrorLatencyMS  int64                `json:"avg_mirror_latency_ms"`
+	LastReportAt        time.Time            `json:"last_report_at"`
+	RecentDiffs         []MirrorResultRecord `json:"recent_diffs"`
 }
 
 type TunnelEventRecord struct {
@@ -61,34 +256,71 @@ type TunnelEventRecord struct {
 }
 
 type AccessRuleInput struct {
-	AuthMode               string   `json:"auth_mode"`
-	BasicAuthUsername      string   `json:"basic_auth_username"`
-	BasicAuthPassword      string   `json:"basic_auth_password"`
-	SharedSecretHeaderName string   `json:"shared_secret_header_name"`
-	SharedSecretValue      string   `json:"shared_secret_value"`
-	AllowedIPs             []string `json:"allowed_ips"`
+	AuthMode               string            `json:"auth_mode"`
+	BasicAuthUsername      string            `json:"basic_auth_username"`
+	BasicAuthPassword      string            `json:"basic_auth_password"`
+	SharedSecretHeaderName string            `json:"shared_secret_header_name"`
+	SharedSecretValue      string            `json:"shared_secret_value"`
+	AllowedIPs             []string          `json:"allowed_ips"`
+	OIDCEmailDomains       []string          `json:"oidc_email_domains"`
+	OIDCEmails             []string          `json:"oidc_emails"`
+	OIDCGroups             []string          `json:"oidc_groups"`
+	JWTIssuer              string            `json:"jwt_issuer"`
+	JWTAudience            string            `json:"jwt_audience"`
+	JWTRequiredClaims      map[string]string `json:"jwt_required_claims"`
+	// ClearPrimarySecret drops the stored basic auth password or shared secret so only named
+	// credentials are accepted.
+	ClearPrimarySecret bool `json:"clear_primary_secret"`
 }
 
 type RequestLogRecord struct {
-	ID        int64     `json:"id"`
-	TunnelID  int64     `json:"tunnel_id"`
-	Hostname  string    `json:"hostname"`
-	Timestamp time.Time `json:"timestamp"`
-	Method    string    `json:"method"`
-	Host      string    `json:"host"`
-	Path      string    `json:"path"`
-	Status    int       `json:"status"`
-	LatencyMS int64     `json:"latency_ms"`
-	BytesIn   int64     `json:"bytes_in"`
-	BytesOut  int64     `json:"bytes_out"`
-	ClientIP  string    `json:"client_ip"`
-	ErrorText string    `json:"error_text"`
-	WSUpgrade bool      `json:"ws_upgrade"`
+	ID         int64     `json:"id"`
+	TunnelID   int64     `json:"tunnel_id"`
+	Hostname   string    `json:"hostname"`
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	LatencyMS  int64     `json:"latency_ms"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	ClientIP   string    `json:"client_ip"`
+	ErrorText  string    `json:"error_text"`
+	WSUpgrade  bool      `json:"ws_upgrade"`
+	Credential string    `json:"credential"`
+	Fault      string    `json:"fault,omitempty"`
+}
+
+// RequestLogQuery selects request logs, newest first. Zero fields do not filter.
+type RequestLogQuery struct {
+	TunnelID int64
+	Hostname string // used when TunnelID is 0
+	// From and To bound the timestamp as [From, To), to the second.
+	From time.Time
+	To   time.Time
+	// Statuses are exact codes and StatusClasses hundreds digits (5 for 5xx); a row matching
+	// any of them is kept.
+	Statuses      []int
+	StatusClasses []int
+	Method        string
+	// Path is a prefix, or a GLOB pattern when it contains *, ? or [.
+	Path         string
+	ClientIP     string
+	MinLatencyMS int64
+	// Before is the pagination cursor: only rows with a smaller id are returned.
+	Before int64
+	Limit  int
 }
 
 type Store struct {
 	db            *sql.DB
 	retentionHits atomic.Uint64
+	certVersion   atomic.Uint64
+	quotaVersion  atomic.Uint64
+	quotas        *QuotaManager
+	statsHistory  *StatsHistory
+	usage         *UsageBuffer
 }
 
 func NewStore(dataDir string) (*Store, error) {
@@ -106,6 +338,9 @@ func NewStore(dataDir string) (*Store, error) {
 	}
 	db.SetMaxOpenConns(1)
 	s := &Store{db: db}
+	s.quotas = newQuotaManager(s)
+	s.statsHistory = newStatsHistory()
+	s.usage = newUsageBuffer()
 	if err := s.migrate(context.Background()); err != nil {
 		_ = db.Close()
 		return nil, err
@@ -120,6 +355,15 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// exec runs a write statement and records its latency.
+func (s *Store) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	res, err := s.db.ExecContext(ctx, query, args...)
+	op, table := sqlWriteLabels(query)
+	metricSQLiteWrite.Observe(time.Since(start).Seconds(), op, table)
+	return res, err
+}
+
 func (s *Store) migrate(ctx context.Context) error {
 	const schema = `
 CREATE TABLE IF NOT EXISTS tunnels (
@@ -134,7 +378,16 @@ CREATE TABLE IF NOT EXISTS tunnels (
   last_error TEXT NOT NULL DEFAULT '',
   last_seen_at TEXT NOT NULL DEFAULT '',
   created_at TEXT NOT NULL,
-  updated_at TEXT NOT NULL
+  updated_at TEXT NOT NULL,
+  expires_at TEXT NOT NULL DEFAULT '',
+  expiry_action TEXT NOT NULL DEFAULT 'stop',
+  expired_at TEXT NOT NULL DEFAULT '',
+  maintenance INTEGER NOT NULL DEFAULT 0,
+  maintenance_message TEXT NOT NULL DEFAULT '',
+  maintenance_retry_after INTEGER NOT NULL DEFAULT 0,
+  maintenance_bypass_ips_json TEXT NOT NULL DEFAULT '[]',
+  maintenance_bypass_secret_hash TEXT NOT NULL DEFAULT '',
+  capture INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS agents (
@@ -165,6 +418,7 @@ CREATE TABLE IF NOT EXISTS request_logs (
   client_ip TEXT NOT NULL,
   error_text TEXT NOT NULL DEFAULT '',
   ws_upgrade INTEGER NOT NULL DEFAULT 0,
+  credential TEXT NOT NULL DEFAULT '',
   FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
 );
 
@@ -177,10 +431,177 @@ CREATE TABLE IF NOT EXISTS tunnel_access_rules (
   shared_secret_header_name TEXT NOT NULL DEFAULT '',
   shared_secret_hash TEXT NOT NULL DEFAULT '',
   allowed_ips_json TEXT NOT NULL DEFAULT '[]',
+  oidc_email_domains_json TEXT NOT NULL DEFAULT '[]',
+  oidc_emails_json TEXT NOT NULL DEFAULT '[]',
+  oidc_groups_json TEXT NOT NULL DEFAULT '[]',
+  jwt_issuer TEXT NOT NULL DEFAULT '',
+  jwt_audience TEXT NOT NULL DEFAULT '',
+  jwt_required_claims_json TEXT NOT NULL DEFAULT '{}',
+  share_link_key TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tunnel_credentials (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tunnel_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  username TEXT NOT NULL DEFAULT '',
+  secret_hash TEXT NOT NULL,
+  expires_at TEXT NOT NULL DEFAULT '',
+  last_used_at TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  UNIQUE(tunnel_id, name),
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS request_captures (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tunnel_id INTEGER NOT NULL,
+  ts TEXT NOT NULL,
+  method TEXT NOT NULL,
+  path TEXT NOT NULL,
+  query TEXT NOT NULL DEFAULT '',
+  request_headers_json TEXT NOT NULL DEFAULT '{}',
+  request_body BLOB,
+  request_truncated INTEGER NOT NULL DEFAULT 0,
+  status INTEGER NOT NULL DEFAULT 0,
+  response_headers_json TEXT NOT NULL DEFAULT '{}',
+  response_body BLOB,
+  response_truncated INTEGER NOT NULL DEFAULT 0,
+  latency_ms INTEGER NOT NULL DEFAULT 0,
+  error_text TEXT NOT NULL DEFAULT '',
+  replay_of INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_request_captures_tunnel ON request_captures(tunnel_id, id);
+
+CREATE TABLE IF NOT EXISTS mirror_results (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tunnel_id INTEGER NOT NULL,
+  ts TEXT NOT NULL,
+  request_id TEXT NOT NULL DEFAULT '',
+  method TEXT NOT NULL,
+  path TEXT NOT NULL,
+  primary_status INTEGER NOT NULL DEFAULT 0,
+  primary_latency_ms INTEGER NOT NULL DEFAULT 0,
+  mirror_status INTEGER NOT NULL DEFAULT 0,
+  mirror_latency_ms INTEGER NOT NULL DEFAULT 0,
+  mirror_error TEXT NOT NULL DEFAULT '',
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_mirror_results_tunnel ON mirror_results(tunnel_id, id);
+
+CREATE TABLE IF NOT EXISTS tunnel_chaos (
+  tunnel_id INTEGER PRIMARY KEY,
+  policy_json TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS error_pages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tunnel_id INTEGER NOT NULL DEFAULT 0,
+  status INTEGER NOT NULL,
+  template TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  UNIQUE(tunnel_id, status)
+);
+
+CREATE TABLE IF NOT EXISTS domains (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  domain TEXT NOT NULL UNIQUE,
+  owner_user_id INTEGER NOT NULL DEFAULT 0,
+  description TEXT NOT NULL DEFAULT '',
+  shared INTEGER NOT NULL DEFAULT 0,
+  approval TEXT NOT NULL DEFAULT 'pending',
+  status TEXT NOT NULL DEFAULT 'pending',
+  token TEXT NOT NULL,
+  verified_at TEXT NOT NULL DEFAULT '',
+  last_checked_at TEXT NOT NULL DEFAULT '',
+  failing_since TEXT NOT NULL DEFAULT '',
+  last_error TEXT NOT NULL DEFAULT '',
   created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS domain_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  domain TEXT NOT NULL,
+  actor_user_id INTEGER NOT NULL DEFAULT 0,
+  event_type TEXT NOT NULL,
+  message TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_domain_events_domain ON domain_events(domain, id);
+
+CREATE TABLE IF NOT EXISTS tls_certificates (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  names_json TEXT NOT NULL,
+  issuer TEXT NOT NULL DEFAULT '',
+  not_before TEXT NOT NULL,
+  not_after TEXT NOT NULL,
+  cert_pem TEXT NOT NULL,
+  key_pem TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS hostname_reservations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  hostname TEXT NOT NULL UNIQUE,
+  owner_user_id INTEGER NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS hostname_prefixes (
+  user_id INTEGER PRIMARY KEY,
+  prefix TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quotas (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  scope TEXT NOT NULL,
+  subject TEXT NOT NULL DEFAULT '',
+  max_tunnels INTEGER NOT NULL DEFAULT 0,
+  max_agents INTEGER NOT NULL DEFAULT 0,
+  max_connections INTEGER NOT NULL DEFAULT 0,
+  monthly_transfer_bytes INTEGER NOT NULL DEFAULT 0,
   updated_at TEXT NOT NULL,
+  UNIQUE(scope, subject)
+);
+
+CREATE TABLE IF NOT EXISTS usage_daily (
+  day TEXT NOT NULL,
+  owner_user_id INTEGER NOT NULL,
+  tunnel_name TEXT NOT NULL,
+  hostname TEXT NOT NULL,
+  requests INTEGER NOT NULL DEFAULT 0,
+  bytes_in INTEGER NOT NULL DEFAULT 0,
+  bytes_out INTEGER NOT NULL DEFAULT 0,
+  errors INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY(day, owner_user_id, tunnel_name)
+);
+
+CREATE TABLE IF NOT EXISTS tunnel_stats (
+  tunnel_id INTEGER NOT NULL,
+  step INTEGER NOT NULL,
+  bucket_start INTEGER NOT NULL,
+  requests INTEGER NOT NULL DEFAULT 0,
+  errors INTEGER NOT NULL DEFAULT 0,
+  bytes_in INTEGER NOT NULL DEFAULT 0,
+  bytes_out INTEGER NOT NULL DEFAULT 0,
+  latency_sum_ms INTEGER NOT NULL DEFAULT 0,
+  latency_max_ms INTEGER NOT NULL DEFAULT 0,
+  latency_hist TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY(tunnel_id, step, bucket_start),
   FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE
 );
+CREATE INDEX IF NOT EXISTS idx_tunnel_stats_start ON tunnel_stats(tunnel_id, bucket_start);
 
 CREATE TABLE IF NOT EXISTS tunnel_events (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -222,6 +643,30 @@ CREATE TABLE IF NOT EXISTS oidc_login_states (
   created_at TEXT NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS tunnel_sessions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  tunnel_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  session_token_hash TEXT NOT NULL UNIQUE,
+  expires_at TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  last_seen_at TEXT NOT NULL,
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tunnel_auth_codes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  code_hash TEXT NOT NULL UNIQUE,
+  tunnel_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  redirect_to TEXT NOT NULL DEFAULT '/',
+  expires_at TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  FOREIGN KEY(tunnel_id) REFERENCES tunnels(id) ON DELETE CASCADE,
+  FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
 CREATE INDEX IF NOT EXISTS idx_tunnels_name ON tunnels(name);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_tunnels_name_unique ON tunnels(name);
 CREATE INDEX IF NOT EXISTS idx_tunnels_hostname ON tunnels(hostname);
@@ -231,11 +676,13 @@ CREATE INDEX IF NOT EXISTS idx_agents_name ON agents(name);
 CREATE INDEX IF NOT EXISTS idx_agents_owner ON agents(owner_user_id);
 CREATE INDEX IF NOT EXISTS idx_agents_credential_hash ON agents(credential_hash);
 CREATE INDEX IF NOT EXISTS idx_request_logs_tunnel_time ON request_logs(tunnel_id, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_request_logs_tunnel_id ON request_logs(tunnel_id, id);
 CREATE INDEX IF NOT EXISTS idx_tunnel_access_rules_tunnel_id ON tunnel_access_rules(tunnel_id);
 CREATE INDEX IF NOT EXISTS idx_tunnel_events_tunnel_time ON tunnel_events(tunnel_id, created_at DESC);
 CREATE INDEX IF NOT EXISTS idx_users_subject ON users(oidc_subject);
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_oidc_sessions_hash ON oidc_sessions(session_token_hash);
+CREATE INDEX IF NOT EXISTS idx_tunnel_sessions_hash ON tunnel_sessions(session_token_hash);
 `
 	if _, err := s.db.ExecContext(ctx, schema); err != nil {
 		return err
@@ -243,6 +690,24 @@ CREATE INDEX IF NOT EXISTS idx_oidc_sessions_hash ON oidc_sessions(session_token
 	legacy := []string{
 		`ALTER TABLE tunnels ADD COLUMN owner_user_id INTEGER NOT NULL DEFAULT 0`,
 		`ALTER TABLE tunnels ADD COLUMN assigned_agent_id INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN oidc_email_domains_json TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN oidc_emails_json TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN oidc_groups_json TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN jwt_issuer TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN jwt_audience TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN jwt_required_claims_json TEXT NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE request_logs ADD COLUMN credential TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnels ADD COLUMN expires_at TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnels ADD COLUMN expiry_action TEXT NOT NULL DEFAULT 'stop'`,
+		`ALTER TABLE tunnels ADD COLUMN expired_at TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnel_access_rules ADD COLUMN share_link_key TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnels ADD COLUMN maintenance INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE tunnels ADD COLUMN maintenance_message TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnels ADD COLUMN maintenance_retry_after INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE tunnels ADD COLUMN maintenance_bypass_ips_json TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE tunnels ADD COLUMN maintenance_bypass_secret_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tunnels ADD COLUMN capture INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE request_logs ADD COLUMN fault TEXT NOT NULL DEFAULT ''`,
 	}
 	for _, stmt := range legacy {
 		_, _ = s.db.ExecContext(ctx, stmt)
@@ -282,7 +747,7 @@ ON CONFLICT(hostname) DO UPDATE SET
   last_seen_at=excluded.last_seen_at,
   updated_at=excluded.updated_at
 `
-	_, err := s.db.ExecContext(ctx, q, name, hostname, localHint, desiredState, actualState, lastError, seen, now, now)
+	_, err := s.exec(ctx, q, name, hostname, localHint, desiredState, actualState, lastError, seen, now, now)
 	return err
 }
 
@@ -294,13 +759,13 @@ SET actual_state = 'offline',
     updated_at = ?
 WHERE hostname = ?
 `
-	_, err := s.db.ExecContext(ctx, q, lastError, time.Now().UTC().Format(time.RFC3339Nano), hostname)
+	_, err := s.exec(ctx, q, lastError, time.Now().UTC().Format(time.RFC3339Nano), hostname)
 	return err
 }
 
 func (s *Store) ListTunnels(ctx context.Context) ([]TunnelRecord, error) {
 	rows, err := s.db.QueryContext(ctx, `
-SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at
+SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at, t.expires_at, t.expiry_action, t.expired_at, t.maintenance, t.maintenance_message, t.maintenance_retry_after, t.maintenance_bypass_ips_json, t.maintenance_bypass_secret_hash, t.capture
 FROM tunnels t
 LEFT JOIN users u ON u.id = t.owner_user_id
 LEFT JOIN agents a ON a.id = t.assigned_agent_id
@@ -313,13 +778,16 @@ ORDER BY hostname ASC`)
 	var out []TunnelRecord
 	for rows.Next() {
 		var rec TunnelRecord
-		var lastSeen, created, updated string
-		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated); err != nil {
+		var lastSeen, created, updated, expires, expired, bypassIPs string
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated, &expires, &rec.ExpiryAction, &expired, &rec.Maintenance, &rec.MaintenanceMessage, &rec.MaintenanceRetryAfter, &bypassIPs, &rec.MaintenanceBypassSecretHash, &rec.Capture); err != nil {
 			return nil, err
 		}
 		rec.LastSeenAt = parseRFC3339(lastSeen)
 		rec.CreatedAt = parseRFC3339(created)
 		rec.UpdatedAt = parseRFC3339(updated)
+		rec.ExpiresAt = parseRFC3339(expires)
+		rec.ExpiredAt = parseRFC3339(expired)
+		rec.MaintenanceBypassIPs = parseJSONStrings(bypassIPs)
 		out = append(out, rec)
 	}
 	return out, rows.Err()
@@ -330,7 +798,7 @@ func (s *Store) ListTunnelsForUser(ctx context.Context, userID int64, isAdmin bo
 		return s.ListTunnels(ctx)
 	}
 	rows, err := s.db.QueryContext(ctx, `
-SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at
+SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at, t.expires_at, t.expiry_action, t.expired_at, t.maintenance, t.maintenance_message, t.maintenance_retry_after, t.maintenance_bypass_ips_json, t.maintenance_bypass_secret_hash, t.capture
 FROM tunnels t
 LEFT JOIN users u ON u.id = t.owner_user_id
 LEFT JOIN agents a ON a.id = t.assigned_agent_id
@@ -343,13 +811,16 @@ ORDER BY t.hostname ASC`, userID)
 	var out []TunnelRecord
 	for rows.Next() {
 		var rec TunnelRecord
-		var lastSeen, created, updated string
-		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated); err != nil {
+		var lastSeen, created, updated, expires, expired, bypassIPs string
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated, &expires, &rec.ExpiryAction, &expired, &rec.Maintenance, &rec.MaintenanceMessage, &rec.MaintenanceRetryAfter, &bypassIPs, &rec.MaintenanceBypassSecretHash, &rec.Capture); err != nil {
 			return nil, err
 		}
 		rec.LastSeenAt = parseRFC3339(lastSeen)
 		rec.CreatedAt = parseRFC3339(created)
 		rec.UpdatedAt = parseRFC3339(updated)
+		rec.ExpiresAt = parseRFC3339(expires)
+		rec.ExpiredAt = parseRFC3339(expired)
+		rec.MaintenanceBypassIPs = parseJSONStrings(bypassIPs)
 		out = append(out, rec)
 	}
 	return out, rows.Err()
@@ -380,34 +851,133 @@ VALUES (?, ?, ?, ?, ?, 'stopped', 'offline', '', '', ?, ?)`,
 
 func (s *Store) GetTunnelByName(ctx context.Context, name string) (TunnelRecord, error) {
 	row := s.db.QueryRowContext(ctx, `
-SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at
+SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at, t.expires_at, t.expiry_action, t.expired_at, t.maintenance, t.maintenance_message, t.maintenance_retry_after, t.maintenance_bypass_ips_json, t.maintenance_bypass_secret_hash, t.capture
 FROM tunnels t
 LEFT JOIN users u ON u.id = t.owner_user_id
 LEFT JOIN agents a ON a.id = t.assigned_agent_id
 WHERE t.name = ?`, name)
 	var rec TunnelRecord
-	var lastSeen, created, updated string
-	if err := row.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated); err != nil {
+	var lastSeen, created, updated, expires, expired, bypassIPs string
+	if err := row.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated, &expires, &rec.ExpiryAction, &expired, &rec.Maintenance, &rec.MaintenanceMessage, &rec.MaintenanceRetryAfter, &bypassIPs, &rec.MaintenanceBypassSecretHash, &rec.Capture); err != nil {
 		return TunnelRecord{}, err
 	}
 	rec.LastSeenAt = parseRFC3339(lastSeen)
 	rec.CreatedAt = parseRFC3339(created)
 	rec.UpdatedAt = parseRFC3339(updated)
+	rec.ExpiresAt = parseRFC3339(expires)
+	rec.ExpiredAt = parseRFC3339(expired)
+	rec.MaintenanceBypassIPs = parseJSONStrings(bypassIPs)
 	return rec, nil
 }
 
+// SetTunnelExpiry sets or clears (zero expiresAt) a tunnel's expiry and re-arms the janitor.
+// An empty action keeps the current one.
+func (s *Store) SetTunnelExpiry(ctx context.Context, name string, expiresAt time.Time, action string) error {
+	exp := ""
+	if !expiresAt.IsZero() {
+		exp = expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	switch action {
+	case "", "stop", "delete":
+	default:
+		return fmt.Errorf("expiry action must be stop or delete")
+	}
+	_, err := s.exec(ctx, `
+UPDATE tunnels SET expires_at = ?, expiry_action = COALESCE(NULLIF(?, ''), expiry_action), expired_at = '', updated_at = ?
+WHERE name = ?`, exp, action, time.Now().UTC().Format(time.RFC3339Nano), name)
+	return err
+}
+
+// ListExpiredTunnels returns tunnels whose expiry has passed and that the janitor has not handled yet.
+func (s *Store) ListExpiredTunnels(ctx context.Context, now time.Time) ([]TunnelRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at, t.expires_at, t.expiry_action, t.expired_at, t.maintenance, t.maintenance_message, t.maintenance_retry_after, t.maintenance_bypass_ips_json, t.maintenance_bypass_secret_hash, t.capture
+FROM tunnels t
+LEFT JOIN users u ON u.id = t.owner_user_id
+LEFT JOIN agents a ON a.id = t.assigned_agent_id
+WHERE t.expires_at != '' AND t.expired_at = ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TunnelRecord
+	for rows.Next() {
+		var rec TunnelRecord
+		var lastSeen, created, updated, expires, expired, bypassIPs string
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated, &expires, &rec.ExpiryAction, &expired, &rec.Maintenance, &rec.MaintenanceMessage, &rec.MaintenanceRetryAfter, &bypassIPs, &rec.MaintenanceBypassSecretHash, &rec.Capture); err != nil {
+			return nil, err
+		}
+		rec.ExpiresAt = parseRFC3339(expires)
+		if rec.ExpiresAt.After(now) {
+			continue
+		}
+		rec.LastSeenAt = parseRFC3339(lastSeen)
+		rec.CreatedAt = parseRFC3339(created)
+		rec.UpdatedAt = parseRFC3339(updated)
+		rec.ExpiredAt = parseRFC3339(expired)
+		rec.MaintenanceBypassIPs = parseJSONStrings(bypassIPs)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// MarkTunnelExpired stops a tunnel and records that its expiry has been handled.
+func (s *Store) MarkTunnelExpired(ctx context.Context, name string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.exec(ctx, `
+UPDATE tunnels SET desired_state = 'stopped', actual_state = 'offline', expired_at = ?, updated_at = ?
+WHERE name = ?`, now, now, name)
+	return err
+}
+
+// SetTunnelMaintenance switches maintenance mode and stores its settings.
+func (s *Store) SetTunnelMaintenance(ctx context.Context, name string, input MaintenanceInput) error {
+	tun, err := s.GetTunnelByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if input.RetryAfter < 0 {
+		return fmt.Errorf("retry_after must not be negative")
+	}
+	bypass := make([]string, 0, len(input.BypassIPs))
+	for _, cidr := range input.BypassIPs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid cidr: %s", cidr)
+		}
+		bypass = append(bypass, cidr)
+	}
+	secretHash := tun.MaintenanceBypassSecretHash
+	if strings.TrimSpace(input.BypassSecret) != "" {
+		secretHash = hashSecret(input.BypassSecret)
+	} else if input.ClearBypassSecret {
+		secretHash = ""
+	}
+	_, err = s.exec(ctx, `
+UPDATE tunnels SET maintenance = ?, maintenance_message = ?, maintenance_retry_after = ?, maintenance_bypass_ips_json = ?, maintenance_bypass_secret_hash = ?, updated_at = ?
+WHERE name = ?`, input.Enabled, strings.TrimSpace(input.Message), input.RetryAfter, jsonStrings(bypass), secretHash, time.Now().UTC().Format(time.RFC3339Nano), name)
+	return err
+}
+
 func (s *Store) DeleteTunnelByName(ctx context.Context, name string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM tunnels WHERE name = ?`, name)
+	// error_pages has no foreign key because tunnel_id 0 holds the server-wide pages.
+	if _, err := s.exec(ctx, `DELETE FROM error_pages WHERE tunnel_id IN (SELECT id FROM tunnels WHERE name = ?)`, name); err != nil {
+		return err
+	}
+	_, err := s.exec(ctx, `DELETE FROM tunnels WHERE name = ?`, name)
 	return err
 }
 
 func (s *Store) AssignTunnelToAgent(ctx context.Context, name string, agentID int64) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE tunnels SET assigned_agent_id = ?, updated_at = ? WHERE name = ?`, agentID, time.Now().UTC().Format(time.RFC3339Nano), name)
+	_, err := s.exec(ctx, `UPDATE tunnels SET assigned_agent_id = ?, updated_at = ? WHERE name = ?`, agentID, time.Now().UTC().Format(time.RFC3339Nano), name)
 	return err
 }
 
 func (s *Store) SetTunnelDesiredState(ctx context.Context, name, desiredState string) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE tunnels SET desired_state = ?, updated_at = ? WHERE name = ?`, desiredState, time.Now().UTC().Format(time.RFC3339Nano), name)
+	_, err := s.exec(ctx, `UPDATE tunnels SET desired_state = ?, updated_at = ? WHERE name = ?`, desiredState, time.Now().UTC().Format(time.RFC3339Nano), name)
 	return err
 }
 
@@ -416,7 +986,7 @@ func (s *Store) UpdateTunnelStateByName(ctx context.Context, name, localHint, ac
 	if !seenAt.IsZero() {
 		seen = seenAt.UTC().Format(time.RFC3339Nano)
 	}
-	_, err := s.db.ExecContext(ctx, `
+	_, err := s.exec(ctx, `
 UPDATE tunnels
 SET local_target_hint = CASE WHEN ? <> '' THEN ? ELSE local_target_hint END,
     actual_state = ?,
@@ -430,113 +1000,368 @@ WHERE name = ?`,
 
 func (s *Store) GetTunnelForAgent(ctx context.Context, name string, agentID int64) (TunnelRecord, error) {
 	row := s.db.QueryRowContext(ctx, `
-SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at
+SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at, t.expires_at, t.expiry_action, t.expired_at, t.maintenance, t.maintenance_message, t.maintenance_retry_after, t.maintenance_bypass_ips_json, t.maintenance_bypass_secret_hash, t.capture
 FROM tunnels t
 LEFT JOIN users u ON u.id = t.owner_user_id
 LEFT JOIN agents a ON a.id = t.assigned_agent_id
 WHERE t.name = ? AND t.assigned_agent_id = ?`, name, agentID)
 	var rec TunnelRecord
-	var lastSeen, created, updated string
-	if err := row.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated); err != nil {
+	var lastSeen, created, updated, expires, expired, bypassIPs string
+	if err := row.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated, &expires, &rec.ExpiryAction, &expired, &rec.Maintenance, &rec.MaintenanceMessage, &rec.MaintenanceRetryAfter, &bypassIPs, &rec.MaintenanceBypassSecretHash, &rec.Capture); err != nil {
 		return TunnelRecord{}, err
 	}
 	rec.LastSeenAt = parseRFC3339(lastSeen)
 	rec.CreatedAt = parseRFC3339(created)
 	rec.UpdatedAt = parseRFC3339(updated)
+	rec.ExpiresAt = parseRFC3339(expires)
+	rec.ExpiredAt = parseRFC3339(expired)
+	rec.MaintenanceBypassIPs = parseJSONStrings(bypassIPs)
 	return rec, nil
 }
 
-func (s *Store) InsertRequestLog(ctx context.Context, rec RequestLogRecord) error {
-	tunnelID := rec.TunnelID
-	var err error
-	if tunnelID == 0 {
-		tunnelID, err = s.tunnelIDByHostname(ctx, rec.Hostname)
-		if err != nil {
-			return err
-		}
-	}
-	if tunnelID == 0 {
-		return nil
-	}
-	const q = `
-INSERT INTO request_logs (tunnel_id, hostname, timestamp, method, host, path, status, latency_ms, bytes_in, bytes_out, client_ip, error_text, ws_upgrade)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-`
-	_, err = s.db.ExecContext(ctx, q,
-		tunnelID,
-		rec.Hostname,
-		rec.Timestamp.UTC().Format(time.RFC3339Nano),
-		rec.Method,
-		rec.Host,
-		rec.Path,
-		rec.Status,
-		rec.LatencyMS,
-		rec.BytesIn,
-		rec.BytesOut,
-		rec.ClientIP,
-		rec.ErrorText,
-		boolToInt(rec.WSUpgrade),
-	)
+func (s *Store) SetTunnelCapture(ctx context.Context, name string, enabled bool) error {
+	res, err := s.exec(ctx, `UPDATE tunnels SET capture = ?, updated_at = ? WHERE name = ?`, enabled, time.Now().UTC().Format(time.RFC3339Nano), name)
 	if err != nil {
 		return err
 	}
-	if s.retentionHits.Add(1)%100 == 0 {
-		_ = s.enforceLogRetention(ctx, tunnelID)
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
 	}
 	return nil
 }
 
-func (s *Store) ListRequestLogs(ctx context.Context, hostname string, limit int) ([]RequestLogRecord, error) {
-	if limit <= 0 || limit > 500 {
-		limit = 100
+// InsertRequestCapture stores a capture and trims the tunnel's captures to the newest keep rows.
+func (s *Store) InsertRequestCapture(ctx context.Context, rec RequestCaptureRecord, keep int) (int64, error) {
+	reqHeaders, _ := json.Marshal(rec.RequestHeader)
+	respHeaders, _ := json.Marshal(rec.ResponseHeader)
+	res, err := s.exec(ctx, `
+INSERT INTO request_captures (tunnel_id, ts, method, path, query, request_headers_json, request_body, request_truncated, status, response_headers_json, response_body, response_truncated, latency_ms, error_text, replay_of)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.TunnelID, rec.Timestamp.UTC().Format(time.RFC3339Nano), rec.Method, rec.Path, rec.Query, string(reqHeaders), rec.RequestBody, rec.RequestTruncated,
+		rec.Status, string(respHeaders), rec.ResponseBody, rec.ResponseTruncated, rec.LatencyMS, rec.ErrorText, rec.ReplayOf)
+	if err != nil {
+		return 0, err
 	}
-	rows, err := s.db.QueryContext(ctx, `
-SELECT id, tunnel_id, hostname, timestamp, method, host, path, status, latency_ms, bytes_in, bytes_out, client_ip, error_text, ws_upgrade
-FROM request_logs
-WHERE hostname = ?
-ORDER BY timestamp DESC
-LIMIT ?`, hostname, limit)
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.exec(ctx, `
+DELETE FROM request_captures
+WHERE tunnel_id = ? AND id NOT IN (SELECT id FROM request_captures WHERE tunnel_id = ? ORDER BY id DESC LIMIT ?)`, rec.TunnelID, rec.TunnelID, keep)
+	return id, err
+}
+
+const requestCaptureColumns = `id, tunnel_id, ts, method, path, query, request_headers_json, request_body, request_truncated, status, response_headers_json, response_body, response_truncated, latency_ms, error_text, replay_of`
+
+func scanRequestCaptureRecord(row rowScanner) (RequestCaptureRecord, error) {
+	var rec RequestCaptureRecord
+	var ts, reqHeaders, respHeaders string
+	if err := row.Scan(&rec.ID, &rec.TunnelID, &ts, &rec.Method, &rec.Path, &rec.Query, &reqHeaders, &rec.RequestBody, &rec.RequestTruncated,
+		&rec.Status, &respHeaders, &rec.ResponseBody, &rec.ResponseTruncated, &rec.LatencyMS, &rec.ErrorText, &rec.ReplayOf); err != nil {
+		return RequestCaptureRecord{}, err
+	}
+	rec.Timestamp = parseRFC3339(ts)
+	_ = json.Unmarshal([]byte(reqHeaders), &rec.RequestHeader)
+	_ = json.Unmarshal([]byte(respHeaders), &rec.ResponseHeader)
+	return rec, nil
+}
+
+func (s *Store) ListRequestCaptures(ctx context.Context, tunnelID int64, limit int) ([]RequestCaptureRecord, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+requestCaptureColumns+` FROM request_captures WHERE tunnel_id = ? ORDER BY id DESC LIMIT ?`, tunnelID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
-	var out []RequestLogRecord
+	var out []RequestCaptureRecord
 	for rows.Next() {
-		var rec RequestLogRecord
-		var ts string
-		var ws int
-		if err := rows.Scan(&rec.ID, &rec.TunnelID, &rec.Hostname, &ts, &rec.Method, &rec.Host, &rec.Path, &rec.Status, &rec.LatencyMS, &rec.BytesIn, &rec.BytesOut, &rec.ClientIP, &rec.ErrorText, &ws); err != nil {
+		rec, err := scanRequestCaptureRecord(rows)
+		if err != nil {
 			return nil, err
 		}
-		rec.Timestamp = parseRFC3339(ts)
-		rec.WSUpgrade = ws == 1
 		out = append(out, rec)
 	}
 	return out, rows.Err()
 }
 
-func (s *Store) ListRequestLogsByTunnel(ctx context.Context, tunnelID int64, limit int) ([]RequestLogRecord, error) {
-	if limit <= 0 || limit > 500 {
-		limit = 100
+func (s *Store) GetRequestCapture(ctx context.Context, tunnelID, id int64) (RequestCaptureRecord, error) {
+	return scanRequestCaptureRecord(s.db.QueryRowContext(ctx, `SELECT `+requestCaptureColumns+` FROM request_captures WHERE tunnel_id = ? AND id = ?`, tunnelID, id))
+}
+
+// InsertMirrorResult stores a mirror report and trims the tunnel's results to the newest keep rows.
+func (s *Store) InsertMirrorResult(ctx context.Context, rec MirrorResultRecord, keep int) error {
+	_, err := s.exec(ctx, `
+INSERT INTO mirror_results (tunnel_id, ts, request_id, method, path, primary_status, primary_latency_ms, mirror_status, mirror_latency_ms, mirror_error)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.TunnelID, rec.Timestamp.UTC().Format(time.RFC3339Nano), rec.RequestID, rec.Method, rec.Path,
+		rec.PrimaryStatus, rec.PrimaryLatencyMS, rec.MirrorStatus, rec.MirrorLatencyMS, rec.MirrorError)
+	if err != nil {
+		return err
 	}
+	_, err = s.exec(ctx, `
+DELETE FROM mirror_results
+WHERE tunnel_id = ? AND id NOT IN (SELECT id FROM mirror_results WHERE tunnel_id = ? ORDER BY id DESC LIMIT ?)`, rec.TunnelID, rec.TunnelID, keep)
+	return err
+}
+
+// GetMirrorSummary aggregates the tunnel's stored mirror results and returns up to limit of the
+// most recent results whose status differed or whose mirror failed. Latency averages only count
+// results where the mirror answered.
+func (s *Store) GetMirrorSummary(ctx context.Context, tunnelID int64, limit int) (MirrorSummary, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	var sum MirrorSummary
+	var avgPrimary, avgMirror sql.NullFloat64
+	var last sql.NullString
+	if err := s.db.QueryRowContext(ctx, `
+SELECT COUNT(*),
+  COALESCE(SUM(CASE WHEN mirror_error = '' AND mirror_status != primary_status THEN 1 ELSE 0 END), 0),
+  COALESCE(SUM(CASE WHEN mirror_error != '' THEN 1 ELSE 0 END), 0),
+  AVG(CASE WHEN mirror_error = '' THEN primary_latency_ms END),
+  AVG(CASE WHEN mirror_error = '' THEN mirror_latency_ms END),
+  (SELECT ts FROM mirror_results WHERE tunnel_id = ? ORDER BY id DESC LIMIT 1)
+FROM mirror_results WHERE tunnel_id = ?`, tunnelID, tunnelID).Scan(&sum.Total, &sum.StatusMismatches, &sum.MirrorErrors, &avgPrimary, &avgMirror, &last); err != nil {
+		return MirrorSummary{}, err
+	}
+	sum.AvgPrimaryLatencyMS = int64(avgPrimary.Float64)
+	sum.AvgMirrorLatencyMS = int64(avgMirror.Float64)
+	sum.LastReportAt = parseRFC3339(last.String)
 	rows, err := s.db.QueryContext(ctx, `
-SELECT id, tunnel_id, hostname, timestamp, method, host, path, status, latency_ms, bytes_in, bytes_out, client_ip, error_text, ws_upgrade
-FROM request_logs
-WHERE tunnel_id = ?
-ORDER BY timestamp DESC
-LIMIT ?`, tunnelID, limit)
+SELECT id, tunnel_id, ts, request_id, method, path, primary_status, primary_latency_ms, mirror_status, mirror_latency_ms, mirror_error
+FROM mirror_results
+WHERE tunnel_id = ? AND (mirror_error != '' OR mirror_status != primary_status)
+ORDER BY id DESC LIMIT ?`, tunnelID, limit)
 	if err != nil {
-		return nil, err
+		return MirrorSummary{}, err
 	}
 	defer rows.Close()
-	var out []RequestLogRecord
 	for rows.Next() {
-		var rec RequestLogRecord
+		var rec MirrorResultRecord
 		var ts string
-		var ws int
-		if err := rows.Scan(&rec.ID, &rec.TunnelID, &rec.Hostname, &ts, &rec.Method, &rec.Host, &rec.Path, &rec.Status, &rec.LatencyMS, &rec.BytesIn, &rec.BytesOut, &rec.ClientIP, &rec.ErrorText, &ws); err != nil {
-			return nil, err
+		if err := rows.Scan(&rec.ID, &rec.TunnelID, &ts, &rec.RequestID, &rec.Method, &rec.Path, &rec.PrimaryStatus, &rec.PrimaryLatencyMS,
+			&rec.MirrorStatus, &rec.MirrorLatencyMS, &rec.MirrorError); err != nil {
+			return MirrorSummary{}, err
+		}
+		rec.Timestamp = parseRFC3339(ts)
+		sum.RecentDiffs = append(sum.RecentDiffs, rec)
+	}
+	return sum, rows.Err()
+}
+
+// SetTunnelChaos installs or replaces a tunnel's chaos policy.
+func (s *Store) SetTunnelChaos(ctx context.Context, tunnelID int64, policy ChaosPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, `
+INSERT INTO tunnel_chaos (tunnel_id, policy_json, expires_at, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(tunnel_id) DO UPDATE SET policy_json = excluded.policy_json, expires_at = excluded.expires_at, updated_at = excluded.updated_at`,
+		tunnelID, string(data), policy.ExpiresAt.UTC().Format(time.RFC3339Nano), time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// GetTunnelChaos returns the tunnel's chaos policy, or sql.ErrNoRows when none is set. The policy
+// may already be past its expiry if the janitor has not removed it yet.
+func (s *Store) GetTunnelChaos(ctx context.Context, tunnelID int64) (ChaosPolicy, error) {
+	var raw, expires string
+	if err := s.db.QueryRowContext(ctx, `SELECT policy_json, expires_at FROM tunnel_chaos WHERE tunnel_id = ?`, tunnelID).Scan(&raw, &expires); err != nil {
+		return ChaosPolicy{}, err
+	}
+	var policy ChaosPolicy
+	if err := json.Unmarshal([]byte(raw), &policy); err != nil {
+		return ChaosPolicy{}, err
+	}
+	policy.ExpiresAt = parseRFC3339(expires)
+	return policy, nil
+}
+
+func (s *Store) ClearTunnelChaos(ctx context.Context, tunnelID int64) error {
+	res, err := s.exec(ctx, `DELETE FROM tunnel_chaos WHERE tunnel_id = ?`, tunnelID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ExpireTunnelChaos removes chaos policies whose expiry has passed and returns the hostnames of
+// the affected tunnels.
+func (s *Store) ExpireTunnelChaos(ctx context.Context, now time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT c.tunnel_id, c.expires_at, t.hostname
+FROM tunnel_chaos c
+JOIN tunnels t ON t.id = c.tunnel_id`)
+	if err != nil {
+		return nil, err
+	}
+	type expired struct {
+		id       int64
+		hostname string
+	}
+	var list []expired
+	for rows.Next() {
+		var e expired
+		var expires string
+		if err := rows.Scan(&e.id, &expires, &e.hostname); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !parseRFC3339(expires).After(now) {
+			list = append(list, e)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range list {
+		if _, err := s.exec(ctx, `DELETE FROM tunnel_chaos WHERE tunnel_id = ?`, e.id); err != nil {
+			return out, err
+		}
+		out = append(out, e.hostname)
+	}
+	return out, nil
+}
+
+func (s *Store) InsertRequestLog(ctx context.Context, rec RequestLogRecord) error {
+	tunnelID := rec.TunnelID
+	var err error
+	if tunnelID == 0 {
+		tunnelID, err = s.tunnelIDByHostname(ctx, rec.Hostname)
+		if err != nil {
+			return err
+		}
+	}
+	if tunnelID == 0 {
+		return nil
+	}
+	const q = `
+INSERT INTO request_logs (tunnel_id, hostname, timestamp, method, host, path, status, latency_ms, bytes_in, bytes_out, client_ip, error_text, ws_upgrade, credential, fault)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+	_, err = s.exec(ctx, q,
+		tunnelID,
+		rec.Hostname,
+		rec.Timestamp.UTC().Format(time.RFC3339Nano),
+		rec.Method,
+		rec.Host,
+		rec.Path,
+		rec.Status,
+		rec.LatencyMS,
+		rec.BytesIn,
+		rec.BytesOut,
+		rec.ClientIP,
+		rec.ErrorText,
+		boolToInt(rec.WSUpgrade),
+		rec.Credential,
+		rec.Fault,
+	)
+	if err != nil {
+		return err
+	}
+	if s.retentionHits.Add(1)%100 == 0 {
+		_ = s.enforceLogRetention(ctx, tunnelID)
+	}
+	return nil
+}
+
+func (s *Store) ListRequestLogs(ctx context.Context, hostname string, limit int) ([]RequestLogRecord, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return s.QueryRequestLogs(ctx, RequestLogQuery{Hostname: hostname, Limit: limit})
+}
+
+func (s *Store) ListRequestLogsByTunnel(ctx context.Context, tunnelID int64, limit int) ([]RequestLogRecord, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return s.QueryRequestLogs(ctx, RequestLogQuery{TunnelID: tunnelID, Limit: limit})
+}
+
+// QueryRequestLogs returns up to q.Limit (at most maxRequestLogPage) logs matching q, newest
+// first. The id of the last row is the cursor for the next page.
+func (s *Store) QueryRequestLogs(ctx context.Context, q RequestLogQuery) ([]RequestLogRecord, error) {
+	var where []string
+	var args []any
+	add := func(clause string, values ...any) {
+		where = append(where, clause)
+		args = append(args, values...)
+	}
+	if q.TunnelID != 0 {
+		add("tunnel_id = ?", q.TunnelID)
+	} else {
+		add("hostname = ?", q.Hostname)
+	}
+	// Timestamps are RFC3339Nano, whose fraction is trimmed, so bounds are compared without a
+	// zone suffix: "…T14:00:00" sorts before "…T14:00:00Z" and "…T14:00:00.5Z" alike.
+	if !q.From.IsZero() {
+		add("timestamp >= ?", q.From.UTC().Format("2006-01-02T15:04:05"))
+	}
+	if !q.To.IsZero() {
+		add("timestamp < ?", q.To.UTC().Format("2006-01-02T15:04:05"))
+	}
+	var statuses []string
+	for _, code := range q.Statuses {
+		statuses = append(statuses, "status = ?")
+		args = append(args, code)
+	}
+	for _, class := range q.StatusClasses {
+		statuses = append(statuses, "status BETWEEN ? AND ?")
+		args = append(args, class*100, class*100+99)
+	}
+	if len(statuses) > 0 {
+		where = append(where, "("+strings.Join(statuses, " OR ")+")")
+	}
+	if q.Method != "" {
+		add("method = ?", strings.ToUpper(q.Method))
+	}
+	if q.Path != "" {
+		if strings.ContainsAny(q.Path, "*?[") {
+			add("path GLOB ?", q.Path)
+		} else {
+			add("substr(path, 1, ?) = ?", len(q.Path), q.Path)
+		}
+	}
+	if q.ClientIP != "" {
+		add("client_ip = ?", q.ClientIP)
+	}
+	if q.MinLatencyMS > 0 {
+		add("latency_ms >= ?", q.MinLatencyMS)
+	}
+	if q.Before > 0 {
+		add("id < ?", q.Before)
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > maxRequestLogPage {
+		limit = maxRequestLogPage
+	}
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, tunnel_id, hostname, timestamp, method, host, path, status, latency_ms, bytes_in, bytes_out, client_ip, error_text, ws_upgrade, credential, fault
+FROM request_logs
+WHERE `+strings.Join(where, " AND ")+`
+ORDER BY id DESC
+LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RequestLogRecord
+	for rows.Next() {
+		var rec RequestLogRecord
+		var ts string
+		var ws int
+		if err := rows.Scan(&rec.ID, &rec.TunnelID, &rec.Hostname, &ts, &rec.Method, &rec.Host, &rec.Path, &rec.Status, &rec.LatencyMS, &rec.BytesIn, &rec.BytesOut, &rec.ClientIP, &rec.ErrorText, &ws, &rec.Credential, &rec.Fault); err != nil {
+			return nil, err
 		}
 		rec.Timestamp = parseRFC3339(ts)
 		rec.WSUpgrade = ws == 1
@@ -566,7 +1391,7 @@ ON CONFLICT(oidc_subject) DO UPDATE SET
   updated_at=excluded.updated_at,
   last_login_at=excluded.last_login_at
 `
-	if _, err := s.db.ExecContext(ctx, q, subject, email, displayName, role, groupsJSON, now, now, now); err != nil {
+	if _, err := s.exec(ctx, q, subject, email, displayName, role, groupsJSON, now, now, now); err != nil {
 		return UserRecord{}, err
 	}
 	row := s.db.QueryRowContext(ctx, `
@@ -577,7 +1402,7 @@ FROM users WHERE oidc_subject = ?`, subject)
 
 func (s *Store) CreateSession(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	_, err := s.db.ExecContext(ctx, `
+	_, err := s.exec(ctx, `
 INSERT INTO oidc_sessions (user_id, session_token_hash, expires_at, created_at, last_seen_at)
 VALUES (?, ?, ?, ?, ?)`,
 		userID, tokenHash, expiresAt.UTC().Format(time.RFC3339Nano), now, now)
@@ -612,12 +1437,12 @@ WHERE s.session_token_hash = ?`, tokenHash)
 	user.UpdatedAt = parseRFC3339(userUpdated)
 	user.LastLoginAt = parseRFC3339(userLastLogin)
 	user.Groups = parseJSONStrings(groupsJSON)
-	_, _ = s.db.ExecContext(ctx, `UPDATE oidc_sessions SET last_seen_at = ? WHERE id = ?`, time.Now().UTC().Format(time.RFC3339Nano), sess.ID)
+	_, _ = s.exec(ctx, `UPDATE oidc_sessions SET last_seen_at = ? WHERE id = ?`, time.Now().UTC().Format(time.RFC3339Nano), sess.ID)
 	return sess, user, nil
 }
 
 func (s *Store) DeleteSession(ctx context.Context, tokenHash string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM oidc_sessions WHERE session_token_hash = ?`, tokenHash)
+	_, err := s.exec(ctx, `DELETE FROM oidc_sessions WHERE session_token_hash = ?`, tokenHash)
 	return err
 }
 
@@ -625,7 +1450,7 @@ func (s *Store) CreateLoginState(ctx context.Context, state, verifier, redirectT
 	if redirectTo == "" {
 		redirectTo = "/admin/ui"
 	}
-	_, err := s.db.ExecContext(ctx, `
+	_, err := s.exec(ctx, `
 INSERT INTO oidc_login_states (state, code_verifier, redirect_to, expires_at, created_at)
 VALUES (?, ?, ?, ?, ?)`,
 		state, verifier, redirectTo, expiresAt.UTC().Format(time.RFC3339Nano), time.Now().UTC().Format(time.RFC3339Nano))
@@ -652,84 +1477,748 @@ WHERE state = ?`, state)
 	if _, err := tx.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE id = ?`, rec.ID); err != nil {
 		return LoginStateRecord{}, err
 	}
-	if err := tx.Commit(); err != nil {
-		return LoginStateRecord{}, err
+	if err := tx.Commit(); err != nil {
+		return LoginStateRecord{}, err
+	}
+	if !rec.ExpiresAt.IsZero() && time.Now().After(rec.ExpiresAt) {
+		return LoginStateRecord{}, sql.ErrNoRows
+	}
+	return rec, nil
+}
+
+func (s *Store) CreateTunnelAuthCode(ctx context.Context, codeHash string, tunnelID, userID int64, redirectTo string, expiresAt time.Time) error {
+	if redirectTo == "" {
+		redirectTo = "/"
+	}
+	_, err := s.exec(ctx, `
+INSERT INTO tunnel_auth_codes (code_hash, tunnel_id, user_id, redirect_to, expires_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?)`,
+		codeHash, tunnelID, userID, redirectTo, expiresAt.UTC().Format(time.RFC3339Nano), time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// ConsumeTunnelAuthCode deletes and returns a one-time tunnel handoff code.
+func (s *Store) ConsumeTunnelAuthCode(ctx context.Context, codeHash string) (TunnelAuthCodeRecord, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TunnelAuthCodeRecord{}, err
+	}
+	defer tx.Rollback()
+	row := tx.QueryRowContext(ctx, `
+SELECT id, tunnel_id, user_id, redirect_to, expires_at, created_at
+FROM tunnel_auth_codes
+WHERE code_hash = ?`, codeHash)
+	var rec TunnelAuthCodeRecord
+	var expiresAt, createdAt string
+	if err := row.Scan(&rec.ID, &rec.TunnelID, &rec.UserID, &rec.RedirectTo, &expiresAt, &createdAt); err != nil {
+		return TunnelAuthCodeRecord{}, err
+	}
+	rec.ExpiresAt = parseRFC3339(expiresAt)
+	rec.CreatedAt = parseRFC3339(createdAt)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tunnel_auth_codes WHERE id = ? OR expires_at < ?`, rec.ID, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		return TunnelAuthCodeRecord{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return TunnelAuthCodeRecord{}, err
+	}
+	if !rec.ExpiresAt.IsZero() && time.Now().After(rec.ExpiresAt) {
+		return TunnelAuthCodeRecord{}, sql.ErrNoRows
+	}
+	return rec, nil
+}
+
+func (s *Store) CreateTunnelSession(ctx context.Context, tunnelID, userID int64, tokenHash string, expiresAt time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.exec(ctx, `
+INSERT INTO tunnel_sessions (tunnel_id, user_id, session_token_hash, expires_at, created_at, last_seen_at)
+VALUES (?, ?, ?, ?, ?, ?)`,
+		tunnelID, userID, tokenHash, expiresAt.UTC().Format(time.RFC3339Nano), now, now)
+	return err
+}
+
+func (s *Store) GetTunnelSessionByToken(ctx context.Context, tokenHash string) (TunnelSessionRecord, UserRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT s.id, s.tunnel_id, s.user_id, s.expires_at, s.created_at, s.last_seen_at,
+       u.id, u.oidc_subject, u.email, u.display_name, u.roles, u.groups_snapshot, u.created_at, u.updated_at, u.last_login_at
+FROM tunnel_sessions s
+JOIN users u ON u.id = s.user_id
+WHERE s.session_token_hash = ?`, tokenHash)
+	var sess TunnelSessionRecord
+	var user UserRecord
+	var sessExp, sessCreated, sessLastSeen string
+	var groupsJSON, userCreated, userUpdated, userLastLogin string
+	if err := row.Scan(
+		&sess.ID, &sess.TunnelID, &sess.UserID, &sessExp, &sessCreated, &sessLastSeen,
+		&user.ID, &user.OIDCSubject, &user.Email, &user.DisplayName, &user.Role, &groupsJSON, &userCreated, &userUpdated, &userLastLogin,
+	); err != nil {
+		return TunnelSessionRecord{}, UserRecord{}, err
+	}
+	sess.ExpiresAt = parseRFC3339(sessExp)
+	sess.CreatedAt = parseRFC3339(sessCreated)
+	sess.LastSeenAt = parseRFC3339(sessLastSeen)
+	if !sess.ExpiresAt.IsZero() && time.Now().After(sess.ExpiresAt) {
+		_ = s.DeleteTunnelSession(ctx, tokenHash)
+		return TunnelSessionRecord{}, UserRecord{}, sql.ErrNoRows
+	}
+	user.CreatedAt = parseRFC3339(userCreated)
+	user.UpdatedAt = parseRFC3339(userUpdated)
+	user.LastLoginAt = parseRFC3339(userLastLogin)
+	user.Groups = parseJSONStrings(groupsJSON)
+	return sess, user, nil
+}
+
+func (s *Store) DeleteTunnelSession(ctx context.Context, tokenHash string) error {
+	_, err := s.exec(ctx, `DELETE FROM tunnel_sessions WHERE session_token_hash = ?`, tokenHash)
+	return err
+}
+
+// DeleteExpiredTunnelSessions removes tunnel login sessions that expired before now and returns
+// how many were removed.
+func (s *Store) DeleteExpiredTunnelSessions(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.exec(ctx, `DELETE FROM tunnel_sessions WHERE expires_at != '' AND julianday(expires_at) < julianday(?)`, now.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) AddTunnelEvent(ctx context.Context, hostname, eventType, message string) error {
+	tunnelID, err := s.tunnelIDByHostname(ctx, hostname)
+	if err != nil || tunnelID == 0 {
+		return err
+	}
+	_, err = s.exec(ctx, `
+INSERT INTO tunnel_events (tunnel_id, event_type, message, created_at)
+VALUES (?, ?, ?, ?)`, tunnelID, eventType, message, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *Store) ListTunnelEventsByTunnel(ctx context.Context, tunnelID int64, limit int) ([]TunnelEventRecord, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, tunnel_id, event_type, message, created_at
+FROM tunnel_events
+WHERE tunnel_id = ?
+ORDER BY created_at DESC
+LIMIT ?`, tunnelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TunnelEventRecord
+	for rows.Next() {
+		var rec TunnelEventRecord
+		var created string
+		if err := rows.Scan(&rec.ID, &rec.TunnelID, &rec.EventType, &rec.Message, &created); err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = parseRFC3339(created)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// GetTunnelByHostname returns the tunnel serving hostname: an exact match first, then the
+// wildcard hostname covering its leading label.
+func (s *Store) GetTunnelByHostname(ctx context.Context, hostname string) (TunnelRecord, error) {
+	rec, err := s.getTunnelByExactHostname(ctx, hostname)
+	if err != sql.ErrNoRows {
+		return rec, err
+	}
+	if pattern := wildcardFor(hostname); pattern != "" {
+		return s.getTunnelByExactHostname(ctx, pattern)
+	}
+	return TunnelRecord{}, sql.ErrNoRows
+}
+
+func (s *Store) getTunnelByExactHostname(ctx context.Context, hostname string) (TunnelRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at, t.expires_at, t.expiry_action, t.expired_at, t.maintenance, t.maintenance_message, t.maintenance_retry_after, t.maintenance_bypass_ips_json, t.maintenance_bypass_secret_hash, t.capture
+FROM tunnels t
+LEFT JOIN users u ON u.id = t.owner_user_id
+LEFT JOIN agents a ON a.id = t.assigned_agent_id
+WHERE t.hostname = ?`, hostname)
+	var rec TunnelRecord
+	var lastSeen, created, updated, expires, expired, bypassIPs string
+	if err := row.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated, &expires, &rec.ExpiryAction, &expired, &rec.Maintenance, &rec.MaintenanceMessage, &rec.MaintenanceRetryAfter, &bypassIPs, &rec.MaintenanceBypassSecretHash, &rec.Capture); err != nil {
+		return TunnelRecord{}, err
+	}
+	rec.LastSeenAt = parseRFC3339(lastSeen)
+	rec.CreatedAt = parseRFC3339(created)
+	rec.UpdatedAt = parseRFC3339(updated)
+	rec.ExpiresAt = parseRFC3339(expires)
+	rec.ExpiredAt = parseRFC3339(expired)
+	rec.MaintenanceBypassIPs = parseJSONStrings(bypassIPs)
+	return rec, nil
+}
+
+func (s *Store) GetTunnelAccessRule(ctx context.Context, tunnelID int64) (TunnelAccessRuleRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT id, tunnel_id, auth_mode, basic_auth_username, basic_auth_password_hash, shared_secret_header_name, shared_secret_hash, allowed_ips_json, oidc_email_domains_json, oidc_emails_json, oidc_groups_json, jwt_issuer, jwt_audience, jwt_required_claims_json, share_link_key, created_at, updated_at
+FROM tunnel_access_rules
+WHERE tunnel_id = ?`, tunnelID)
+	var rec TunnelAccessRuleRecord
+	var allowedIPsJSON, emailDomainsJSON, emailsJSON, groupsJSON, claimsJSON, created, updated string
+	if err := row.Scan(&rec.ID, &rec.TunnelID, &rec.AuthMode, &rec.BasicAuthUsername, &rec.BasicAuthPasswordHash, &rec.SharedSecretHeaderName, &rec.SharedSecretHash, &allowedIPsJSON, &emailDomainsJSON, &emailsJSON, &groupsJSON, &rec.JWTIssuer, &rec.JWTAudience, &claimsJSON, &rec.ShareLinkKey, &created, &updated); err != nil {
+		return TunnelAccessRuleRecord{}, err
+	}
+	rec.AllowedIPs = parseJSONStrings(allowedIPsJSON)
+	rec.OIDCEmailDomains = parseJSONStrings(emailDomainsJSON)
+	rec.OIDCEmails = parseJSONStrings(emailsJSON)
+	rec.OIDCGroups = parseJSONStrings(groupsJSON)
+	rec.JWTRequiredClaims = parseJSONStringMap(claimsJSON)
+	rec.CreatedAt = parseRFC3339(created)
+	rec.UpdatedAt = parseRFC3339(updated)
+	return rec, nil
+}
+
+// ShareLinkKey returns the tunnel's share link signing key, generating one on first use.
+func (s *Store) ShareLinkKey(ctx context.Context, tunnelID int64) (string, error) {
+	rule, err := s.GetTunnelAccessRule(ctx, tunnelID)
+	if err != nil {
+		return "", err
+	}
+	if rule.ShareLinkKey != "" {
+		return rule.ShareLinkKey, nil
+	}
+	return s.RotateShareLinkKey(ctx, tunnelID)
+}
+
+// RotateShareLinkKey replaces the tunnel's share link signing key, invalidating every link and
+// cookie signed with the previous one.
+func (s *Store) RotateShareLinkKey(ctx context.Context, tunnelID int64) (string, error) {
+	key, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	res, err := s.exec(ctx, `UPDATE tunnel_access_rules SET share_link_key = ?, updated_at = ? WHERE tunnel_id = ?`, key, time.Now().UTC().Format(time.RFC3339Nano), tunnelID)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (s *Store) SetErrorPage(ctx context.Context, tunnelID int64, status int, tpl string) error {
+	_, err := s.exec(ctx, `
+INSERT INTO error_pages (tunnel_id, status, template, updated_at) VALUES (?, ?, ?, ?)
+ON CONFLICT(tunnel_id, status) DO UPDATE SET template=excluded.template, updated_at=excluded.updated_at`,
+		tunnelID, status, tpl, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *Store) DeleteErrorPage(ctx context.Context, tunnelID int64, status int) error {
+	res, err := s.exec(ctx, `DELETE FROM error_pages WHERE tunnel_id = ? AND status = ?`, tunnelID, status)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) ListErrorPages(ctx context.Context, tunnelID int64) ([]ErrorPageRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tunnel_id, status, template, updated_at FROM error_pages WHERE tunnel_id = ? ORDER BY status ASC`, tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ErrorPageRecord
+	for rows.Next() {
+		var rec ErrorPageRecord
+		var updated string
+		if err := rows.Scan(&rec.TunnelID, &rec.Status, &rec.Template, &updated); err != nil {
+			return nil, err
+		}
+		rec.UpdatedAt = parseRFC3339(updated)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// ResolveErrorPage returns the tunnel's template for status, falling back to the server-wide one.
+func (s *Store) ResolveErrorPage(ctx context.Context, tunnelID int64, status int) (string, error) {
+	var tpl string
+	err := s.db.QueryRowContext(ctx, `
+SELECT template FROM error_pages WHERE status = ? AND tunnel_id IN (?, 0)
+ORDER BY tunnel_id DESC LIMIT 1`, status, tunnelID).Scan(&tpl)
+	return tpl, err
+}
+
+const domainColumns = `d.id, d.domain, d.owner_user_id, COALESCE(u.email, ''), d.description, d.shared, d.approval, d.status, d.token,
+d.created_at, d.updated_at, d.verified_at, d.last_checked_at, d.failing_since, d.last_error`
+
+func scanDomain(row interface{ Scan(...any) error }) (DomainRecord, error) {
+	var rec DomainRecord
+	var shared int
+	var created, updated, verified, checked, failing string
+	if err := row.Scan(&rec.ID, &rec.Domain, &rec.OwnerUserID, &rec.OwnerEmail, &rec.Description, &shared, &rec.Approval, &rec.Status, &rec.Token,
+		&created, &updated, &verified, &checked, &failing, &rec.LastError); err != nil {
+		return DomainRecord{}, err
+	}
+	rec.Shared = shared == 1
+	rec.CreatedAt = parseRFC3339(created)
+	rec.UpdatedAt = parseRFC3339(updated)
+	rec.VerifiedAt = parseRFC3339(verified)
+	rec.LastCheckedAt = parseRFC3339(checked)
+	rec.FailingSince = parseRFC3339(failing)
+	return rec, nil
+}
+
+// CreateDomain inserts a domain. Status, verification times and LastError are taken from rec so
+// legacy imports can keep their state.
+func (s *Store) CreateDomain(ctx context.Context, rec DomainRecord) (DomainRecord, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	res, err := s.exec(ctx, `
+INSERT INTO domains (domain, owner_user_id, description, shared, approval, status, token, verified_at, last_checked_at, failing_since, last_error, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Domain, rec.OwnerUserID, rec.Description, boolToInt(rec.Shared), rec.Approval, rec.Status, rec.Token,
+		formatOptionalTime(rec.VerifiedAt), formatOptionalTime(rec.LastCheckedAt), formatOptionalTime(rec.FailingSince), rec.LastError, now, now)
+	if err != nil {
+		return DomainRecord{}, err
+	}
+	id, _ := res.LastInsertId()
+	return s.getDomain(ctx, "d.id = ?", id)
+}
+
+func (s *Store) GetDomain(ctx context.Context, domain string) (DomainRecord, error) {
+	return s.getDomain(ctx, "d.domain = ?", domain)
+}
+
+func (s *Store) getDomain(ctx context.Context, where string, arg any) (DomainRecord, error) {
+	return scanDomain(s.db.QueryRowContext(ctx, `SELECT `+domainColumns+`
+FROM domains d LEFT JOIN users u ON u.id = d.owner_user_id WHERE `+where, arg))
+}
+
+// ListDomains returns every domain, or only those a member owns or that are shared.
+func (s *Store) ListDomains(ctx context.Context, userID int64, isAdmin bool) ([]DomainRecord, error) {
+	query := `SELECT ` + domainColumns + ` FROM domains d LEFT JOIN users u ON u.id = d.owner_user_id`
+	var args []any
+	if !isAdmin {
+		query += ` WHERE d.owner_user_id = ? OR d.shared = 1`
+		args = append(args, userID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY d.domain ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DomainRecord
+	for rows.Next() {
+		rec, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// UpdateDomainVerification stores the result of an ownership check.
+func (s *Store) UpdateDomainVerification(ctx context.Context, rec DomainRecord) error {
+	_, err := s.exec(ctx, `
+UPDATE domains SET status = ?, verified_at = ?, last_checked_at = ?, failing_since = ?, last_error = ?, updated_at = ?
+WHERE id = ?`,
+		rec.Status, formatOptionalTime(rec.VerifiedAt), formatOptionalTime(rec.LastCheckedAt), formatOptionalTime(rec.FailingSince),
+		rec.LastError, time.Now().UTC().Format(time.RFC3339Nano), rec.ID)
+	return err
+}
+
+func (s *Store) SetDomainApproval(ctx context.Context, domain, approval string) error {
+	return s.updateDomain(ctx, domain, `approval = ?`, approval)
+}
+
+func (s *Store) UpdateDomainDetails(ctx context.Context, domain, description string, shared bool) error {
+	return s.updateDomain(ctx, domain, `description = ?, shared = ?`, description, boolToInt(shared))
+}
+
+func (s *Store) updateDomain(ctx context.Context, domain, set string, args ...any) error {
+	args = append(args, time.Now().UTC().Format(time.RFC3339Nano), domain)
+	res, err := s.exec(ctx, `UPDATE domains SET `+set+`, updated_at = ? WHERE domain = ?`, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) DeleteDomain(ctx context.Context, domain string) error {
+	res, err := s.exec(ctx, `DELETE FROM domains WHERE domain = ?`, domain)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) AddDomainEvent(ctx context.Context, domain string, actorUserID int64, eventType, message string) error {
+	_, err := s.exec(ctx, `
+INSERT INTO domain_events (domain, actor_user_id, event_type, message, created_at)
+VALUES (?, ?, ?, ?, ?)`, domain, actorUserID, eventType, message, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *Store) ListDomainEvents(ctx context.Context, domain string, limit int) ([]DomainEventRecord, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, domain, actor_user_id, event_type, message, created_at
+FROM domain_events WHERE domain = ? ORDER BY id DESC LIMIT ?`, domain, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DomainEventRecord
+	for rows.Next() {
+		var rec DomainEventRecord
+		var created string
+		if err := rows.Scan(&rec.ID, &rec.Domain, &rec.ActorUserID, &rec.EventType, &rec.Message, &created); err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = parseRFC3339(created)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// AddTLSCertificate stores an uploaded certificate. Callers validate the PEM pair first.
+func (s *Store) AddTLSCertificate(ctx context.Context, rec TLSCertificateRecord) (TLSCertificateRecord, error) {
+	names, err := json.Marshal(rec.Names)
+	if err != nil {
+		return TLSCertificateRecord{}, err
+	}
+	rec.CreatedAt = time.Now().UTC()
+	res, err := s.exec(ctx, `
+INSERT INTO tls_certificates (names_json, issuer, not_before, not_after, cert_pem, key_pem, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		string(names), rec.Issuer, rec.NotBefore.UTC().Format(time.RFC3339Nano), rec.NotAfter.UTC().Format(time.RFC3339Nano),
+		rec.CertPEM, rec.KeyPEM, rec.CreatedAt.Format(time.RFC3339Nano))
+	if err != nil {
+		return TLSCertificateRecord{}, err
+	}
+	rec.ID, _ = res.LastInsertId()
+	s.certVersion.Add(1)
+	return rec, nil
+}
+
+// ListTLSCertificates returns all uploaded certificates, soonest expiry first.
+func (s *Store) ListTLSCertificates(ctx context.Context) ([]TLSCertificateRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, names_json, issuer, not_before, not_after, cert_pem, key_pem, created_at
+FROM tls_certificates ORDER BY not_after ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TLSCertificateRecord
+	for rows.Next() {
+		var rec TLSCertificateRecord
+		var names, notBefore, notAfter, created string
+		if err := rows.Scan(&rec.ID, &names, &rec.Issuer, &notBefore, &notAfter, &rec.CertPEM, &rec.KeyPEM, &created); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(names), &rec.Names)
+		rec.NotBefore = parseRFC3339(notBefore)
+		rec.NotAfter = parseRFC3339(notAfter)
+		rec.CreatedAt = parseRFC3339(created)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) DeleteTLSCertificate(ctx context.Context, id int64) error {
+	res, err := s.exec(ctx, `DELETE FROM tls_certificates WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	s.certVersion.Add(1)
+	return nil
+}
+
+// TLSCertificatesVersion changes whenever a certificate is added or removed.
+func (s *Store) TLSCertificatesVersion() uint64 {
+	return s.certVersion.Load()
+}
+
+// hostnameClaim is a hostname held by a user through a tunnel or a reservation.
+type hostnameClaim struct {
+	Hostname    string
+	OwnerUserID int64
+}
+
+// ListHostnameClaims returns every tunnel hostname and reserved hostname with its owner.
+func (s *Store) ListHostnameClaims(ctx context.Context) ([]hostnameClaim, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT hostname, owner_user_id FROM tunnels
+UNION ALL
+SELECT hostname, owner_user_id FROM hostname_reservations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []hostnameClaim
+	for rows.Next() {
+		var c hostnameClaim
+		if err := rows.Scan(&c.Hostname, &c.OwnerUserID); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CreateHostnameReservation(ctx context.Context, ownerUserID int64, hostname, note string) (HostnameReservationRecord, error) {
+	now := time.Now().UTC()
+	res, err := s.exec(ctx, `
+INSERT INTO hostname_reservations (hostname, owner_user_id, note, created_at)
+VALUES (?, ?, ?, ?)`, hostname, ownerUserID, note, now.Format(time.RFC3339Nano))
+	if err != nil {
+		return HostnameReservationRecord{}, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetHostnameReservation(ctx, id)
+}
+
+func (s *Store) GetHostnameReservation(ctx context.Context, id int64) (HostnameReservationRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT r.id, r.hostname, r.owner_user_id, COALESCE(u.email, ''), r.note, r.created_at
+FROM hostname_reservations r
+LEFT JOIN users u ON u.id = r.owner_user_id
+WHERE r.id = ?`, id)
+	var rec HostnameReservationRecord
+	var created string
+	if err := row.Scan(&rec.ID, &rec.Hostname, &rec.OwnerUserID, &rec.OwnerEmail, &rec.Note, &created); err != nil {
+		return HostnameReservationRecord{}, err
+	}
+	rec.CreatedAt = parseRFC3339(created)
+	return rec, nil
+}
+
+// ListHostnameReservations returns the user's reservations, or every reservation for admins.
+func (s *Store) ListHostnameReservations(ctx context.Context, userID int64, isAdmin bool) ([]HostnameReservationRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT r.id, r.hostname, r.owner_user_id, COALESCE(u.email, ''), r.note, r.created_at
+FROM hostname_reservations r
+LEFT JOIN users u ON u.id = r.owner_user_id
+WHERE ? OR r.owner_user_id = ?
+ORDER BY r.hostname`, boolToInt(isAdmin), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []HostnameReservationRecord
+	for rows.Next() {
+		var rec HostnameReservationRecord
+		var created string
+		if err := rows.Scan(&rec.ID, &rec.Hostname, &rec.OwnerUserID, &rec.OwnerEmail, &rec.Note, &created); err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = parseRFC3339(created)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) DeleteHostnameReservation(ctx context.Context, id int64) error {
+	res, err := s.exec(ctx, `DELETE FROM hostname_reservations WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetHostnamePrefix returns the user's required subdomain prefix, or "" when none is set.
+func (s *Store) GetHostnamePrefix(ctx context.Context, userID int64) (string, error) {
+	var prefix string
+	err := s.db.QueryRowContext(ctx, `SELECT prefix FROM hostname_prefixes WHERE user_id = ?`, userID).Scan(&prefix)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return prefix, err
+}
+
+// SetHostnamePrefix sets the required subdomain prefix for the user with email. An empty prefix
+// removes the requirement. Unknown users return sql.ErrNoRows.
+func (s *Store) SetHostnamePrefix(ctx context.Context, email, prefix string) (HostnamePrefixRecord, error) {
+	var userID int64
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE lower(email) = lower(?)`, email).Scan(&userID); err != nil {
+		return HostnamePrefixRecord{}, err
 	}
-	if !rec.ExpiresAt.IsZero() && time.Now().After(rec.ExpiresAt) {
-		return LoginStateRecord{}, sql.ErrNoRows
+	now := time.Now().UTC()
+	var err error
+	if prefix == "" {
+		_, err = s.exec(ctx, `DELETE FROM hostname_prefixes WHERE user_id = ?`, userID)
+	} else {
+		_, err = s.exec(ctx, `
+INSERT INTO hostname_prefixes (user_id, prefix, updated_at) VALUES (?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET prefix = excluded.prefix, updated_at = excluded.updated_at`,
+			userID, prefix, now.Format(time.RFC3339Nano))
 	}
-	return rec, nil
+	if err != nil {
+		return HostnamePrefixRecord{}, err
+	}
+	return HostnamePrefixRecord{UserID: userID, Email: email, Prefix: prefix, UpdatedAt: now}, nil
 }
 
-func (s *Store) AddTunnelEvent(ctx context.Context, hostname, eventType, message string) error {
-	tunnelID, err := s.tunnelIDByHostname(ctx, hostname)
-	if err != nil || tunnelID == 0 {
-		return err
+func (s *Store) ListHostnamePrefixes(ctx context.Context) ([]HostnamePrefixRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT p.user_id, COALESCE(u.email, ''), p.prefix, p.updated_at
+FROM hostname_prefixes p
+LEFT JOIN users u ON u.id = p.user_id
+ORDER BY u.email`)
+	if err != nil {
+		return nil, err
 	}
-	_, err = s.db.ExecContext(ctx, `
-INSERT INTO tunnel_events (tunnel_id, event_type, message, created_at)
-VALUES (?, ?, ?, ?)`, tunnelID, eventType, message, time.Now().UTC().Format(time.RFC3339Nano))
-	return err
+	defer rows.Close()
+	var out []HostnamePrefixRecord
+	for rows.Next() {
+		var rec HostnamePrefixRecord
+		var updated string
+		if err := rows.Scan(&rec.UserID, &rec.Email, &rec.Prefix, &updated); err != nil {
+			return nil, err
+		}
+		rec.UpdatedAt = parseRFC3339(updated)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
 }
 
-func (s *Store) ListTunnelEventsByTunnel(ctx context.Context, tunnelID int64, limit int) ([]TunnelEventRecord, error) {
-	if limit <= 0 || limit > 500 {
-		limit = 100
+func (s *Store) CreateTunnelCredential(ctx context.Context, tunnelID int64, name, kind, username, secretHash string, expiresAt time.Time) (TunnelCredentialRecord, error) {
+	name = normalizeName(name)
+	if name == "" {
+		return TunnelCredentialRecord{}, fmt.Errorf("credential name required")
+	}
+	switch kind {
+	case "basic_auth":
+		username = strings.TrimSpace(username)
+		if username == "" {
+			username = name
+		}
+	case "shared_secret":
+		username = ""
+	default:
+		return TunnelCredentialRecord{}, fmt.Errorf("credential kind must be basic_auth or shared_secret")
+	}
+	if secretHash == "" {
+		return TunnelCredentialRecord{}, fmt.Errorf("credential secret required")
+	}
+	exp := ""
+	if !expiresAt.IsZero() {
+		exp = expiresAt.UTC().Format(time.RFC3339Nano)
 	}
+	_, err := s.exec(ctx, `
+INSERT INTO tunnel_credentials (tunnel_id, name, kind, username, secret_hash, expires_at, last_used_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?, '', ?)`, tunnelID, name, kind, username, secretHash, exp, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return TunnelCredentialRecord{}, fmt.Errorf("credential %s already exists", name)
+		}
+		return TunnelCredentialRecord{}, err
+	}
+	row := s.db.QueryRowContext(ctx, `
+SELECT id, tunnel_id, name, kind, username, secret_hash, expires_at, last_used_at, created_at
+FROM tunnel_credentials WHERE tunnel_id = ? AND name = ?`, tunnelID, name)
+	return scanTunnelCredentialRecord(row)
+}
+
+func (s *Store) ListTunnelCredentials(ctx context.Context, tunnelID int64) ([]TunnelCredentialRecord, error) {
 	rows, err := s.db.QueryContext(ctx, `
-SELECT id, tunnel_id, event_type, message, created_at
-FROM tunnel_events
-WHERE tunnel_id = ?
-ORDER BY created_at DESC
-LIMIT ?`, tunnelID, limit)
+SELECT id, tunnel_id, name, kind, username, secret_hash, expires_at, last_used_at, created_at
+FROM tunnel_credentials WHERE tunnel_id = ? ORDER BY name ASC`, tunnelID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var out []TunnelEventRecord
+	var out []TunnelCredentialRecord
 	for rows.Next() {
-		var rec TunnelEventRecord
-		var created string
-		if err := rows.Scan(&rec.ID, &rec.TunnelID, &rec.EventType, &rec.Message, &created); err != nil {
+		rec, err := scanTunnelCredentialRecord(rows)
+		if err != nil {
 			return nil, err
 		}
-		rec.CreatedAt = parseRFC3339(created)
 		out = append(out, rec)
 	}
 	return out, rows.Err()
 }
 
-func (s *Store) GetTunnelByHostname(ctx context.Context, hostname string) (TunnelRecord, error) {
-	row := s.db.QueryRowContext(ctx, `
-SELECT t.id, t.name, t.hostname, t.local_target_hint, t.owner_user_id, COALESCE(u.email, ''), t.assigned_agent_id, COALESCE(a.name, ''), t.desired_state, t.actual_state, t.last_error, t.last_seen_at, t.created_at, t.updated_at
-FROM tunnels t
-LEFT JOIN users u ON u.id = t.owner_user_id
-LEFT JOIN agents a ON a.id = t.assigned_agent_id
-WHERE t.hostname = ?`, hostname)
-	var rec TunnelRecord
-	var lastSeen, created, updated string
-	if err := row.Scan(&rec.ID, &rec.Name, &rec.Hostname, &rec.LocalHint, &rec.OwnerUserID, &rec.OwnerEmail, &rec.AssignedAgentID, &rec.AssignedAgent, &rec.DesiredState, &rec.ActualState, &rec.LastError, &lastSeen, &created, &updated); err != nil {
-		return TunnelRecord{}, err
+func (s *Store) DeleteTunnelCredential(ctx context.Context, tunnelID int64, name string) error {
+	res, err := s.exec(ctx, `DELETE FROM tunnel_credentials WHERE tunnel_id = ? AND name = ?`, tunnelID, normalizeName(name))
+	if err != nil {
+		return err
 	}
-	rec.LastSeenAt = parseRFC3339(lastSeen)
-	rec.CreatedAt = parseRFC3339(created)
-	rec.UpdatedAt = parseRFC3339(updated)
-	return rec, nil
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (s *Store) GetTunnelAccessRule(ctx context.Context, tunnelID int64) (TunnelAccessRuleRecord, error) {
-	row := s.db.QueryRowContext(ctx, `
-SELECT id, tunnel_id, auth_mode, basic_auth_username, basic_auth_password_hash, shared_secret_header_name, shared_secret_hash, allowed_ips_json, created_at, updated_at
-FROM tunnel_access_rules
-WHERE tunnel_id = ?`, tunnelID)
-	var rec TunnelAccessRuleRecord
-	var allowedIPsJSON, created, updated string
-	if err := row.Scan(&rec.ID, &rec.TunnelID, &rec.AuthMode, &rec.BasicAuthUsername, &rec.BasicAuthPasswordHash, &rec.SharedSecretHeaderName, &rec.SharedSecretHash, &allowedIPsJSON, &created, &updated); err != nil {
-		return TunnelAccessRuleRecord{}, err
+// credentialLastUsedInterval is how stale a credential's last_used_at may get before a match
+// writes it again, so busy credentials don't put a write on every request.
+const credentialLastUsedInterval = time.Minute
+
+// MatchTunnelCredential finds an unexpired credential of kind whose secret hashes to secretHash
+// and records the use. username is only compared for basic_auth credentials.
+func (s *Store) MatchTunnelCredential(ctx context.Context, tunnelID int64, kind, username, secretHash string) (TunnelCredentialRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, tunnel_id, name, kind, username, secret_hash, expires_at, last_used_at, created_at
+FROM tunnel_credentials WHERE tunnel_id = ? AND kind = ? AND secret_hash = ?`, tunnelID, kind, secretHash)
+	if err != nil {
+		return TunnelCredentialRecord{}, err
 	}
-	rec.AllowedIPs = parseJSONStrings(allowedIPsJSON)
+	var match *TunnelCredentialRecord
+	now := time.Now()
+	for rows.Next() {
+		rec, err := scanTunnelCredentialRecord(rows)
+		if err != nil {
+			rows.Close()
+			return TunnelCredentialRecord{}, err
+		}
+		if !rec.ExpiresAt.IsZero() && now.After(rec.ExpiresAt) {
+			continue
+		}
+		if kind == "basic_auth" && rec.Username != strings.TrimSpace(username) {
+			continue
+		}
+		match = &rec
+		break
+	}
+	rows.Close()
+	if match == nil {
+		return TunnelCredentialRecord{}, sql.ErrNoRows
+	}
+	if now.Sub(match.LastUsedAt) >= credentialLastUsedInterval {
+		if _, err := s.exec(ctx, `UPDATE tunnel_credentials SET last_used_at = ? WHERE id = ?`, now.UTC().Format(time.RFC3339Nano), match.ID); err != nil {
+			slog.Warn("record credential use failed", "credential", match.Name, "error", err)
+		}
+	}
+	match.LastUsedAt = now
+	return *match, nil
+}
+
+func scanTunnelCredentialRecord(row rowScanner) (TunnelCredentialRecord, error) {
+	var rec TunnelCredentialRecord
+	var expires, lastUsed, created string
+	if err := row.Scan(&rec.ID, &rec.TunnelID, &rec.Name, &rec.Kind, &rec.Username, &rec.SecretHash, &expires, &lastUsed, &created); err != nil {
+		return TunnelCredentialRecord{}, err
+	}
+	rec.ExpiresAt = parseRFC3339(expires)
+	rec.LastUsedAt = parseRFC3339(lastUsed)
 	rec.CreatedAt = parseRFC3339(created)
-	rec.UpdatedAt = parseRFC3339(updated)
 	return rec, nil
 }
 
@@ -754,7 +2243,7 @@ func (s *Store) AccessRuleByTunnelName(ctx context.Context, name string) (Tunnel
 
 func (s *Store) CreateAgent(ctx context.Context, ownerUserID int64, name, credentialHash string) (AgentRecord, error) {
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	_, err := s.db.ExecContext(ctx, `
+	_, err := s.exec(ctx, `
 INSERT INTO agents (name, credential_hash, owner_user_id, status, last_seen_at, created_at, revoked_at, metadata_json)
 VALUES (?, ?, ?, 'authorized', '', ?, '', '{}')`, name, credentialHash, ownerUserID, now)
 	if err != nil {
@@ -804,12 +2293,12 @@ FROM agents`
 }
 
 func (s *Store) RevokeAgentByName(ctx context.Context, name string) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE agents SET status = 'revoked', revoked_at = ?, last_seen_at = ? WHERE name = ?`, time.Now().UTC().Format(time.RFC3339Nano), "", name)
+	_, err := s.exec(ctx, `UPDATE agents SET status = 'revoked', revoked_at = ?, last_seen_at = ? WHERE name = ?`, time.Now().UTC().Format(time.RFC3339Nano), "", name)
 	return err
 }
 
 func (s *Store) TouchAgent(ctx context.Context, agentID int64, status string) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE agents SET status = ?, last_seen_at = ? WHERE id = ?`, status, time.Now().UTC().Format(time.RFC3339Nano), agentID)
+	_, err := s.exec(ctx, `UPDATE agents SET status = ?, last_seen_at = ? WHERE id = ?`, status, time.Now().UTC().Format(time.RFC3339Nano), agentID)
 	return err
 }
 
@@ -824,9 +2313,9 @@ func (s *Store) tunnelIDByHostname(ctx context.Context, hostname string) (int64,
 
 func (s *Store) enforceLogRetention(ctx context.Context, tunnelID int64) error {
 	cutoff := time.Now().Add(-defaultRequestLogTTL).UTC().Format(time.RFC3339Nano)
-	_, _ = s.db.ExecContext(ctx, `DELETE FROM request_logs WHERE tunnel_id = ? AND timestamp < ?`, tunnelID, cutoff)
+	_, _ = s.exec(ctx, `DELETE FROM request_logs WHERE tunnel_id = ? AND timestamp < ?`, tunnelID, cutoff)
 
-	_, err := s.db.ExecContext(ctx, `
+	_, err := s.exec(ctx, `
 DELETE FROM request_logs
 WHERE tunnel_id = ?
   AND id NOT IN (
@@ -836,6 +2325,13 @@ WHERE tunnel_id = ?
 	return err
 }
 
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
 func parseRFC3339(s string) time.Time {
 	if s == "" {
 		return time.Time{}
@@ -853,10 +2349,38 @@ func parseJSONStrings(s string) []string {
 	return out
 }
 
+func parseJSONStringMap(s string) map[string]string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var out map[string]string
+	_ = json.Unmarshal([]byte(s), &out)
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func jsonStringMap(m map[string]string) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
 func jsonMarshal(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
 
+func jsonStrings(list []string) string {
+	if len(list) == 0 {
+		return "[]"
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
 type rowScanner interface {
 	Scan(dest ...any) error
 }
@@ -905,19 +2429,14 @@ func upsertTunnelAccessRuleTx(ctx context.Context, db execContexter, tunnelID in
 			return err
 		}
 	}
-	mode, username, passwordHash, headerName, secretHash, allowed, err := validateAccessRuleInput(input, existing)
+	rule, err := validateAccessRuleInput(input, existing)
 	if err != nil {
 		return err
 	}
-	allowedJSON := "[]"
-	if len(allowed) > 0 {
-		data, _ := json.Marshal(allowed)
-		allowedJSON = string(data)
-	}
 	now := time.Now().UTC().Format(time.RFC3339Nano)
 	_, err = db.ExecContext(ctx, `
-INSERT INTO tunnel_access_rules (tunnel_id, auth_mode, basic_auth_username, basic_auth_password_hash, shared_secret_header_name, shared_secret_hash, allowed_ips_json, created_at, updated_at)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+INSERT INTO tunnel_access_rules (tunnel_id, auth_mode, basic_auth_username, basic_auth_password_hash, shared_secret_header_name, shared_secret_hash, allowed_ips_json, oidc_email_domains_json, oidc_emails_json, oidc_groups_json, jwt_issuer, jwt_audience, jwt_required_claims_json, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(tunnel_id) DO UPDATE SET
   auth_mode=excluded.auth_mode,
   basic_auth_username=excluded.basic_auth_username,
@@ -925,13 +2444,24 @@ ON CONFLICT(tunnel_id) DO UPDATE SET
   shared_secret_header_name=excluded.shared_secret_header_name,
   shared_secret_hash=excluded.shared_secret_hash,
   allowed_ips_json=excluded.allowed_ips_json,
+  oidc_email_domains_json=excluded.oidc_email_domains_json,
+  oidc_emails_json=excluded.oidc_emails_json,
+  oidc_groups_json=excluded.oidc_groups_json,
+  jwt_issuer=excluded.jwt_issuer,
+  jwt_audience=excluded.jwt_audience,
+  jwt_required_claims_json=excluded.jwt_required_claims_json,
   updated_at=excluded.updated_at
-`, tunnelID, mode, username, passwordHash, headerName, secretHash, allowedJSON, now, now)
+`, tunnelID, rule.AuthMode, rule.BasicAuthUsername, rule.BasicAuthPasswordHash, rule.SharedSecretHeaderName, rule.SharedSecretHash,
+		jsonStrings(rule.AllowedIPs), jsonStrings(rule.OIDCEmailDomains), jsonStrings(rule.OIDCEmails), jsonStrings(rule.OIDCGroups),
+		rule.JWTIssuer, rule.JWTAudience, jsonStringMap(rule.JWTRequiredClaims), now, now)
 	return err
 }
 
-func validateAccessRuleInput(input AccessRuleInput, existing *TunnelAccessRuleRecord) (mode, username, passwordHash, headerName, secretHash string, allowed []string, err error) {
-	mode = strings.TrimSpace(strings.ToLower(input.AuthMode))
+// validateAccessRuleInput normalizes input into the rule that should be stored. The primary basic
+// auth password and shared secret are optional: a rule without one accepts only named credentials.
+// Secrets left blank in input are carried over from existing.
+func validateAccessRuleInput(input AccessRuleInput, existing *TunnelAccessRuleRecord) (TunnelAccessRuleRecord, error) {
+	mode := strings.TrimSpace(strings.ToLower(input.AuthMode))
 	if mode == "" {
 		if existing != nil && existing.AuthMode != "" {
 			mode = existing.AuthMode
@@ -940,60 +2470,105 @@ func validateAccessRuleInput(input AccessRuleInput, existing *TunnelAccessRuleRe
 		}
 	}
 	switch mode {
-	case "public", "basic_auth", "shared_secret_header":
+	case "public", "basic_auth", "shared_secret_header", "oidc", "jwt", "signed_link":
 	default:
-		return "", "", "", "", "", nil, fmt.Errorf("invalid auth mode")
+		return TunnelAccessRuleRecord{}, fmt.Errorf("invalid auth mode")
 	}
-	allowed = make([]string, 0, len(input.AllowedIPs))
+	allowed := make([]string, 0, len(input.AllowedIPs))
 	for _, cidr := range input.AllowedIPs {
 		cidr = strings.TrimSpace(cidr)
 		if cidr == "" {
 			continue
 		}
 		if _, err := netip.ParsePrefix(cidr); err != nil {
-			return "", "", "", "", "", nil, fmt.Errorf("invalid cidr: %s", cidr)
+			return TunnelAccessRuleRecord{}, fmt.Errorf("invalid cidr: %s", cidr)
 		}
 		allowed = append(allowed, cidr)
 	}
+	rule := TunnelAccessRuleRecord{AuthMode: mode, AllowedIPs: allowed}
 	switch mode {
-	case "public":
-		return mode, "", "", "", "", allowed, nil
+	case "public", "signed_link":
+		return rule, nil
 	case "basic_auth":
-		username = strings.TrimSpace(input.BasicAuthUsername)
-		if username == "" && existing != nil {
-			username = existing.BasicAuthUsername
-		}
-		if username == "" {
-			return "", "", "", "", "", nil, fmt.Errorf("basic auth username required")
+		rule.BasicAuthUsername = strings.TrimSpace(input.BasicAuthUsername)
+		if rule.BasicAuthUsername == "" && existing != nil {
+			rule.BasicAuthUsername = existing.BasicAuthUsername
 		}
 		if strings.TrimSpace(input.BasicAuthPassword) != "" {
-			passwordHash = hashSecret(input.BasicAuthPassword)
-		} else if existing != nil && existing.BasicAuthPasswordHash != "" {
-			passwordHash = existing.BasicAuthPasswordHash
+			rule.BasicAuthPasswordHash = hashSecret(input.BasicAuthPassword)
+		} else if existing != nil && existing.BasicAuthPasswordHash != "" && !input.ClearPrimarySecret {
+			rule.BasicAuthPasswordHash = existing.BasicAuthPasswordHash
 		}
-		if passwordHash == "" {
-			return "", "", "", "", "", nil, fmt.Errorf("basic auth password required")
+		if rule.BasicAuthPasswordHash != "" && rule.BasicAuthUsername == "" {
+			return TunnelAccessRuleRecord{}, fmt.Errorf("basic auth username required")
 		}
-		return mode, username, passwordHash, "", "", allowed, nil
+		return rule, nil
 	case "shared_secret_header":
-		headerName = httpCanonicalHeaderKey(strings.TrimSpace(input.SharedSecretHeaderName))
-		if headerName == "" && existing != nil {
-			headerName = existing.SharedSecretHeaderName
+		rule.SharedSecretHeaderName = httpCanonicalHeaderKey(strings.TrimSpace(input.SharedSecretHeaderName))
+		if rule.SharedSecretHeaderName == "" && existing != nil {
+			rule.SharedSecretHeaderName = existing.SharedSecretHeaderName
 		}
-		if headerName == "" {
-			return "", "", "", "", "", nil, fmt.Errorf("shared secret header name required")
+		if rule.SharedSecretHeaderName == "" {
+			return TunnelAccessRuleRecord{}, fmt.Errorf("shared secret header name required")
 		}
 		if strings.TrimSpace(input.SharedSecretValue) != "" {
-			secretHash = hashSecret(input.SharedSecretValue)
-		} else if existing != nil && existing.SharedSecretHash != "" {
-			secretHash = existing.SharedSecretHash
+			rule.SharedSecretHash = hashSecret(input.SharedSecretValue)
+		} else if existing != nil && existing.SharedSecretHash != "" && !input.ClearPrimarySecret {
+			rule.SharedSecretHash = existing.SharedSecretHash
+		}
+		return rule, nil
+	case "oidc":
+		for _, d := range input.OIDCEmailDomains {
+			d = strings.TrimPrefix(strings.TrimSpace(strings.ToLower(d)), "@")
+			if d == "" {
+				continue
+			}
+			if strings.ContainsAny(d, "@ /") {
+				return TunnelAccessRuleRecord{}, fmt.Errorf("invalid email domain: %s", d)
+			}
+			rule.OIDCEmailDomains = append(rule.OIDCEmailDomains, d)
+		}
+		for _, e := range input.OIDCEmails {
+			e = strings.TrimSpace(strings.ToLower(e))
+			if e == "" {
+				continue
+			}
+			if !strings.Contains(e, "@") {
+				return TunnelAccessRuleRecord{}, fmt.Errorf("invalid email: %s", e)
+			}
+			rule.OIDCEmails = append(rule.OIDCEmails, e)
+		}
+		for _, g := range input.OIDCGroups {
+			if g = strings.TrimSpace(g); g != "" {
+				rule.OIDCGroups = append(rule.OIDCGroups, g)
+			}
 		}
-		if secretHash == "" {
-			return "", "", "", "", "", nil, fmt.Errorf("shared secret value required")
+		return rule, nil
+	case "jwt":
+		rule.JWTIssuer = strings.TrimSpace(input.JWTIssuer)
+		if rule.JWTIssuer != "" {
+			u, err := url.Parse(rule.JWTIssuer)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return TunnelAccessRuleRecord{}, fmt.Errorf("invalid jwt issuer")
+			}
 		}
-		return mode, "", "", headerName, secretHash, allowed, nil
+		rule.JWTAudience = strings.TrimSpace(input.JWTAudience)
+		if rule.JWTAudience == "" {
+			return TunnelAccessRuleRecord{}, fmt.Errorf("jwt audience required")
+		}
+		for name, value := range input.JWTRequiredClaims {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return TunnelAccessRuleRecord{}, fmt.Errorf("jwt claim name required")
+			}
+			if rule.JWTRequiredClaims == nil {
+				rule.JWTRequiredClaims = map[string]string{}
+			}
+			rule.JWTRequiredClaims[name] = strings.TrimSpace(value)
+		}
+		return rule, nil
 	}
-	return "", "", "", "", "", nil, fmt.Errorf("invalid auth mode")
+	return TunnelAccessRuleRecord{}, fmt.Errorf("invalid auth mode")
 }
 
 func hashSecret(raw string) string {
@@ -1025,3 +2600,310 @@ func httpCanonicalHeaderKey(v string) string {
 	}
 	return strings.Join(parts, "-")
 }
+
+// ListQuotas returns every quota definition.
+func (s *Store) ListQuotas(ctx context.Context) ([]QuotaRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, scope, subject, max_tunnels, max_agents, max_connections, monthly_transfer_bytes, updated_at
+FROM quotas ORDER BY scope, subject`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []QuotaRecord
+	for rows.Next() {
+		var rec QuotaRecord
+		var updated string
+		if err := rows.Scan(&rec.ID, &rec.Scope, &rec.Subject, &rec.MaxTunnels, &rec.MaxAgents, &rec.MaxConnections, &rec.MonthlyTransferBytes, &updated); err != nil {
+			return nil, err
+		}
+		rec.UpdatedAt = parseRFC3339(updated)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// UpsertQuota creates or replaces the quota for rec.Scope and rec.Subject.
+func (s *Store) UpsertQuota(ctx context.Context, rec QuotaRecord) (QuotaRecord, error) {
+	rec.UpdatedAt = time.Now().UTC()
+	err := s.db.QueryRowContext(ctx, `
+INSERT INTO quotas (scope, subject, max_tunnels, max_agents, max_connections, monthly_transfer_bytes, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(scope, subject) DO UPDATE SET
+  max_tunnels = excluded.max_tunnels,
+  max_agents = excluded.max_agents,
+  max_connections = excluded.max_connections,
+  monthly_transfer_bytes = excluded.monthly_transfer_bytes,
+  updated_at = excluded.updated_at
+RETURNING id`, rec.Scope, rec.Subject, rec.MaxTunnels, rec.MaxAgents, rec.MaxConnections, rec.MonthlyTransferBytes, rec.UpdatedAt.Format(time.RFC3339Nano)).Scan(&rec.ID)
+	if err != nil {
+		return QuotaRecord{}, err
+	}
+	s.quotaVersion.Add(1)
+	return rec, nil
+}
+
+func (s *Store) DeleteQuota(ctx context.Context, id int64) error {
+	res, err := s.exec(ctx, `DELETE FROM quotas WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	s.quotaVersion.Add(1)
+	return nil
+}
+
+// QuotasVersion changes whenever a quota definition is added, changed or removed.
+func (s *Store) QuotasVersion() uint64 {
+	return s.quotaVersion.Load()
+}
+
+// Quotas returns the quota enforcer shared by the proxy and the API.
+func (s *Store) Quotas() *QuotaManager {
+	return s.quotas
+}
+
+// StatsHistory returns the per-minute stats accumulator fed by the proxy.
+func (s *Store) StatsHistory() *StatsHistory {
+	return s.statsHistory
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int64) (UserRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+SELECT id, oidc_subject, email, display_name, roles, groups_snapshot, created_at, updated_at, last_login_at
+FROM users WHERE id = ?`, id)
+	return scanUserRecord(row)
+}
+
+// CountUserResources returns how many tunnels and active (unrevoked) agents the user owns.
+func (s *Store) CountUserResources(ctx context.Context, userID int64) (tunnels, agents int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+SELECT (SELECT COUNT(*) FROM tunnels WHERE owner_user_id = ?),
+       (SELECT COUNT(*) FROM agents WHERE owner_user_id = ? AND status != 'revoked')`, userID, userID).Scan(&tunnels, &agents)
+	return tunnels, agents, err
+}
+
+const usageUpsertSQL = `
+INSERT INTO usage_daily (day, owner_user_id, tunnel_name, hostname, requests, bytes_in, bytes_out, errors)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(day, owner_user_id, tunnel_name) DO UPDATE SET
+  hostname = excluded.hostname,
+  requests = requests + excluded.requests,
+  bytes_in = bytes_in + excluded.bytes_in,
+  bytes_out = bytes_out + excluded.bytes_out,
+  errors = errors + excluded.errors`
+
+// RecordUsage adds rec to the durable per-day, per-owner, per-tunnel totals. The proxy buffers
+// usage in UsageBuffer instead; FlushUsage writes it.
+func (s *Store) RecordUsage(ctx context.Context, rec UsageRecord) error {
+	_, err := s.exec(ctx, usageUpsertSQL,
+		rec.Day, rec.OwnerUserID, rec.TunnelName, rec.Hostname, rec.Requests, rec.BytesIn, rec.BytesOut, rec.Errors)
+	return err
+}
+
+// UsageBuffer returns the usage accumulator fed by the proxy.
+func (s *Store) UsageBuffer() *UsageBuffer {
+	return s.usage
+}
+
+// FlushUsage writes the usage recorded since the last flush, adding it to the stored daily
+// totals. On failure it stays pending for the next flush.
+func (s *Store) FlushUsage(ctx context.Context) error {
+	b := s.usage
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	recs := b.take()
+	if len(recs) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		for _, rec := range recs {
+			if _, err = tx.ExecContext(ctx, usageUpsertSQL,
+				rec.Day, rec.OwnerUserID, rec.TunnelName, rec.Hostname, rec.Requests, rec.BytesIn, rec.BytesOut, rec.Errors); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		b.putBack(recs)
+	}
+	return err
+}
+
+// ListUsage returns the daily usage rows for month (YYYY-MM), ordered by owner, tunnel and day.
+// Unless all is set only rows owned by ownerUserID are returned.
+func (s *Store) ListUsage(ctx context.Context, month string, ownerUserID int64, all bool) ([]UsageRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT u.day, u.owner_user_id, COALESCE(usr.email, ''), u.tunnel_name, u.hostname, u.requests, u.bytes_in, u.bytes_out, u.errors
+FROM usage_daily u
+LEFT JOIN users usr ON usr.id = u.owner_user_id
+WHERE u.day LIKE ? || '-%' AND (? OR u.owner_user_id = ?)
+ORDER BY COALESCE(usr.email, ''), u.owner_user_id, u.tunnel_name, u.day`, month, all, ownerUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UsageRecord
+	for rows.Next() {
+		var rec UsageRecord
+		if err := rows.Scan(&rec.Day, &rec.OwnerUserID, &rec.OwnerEmail, &rec.TunnelName, &rec.Hostname,
+			&rec.Requests, &rec.BytesIn, &rec.BytesOut, &rec.Errors); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// GetUserTransfer returns the bytes in and out of the user's tunnels during month (YYYY-MM),
+// including usage not flushed yet.
+func (s *Store) GetUserTransfer(ctx context.Context, userID int64, month string) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(bytes_in + bytes_out), 0) FROM usage_daily
+WHERE owner_user_id = ? AND day LIKE ? || '-%'`, userID, month).Scan(&n)
+	return n + s.usage.pendingTransfer(userID, month), err
+}
+
+// FlushStats writes the stats recorded since the last flush, adding them to stored buckets. On
+// failure they stay pending for the next flush.
+func (s *Store) FlushStats(ctx context.Context) error {
+	h := s.statsHistory
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+	buckets := h.take()
+	if len(buckets) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		for _, b := range buckets {
+			if err = mergeStatsBucketTx(ctx, tx, b); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		h.putBack(buckets)
+	}
+	return err
+}
+
+// mergeStatsBucketTx adds b to its stored bucket. Buckets of deleted tunnels are skipped.
+func mergeStatsBucketTx(ctx context.Context, tx *sql.Tx, b StatsBucket) error {
+	step := int64(b.Step / time.Second)
+	out := StatsBucket{TunnelID: b.TunnelID, Start: b.Start, Step: b.Step}
+	out.merge(b)
+	cur, err := scanStatsBucket(tx.QueryRowContext(ctx, `
+SELECT tunnel_id, step, bucket_start, requests, errors, bytes_in, bytes_out, latency_sum_ms, latency_max_ms, latency_hist
+FROM tunnel_stats WHERE tunnel_id = ? AND step = ? AND bucket_start = ?`, b.TunnelID, step, b.Start.Unix()))
+	switch {
+	case err == nil:
+		out.merge(cur)
+	case err != sql.ErrNoRows:
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT OR REPLACE INTO tunnel_stats (tunnel_id, step, bucket_start, requests, errors, bytes_in, bytes_out, latency_sum_ms, latency_max_ms, latency_hist)
+SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+WHERE EXISTS (SELECT 1 FROM tunnels WHERE id = ?)`,
+		out.TunnelID, step, out.Start.Unix(), out.Requests, out.Errors, out.BytesIn, out.BytesOut,
+		out.LatencySumMs, out.LatencyMaxMs, encodeLatencyHist(out.LatencyHist), out.TunnelID)
+	return err
+}
+
+func scanStatsBucket(row interface{ Scan(...any) error }) (StatsBucket, error) {
+	var b StatsBucket
+	var step, start int64
+	var hist string
+	if err := row.Scan(&b.TunnelID, &step, &start, &b.Requests, &b.Errors, &b.BytesIn, &b.BytesOut,
+		&b.LatencySumMs, &b.LatencyMaxMs, &hist); err != nil {
+		return StatsBucket{}, err
+	}
+	b.Step = time.Duration(step) * time.Second
+	b.Start = time.Unix(start, 0).UTC()
+	b.LatencyHist = decodeLatencyHist(hist)
+	return b, nil
+}
+
+// ListStatsBuckets returns the stored minute and hour buckets of a tunnel that start in
+// [from, to), oldest first.
+func (s *Store) ListStatsBuckets(ctx context.Context, tunnelID int64, from, to time.Time) ([]StatsBucket, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT tunnel_id, step, bucket_start, requests, errors, bytes_in, bytes_out, latency_sum_ms, latency_max_ms, latency_hist
+FROM tunnel_stats
+WHERE tunnel_id = ? AND bucket_start >= ? AND bucket_start < ?
+ORDER BY bucket_start, step`, tunnelID, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StatsBucket
+	for rows.Next() {
+		b, err := scanStatsBucket(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// RollupStats merges minute buckets that start before minutesBefore into hourly buckets, then
+// deletes every bucket that starts before retainAfter. minutesBefore should be on the hour so
+// that only complete hours are merged.
+func (s *Store) RollupStats(ctx context.Context, minutesBefore, retainAfter time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `
+SELECT tunnel_id, step, bucket_start, requests, errors, bytes_in, bytes_out, latency_sum_ms, latency_max_ms, latency_hist
+FROM tunnel_stats WHERE step = ? AND bucket_start < ?`, int64(statsMinuteStep/time.Second), minutesBefore.Unix())
+	if err != nil {
+		return err
+	}
+	hours := map[statsKey]*StatsBucket{}
+	for rows.Next() {
+		b, err := scanStatsBucket(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		start := b.Start.Truncate(statsHourStep)
+		key := statsKey{b.TunnelID, start.Unix()}
+		if hours[key] == nil {
+			hours[key] = &StatsBucket{TunnelID: b.TunnelID, Start: start, Step: statsHourStep}
+		}
+		hours[key].merge(b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, b := range hours {
+		if err := mergeStatsBucketTx(ctx, tx, *b); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tunnel_stats WHERE step = ? AND bucket_start < ?`,
+		int64(statsMinuteStep/time.Second), minutesBefore.Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tunnel_stats WHERE bucket_start < ?`, retainAfter.Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
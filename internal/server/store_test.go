@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 	"time"
 )
@@ -127,3 +128,108 @@ func TestStore_UpsertTunnelAccessRule_PreservesSecrets(t *testing.T) {
 		t.Fatalf("username=%q want demo2", second.BasicAuthUsername)
 	}
 }
+
+func TestStore_TunnelCredentials(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tun, err := store.CreateTunnel(ctx, 1, "app", "app.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnelCredential(ctx, tun.ID, "Partner A", "basic_auth", "", hashSecret("pw-a"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnelCredential(ctx, tun.ID, "partner-a", "basic_auth", "", hashSecret("pw"), time.Time{}); err == nil {
+		t.Fatal("expected duplicate credential name to fail")
+	}
+	if _, err := store.CreateTunnelCredential(ctx, tun.ID, "old", "shared_secret", "", hashSecret("s-old"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnelCredential(ctx, tun.ID, "bad", "token", "", hashSecret("x"), time.Time{}); err == nil {
+		t.Fatal("expected invalid kind to fail")
+	}
+
+	cred, err := store.MatchTunnelCredential(ctx, tun.ID, "basic_auth", "partner-a", hashSecret("pw-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Name != "partner-a" || cred.LastUsedAt.IsZero() {
+		t.Fatalf("unexpected credential %+v", cred)
+	}
+	if _, err := store.MatchTunnelCredential(ctx, tun.ID, "basic_auth", "partner-a", hashSecret("pw-a")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.ListTunnelCredentials(ctx, tun.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range stored {
+		if c.Name == "partner-a" && !c.LastUsedAt.Equal(cred.LastUsedAt.UTC()) {
+			t.Fatalf("last_used_at rewritten within interval: %v -> %v", cred.LastUsedAt, c.LastUsedAt)
+		}
+	}
+	if _, err := store.MatchTunnelCredential(ctx, tun.ID, "basic_auth", "someone", hashSecret("pw-a")); err == nil {
+		t.Fatal("expected username mismatch to fail")
+	}
+	if _, err := store.MatchTunnelCredential(ctx, tun.ID, "shared_secret", "", hashSecret("s-old")); err == nil {
+		t.Fatal("expected expired credential to fail")
+	}
+
+	list, err := store.ListTunnelCredentials(ctx, tun.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].LastUsedAt.IsZero() {
+		t.Fatalf("unexpected credentials %+v", list)
+	}
+	if err := store.DeleteTunnelCredential(ctx, tun.ID, "partner-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteTunnelCredential(ctx, tun.ID, "partner-a"); err == nil {
+		t.Fatal("expected second revoke to report not found")
+	}
+	if _, err := store.MatchTunnelCredential(ctx, tun.ID, "basic_auth", "partner-a", hashSecret("pw-a")); err == nil {
+		t.Fatal("expected revoked credential to fail")
+	}
+}
+
+func TestStore_GetTunnelByHostname_Wildcard(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	for name, host := range map[string]string{
+		"acme":   "*.acme.tunnel.example.com",
+		"acmeeu": "*.eu.acme.tunnel.example.com",
+		"www":    "www.acme.tunnel.example.com",
+	} {
+		if _, err := store.CreateTunnel(ctx, 1, name, host, "http://localhost:3000", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := map[string]string{
+		"www.acme.tunnel.example.com":     "www",
+		"shop.acme.tunnel.example.com":    "acme",
+		"shop.eu.acme.tunnel.example.com": "acmeeu",
+	}
+	for host, want := range cases {
+		tun, err := store.GetTunnelByHostname(ctx, host)
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if tun.Name != want {
+			t.Fatalf("%s resolved to %s, want %s", host, tun.Name, want)
+		}
+	}
+	for _, host := range []string{"acme.tunnel.example.com", "a.b.acme.tunnel.example.com"} {
+		if _, err := store.GetTunnelByHostname(ctx, host); err != sql.ErrNoRows {
+			t.Fatalf("%s: err=%v want sql.ErrNoRows", host, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tunnelSessionCookieName = "fwdx_tunnel_session"
+	tunnelAuthCallbackPath  = "/.fwdx/auth/callback"
+	tunnelAuthCodeTTL       = 2 * time.Minute
+)
+
+// publicBaseURL returns the externally reachable base URL of the server hostname.
+// The OIDC redirect URL is preferred because it already carries the public scheme and port.
+func (a *AuthManager) publicBaseURL() string {
+	if u, err := url.Parse(strings.TrimSpace(a.cfg.OIDCRedirectURL)); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return a.publicScheme() + "://" + a.cfg.Hostname
+}
+
+func (a *AuthManager) publicScheme() string {
+	if u, err := url.Parse(strings.TrimSpace(a.cfg.OIDCRedirectURL)); err == nil && u.Scheme != "" {
+		return u.Scheme
+	}
+	if a.secure {
+		return "https"
+	}
+	return "http"
+}
+
+// handleTunnelAuthorize runs on the server hostname. It turns the visitor's server session into a
+// one-time code and redirects back to the tunnel hostname, which may be on an unrelated custom domain.
+func (a *AuthManager) handleTunnelAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.OIDCEnabled() {
+		http.Error(w, "oidc not configured", http.StatusServiceUnavailable)
+		return
+	}
+	host := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("host")))
+	redirectTo := strings.TrimSpace(r.URL.Query().Get("redirect"))
+	if redirectTo == "" || !strings.HasPrefix(redirectTo, "/") || strings.HasPrefix(redirectTo, "//") {
+		redirectTo = "/"
+	}
+	if !validTunnelAuthHost(host) {
+		http.Error(w, "invalid host", http.StatusBadRequest)
+		return
+	}
+	tun, err := a.store.GetTunnelByHostname(r.Context(), hostWithoutPort(host))
+	if err != nil {
+		http.Error(w, "tunnel not found", http.StatusNotFound)
+		return
+	}
+	rule, err := a.store.GetTunnelAccessRule(r.Context(), tun.ID)
+	if err != nil || rule.AuthMode != "oidc" {
+		http.Error(w, "tunnel does not use oidc access", http.StatusBadRequest)
+		return
+	}
+	user, status, _ := a.requestUser(r.Context(), r)
+	if status != http.StatusOK || user == nil {
+		http.Redirect(w, r, "/auth/oidc/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	if !oidcRuleAllows(rule, *user) {
+		a.logAuthEvent("tunnel oidc denied", "tunnel", tun.Name, "hostname", tun.Hostname, "email", user.Email)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	code, err := randomString(32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := a.store.CreateTunnelAuthCode(r.Context(), a.sessionHash(code), tun.ID, user.ID, redirectTo, time.Now().Add(tunnelAuthCodeTTL)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, a.publicScheme()+"://"+host+tunnelAuthCallbackPath+"?code="+url.QueryEscape(code), http.StatusFound)
+}
+
+// checkTunnelOIDC enforces an oidc access rule on a proxied request. When handled is true the
+// response has been written and the request must not be forwarded to the agent.
+func (a *AuthManager) checkTunnelOIDC(w http.ResponseWriter, r *http.Request, tun TunnelRecord, rule TunnelAccessRuleRecord, deny proxyErrorFunc) (handled bool, status int, errText string) {
+	if !a.OIDCEnabled() {
+		deny(http.StatusServiceUnavailable, "oidc not configured")
+		return true, http.StatusServiceUnavailable, "oidc not configured"
+	}
+	if r.URL.Path == tunnelAuthCallbackPath {
+		return a.completeTunnelHandoff(w, r, tun, deny)
+	}
+	user := a.tunnelSessionUser(r, tun.ID)
+	if user == nil {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			deny(http.StatusUnauthorized, "unauthorized")
+			return true, http.StatusUnauthorized, "oidc session required"
+		}
+		target := a.publicBaseURL() + "/auth/tunnel/authorize?host=" + url.QueryEscape(r.Host) + "&redirect=" + url.QueryEscape(r.URL.RequestURI())
+		http.Redirect(w, r, target, http.StatusFound)
+		return true, http.StatusFound, "oidc login required"
+	}
+	if !oidcRuleAllows(rule, *user) {
+		deny(http.StatusForbidden, "forbidden")
+		return true, http.StatusForbidden, "oidc user not allowed"
+	}
+	return false, 0, ""
+}
+
+func (a *AuthManager) completeTunnelHandoff(w http.ResponseWriter, r *http.Request, tun TunnelRecord, deny proxyErrorFunc) (bool, int, string) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		deny(http.StatusBadRequest, "missing auth code")
+		return true, http.StatusBadRequest, "missing auth code"
+	}
+	rec, err := a.store.ConsumeTunnelAuthCode(r.Context(), a.sessionHash(code))
+	if err != nil || rec.TunnelID != tun.ID {
+		deny(http.StatusBadRequest, "invalid auth code")
+		return true, http.StatusBadRequest, "invalid auth code"
+	}
+	raw, err := randomString(32)
+	if err != nil {
+		deny(http.StatusInternalServerError, err.Error())
+		return true, http.StatusInternalServerError, err.Error()
+	}
+	expiresAt := time.Now().Add(a.sessionTTL())
+	if err := a.store.CreateTunnelSession(r.Context(), tun.ID, rec.UserID, a.sessionHash(raw), expiresAt); err != nil {
+		deny(http.StatusInternalServerError, err.Error())
+		return true, http.StatusInternalServerError, err.Error()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tunnelSessionCookieName,
+		Value:    raw,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.publicScheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expiresAt,
+	})
+	http.Redirect(w, r, rec.RedirectTo, http.StatusFound)
+	return true, http.StatusFound, ""
+}
+
+func (a *AuthManager) tunnelSessionUser(r *http.Request, tunnelID int64) *UserRecord {
+	c, err := r.Cookie(tunnelSessionCookieName)
+	if err != nil || strings.TrimSpace(c.Value) == "" {
+		return nil
+	}
+	sess, user, err := a.store.GetTunnelSessionByToken(r.Context(), a.sessionHash(strings.TrimSpace(c.Value)))
+	if err != nil || sess.TunnelID != tunnelID {
+		return nil
+	}
+	return &user
+}
+
+// oidcRuleAllows reports whether user passes the rule's optional email domain, email and group
+// restrictions. A rule without restrictions admits every authenticated user.
+func oidcRuleAllows(rule TunnelAccessRuleRecord, user UserRecord) bool {
+	if len(rule.OIDCEmailDomains) == 0 && len(rule.OIDCEmails) == 0 && len(rule.OIDCGroups) == 0 {
+		return true
+	}
+	email := strings.ToLower(strings.TrimSpace(user.Email))
+	if containsStringFold(rule.OIDCEmails, email) {
+		return true
+	}
+	if i := strings.LastIndexByte(email, '@'); i >= 0 && containsStringFold(rule.OIDCEmailDomains, email[i+1:]) {
+		return true
+	}
+	for _, g := range user.Groups {
+		if containsStringFold(rule.OIDCGroups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func validTunnelAuthHost(host string) bool {
+	name := hostWithoutPort(host)
+	if name == "" || strings.ContainsAny(name, "/@?#\\") {
+		return false
+	}
+	port := strings.TrimPrefix(host[len(name):], ":")
+	if port == "" {
+		return len(host) == len(name)
+	}
+	_, err := strconv.Atoi(port)
+	return err == nil
+}
+
+// stripCookie removes a single cookie from the request headers so fwdx credentials are never
+// forwarded to the local app.
+func stripCookie(h http.Header, name string) {
+	values := h.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+	h.Del("Cookie")
+	for _, line := range values {
+		parts := strings.Split(line, ";")
+		kept := parts[:0]
+		for _, part := range parts {
+			if k, _, _ := strings.Cut(strings.TrimSpace(part), "="); k == name {
+				continue
+			}
+			kept = append(kept, strings.TrimSpace(part))
+		}
+		if len(kept) > 0 {
+			h.Add("Cookie", strings.Join(kept, "; "))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTunnelOIDCFixture(t *testing.T, input AccessRuleInput) (*Store, *AuthManager, *captureConn, http.Handler) {
+	t.Helper()
+	provider := newMockOIDCProvider(t)
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cfg := Config{
+		Hostname:          "fwdx.test",
+		OIDCIssuer:        provider.server.URL,
+		OIDCClientID:      "fwdx-web",
+		OIDCRedirectURL:   "http://fwdx.test/auth/oidc/callback",
+		OIDCSessionSecret: "test-secret",
+	}
+	auth, err := NewAuthManager(context.Background(), cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := store.CreateTunnel(context.Background(), 1, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(context.Background(), tun.ID, input); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	conn := &captureConn{}
+	reg.Register("app.example.com", conn)
+	return store, auth, conn, ProxyHandlerWithConfig(reg, cfg, nil, store, nil, auth)
+}
+
+func TestProxyHandler_OIDCRuleHandoff(t *testing.T) {
+	_, auth, conn, handler := newTunnelOIDCFixture(t, AccessRuleInput{AuthMode: "oidc", OIDCEmailDomains: []string{"example.com"}})
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/dash?x=1", nil)
+	req.Host = "app.example.com"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status=%d want 302", rec.Code)
+	}
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Host != "fwdx.test" || loc.Path != "/auth/tunnel/authorize" || loc.Query().Get("host") != "app.example.com" || loc.Query().Get("redirect") != "/dash?x=1" {
+		t.Fatalf("unexpected authorize redirect %s", loc)
+	}
+
+	post := httptest.NewRequest(http.MethodPost, "http://app.example.com/api", nil)
+	post.Host = "app.example.com"
+	postRec := httptest.NewRecorder()
+	handler.ServeHTTP(postRec, post)
+	if postRec.Code != http.StatusUnauthorized {
+		t.Fatalf("post status=%d want 401", postRec.Code)
+	}
+
+	authReq := httptest.NewRequest(http.MethodGet, loc.RequestURI(), nil)
+	authReq.AddCookie(issueAdminCookie(t, auth))
+	authRec := httptest.NewRecorder()
+	auth.handleTunnelAuthorize(authRec, authReq)
+	if authRec.Code != http.StatusFound {
+		t.Fatalf("authorize status=%d body=%s", authRec.Code, authRec.Body.String())
+	}
+	cb, err := url.Parse(authRec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.Host != "app.example.com" || cb.Path != tunnelAuthCallbackPath {
+		t.Fatalf("unexpected callback redirect %s", cb)
+	}
+
+	cbReq := httptest.NewRequest(http.MethodGet, cb.String(), nil)
+	cbReq.Host = "app.example.com"
+	cbRec := httptest.NewRecorder()
+	handler.ServeHTTP(cbRec, cbReq)
+	if cbRec.Code != http.StatusFound || cbRec.Header().Get("Location") != "/dash?x=1" {
+		t.Fatalf("callback status=%d location=%q", cbRec.Code, cbRec.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range cbRec.Result().Cookies() {
+		if c.Name == tunnelSessionCookieName {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("missing tunnel session cookie")
+	}
+
+	replay := httptest.NewRecorder()
+	handler.ServeHTTP(replay, cbReq)
+	if replay.Code != http.StatusBadRequest {
+		t.Fatalf("reused code status=%d want 400", replay.Code)
+	}
+
+	okReq := httptest.NewRequest(http.MethodGet, "http://app.example.com/dash", nil)
+	okReq.Host = "app.example.com"
+	okReq.AddCookie(session)
+	okReq.AddCookie(&http.Cookie{Name: "app", Value: "keep"})
+	okRec := httptest.NewRecorder()
+	handler.ServeHTTP(okRec, okReq)
+	if okRec.Code != http.StatusOK {
+		t.Fatalf("status=%d want 200", okRec.Code)
+	}
+	if conn.last == nil {
+		t.Fatal("expected request to be forwarded")
+	}
+	if cookie := conn.last.Header.Get("Cookie"); strings.Contains(cookie, tunnelSessionCookieName) || !strings.Contains(cookie, "app=keep") {
+		t.Fatalf("unexpected forwarded cookie %q", cookie)
+	}
+}
+
+func TestHandleTunnelAuthorize_DeniesOutsideRestrictions(t *testing.T) {
+	_, auth, _, _ := newTunnelOIDCFixture(t, AccessRuleInput{AuthMode: "oidc", OIDCGroups: []string{"engineering"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/tunnel/authorize?host=app.example.com&redirect=/", nil)
+	req.AddCookie(issueAdminCookie(t, auth))
+	rec := httptest.NewRecorder()
+	auth.handleTunnelAuthorize(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status=%d want 403", rec.Code)
+	}
+}
+
+func TestOIDCRuleAllows(t *testing.T) {
+	user := UserRecord{Email: "Alice@Example.com", Groups: []string{"Ops"}}
+	cases := []struct {
+		rule TunnelAccessRuleRecord
+		want bool
+	}{
+		{TunnelAccessRuleRecord{}, true},
+		{TunnelAccessRuleRecord{OIDCEmailDomains: []string{"example.com"}}, true},
+		{TunnelAccessRuleRecord{OIDCEmailDomains: []string{"corp.com"}}, false},
+		{TunnelAccessRuleRecord{OIDCEmails: []string{"alice@example.com"}}, true},
+		{TunnelAccessRuleRecord{OIDCGroups: []string{"ops"}}, true},
+		{TunnelAccessRuleRecord{OIDCEmails: []string{"bob@example.com"}, OIDCGroups: []string{"dev"}}, false},
+	}
+	for i, tc := range cases {
+		if got := oidcRuleAllows(tc.rule, user); got != tc.want {
+			t.Fatalf("case %d: got %v want %v", i, got, tc.want)
+		}
+	}
+}
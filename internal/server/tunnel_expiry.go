@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const tunnelJanitorInterval = 15 * time.Second
+
+// parseTunnelTTL parses a user supplied lifetime such as "2h" or "30m".
+func parseTunnelTTL(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q", v)
+	}
+	return d, nil
+}
+
+// tunnelExpired reports whether tun has an expiry that has already passed.
+func tunnelExpired(tun TunnelRecord, now time.Time) bool {
+	return !tun.ExpiresAt.IsZero() && !now.Before(tun.ExpiresAt)
+}
+
+// runTunnelJanitor tears down expired tunnels and removes expired chaos policies until ctx is
+// cancelled.
+func runTunnelJanitor(ctx context.Context, store *Store, registry *Registry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expireTunnels(ctx, store, registry, time.Now())
+		expireChaosPolicies(ctx, store, time.Now())
+		expireTunnelSessions(ctx, store, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireTunnelSessions deletes tunnel login sessions past their expiry. Sessions are otherwise
+// only removed on logout or when an expired one is presented.
+func expireTunnelSessions(ctx context.Context, store *Store, now time.Time) {
+	n, err := store.DeleteExpiredTunnelSessions(ctx, now)
+	if err != nil {
+		slog.Error("tunnel session janitor failed", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Debug("tunnel sessions expired", "count", n)
+	}
+}
+
+// expireTunnels stops or deletes every tunnel whose expiry has passed. The store is updated before
+// the active connection is dropped so a reconnecting agent is already refused.
+func expireTunnels(ctx context.Context, store *Store, registry *Registry, now time.Time) int {
+	list, err := store.ListExpiredTunnels(ctx, now)
+	if err != nil {
+		slog.Error("tunnel janitor failed", "error", err)
+		return 0
+	}
+	for _, tun := range list {
+		if tun.ExpiryAction == "delete" {
+			if err := store.DeleteTunnelByName(ctx, tun.Name); err != nil {
+				slog.Error("tunnel janitor delete failed", "tunnel", tun.Name, "error", err)
+				continue
+			}
+			registry.Disconnect(tun.Hostname)
+			slog.Info("tunnel expired", "tunnel", tun.Name, "hostname", tun.Hostname, "action", "delete")
+			continue
+		}
+		if err := store.MarkTunnelExpired(ctx, tun.Name); err != nil {
+			slog.Error("tunnel janitor stop failed", "tunnel", tun.Name, "error", err)
+			continue
+		}
+		registry.Disconnect(tun.Hostname)
+		_ = store.AddTunnelEvent(ctx, tun.Hostname, "tunnel_expired", "tunnel expired at "+tun.ExpiresAt.UTC().Format(time.RFC3339)+"; stopped")
+		slog.Info("tunnel expired", "tunnel", tun.Name, "hostname", tun.Hostname, "action", "stop")
+	}
+	return len(list)
+}
+
+// nextTunnelExpiry resolves an expiry update. A ttl extends the current expiry, or starts from now
+// when the tunnel has none or has already expired; an absolute expiresAt replaces it.
+func nextTunnelExpiry(tun TunnelRecord, ttl string, expiresAt time.Time, clearExpiry bool, now time.Time) (time.Time, string, error) {
+	switch {
+	case clearExpiry:
+		return time.Time{}, "expiry cleared", nil
+	case strings.TrimSpace(ttl) != "":
+		d, err := parseTunnelTTL(ttl)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		base := now
+		if !tun.ExpiresAt.IsZero() && tun.ExpiresAt.After(now) {
+			base = tun.ExpiresAt
+		}
+		next := base.Add(d)
+		return next, "expiry extended to " + next.UTC().Format(time.RFC3339), nil
+	case !expiresAt.IsZero():
+		if !expiresAt.After(now) {
+			return time.Time{}, "", fmt.Errorf("expires_at must be in the future")
+		}
+		return expiresAt, "expiry set to " + expiresAt.UTC().Format(time.RFC3339), nil
+	default:
+		return time.Time{}, "", fmt.Errorf("ttl, expires_at, or clear required")
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExpireTunnels_StopAndDelete(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	now := time.Now()
+
+	stopTun, err := store.CreateTunnel(ctx, 1, "stopme", "stopme.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnel(ctx, 1, "deleteme", "deleteme.example.com", "http://localhost:3001", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTunnel(ctx, 1, "later", "later.example.com", "http://localhost:3002", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelExpiry(ctx, "stopme", now.Add(-time.Minute), "stop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelExpiry(ctx, "deleteme", now.Add(-time.Minute), "delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelExpiry(ctx, "later", now.Add(time.Hour), "stop"); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry()
+	reg.Register("stopme.example.com", &captureConn{})
+	reg.Register("deleteme.example.com", &captureConn{})
+	reg.Register("later.example.com", &captureConn{})
+
+	if n := expireTunnels(ctx, store, reg, now); n != 2 {
+		t.Fatalf("expired=%d want 2", n)
+	}
+	if reg.Get("stopme.example.com") != nil || reg.Get("deleteme.example.com") != nil {
+		t.Fatal("expected expired tunnels to be disconnected")
+	}
+	if reg.Get("later.example.com") == nil {
+		t.Fatal("unexpired tunnel should stay connected")
+	}
+
+	got, err := store.GetTunnelByName(ctx, "stopme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DesiredState != "stopped" || got.ExpiredAt.IsZero() {
+		t.Fatalf("unexpected stopped tunnel: desired=%q expired_at=%v", got.DesiredState, got.ExpiredAt)
+	}
+	events, err := store.ListTunnelEventsByTunnel(ctx, stopTun.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || events[0].EventType != "tunnel_expired" {
+		t.Fatalf("expected tunnel_expired event, got %+v", events)
+	}
+	if _, err := store.GetTunnelByName(ctx, "deleteme"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected deleted tunnel, got err=%v", err)
+	}
+
+	// Already expired tunnels are not processed twice.
+	if n := expireTunnels(ctx, store, reg, now); n != 0 {
+		t.Fatalf("second pass expired=%d want 0", n)
+	}
+}
+
+func TestExpireTunnelSessions(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	now := time.Now()
+	user, err := store.UpsertUserFromOIDC(ctx, "alice", "alice@example.com", "Alice", nil, "member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun, err := store.CreateTunnel(ctx, user.ID, "app", "app.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hash, expires := range map[string]time.Time{
+		"old":    now.Add(-time.Hour),
+		"recent": now.Add(-1500 * time.Millisecond),
+		"live":   now.Add(time.Hour),
+	} {
+		if err := store.CreateTunnelSession(ctx, tun.ID, user.ID, hash, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expireTunnelSessions(ctx, store, now)
+	var n int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM tunnel_sessions`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("sessions left=%d want 1", n)
+	}
+	if _, _, err := store.GetTunnelSessionByToken(ctx, "live"); err != nil {
+		t.Fatalf("live session: %v", err)
+	}
+}
+
+func TestNextTunnelExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	tests := []struct {
+		name      string
+		current   time.Time
+		ttl       string
+		expiresAt time.Time
+		clear     bool
+		want      time.Time
+		wantErr   bool
+	}{
+		{name: "ttl from now", ttl: "2h", want: now.Add(2 * time.Hour)},
+		{name: "ttl extends current", current: future, ttl: "30m", want: future.Add(30 * time.Minute)},
+		{name: "ttl after expiry starts from now", current: now.Add(-time.Hour), ttl: "1h", want: now.Add(time.Hour)},
+		{name: "absolute", expiresAt: future, want: future},
+		{name: "absolute in past", expiresAt: now.Add(-time.Second), wantErr: true},
+		{name: "clear", current: future, clear: true},
+		{name: "bad ttl", ttl: "-5m", wantErr: true},
+		{name: "nothing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := nextTunnelExpiry(TunnelRecord{ExpiresAt: tt.current}, tt.ttl, tt.expiresAt, tt.clear, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err=%v wantErr=%v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_ExpiredTunnel(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if _, err := store.CreateTunnel(ctx, 1, "app", "app.example.com", "http://localhost:3000", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTunnelExpiry(ctx, "app", time.Now().Add(-time.Second), "stop"); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	reg.Register("app.example.com", &captureConn{})
+	handler := ProxyHandlerWithConfig(reg, Config{Hostname: "tunnel.example.com"}, nil, store, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+	req.Host = "app.example.com"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGone {
+		t.Fatalf("status=%d want 410", rec.Code)
+	}
+}
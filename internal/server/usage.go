@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsageTotals sums proxied traffic.
+type UsageTotals struct {
+	Requests int64 `json:"requests"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	Errors   int64 `json:"errors"`
+}
+
+func (t *UsageTotals) add(rec UsageRecord) {
+	t.Requests += rec.Requests
+	t.BytesIn += rec.BytesIn
+	t.BytesOut += rec.BytesOut
+	t.Errors += rec.Errors
+}
+
+// UsageReport is one month of usage, per owner and per tunnel.
+type UsageReport struct {
+	Month  string      `json:"month"`
+	Totals UsageTotals `json:"totals"`
+	Users  []UserUsage `json:"users"`
+}
+
+type UserUsage struct {
+	OwnerUserID int64         `json:"owner_user_id"`
+	OwnerEmail  string        `json:"owner_email"`
+	Totals      UsageTotals   `json:"totals"`
+	Tunnels     []TunnelUsage `json:"tunnels"`
+}
+
+type TunnelUsage struct {
+	TunnelName string      `json:"tunnel_name"`
+	Hostname   string      `json:"hostname"`
+	Totals     UsageTotals `json:"totals"`
+}
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+type usageKey struct {
+	day         string
+	ownerUserID int64
+	tunnelName  string
+}
+
+// UsageBuffer totals proxied traffic per day, owner and tunnel in memory until the stats janitor
+// flushes it, so billing does not add a SQLite write to every request.
+type UsageBuffer struct {
+	mu      sync.Mutex
+	pending map[usageKey]*UsageRecord
+	flushMu sync.Mutex
+}
+
+func newUsageBuffer() *UsageBuffer {
+	return &UsageBuffer{pending: make(map[usageKey]*UsageRecord)}
+}
+
+// Add merges rec into the pending totals of its day, owner and tunnel.
+func (b *UsageBuffer) Add(rec UsageRecord) {
+	key := usageKey{rec.Day, rec.OwnerUserID, rec.TunnelName}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur := b.pending[key]
+	if cur == nil {
+		copied := rec
+		b.pending[key] = &copied
+		return
+	}
+	cur.Hostname = rec.Hostname
+	cur.Requests += rec.Requests
+	cur.BytesIn += rec.BytesIn
+	cur.BytesOut += rec.BytesOut
+	cur.Errors += rec.Errors
+}
+
+func (b *UsageBuffer) take() []UsageRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]UsageRecord, 0, len(b.pending))
+	for _, rec := range b.pending {
+		out = append(out, *rec)
+	}
+	clear(b.pending)
+	return out
+}
+
+// putBack returns records that could not be written, merging them with newer traffic.
+func (b *UsageBuffer) putBack(recs []UsageRecord) {
+	for _, rec := range recs {
+		b.Add(rec)
+	}
+}
+
+// pendingTransfer returns the unflushed bytes of ownerUserID's tunnels during month (YYYY-MM).
+func (b *UsageBuffer) pendingTransfer(ownerUserID int64, month string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var n int64
+	for key, rec := range b.pending {
+		if key.ownerUserID == ownerUserID && strings.HasPrefix(key.day, month+"-") {
+			n += rec.BytesIn + rec.BytesOut
+		}
+	}
+	return n
+}
+
+// buildUsageReport rolls daily rows, ordered by owner and tunnel as ListUsage returns them, up
+// into monthly totals.
+func buildUsageReport(month string, rows []UsageRecord) UsageReport {
+	report := UsageReport{Month: month, Users: []UserUsage{}}
+	for _, rec := range rows {
+		report.Totals.add(rec)
+		n := len(report.Users)
+		if n == 0 || report.Users[n-1].OwnerUserID != rec.OwnerUserID {
+			report.Users = append(report.Users, UserUsage{OwnerUserID: rec.OwnerUserID, OwnerEmail: rec.OwnerEmail})
+			n++
+		}
+		u := &report.Users[n-1]
+		u.Totals.add(rec)
+		m := len(u.Tunnels)
+		if m == 0 || u.Tunnels[m-1].TunnelName != rec.TunnelName {
+			u.Tunnels = append(u.Tunnels, TunnelUsage{TunnelName: rec.TunnelName})
+			m++
+		}
+		u.Tunnels[m-1].Hostname = rec.Hostname
+		u.Tunnels[m-1].Totals.add(rec)
+	}
+	return report
+}
+
+// handleUsage serves GET /api/usage?month=YYYY-MM: the caller's usage for the month (the
+// current one by default), or every owner's for admins, optionally narrowed with user=<email>.
+// format=csv returns one row per owner and tunnel, or per day as well with by=day.
+func handleUsage(w http.ResponseWriter, r *http.Request, store *Store, user *UserRecord) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	month := strings.TrimSpace(q.Get("month"))
+	if month == "" {
+		month = quotaMonth(time.Now())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		http.Error(w, "month must be YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	isAdmin := user.Role == "admin"
+	rows, err := store.ListUsage(r.Context(), month, user.ID, isAdmin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if email := strings.TrimSpace(q.Get("user")); email != "" && isAdmin {
+		filtered := rows[:0]
+		for _, rec := range rows {
+			if strings.EqualFold(rec.OwnerEmail, email) {
+				filtered = append(filtered, rec)
+			}
+		}
+		rows = filtered
+	}
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, buildUsageReport(month, rows))
+	case "csv":
+		writeUsageCSV(w, month, rows, q.Get("by") == "day")
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+func writeUsageCSV(w http.ResponseWriter, month string, rows []UsageRecord, daily bool) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fwdx-usage-%s.csv"`, month))
+	cw := csv.NewWriter(w)
+	header := []string{"month", "owner_email", "tunnel", "hostname", "requests", "bytes_in", "bytes_out", "errors"}
+	if daily {
+		header[0] = "day"
+	}
+	_ = cw.Write(header)
+	line := func(period, email, tunnel, hostname string, t UsageTotals) {
+		_ = cw.Write([]string{period, email, tunnel, hostname,
+			strconv.FormatInt(t.Requests, 10), strconv.FormatInt(t.BytesIn, 10),
+			strconv.FormatInt(t.BytesOut, 10), strconv.FormatInt(t.Errors, 10)})
+	}
+	if daily {
+		for _, rec := range rows {
+			var t UsageTotals
+			t.add(rec)
+			line(rec.Day, rec.OwnerEmail, rec.TunnelName, rec.Hostname, t)
+		}
+	} else {
+		for _, u := range buildUsageReport(month, rows).Users {
+			for _, tu := range u.Tunnels {
+				line(month, u.OwnerEmail, tu.TunnelName, tu.Hostname, tu.Totals)
+			}
+		}
+	}
+	cw.Flush()
+}
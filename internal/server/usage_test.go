@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageAccountingAndReport(t *testing.T) {
+	ds := newTestDomainStore(t, t.TempDir())
+	store := ds.store
+	ctx := context.Background()
+	cfg := Config{Hostname: "tunnel.example.com"}
+	auth, err := NewAuthManager(ctx, cfg, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(subject, role string) (string, UserRecord) {
+		raw, _, user, err := auth.IssueSessionForClaims(ctx, OIDCClaims{Subject: subject, Email: subject + "@example.com"}, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw, user
+	}
+	adminToken, _ := session("admin", "admin")
+	aliceToken, alice := session("alice", "member")
+	bobToken, bob := session("bob", "member")
+
+	tun, err := store.CreateTunnel(ctx, alice.ID, "shop", "shop.tunnel.example.com", "http://localhost:3000", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertTunnelAccessRule(ctx, tun.ID, AccessRuleInput{AuthMode: "basic_auth", BasicAuthUsername: "demo", BasicAuthPassword: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	reg.Register("shop.tunnel.example.com", &captureConn{})
+	proxy := ProxyHandlerWithConfig(reg, cfg, nil, store, nil)
+	for i, authed := range []bool{true, true, false} {
+		req := httptest.NewRequest(http.MethodPost, "https://shop.tunnel.example.com/", strings.NewReader("hello"))
+		req.Host = "shop.tunnel.example.com"
+		if authed {
+			req.SetBasicAuth("demo", "secret")
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if authed != (rec.Code == http.StatusOK) {
+			t.Fatalf("request %d status=%d", i, rec.Code)
+		}
+	}
+	if rows, _ := store.ListUsage(ctx, quotaMonth(time.Now()), alice.ID, false); len(rows) != 0 {
+		t.Fatalf("usage written before flush: %+v", rows)
+	}
+	if n, err := store.GetUserTransfer(ctx, alice.ID, quotaMonth(time.Now())); err != nil || n != 32 {
+		t.Fatalf("pending transfer=%d err=%v", n, err)
+	}
+	if err := store.FlushUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordUsage(ctx, UsageRecord{Day: "2024-01-31", OwnerUserID: bob.ID, TunnelName: "api", Hostname: "api.tunnel.example.com", Requests: 4, BytesOut: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordUsage(ctx, UsageRecord{Day: time.Now().UTC().Format("2006-01-02"), OwnerUserID: bob.ID, TunnelName: "api", Hostname: "api.tunnel.example.com", Requests: 1, BytesIn: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.ListUsage(ctx, quotaMonth(time.Now()), alice.ID, false)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("rows=%v err=%v", rows, err)
+	}
+	if got := rows[0]; got.Requests != 3 || got.Errors != 1 || got.BytesIn != 15 || got.BytesOut != 17 || got.OwnerEmail != "alice@example.com" {
+		t.Fatalf("usage row=%+v", got)
+	}
+	if n, err := store.GetUserTransfer(ctx, alice.ID, quotaMonth(time.Now())); err != nil || n != 32 {
+		t.Fatalf("transfer=%d err=%v", n, err)
+	}
+
+	srv := httptest.NewServer(ControlPlaneRouter(cfg, reg, ds, store, auth))
+	defer srv.Close()
+	get := func(token, path string) (int, http.Header, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, string(body)
+	}
+
+	code, _, body := get(aliceToken, "/api/usage")
+	var report UsageReport
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &report) != nil {
+		t.Fatalf("alice report status=%d body=%s", code, body)
+	}
+	if len(report.Users) != 1 || report.Users[0].OwnerEmail != "alice@example.com" || report.Totals.Requests != 3 {
+		t.Fatalf("alice report=%+v", report)
+	}
+	if code, _, body = get(bobToken, "/api/usage?month=2024-01"); code != http.StatusOK || !strings.Contains(body, `"requests":4`) || strings.Contains(body, "alice") {
+		t.Fatalf("bob january report status=%d body=%s", code, body)
+	}
+	if code, _, _ = get(bobToken, "/api/usage?month=january"); code != http.StatusBadRequest {
+		t.Fatalf("bad month status=%d", code)
+	}
+
+	code, header, body := get(adminToken, "/api/usage?format=csv")
+	if code != http.StatusOK || !strings.HasPrefix(header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("csv status=%d type=%s", code, header.Get("Content-Type"))
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("csv records=%v err=%v", records, err)
+	}
+	if strings.Join(records[1], ",") != quotaMonth(time.Now())+",alice@example.com,shop,shop.tunnel.example.com,3,15,17,1" {
+		t.Fatalf("alice csv row=%v", records[1])
+	}
+	if _, _, body = get(adminToken, "/api/usage?format=csv&by=day&user=BOB@example.com"); strings.Count(body, "\n") != 2 || !strings.HasPrefix(body, "day,") {
+		t.Fatalf("bob daily csv=%s", body)
+	}
+}
+
+func TestRunStatsHistory_FlushesUsageOnStop(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	day := usageDay(time.Now())
+	for range 3 {
+		store.UsageBuffer().Add(UsageRecord{Day: day, OwnerUserID: 7, TunnelName: "app", Hostname: "app.tunnel.example.com", Requests: 1, BytesIn: 10, BytesOut: 20})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runStatsHistory(ctx, store, time.Hour)
+	}()
+	cancel()
+	<-done
+	rows, err := store.ListUsage(context.Background(), quotaMonth(time.Now()), 7, false)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("rows=%v err=%v", rows, err)
+	}
+	if got := rows[0]; got.Requests != 3 || got.BytesIn != 30 || got.BytesOut != 60 {
+		t.Fatalf("usage row=%+v", got)
+	}
+	if n, _ := store.GetUserTransfer(context.Background(), 7, quotaMonth(time.Now())); n != 90 {
+		t.Fatalf("transfer=%d want 90 (counted once after flush)", n)
+	}
+}
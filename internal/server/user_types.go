@@ -32,6 +32,26 @@ type LoginStateRecord struct {
 	CreatedAt    time.Time
 }
 
+// TunnelSessionRecord is a visitor session scoped to a single oidc-protected tunnel hostname.
+type TunnelSessionRecord struct {
+	ID         int64
+	TunnelID   int64
+	UserID     int64
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// TunnelAuthCodeRecord is a one-time code that hands a server session over to a tunnel hostname.
+type TunnelAuthCodeRecord struct {
+	ID         int64
+	TunnelID   int64
+	UserID     int64
+	RedirectTo string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
 type OIDCClaims struct {
 	Subject     string
 	Email       string
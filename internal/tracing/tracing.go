@@ -0,0 +1,91 @@
+// Package tracing wires OpenTelemetry for the server and the tunnel agent: an OTLP/HTTP exporter
+// when an endpoint is configured, and W3C trace context propagation either way.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/BRAVO68WEB/fwdx"
+
+// propagator reads and writes traceparent and tracestate. It is fixed rather than taken from
+// the global so that context still flows through the tunnel when export is off.
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the fwdx tracer from the global provider; spans are no-ops until Setup runs.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup exports spans to the OTLP/HTTP collector at endpoint as serviceName and returns a
+// function that flushes and stops the exporter. An empty endpoint leaves tracing disabled.
+// The endpoint is a URL such as http://localhost:4318; a bare host:port is taken as http and
+// a URL without a path gets the standard /v1/traces.
+func Setup(ctx context.Context, serviceName, endpoint string) (func(context.Context) error, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	target, err := endpointURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(target))
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("otlp resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+func endpointURL(endpoint string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid OTLP endpoint %q: want http(s)://host:port", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// Inject writes the trace context of ctx into carrier, such as ProxyRequest metadata.
+func Inject(ctx context.Context, carrier map[string]string) {
+	propagator.Inject(ctx, propagation.MapCarrier(carrier))
+}
+
+// Extract returns ctx with the remote trace context found in carrier, if any.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// InjectHeader writes traceparent (and tracestate) for ctx into h.
+func InjectHeader(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// ExtractHeader returns ctx with the trace context sent by an HTTP client, if any.
+func ExtractHeader(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEndpointURL(t *testing.T) {
+	cases := map[string]string{
+		"localhost:4318":                     "http://localhost:4318/v1/traces",
+		"http://collector:4318/":             "http://collector:4318/v1/traces",
+		"https://otel.example.com/otlp/v1/t": "https://otel.example.com/otlp/v1/t",
+	}
+	for in, want := range cases {
+		got, err := endpointURL(in)
+		if err != nil || got != want {
+			t.Fatalf("%q: got %q err=%v want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"ftp://collector", "http://"} {
+		if _, err := endpointURL(bad); err == nil {
+			t.Fatalf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestSetup_ExportsToCollector(t *testing.T) {
+	var mu sync.Mutex
+	var got []*coltracepb.ExportTraceServiceRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, &req) != nil {
+			http.Error(w, "bad export", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		got = append(got, &req)
+		mu.Unlock()
+		out, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(out)
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(context.Background(), "fwdx-test", collector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := Tracer().Start(context.Background(), "unit")
+	carrier := map[string]string{}
+	Inject(ctx, carrier)
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := trace.SpanContextFromContext(Extract(context.Background(), carrier))
+	if remote.TraceID() != span.SpanContext().TraceID() || remote.SpanID() != span.SpanContext().SpanID() || !remote.IsRemote() {
+		t.Fatalf("carrier %v did not round-trip the span context", carrier)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || len(got[0].ResourceSpans) != 1 {
+		t.Fatalf("exports=%d", len(got))
+	}
+	rs := got[0].ResourceSpans[0]
+	service := ""
+	for _, kv := range rs.Resource.Attributes {
+		if kv.Key == "service.name" {
+			service = kv.Value.GetStringValue()
+		}
+	}
+	if service != "fwdx-test" || len(rs.ScopeSpans) != 1 || rs.ScopeSpans[0].Spans[0].Name != "unit" {
+		t.Fatalf("unexpected export %v", rs)
+	}
+}
@@ -0,0 +1,24 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SetCapture turns request capture on or off for a tunnel.
+func (m *Manager) SetCapture(name string, enabled bool) (*Tunnel, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(map[string]bool{"enabled": enabled})
+	var rec apiTunnel
+	path := "/api/tunnels/" + url.PathEscape(strings.ToLower(name)) + "/capture"
+	if err := apiJSON(base, sess.AccessToken, http.MethodPatch, path, bytes.NewReader(body), &rec, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return m.fromAPI(rec), nil
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ChaosPolicy is the fault injection applied to a tunnel by the server.
+type ChaosPolicy struct {
+	LatencyMS     int       `json:"latency_ms"`
+	JitterMS      int       `json:"jitter_ms"`
+	ErrorPercent  float64   `json:"error_percent"`
+	ErrorStatus   int       `json:"error_status"`
+	DropPercent   float64   `json:"drop_percent"`
+	BandwidthKBps int       `json:"bandwidth_kbps"`
+	Paths         []string  `json:"paths"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// ChaosOptions describes a chaos policy to install. A zero TTL uses the server default.
+type ChaosOptions struct {
+	Latency       time.Duration
+	Jitter        time.Duration
+	ErrorPercent  float64
+	ErrorStatus   int
+	DropPercent   float64
+	BandwidthKBps int
+	Paths         []string
+	TTL           time.Duration
+}
+
+func chaosPath(tunnelName string) string {
+	return "/api/tunnels/" + url.PathEscape(strings.ToLower(tunnelName)) + "/chaos"
+}
+
+// SetChaos installs or replaces the tunnel's chaos policy.
+func (m *Manager) SetChaos(tunnelName string, opts ChaosOptions) (*ChaosPolicy, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]any{
+		"latency_ms":     opts.Latency.Milliseconds(),
+		"jitter_ms":      opts.Jitter.Milliseconds(),
+		"error_percent":  opts.ErrorPercent,
+		"error_status":   opts.ErrorStatus,
+		"drop_percent":   opts.DropPercent,
+		"bandwidth_kbps": opts.BandwidthKBps,
+		"paths":          opts.Paths,
+	}
+	if opts.TTL > 0 {
+		payload["ttl"] = opts.TTL.String()
+	}
+	body, _ := json.Marshal(payload)
+	var out ChaosPolicy
+	if err := apiJSON(base, sess.AccessToken, http.MethodPut, chaosPath(tunnelName), bytes.NewReader(body), &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ClearChaos removes the tunnel's chaos policy.
+func (m *Manager) ClearChaos(tunnelName string) error {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return err
+	}
+	return apiJSON(base, sess.AccessToken, http.MethodDelete, chaosPath(tunnelName), nil, nil, http.StatusNoContent)
+}
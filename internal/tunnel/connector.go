@@ -6,15 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	tunnelv1 "github.com/BRAVO68WEB/fwdx/api/tunnel/v1"
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
@@ -36,7 +41,10 @@ func maxProxyBodyBytes() int {
 
 // Connect runs the tunnel client over gRPC: register, then receive ProxyRequests and send ProxyResponses.
 // tunnelURL is the gRPC endpoint (e.g. https://tunnel.example.com:4443). Agent credential is sent in gRPC metadata.
-func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL string, debug bool) error {
+// When inspector is non-nil every exchange is recorded for the local inspector. When mirror is
+// non-nil sampled requests are also sent to the mirror target and compared in a MirrorReport.
+// Per-request details are logged at debug level.
+func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL string, inspector *Inspector, mirror *Mirror) error {
 	tunnelURL = strings.TrimSuffix(tunnelURL, "/")
 	u, err := url.Parse(tunnelURL)
 	if err != nil {
@@ -74,7 +82,7 @@ func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL st
 		return fmt.Errorf("grpc dial: %w", err)
 	}
 	defer conn.Close()
-	log.Printf("[fwdx] tunnel dialing target=%s tunnel=%s local=%s", target, tunnelName, localURL)
+	slog.Info("tunnel dialing", "tunnel", tunnelName, "peer", target, "local", localURL)
 
 	client := tunnelv1.NewTunnelServiceClient(conn)
 	md := metadata.Pairs("authorization", "Bearer "+agentToken)
@@ -109,9 +117,19 @@ func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL st
 		return fmt.Errorf("register: %s", errStr)
 	}
 
-	log.Printf("[fwdx] tunnel connected tunnel=%s local=%s", tunnelName, localURL)
-	if debug {
-		fmt.Printf("tunnel registered %s -> %s\n", tunnelName, localURL)
+	slog.Info("tunnel connected", "tunnel", tunnelName, "peer", target, "local", localURL)
+
+	// Mirror reports are sent from their own goroutines, so sends share a lock.
+	var sendMu sync.Mutex
+	send := func(m *tunnelv1.ClientMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(m)
+	}
+	reportMirror := func(r *tunnelv1.MirrorReport) {
+		slog.Debug("mirror compared", "tunnel", tunnelName, "request_id", r.RequestId, "method", r.Method, "path", r.Path,
+			"primary_status", r.PrimaryStatus, "primary_ms", r.PrimaryLatencyMs, "mirror_status", r.MirrorStatus, "mirror_ms", r.MirrorLatencyMs, "mirror_error", r.MirrorError)
+		_ = send(&tunnelv1.ClientMessage{Message: &tunnelv1.ClientMessage_MirrorReport{MirrorReport: r}})
 	}
 
 	// Loop: receive ProxyRequest, proxy to local, send ProxyResponse
@@ -119,10 +137,10 @@ func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL st
 		msg, err := stream.Recv()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				log.Printf("[fwdx] tunnel closed tunnel=%s reason=eof", tunnelName)
+				slog.Info("tunnel closed", "tunnel", tunnelName, "reason", "eof")
 				return nil
 			}
-			log.Printf("[fwdx] tunnel closed tunnel=%s err=%v", tunnelName, err)
+			slog.Warn("tunnel closed", "tunnel", tunnelName, "error", err)
 			return err
 		}
 		preq := msg.GetProxyRequest()
@@ -141,30 +159,37 @@ func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL st
 		for k, v := range preq.Headers {
 			pr.Header.Set(k, v)
 		}
+		reqCtx, span := tracing.Tracer().Start(tracing.Extract(ctx, preq.Metadata), "tunnel receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("fwdx.tunnel", tunnelName), attribute.String("fwdx.tunnel.request_id", pr.ID)))
 
 		var resp *ProxyResp
 		var proxyErr error
+		attempts := 0
+		start := time.Now()
 		for attempt := 0; attempt < 4; attempt++ {
-			resp, proxyErr = ProxyToLocal(localURL, pr)
+			attempts++
+			resp, proxyErr = ProxyToLocal(reqCtx, localURL, pr)
 			if proxyErr == nil {
 				break
 			}
 			if !errors.Is(proxyErr, ErrLocalTransport) || !IsIdempotentMethod(pr.Method) || attempt == 3 {
 				break
 			}
-			if debug {
-				log.Printf("[fwdx] local transport retry id=%s method=%s attempt=%d err=%v", pr.ID, pr.Method, attempt+1, proxyErr)
-			}
+			slog.Debug("local transport retry", "tunnel", tunnelName, "request_id", pr.ID, "method", pr.Method, "attempt", attempt+1, "error", proxyErr)
 			select {
 			case <-ctx.Done():
+				span.End()
 				return ctx.Err()
 			case <-time.After(150 * time.Millisecond):
 			}
 		}
+		observeUpstream(tunnelName, resp, time.Since(start), attempts, proxyErr)
+		span.SetAttributes(attribute.Int("fwdx.attempts", attempts))
 		if proxyErr != nil {
-			if debug {
-				log.Printf("[fwdx] local proxy failed id=%s method=%s err=%v", pr.ID, pr.Method, proxyErr)
-			}
+			span.SetStatus(codes.Error, proxyErr.Error())
+			inspector.Record(pr, nil, time.Since(start), attempts, proxyErr, 0)
+			slog.Debug("local proxy failed", "tunnel", tunnelName, "request_id", pr.ID, "method", pr.Method, "path", pr.Path, "error", proxyErr)
 			body := []byte("bad gateway")
 			if errors.Is(proxyErr, ErrLocalResponseTooLarge) {
 				body = []byte("local response too large")
@@ -175,7 +200,10 @@ func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL st
 				Body:   body,
 				Header: make(http.Header),
 			}
+		} else {
+			inspector.Record(pr, resp, time.Since(start), attempts, nil, 0)
 		}
+		mirror.Send(ctx, pr, resp, time.Since(start), reportMirror)
 
 		headers := make(map[string]string)
 		for k, vv := range resp.Header {
@@ -183,7 +211,9 @@ func Connect(ctx context.Context, tunnelURL, agentToken, tunnelName, localURL st
 				headers[k] = strings.Join(vv, ", ")
 			}
 		}
-		if err := stream.Send(&tunnelv1.ClientMessage{
+		// The trip back is part of the server's wait span.
+		span.End()
+		if err := send(&tunnelv1.ClientMessage{
 			Message: &tunnelv1.ClientMessage_ProxyResponse{
 				ProxyResponse: &tunnelv1.ProxyResponse{
 					Id:      resp.ID,
@@ -7,7 +7,7 @@ import (
 
 func TestConnect_InvalidURL(t *testing.T) {
 	ctx := context.Background()
-	err := Connect(ctx, "://invalid", "token", "app.example.com", "http://localhost:8080", false)
+	err := Connect(ctx, "://invalid", "token", "app.example.com", "http://localhost:8080", nil, nil)
 	if err == nil {
 		t.Error("expected error for invalid URL")
 	}
@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Credential is a named basic-auth or shared-secret credential attached to a tunnel.
+type Credential struct {
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Username   string    `json:"username,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CredentialInput describes a credential to add. A blank Secret is generated by the server.
+type CredentialInput struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Username string `json:"username,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+}
+
+func credentialsPath(tunnelName string) string {
+	return "/api/tunnels/" + url.PathEscape(strings.ToLower(tunnelName)) + "/credentials"
+}
+
+func (m *Manager) ListCredentials(tunnelName string) ([]Credential, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, err
+	}
+	var out []Credential
+	if err := apiJSON(base, sess.AccessToken, http.MethodGet, credentialsPath(tunnelName), nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddCredential creates a credential and returns it with the plaintext secret, which the server
+// does not store.
+func (m *Manager) AddCredential(tunnelName string, input CredentialInput) (*Credential, string, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, "", err
+	}
+	body, _ := json.Marshal(input)
+	var out struct {
+		Credential Credential `json:"credential"`
+		Secret     string     `json:"secret"`
+	}
+	if err := apiJSON(base, sess.AccessToken, http.MethodPost, credentialsPath(tunnelName), bytes.NewReader(body), &out, http.StatusCreated); err != nil {
+		return nil, "", err
+	}
+	return &out.Credential, out.Secret, nil
+}
+
+func (m *Manager) RevokeCredential(tunnelName, credentialName string) error {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return err
+	}
+	return apiJSON(base, sess.AccessToken, http.MethodDelete, credentialsPath(tunnelName)+"/"+url.PathEscape(strings.ToLower(credentialName)), nil, nil, http.StatusNoContent)
+}
+
+// ClearPrimarySecret removes the tunnel's primary basic auth password or shared secret so only
+// named credentials are accepted. The rest of the access rule is sent back unchanged.
+func (m *Manager) ClearPrimarySecret(tunnelName string) error {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return err
+	}
+	path := "/api/tunnels/" + url.PathEscape(strings.ToLower(tunnelName)) + "/access"
+	var rule struct {
+		AuthMode               string            `json:"auth_mode"`
+		BasicAuthUsername      string            `json:"basic_auth_username"`
+		SharedSecretHeaderName string            `json:"shared_secret_header_name"`
+		AllowedIPs             []string          `json:"allowed_ips"`
+		OIDCEmailDomains       []string          `json:"oidc_email_domains"`
+		OIDCEmails             []string          `json:"oidc_emails"`
+		OIDCGroups             []string          `json:"oidc_groups"`
+		JWTIssuer              string            `json:"jwt_issuer"`
+		JWTAudience            string            `json:"jwt_audience"`
+		JWTRequiredClaims      map[string]string `json:"jwt_required_claims"`
+		ClearPrimarySecret     bool              `json:"clear_primary_secret"`
+	}
+	if err := apiJSON(base, sess.AccessToken, http.MethodGet, path, nil, &rule, http.StatusOK); err != nil {
+		return err
+	}
+	rule.ClearPrimarySecret = true
+	body, _ := json.Marshal(rule)
+	return apiJSON(base, sess.AccessToken, http.MethodPatch, path, bytes.NewReader(body), nil, http.StatusOK)
+}
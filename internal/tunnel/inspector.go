@@ -0,0 +1,411 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// inspectorRingSize is how many exchanges the local inspector keeps.
+	inspectorRingSize = 100
+	// inspectorMaxBodyBytes caps each body kept for display.
+	inspectorMaxBodyBytes = 64 << 10
+	// inspectorMaxReplayBytes caps the full request body kept for replay. Larger requests are
+	// shown but cannot be replayed, so the ring stays bounded at about 100 MiB.
+	inspectorMaxReplayBytes = 1 << 20
+)
+
+// InspectorEntry is one request seen by the tunnel client, or a replay of one.
+type InspectorEntry struct {
+	ID                int64         `json:"id"`
+	Time              time.Time     `json:"time"`
+	Method            string        `json:"method"`
+	Path              string        `json:"path"`
+	Query             string        `json:"query,omitempty"`
+	RequestHeader     http.Header   `json:"request_headers"`
+	RequestBody       []byte        `json:"request_body,omitempty"`
+	RequestTruncated  bool          `json:"request_truncated,omitempty"`
+	Status            int           `json:"status"`
+	ResponseHeader    http.Header   `json:"response_headers,omitempty"`
+	ResponseBody      []byte        `json:"response_body,omitempty"`
+	ResponseTruncated bool          `json:"response_truncated,omitempty"`
+	Duration          time.Duration `json:"duration_ns"`
+	Attempts          int           `json:"attempts"`
+	Error             string        `json:"error,omitempty"`
+	ReplayOf          int64         `json:"replay_of,omitempty"`
+	Replayable        bool          `json:"replayable"`
+
+	replayBody []byte
+}
+
+// Inspector records traffic passing through Connect and serves it on a local web page. A nil
+// *Inspector records nothing.
+type Inspector struct {
+	localURL string
+	// addr is the address Serve was asked to listen on; its host is accepted in Host headers.
+	addr string
+
+	mu      sync.Mutex
+	nextID  int64
+	entries []*InspectorEntry
+}
+
+// NewInspector returns an inspector that replays requests against localURL.
+func NewInspector(localURL string) *Inspector {
+	return &Inspector{localURL: localURL}
+}
+
+// NormalizeInspectAddr turns a bare port such as "4040" into a loopback address so the inspector
+// is not exposed beyond the developer's machine unless a host is given explicitly.
+func NormalizeInspectAddr(v string) string {
+	v = strings.TrimSpace(v)
+	if _, err := strconv.Atoi(v); err == nil {
+		return "127.0.0.1:" + v
+	}
+	if strings.HasPrefix(v, ":") {
+		return "127.0.0.1" + v
+	}
+	return v
+}
+
+// Record stores a proxied exchange. resp is nil when the local app could not be reached.
+func (in *Inspector) Record(pr *ProxyReq, resp *ProxyResp, took time.Duration, attempts int, proxyErr error, replayOf int64) *InspectorEntry {
+	if in == nil || pr == nil {
+		return nil
+	}
+	e := &InspectorEntry{
+		Time:          time.Now(),
+		Method:        pr.Method,
+		Path:          pr.Path,
+		Query:         pr.Query,
+		RequestHeader: pr.Header.Clone(),
+		Duration:      took,
+		Attempts:      attempts,
+		ReplayOf:      replayOf,
+	}
+	if len(pr.Body) <= inspectorMaxReplayBytes {
+		e.Replayable, e.replayBody = true, pr.Body
+	}
+	e.RequestBody, e.RequestTruncated = capInspectorBody(pr.Body)
+	if resp != nil {
+		e.Status = resp.Status
+		e.ResponseHeader = resp.Header.Clone()
+		e.ResponseBody, e.ResponseTruncated = capInspectorBody(resp.Body)
+	}
+	if proxyErr != nil {
+		e.Error = proxyErr.Error()
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.nextID++
+	e.ID = in.nextID
+	in.entries = append(in.entries, e)
+	if len(in.entries) > inspectorRingSize {
+		in.entries = in.entries[len(in.entries)-inspectorRingSize:]
+	}
+	return e
+}
+
+// capInspectorBody copies at most inspectorMaxBodyBytes of b so a ring entry never pins the
+// whole body.
+func capInspectorBody(b []byte) ([]byte, bool) {
+	if len(b) > inspectorMaxBodyBytes {
+		return append([]byte(nil), b[:inspectorMaxBodyBytes]...), true
+	}
+	return b, false
+}
+
+// Entries returns recorded exchanges, newest first.
+func (in *Inspector) Entries() []*InspectorEntry {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	out := make([]*InspectorEntry, 0, len(in.entries))
+	for i := len(in.entries) - 1; i >= 0; i-- {
+		out = append(out, in.entries[i])
+	}
+	return out
+}
+
+// Entry looks up a recorded exchange by id.
+func (in *Inspector) Entry(id int64) *InspectorEntry {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, e := range in.entries {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// Replay sends a recorded request straight to the local app, bypassing the server, and records
+// the result as a new entry.
+func (in *Inspector) Replay(id int64) (*InspectorEntry, error) {
+	orig := in.Entry(id)
+	if orig == nil {
+		return nil, fmt.Errorf("request %d not found", id)
+	}
+	if !orig.Replayable {
+		return nil, fmt.Errorf("request %d body is larger than %d bytes and was not kept for replay", id, inspectorMaxReplayBytes)
+	}
+	pr := &ProxyReq{
+		ID:     "replay-" + strconv.FormatInt(id, 10),
+		Method: orig.Method,
+		Path:   orig.Path,
+		Query:  orig.Query,
+		Header: orig.RequestHeader.Clone(),
+		Body:   orig.replayBody,
+	}
+	start := time.Now()
+	resp, err := ProxyToLocal(context.Background(), in.localURL, pr)
+	return in.Record(pr, resp, time.Since(start), 1, err, id), nil
+}
+
+// Serve listens on addr and serves the inspector UI until the listener fails. It returns once the
+// listener is bound so the caller can report the address.
+func (in *Inspector) Serve(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("inspector listen: %w", err)
+	}
+	in.addr = addr
+	srv := &http.Server{Handler: in.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("inspector stopped", "addr", ln.Addr().String(), "error", err)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+// Handler serves the inspector page at / and a JSON API under /api/requests. Requests must name
+// a loopback host or the configured address, which defeats DNS rebinding, and cross-site POSTs
+// are refused, so other web pages cannot replay captured requests.
+func (in *Inspector) Handler() http.Handler {
+	return in.checkHost(http.NewCrossOriginProtection().Handler(in.routes()))
+}
+
+func (in *Inspector) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !in.hostAllowed(r.Host) {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostAllowed accepts localhost, loopback addresses and the host the inspector was configured
+// with. When that host is unspecified (0.0.0.0), any IP literal is accepted too; rebinding
+// attacks need a DNS name.
+func (in *Inspector) hostAllowed(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+	configured, _, err := net.SplitHostPort(in.addr)
+	if err != nil || configured == "" {
+		return false
+	}
+	configured = strings.ToLower(configured)
+	if host == configured {
+		return true
+	}
+	if cip := net.ParseIP(configured); cip != nil && cip.IsUnspecified() {
+		return ip != nil
+	}
+	return false
+}
+
+func (in *Inspector) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		in.renderPage(w, in.Entries(), nil)
+	})
+	mux.HandleFunc("GET /requests/{id}", func(w http.ResponseWriter, r *http.Request) {
+		e := in.entryFromPath(r)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+		in.renderPage(w, in.Entries(), e)
+	})
+	mux.HandleFunc("POST /requests/{id}/replay", func(w http.ResponseWriter, r *http.Request) {
+		e := in.entryFromPath(r)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+		replayed, err := in.Replay(e.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Redirect(w, r, "/requests/"+strconv.FormatInt(replayed.ID, 10), http.StatusSeeOther)
+	})
+	mux.HandleFunc("GET /api/requests", func(w http.ResponseWriter, r *http.Request) {
+		writeInspectorJSON(w, http.StatusOK, in.Entries())
+	})
+	mux.HandleFunc("GET /api/requests/{id}", func(w http.ResponseWriter, r *http.Request) {
+		e := in.entryFromPath(r)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeInspectorJSON(w, http.StatusOK, e)
+	})
+	mux.HandleFunc("POST /api/requests/{id}/replay", func(w http.ResponseWriter, r *http.Request) {
+		e := in.entryFromPath(r)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+		replayed, err := in.Replay(e.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeInspectorJSON(w, http.StatusCreated, replayed)
+	})
+	return mux
+}
+
+func (in *Inspector) entryFromPath(r *http.Request) *InspectorEntry {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return in.Entry(id)
+}
+
+func writeInspectorJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type inspectorPageData struct {
+	LocalURL string
+	Entries  []*InspectorEntry
+	Selected *InspectorEntry
+}
+
+func (in *Inspector) renderPage(w http.ResponseWriter, entries []*InspectorEntry, selected *InspectorEntry) {
+	var buf bytes.Buffer
+	if err := inspectorTemplate.Execute(&buf, inspectorPageData{LocalURL: in.localURL, Entries: entries, Selected: selected}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func inspectorHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b.WriteString(k + ": " + v + "\n")
+		}
+	}
+	return b.String()
+}
+
+func inspectorBody(b []byte) string {
+	if !utf8.Valid(b) {
+		return fmt.Sprintf("[%d bytes of binary data]", len(b))
+	}
+	return string(b)
+}
+
+var inspectorTemplate = template.Must(template.New("inspector").Funcs(template.FuncMap{
+	"headers": inspectorHeaders,
+	"body":    inspectorBody,
+	"ms":      func(d time.Duration) int64 { return d.Milliseconds() },
+}).Parse(`<!doctype html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <title>fwdx inspector</title>
+  {{if not .Selected}}<meta http-equiv="refresh" content="2" />{{end}}
+  <style>
+    body { font-family: ui-sans-serif, system-ui, sans-serif; margin: 0; background: #f8fafc; color: #0f172a; }
+    header { padding: 12px 20px; background: #0f172a; color: #fff; }
+    main { display: flex; gap: 16px; padding: 16px; }
+    table { border-collapse: collapse; width: 100%; background: #fff; }
+    th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #e2e8f0; font-size: 13px; }
+    pre { background: #fff; border: 1px solid #e2e8f0; padding: 8px; overflow: auto; max-height: 320px; font-size: 12px; }
+    .list { flex: 1; }
+    .detail { flex: 1; }
+    .err { color: #b91c1c; }
+    .muted { color: #64748b; }
+  </style>
+</head>
+<body>
+<header><b>fwdx inspector</b> <span class="muted">forwarding to {{.LocalURL}}</span></header>
+<main>
+  <div class="list">
+    <table>
+      <thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Duration</th></tr></thead>
+      <tbody>
+      {{range .Entries}}
+        <tr>
+          <td>{{.Time.Format "15:04:05"}}</td>
+          <td>{{.Method}}</td>
+          <td><a href="/requests/{{.ID}}">{{.Path}}{{if .Query}}?{{.Query}}{{end}}</a>{{if .ReplayOf}} <span class="muted">replay of #{{.ReplayOf}}</span>{{end}}</td>
+          <td{{if .Error}} class="err"{{end}}>{{if .Status}}{{.Status}}{{else}}-{{end}}</td>
+          <td>{{ms .Duration}} ms</td>
+        </tr>
+      {{else}}
+        <tr><td colspan="5" class="muted">Waiting for requests...</td></tr>
+      {{end}}
+      </tbody>
+    </table>
+  </div>
+  {{with .Selected}}
+  <div class="detail">
+    <h3>#{{.ID}} {{.Method}} {{.Path}}{{if .Query}}?{{.Query}}{{end}}</h3>
+    <p>{{.Time.Format "2006-01-02 15:04:05"}} - {{if .Status}}{{.Status}}{{else}}no response{{end}} in {{ms .Duration}} ms{{if gt .Attempts 1}} after {{.Attempts}} attempts{{end}}</p>
+    {{if .Error}}<p class="err">{{.Error}}</p>{{end}}
+    {{if .Replayable}}<form method="post" action="/requests/{{.ID}}/replay"><button type="submit">Replay against local app</button></form>
+    {{else}}<p class="muted">Request body over 1 MiB; not kept for replay.</p>{{end}}
+    <h4>Request</h4>
+    <pre>{{headers .RequestHeader}}</pre>
+    {{if .RequestBody}}<pre>{{body .RequestBody}}</pre>{{end}}
+    {{if .RequestTruncated}}<p class="muted">Body truncated to 64 KiB for display.</p>{{end}}
+    <h4>Response</h4>
+    <pre>{{headers .ResponseHeader}}</pre>
+    {{if .ResponseBody}}<pre>{{body .ResponseBody}}</pre>{{end}}
+    {{if .ResponseTruncated}}<p class="muted">Body truncated to 64 KiB for display.</p>{{end}}
+    <p><a href="/">Back to live view</a></p>
+  </div>
+  {{end}}
+</main>
+</body>
+</html>
+`))
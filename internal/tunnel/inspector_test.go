@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInspector_RecordAndReplay(t *testing.T) {
+	var hits int
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", r.Header.Get("X-Event"))
+		_, _ = w.Write(body)
+	}))
+	defer local.Close()
+
+	in := NewInspector(local.URL)
+	pr := &ProxyReq{ID: "r1", Method: http.MethodPost, Path: "/hooks", Query: "a=1", Header: http.Header{"X-Event": {"push"}}, Body: []byte("payload")}
+	in.Record(pr, &ProxyResp{Status: http.StatusAccepted, Header: http.Header{}, Body: []byte("payload")}, 5*time.Millisecond, 1, nil, 0)
+	in.Record(&ProxyReq{Method: http.MethodGet, Path: "/down", Header: http.Header{}}, nil, time.Millisecond, 4, errors.New("local transport error: refused"), 0)
+
+	srv := httptest.NewServer(in.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []InspectorEntry
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(list) != 2 || list[0].Path != "/down" || list[0].Error == "" || list[0].Attempts != 4 {
+		t.Fatalf("unexpected entries %+v", list)
+	}
+
+	resp, err = http.Post(srv.URL+"/api/requests/1/replay", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed InspectorEntry
+	if err := json.NewDecoder(resp.Body).Decode(&replayed); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || replayed.ReplayOf != 1 || replayed.Status != http.StatusOK {
+		t.Fatalf("status=%d replay=%+v", resp.StatusCode, replayed)
+	}
+	if hits != 1 || string(replayed.ResponseBody) != "payload" || replayed.ResponseHeader.Get("X-Echo") != "push" {
+		t.Fatalf("replay did not reach local app as recorded: hits=%d entry=%+v", hits, replayed)
+	}
+
+	resp, err = http.Get(srv.URL + "/requests/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "X-Event: push") {
+		t.Fatalf("detail page missing headers: %s", page)
+	}
+}
+
+func TestInspector_RingAndNil(t *testing.T) {
+	var nilInspector *Inspector
+	if nilInspector.Record(&ProxyReq{Method: http.MethodGet, Path: "/"}, nil, 0, 1, nil, 0) != nil {
+		t.Fatal("nil inspector should not record")
+	}
+	in := NewInspector("http://127.0.0.1:1")
+	for i := 0; i < inspectorRingSize+5; i++ {
+		in.Record(&ProxyReq{Method: http.MethodGet, Path: "/", Header: http.Header{}}, nil, 0, 1, nil, 0)
+	}
+	entries := in.Entries()
+	if len(entries) != inspectorRingSize || entries[0].ID != inspectorRingSize+5 {
+		t.Fatalf("len=%d newest=%d", len(entries), entries[0].ID)
+	}
+	if in.Entry(1) != nil {
+		t.Fatal("oldest entry should have been evicted")
+	}
+	if got := NormalizeInspectAddr("4040"); got != "127.0.0.1:4040" {
+		t.Fatalf("NormalizeInspectAddr=%q", got)
+	}
+}
+
+func TestInspector_RejectsCrossSiteAndRebinding(t *testing.T) {
+	var hits int
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer local.Close()
+	in := NewInspector(local.URL)
+	in.Record(&ProxyReq{Method: http.MethodPost, Path: "/hooks", Header: http.Header{}, Body: []byte("payload")}, &ProxyResp{Status: http.StatusOK, Header: http.Header{}}, 0, 1, nil, 0)
+	handler := in.Handler()
+	do := func(method, host string, header http.Header) int {
+		req := httptest.NewRequest(method, "/api/requests/1/replay", nil)
+		if method == http.MethodGet {
+			req = httptest.NewRequest(method, "/api/requests", nil)
+		}
+		req.Host = host
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do(http.MethodGet, "evil.example.com:4040", nil); code != http.StatusForbidden {
+		t.Fatalf("rebound host status=%d want 403", code)
+	}
+	if code := do(http.MethodPost, "127.0.0.1:4040", http.Header{"Origin": {"https://evil.example.com"}}); code != http.StatusForbidden {
+		t.Fatalf("cross-origin POST status=%d want 403", code)
+	}
+	if code := do(http.MethodPost, "localhost:4040", http.Header{"Sec-Fetch-Site": {"cross-site"}}); code != http.StatusForbidden {
+		t.Fatalf("cross-site POST status=%d want 403", code)
+	}
+	if hits != 0 {
+		t.Fatalf("rejected requests reached the local app %d times", hits)
+	}
+	if code := do(http.MethodPost, "127.0.0.1:4040", http.Header{"Origin": {"http://127.0.0.1:4040"}, "Sec-Fetch-Site": {"same-origin"}}); code != http.StatusCreated {
+		t.Fatalf("same-origin POST status=%d want 201", code)
+	}
+	if code := do(http.MethodGet, "[::1]:4040", nil); code != http.StatusOK {
+		t.Fatalf("loopback GET status=%d want 200", code)
+	}
+
+	in.addr = "0.0.0.0:4040"
+	if code := do(http.MethodGet, "192.168.1.20:4040", nil); code != http.StatusOK {
+		t.Fatalf("LAN IP on wildcard bind status=%d want 200", code)
+	}
+	if code := do(http.MethodGet, "evil.example.com:4040", nil); code != http.StatusForbidden {
+		t.Fatalf("rebound host on wildcard bind status=%d want 403", code)
+	}
+}
+
+func TestInspector_LargeBodyNotReplayable(t *testing.T) {
+	in := NewInspector("http://127.0.0.1:1")
+	e := in.Record(&ProxyReq{Method: http.MethodPost, Path: "/upload", Header: http.Header{}, Body: make([]byte, inspectorMaxReplayBytes+1)}, nil, 0, 1, nil, 0)
+	if e.Replayable || e.replayBody != nil || len(e.RequestBody) != inspectorMaxBodyBytes {
+		t.Fatalf("replayable=%v kept=%d shown=%d", e.Replayable, len(e.replayBody), len(e.RequestBody))
+	}
+	if _, err := in.Replay(e.ID); err == nil {
+		t.Fatal("expected replay of an oversized request to be refused")
+	}
+	if cap(e.RequestBody) != inspectorMaxBodyBytes {
+		t.Fatalf("request body retains cap=%d", cap(e.RequestBody))
+	}
+	resp := &ProxyResp{Status: http.StatusOK, Header: http.Header{}, Body: make([]byte, 8<<20)}
+	e = in.Record(&ProxyReq{Method: http.MethodGet, Path: "/download", Header: http.Header{}}, resp, 0, 1, nil, 0)
+	if !e.ResponseTruncated || cap(e.ResponseBody) != inspectorMaxBodyBytes {
+		t.Fatalf("response truncated=%v cap=%d", e.ResponseTruncated, cap(e.ResponseBody))
+	}
+}
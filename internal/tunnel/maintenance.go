@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MaintenanceOptions configures maintenance mode. Empty fields keep the tunnel's current settings;
+// ClearBypass drops the current bypass CIDRs and secret before BypassIPs and BypassSecret apply.
+type MaintenanceOptions struct {
+	Message      string
+	RetryAfter   time.Duration
+	BypassIPs    []string
+	BypassSecret string
+	ClearBypass  bool
+}
+
+// SetMaintenance turns maintenance mode on or off for a tunnel.
+func (m *Manager) SetMaintenance(name string, enabled bool, opts MaintenanceOptions) (*Tunnel, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, err
+	}
+	path := "/api/tunnels/" + url.PathEscape(strings.ToLower(name))
+	var cur apiTunnel
+	if err := apiJSON(base, sess.AccessToken, http.MethodGet, path, nil, &cur, http.StatusOK); err != nil {
+		return nil, err
+	}
+	payload := map[string]any{
+		"enabled":     enabled,
+		"message":     cur.MaintenanceMessage,
+		"retry_after": cur.MaintenanceRetryAfter,
+		"bypass_ips":  cur.MaintenanceBypassIPs,
+	}
+	if opts.ClearBypass {
+		payload["bypass_ips"] = []string{}
+		payload["clear_bypass_secret"] = true
+	}
+	if opts.Message != "" {
+		payload["message"] = opts.Message
+	}
+	if opts.RetryAfter > 0 {
+		payload["retry_after"] = int(opts.RetryAfter.Seconds())
+	}
+	if len(opts.BypassIPs) > 0 {
+		payload["bypass_ips"] = opts.BypassIPs
+	}
+	if opts.BypassSecret != "" {
+		payload["bypass_secret"] = opts.BypassSecret
+	}
+	body, _ := json.Marshal(payload)
+	var rec apiTunnel
+	if err := apiJSON(base, sess.AccessToken, http.MethodPatch, path+"/maintenance", bytes.NewReader(body), &rec, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return m.fromAPI(rec), nil
+}
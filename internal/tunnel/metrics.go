@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BRAVO68WEB/fwdx/internal/metrics"
+)
+
+// Agent metrics describe the hop from this client to the local app.
+var (
+	agentMetrics = metrics.NewRegistry()
+
+	metricUpstreamRequests = agentMetrics.Counter("fwdx_agent_upstream_requests_total",
+		"Requests forwarded to the local app by tunnel and status class.", "tunnel", "code")
+	metricUpstreamDuration = agentMetrics.Histogram("fwdx_agent_upstream_duration_seconds",
+		"Time for the local app to answer, including retries.", metrics.DefaultBuckets, "tunnel")
+	metricUpstreamErrors = agentMetrics.Counter("fwdx_agent_upstream_errors_total",
+		"Requests the local app could not answer, by reason (transport, too_large, other).", "tunnel", "reason")
+	metricUpstreamRetries = agentMetrics.Counter("fwdx_agent_upstream_retries_total",
+		"Retries of idempotent requests after a local transport error.", "tunnel")
+)
+
+// observeUpstream records one forwarded request after its final attempt.
+func observeUpstream(tunnelName string, resp *ProxyResp, took time.Duration, attempts int, proxyErr error) {
+	metricUpstreamDuration.Observe(took.Seconds(), tunnelName)
+	if attempts > 1 {
+		metricUpstreamRetries.Add(float64(attempts-1), tunnelName)
+	}
+	if proxyErr != nil {
+		reason := "other"
+		switch {
+		case errors.Is(proxyErr, ErrLocalTransport):
+			reason = "transport"
+		case errors.Is(proxyErr, ErrLocalResponseTooLarge):
+			reason = "too_large"
+		}
+		metricUpstreamErrors.Inc(tunnelName, reason)
+		return
+	}
+	metricUpstreamRequests.Inc(tunnelName, strconv.Itoa(resp.Status/100)+"xx")
+}
+
+// ServeMetrics listens on addr and serves the agent's Prometheus metrics at /metrics. It returns
+// once the listener is bound so the caller can report the address.
+func ServeMetrics(addr string) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("metrics listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", agentMetrics.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics stopped", "addr", ln.Addr().String(), "error", err)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeMetrics(t *testing.T) {
+	observeUpstream("metrics-app", &ProxyResp{Status: http.StatusCreated}, 20*time.Millisecond, 1, nil)
+	observeUpstream("metrics-app", nil, time.Second, 4, fmt.Errorf("dial: %w", ErrLocalTransport))
+
+	addr, err := ServeMetrics("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`fwdx_agent_upstream_requests_total{tunnel="metrics-app",code="2xx"} 1`,
+		`fwdx_agent_upstream_errors_total{tunnel="metrics-app",reason="transport"} 1`,
+		`fwdx_agent_upstream_retries_total{tunnel="metrics-app"} 3`,
+		`fwdx_agent_upstream_duration_seconds_count{tunnel="metrics-app"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("missing %q in:\n%s", want, body)
+		}
+	}
+}
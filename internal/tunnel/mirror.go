@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"time"
+
+	tunnelv1 "github.com/BRAVO68WEB/fwdx/api/tunnel/v1"
+	"github.com/BRAVO68WEB/fwdx/internal/config"
+)
+
+const (
+	// mirrorMaxInFlight caps concurrent mirrored requests; extra samples are skipped so a slow
+	// mirror never holds up the tunnel.
+	mirrorMaxInFlight = 16
+	// mirrorTimeout bounds one mirrored request, far below the local client's own timeout, so a
+	// stalled mirror gives its slot back quickly.
+	mirrorTimeout = 5 * time.Second
+)
+
+// Mirror sends a sampled copy of proxied requests to a second local target and compares the
+// result with the primary response. Mirrored responses are discarded.
+type Mirror struct {
+	target        string
+	samplePercent float64
+	slots         chan struct{}
+	timeout       time.Duration
+	roll          func() float64
+}
+
+// NewMirror returns a mirror to target sampling samplePercent (0-100] of requests.
+func NewMirror(target string, samplePercent float64) *Mirror {
+	return &Mirror{
+		target:        normalizeLocalURL(target),
+		samplePercent: samplePercent,
+		slots:         make(chan struct{}, mirrorMaxInFlight),
+		timeout:       mirrorTimeout,
+		roll:          func() float64 { return rand.Float64() * 100 },
+	}
+}
+
+// Target returns the URL requests are mirrored to.
+func (m *Mirror) Target() string {
+	return m.target
+}
+
+// Send mirrors pr in the background when it is sampled and calls report with the comparison
+// once the mirror answers. It never blocks the caller. The mirrored request is cancelled with
+// ctx and after the mirror timeout. Safe to call on a nil Mirror.
+func (m *Mirror) Send(ctx context.Context, pr *ProxyReq, primary *ProxyResp, primaryLatency time.Duration, report func(*tunnelv1.MirrorReport)) bool {
+	if m == nil || pr == nil || primary == nil || m.roll() >= m.samplePercent {
+		return false
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		return false
+	}
+	copied := *pr
+	copied.Header = pr.Header.Clone()
+	timeout := m.timeout
+	go func() {
+		defer func() { <-m.slots }()
+		r := &tunnelv1.MirrorReport{
+			RequestId:        pr.ID,
+			Method:           pr.Method,
+			Path:             pr.Path,
+			PrimaryStatus:    int32(primary.Status),
+			PrimaryLatencyMs: primaryLatency.Milliseconds(),
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		start := time.Now()
+		resp, err := ProxyToLocal(ctx, m.target, &copied)
+		r.MirrorLatencyMs = time.Since(start).Milliseconds()
+		if err != nil {
+			r.MirrorError = err.Error()
+		} else {
+			r.MirrorStatus = int32(resp.Status)
+		}
+		report(r)
+	}()
+	return true
+}
+
+// SetMirror stores a mirror target for the tunnel. It takes effect the next time the tunnel starts.
+func (m *Manager) SetMirror(tunnelName, target string, samplePercent float64) (*config.MirrorConfig, error) {
+	if _, err := m.Get(tunnelName); err != nil {
+		return nil, err
+	}
+	if samplePercent <= 0 || samplePercent > 100 {
+		return nil, fmt.Errorf("sample percent must be between 0 and 100")
+	}
+	u, err := url.Parse(normalizeLocalURL(target))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid mirror target %q", target)
+	}
+	cfg, err := config.LoadTunnelConfig(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Mirror = &config.MirrorConfig{Target: u.String(), SamplePercent: samplePercent}
+	if err := config.SaveTunnelConfig(tunnelName, cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Mirror, nil
+}
+
+// ClearMirror removes the tunnel's mirror target.
+func (m *Manager) ClearMirror(tunnelName string) error {
+	cfg, err := config.LoadTunnelConfig(tunnelName)
+	if err != nil {
+		return err
+	}
+	if cfg.Mirror == nil {
+		return fmt.Errorf("tunnel %s has no mirror configured", tunnelName)
+	}
+	cfg.Mirror = nil
+	return config.SaveTunnelConfig(tunnelName, cfg)
+}
+
+// loadMirror returns the tunnel's configured mirror, or nil when none is set.
+func loadMirror(tunnelName string) (*Mirror, error) {
+	cfg, err := config.LoadTunnelConfig(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Mirror == nil || cfg.Mirror.Target == "" {
+		return nil, nil
+	}
+	return NewMirror(cfg.Mirror.Target, cfg.Mirror.SamplePercent), nil
+}
@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tunnelv1 "github.com/BRAVO68WEB/fwdx/api/tunnel/v1"
+)
+
+func TestMirror_SendReportsComparison(t *testing.T) {
+	mirrorApp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Event") != "push" {
+			t.Errorf("mirror missing header: %v", r.Header)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mirrorApp.Close()
+
+	m := NewMirror(mirrorApp.URL, 100)
+	reports := make(chan *tunnelv1.MirrorReport, 1)
+	pr := &ProxyReq{ID: "r1", Method: http.MethodPost, Path: "/hooks", Header: http.Header{"X-Event": {"push"}}, Body: []byte("x")}
+	if !m.Send(context.Background(), pr, &ProxyResp{Status: http.StatusOK}, 12*time.Millisecond, func(r *tunnelv1.MirrorReport) { reports <- r }) {
+		t.Fatal("expected request to be mirrored")
+	}
+	select {
+	case r := <-reports:
+		if r.RequestId != "r1" || r.Path != "/hooks" || r.PrimaryStatus != 200 || r.PrimaryLatencyMs != 12 || r.MirrorStatus != 500 || r.MirrorError != "" {
+			t.Fatalf("unexpected report %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mirror report")
+	}
+}
+
+func TestMirror_SamplingAndFailures(t *testing.T) {
+	var nilMirror *Mirror
+	if nilMirror.Send(context.Background(), &ProxyReq{}, &ProxyResp{}, 0, nil) {
+		t.Fatal("nil mirror should not send")
+	}
+	m := NewMirror("127.0.0.1:1", 10)
+	m.roll = func() float64 { return 50 }
+	if m.Send(context.Background(), &ProxyReq{Method: http.MethodGet, Path: "/"}, &ProxyResp{Status: 200}, 0, nil) {
+		t.Fatal("request outside the sample was mirrored")
+	}
+	m.roll = func() float64 { return 5 }
+	reports := make(chan *tunnelv1.MirrorReport, 1)
+	m.Send(context.Background(), &ProxyReq{Method: http.MethodGet, Path: "/", Header: http.Header{}}, &ProxyResp{Status: 200}, 0, func(r *tunnelv1.MirrorReport) { reports <- r })
+	select {
+	case r := <-reports:
+		if r.MirrorError == "" || r.MirrorStatus != 0 {
+			t.Fatalf("unreachable mirror report %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mirror report")
+	}
+}
+
+func TestMirror_StalledTargetTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	mirrorApp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer mirrorApp.Close()
+	defer close(release)
+
+	m := NewMirror(mirrorApp.URL, 100)
+	m.timeout = 50 * time.Millisecond
+	reports := make(chan *tunnelv1.MirrorReport, 2)
+	report := func(r *tunnelv1.MirrorReport) { reports <- r }
+	m.Send(context.Background(), &ProxyReq{Method: http.MethodGet, Path: "/", Header: http.Header{}}, &ProxyResp{Status: 200}, 0, report)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.timeout = time.Minute
+	m.Send(ctx, &ProxyReq{Method: http.MethodGet, Path: "/", Header: http.Header{}}, &ProxyResp{Status: 200}, 0, report)
+	cancel()
+
+	for range 2 {
+		select {
+		case r := <-reports:
+			if r.MirrorError == "" {
+				t.Fatalf("stalled mirror report %+v", r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stalled mirror held its slot")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(m.slots) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := len(m.slots); n != 0 {
+		t.Fatalf("%d slots still held", n)
+	}
+}
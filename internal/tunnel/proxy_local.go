@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,11 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ProxyReq is a request to forward to the local app (used by HTTP and gRPC connectors).
@@ -60,17 +66,34 @@ func IsIdempotentMethod(method string) bool {
 	}
 }
 
-// ProxyToLocal forwards the request to localURL and returns the response.
-func ProxyToLocal(localURL string, pr *ProxyReq) (*ProxyResp, error) {
+// ProxyToLocal forwards the request to localURL and returns the response. The local app receives
+// the W3C traceparent of the span for this hop, so its own spans join the tunnel's trace.
+func ProxyToLocal(ctx context.Context, localURL string, pr *ProxyReq) (resp *ProxyResp, err error) {
 	if pr == nil {
 		return nil, fmt.Errorf("%w: nil request", ErrLocalTransport)
 	}
+	ctx, span := tracing.Tracer().Start(ctx, "local "+pr.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", pr.Method),
+			attribute.String("url.path", pr.Path),
+			attribute.String("fwdx.tunnel.request_id", pr.ID),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.Status))
+		}
+		span.End()
+	}()
 	target := localURL + pr.Path
 	if pr.Query != "" {
 		target += "?" + pr.Query
 	}
 	body := bytes.NewReader(pr.Body)
-	req, err := http.NewRequest(pr.Method, target, body)
+	req, err := http.NewRequestWithContext(ctx, pr.Method, target, body)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrLocalTransport, err)
 	}
@@ -81,16 +104,17 @@ func ProxyToLocal(localURL string, pr *ProxyReq) (*ProxyResp, error) {
 	}
 	req.Header.Del("Connection")
 	req.Header.Del("X-Tunnel-Hostname")
+	tracing.InjectHeader(ctx, req.Header)
 
 	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
+	httpResp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrLocalTransport, err)
 	}
-	defer resp.Body.Close()
+	defer httpResp.Body.Close()
 
 	max := maxResponseBodyBytes()
-	outBody, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	outBody, err := io.ReadAll(io.LimitReader(httpResp.Body, max+1))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrLocalTransport, err)
 	}
@@ -99,8 +123,8 @@ func ProxyToLocal(localURL string, pr *ProxyReq) (*ProxyResp, error) {
 	}
 	return &ProxyResp{
 		ID:     pr.ID,
-		Status: resp.StatusCode,
-		Header: resp.Header.Clone(),
+		Status: httpResp.StatusCode,
+		Header: httpResp.Header.Clone(),
 		Body:   outBody,
 	}, nil
 }
@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -32,7 +33,7 @@ func TestProxyToLocal_ResponseTooLarge(t *testing.T) {
 		Path:   "/",
 		Header: make(http.Header),
 	}
-	_, err := ProxyToLocal(local.URL, req)
+	_, err := ProxyToLocal(context.Background(), local.URL, req)
 	if err == nil {
 		t.Fatal("expected response-too-large error")
 	}
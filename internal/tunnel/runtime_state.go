@@ -20,6 +20,7 @@ type RuntimeState struct {
 	Local     string    `json:"local"`
 	PID       int       `json:"pid"`
 	LogPath   string    `json:"log_path"`
+	Inspector string    `json:"inspector,omitempty"`
 	StartedAt time.Time `json:"started_at"`
 }
 
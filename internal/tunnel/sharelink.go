@@ -0,0 +1,52 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ShareLink is a signed, time-limited URL for a tunnel using the signed_link access mode.
+type ShareLink struct {
+	URL       string    `json:"url"`
+	Path      string    `json:"path"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func shareLinksPath(tunnelName string) string {
+	return "/api/tunnels/" + url.PathEscape(strings.ToLower(tunnelName)) + "/share-links"
+}
+
+// CreateShareLink asks the server to sign a link valid for ttl, optionally limited to a path prefix.
+// host names the host the link opens and is required for wildcard tunnels.
+func (m *Manager) CreateShareLink(tunnelName string, ttl time.Duration, path, host string) (*ShareLink, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]string{"path": path}
+	if host != "" {
+		payload["host"] = host
+	}
+	if ttl > 0 {
+		payload["ttl"] = ttl.String()
+	}
+	body, _ := json.Marshal(payload)
+	var out ShareLink
+	if err := apiJSON(base, sess.AccessToken, http.MethodPost, shareLinksPath(tunnelName), bytes.NewReader(body), &out, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokeShareLinks rotates the tunnel's signing key, invalidating every issued link.
+func (m *Manager) RevokeShareLinks(tunnelName string) error {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return err
+	}
+	return apiJSON(base, sess.AccessToken, http.MethodDelete, shareLinksPath(tunnelName), nil, nil, http.StatusNoContent)
+}
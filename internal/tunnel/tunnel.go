@@ -8,7 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/BRAVO68WEB/fwdx/internal/config"
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -35,6 +36,10 @@ type Tunnel struct {
 	AssignedAgent string    `json:"assigned_agent,omitempty"`
 	DesiredState  string    `json:"desired_state,omitempty"`
 	ActualState   string    `json:"actual_state,omitempty"`
+	ExpiresAt     time.Time `json:"expires_at,omitzero"`
+	ExpiryAction  string    `json:"expiry_action,omitempty"`
+	Maintenance   bool      `json:"maintenance,omitempty"`
+	Capture       bool      `json:"capture,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 	Running       bool      `json:"running,omitempty"`
@@ -56,8 +61,16 @@ type apiTunnel struct {
 	DesiredState    string    `json:"desired_state"`
 	ActualState     string    `json:"actual_state"`
 	LastError       string    `json:"last_error"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	ExpiryAction    string    `json:"expiry_action"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
+
+	Maintenance           bool     `json:"maintenance"`
+	MaintenanceMessage    string   `json:"maintenance_message"`
+	MaintenanceRetryAfter int      `json:"maintenance_retry_after"`
+	MaintenanceBypassIPs  []string `json:"maintenance_bypass_ips"`
+	Capture               bool     `json:"capture"`
 }
 
 type apiAgentCreateResponse struct {
@@ -73,7 +86,15 @@ func NewManager() *Manager {
 	return &Manager{tunnelsDir: filepath.Join(home, ".fwdx", "tunnels")}
 }
 
-func (m *Manager) Create(local, subdomain, customURL string, customName string) (*Tunnel, error) {
+// CreateOptions holds optional settings for Create.
+type CreateOptions struct {
+	// TTL makes the tunnel expire after the given duration.
+	TTL time.Duration
+	// ExpiryAction is "stop" (default) or "delete".
+	ExpiryAction string
+}
+
+func (m *Manager) Create(local, subdomain, customURL string, customName string, opts ...CreateOptions) (*Tunnel, error) {
 	cfg, sess, base, err := m.loadControlPlaneContext()
 	if err != nil {
 		return nil, err
@@ -85,18 +106,28 @@ func (m *Manager) Create(local, subdomain, customURL string, customName string)
 	name := strings.TrimSpace(customName)
 	if name == "" {
 		if subdomain != "" {
-			name = subdomain + "-tunnel"
+			name = strings.ReplaceAll(subdomain, ".", "-") + "-tunnel"
 		} else {
 			name = strings.ReplaceAll(strings.TrimSpace(customURL), ".", "-") + "-tunnel"
 		}
+		name = strings.ReplaceAll(name, "*", "wildcard")
 	}
-	body, _ := json.Marshal(map[string]any{
+	payload := map[string]any{
 		"name":       name,
 		"subdomain":  subdomain,
 		"url":        customURL,
 		"local":      local,
 		"agent_name": agentName,
-	})
+	}
+	for _, o := range opts {
+		if o.TTL > 0 {
+			payload["ttl"] = o.TTL.String()
+		}
+		if o.ExpiryAction != "" {
+			payload["expiry_action"] = o.ExpiryAction
+		}
+	}
+	body, _ := json.Marshal(payload)
 	var rec apiTunnel
 	if err := apiJSON(base, sess.AccessToken, http.MethodPost, "/api/tunnels", bytes.NewReader(body), &rec, http.StatusCreated); err != nil {
 		return nil, err
@@ -116,6 +147,24 @@ func (m *Manager) Get(name string) (*Tunnel, error) {
 	return m.fromAPI(rec), nil
 }
 
+// Extend pushes a tunnel's expiry out by ttl, or removes it when clearExpiry is set.
+func (m *Manager) Extend(name string, ttl time.Duration, clearExpiry bool) (*Tunnel, error) {
+	_, sess, base, err := m.loadControlPlaneContext()
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]any{"clear": clearExpiry}
+	if ttl > 0 {
+		payload["ttl"] = ttl.String()
+	}
+	body, _ := json.Marshal(payload)
+	var rec apiTunnel
+	if err := apiJSON(base, sess.AccessToken, http.MethodPatch, "/api/tunnels/"+url.PathEscape(strings.ToLower(name))+"/expiry", bytes.NewReader(body), &rec, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return m.fromAPI(rec), nil
+}
+
 func (m *Manager) List() ([]*Tunnel, error) {
 	_, sess, base, err := m.loadControlPlaneContext()
 	if err != nil {
@@ -132,7 +181,25 @@ func (m *Manager) List() ([]*Tunnel, error) {
 	return out, nil
 }
 
-func (m *Manager) Start(name string, debug bool) error {
+// StartOptions holds optional settings for Start and StartDetached.
+type StartOptions struct {
+	// InspectAddr, when set, serves the local request inspector on this address.
+	InspectAddr string
+	// MetricsAddr, when set, serves Prometheus metrics for the local upstream at /metrics.
+	MetricsAddr string
+	// OTLPEndpoint, when set, exports OpenTelemetry spans to this OTLP/HTTP collector.
+	OTLPEndpoint string
+	// LogLevel and LogFormat are passed on to a detached process so its runtime log matches the
+	// foreground one. Start itself logs through the default slog logger.
+	LogLevel  string
+	LogFormat string
+}
+
+func (m *Manager) Start(name string, opts ...StartOptions) error {
+	var o StartOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
 	t, err := m.Get(name)
 	if err != nil {
 		return err
@@ -149,16 +216,52 @@ func (m *Manager) Start(name string, debug bool) error {
 		return fmt.Errorf("tunnel %s is already running", name)
 	}
 	if _, err := readRuntimeState(name); err == nil {
-		log.Printf("[fwdx] removed stale runtime state for tunnel=%s", name)
+		slog.Info("removed stale runtime state", "tunnel", name)
 		removeRuntimeState(name)
 	}
 	localURL := normalizeLocalURL(t.Local)
 	tunnelURL := cfg.TunnelURL()
-	log.Printf("[fwdx] connecting tunnel=%s hostname=%s local=%s server=%s", name, t.Hostname, localURL, tunnelURL)
-	return Connect(context.Background(), tunnelURL, cfg.AgentToken, t.Name, localURL, debug)
+	var inspector *Inspector
+	if o.InspectAddr != "" {
+		inspector = NewInspector(localURL)
+		addr, err := inspector.Serve(NormalizeInspectAddr(o.InspectAddr))
+		if err != nil {
+			return err
+		}
+		slog.Info("inspector listening", "tunnel", name, "url", "http://"+addr)
+		fmt.Printf("Inspector: http://%s\n", addr)
+	}
+	if o.MetricsAddr != "" {
+		addr, err := ServeMetrics(NormalizeInspectAddr(o.MetricsAddr))
+		if err != nil {
+			return err
+		}
+		slog.Info("metrics listening", "tunnel", name, "url", "http://"+addr+"/metrics")
+	}
+	if o.OTLPEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(context.Background(), "fwdx-agent", o.OTLPEndpoint)
+		if err != nil {
+			return err
+		}
+		defer shutdownTracing(context.Background())
+		slog.Info("tracing enabled", "tunnel", name, "otlp_endpoint", o.OTLPEndpoint)
+	}
+	mirror, err := loadMirror(name)
+	if err != nil {
+		return err
+	}
+	if mirror != nil {
+		slog.Info("mirroring", "tunnel", name, "target", mirror.Target(), "sample_percent", mirror.samplePercent)
+	}
+	slog.Info("connecting", "tunnel", name, "hostname", t.Hostname, "local", localURL, "peer", tunnelURL)
+	return Connect(context.Background(), tunnelURL, cfg.AgentToken, t.Name, localURL, inspector, mirror)
 }
 
-func (m *Manager) StartDetached(name string, debug bool) (*RuntimeState, error) {
+func (m *Manager) StartDetached(name string, opts ...StartOptions) (*RuntimeState, error) {
+	var o StartOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
 	t, err := m.Get(name)
 	if err != nil {
 		return nil, err
@@ -175,7 +278,7 @@ func (m *Manager) StartDetached(name string, debug bool) (*RuntimeState, error)
 		return nil, fmt.Errorf("tunnel %s is already running", name)
 	}
 	if _, err := readRuntimeState(name); err == nil {
-		log.Printf("[fwdx] removed stale runtime state for tunnel=%s before detached start", name)
+		slog.Info("removed stale runtime state before detached start", "tunnel", name)
 		removeRuntimeState(name)
 	}
 	_ = os.MkdirAll(runtimeDir(), 0755)
@@ -190,8 +293,20 @@ func (m *Manager) StartDetached(name string, debug bool) (*RuntimeState, error)
 		return nil, err
 	}
 	args := []string{"tunnel", "start", name, "--watch"}
-	if debug {
-		args = append(args, "--debug")
+	if o.LogLevel != "" {
+		args = append(args, "--log-level", o.LogLevel)
+	}
+	if o.LogFormat != "" {
+		args = append(args, "--log-format", o.LogFormat)
+	}
+	if o.InspectAddr != "" {
+		args = append(args, "--inspect", o.InspectAddr)
+	}
+	if o.MetricsAddr != "" {
+		args = append(args, "--metrics", o.MetricsAddr)
+	}
+	if o.OTLPEndpoint != "" {
+		args = append(args, "--otlp-endpoint", o.OTLPEndpoint)
 	}
 	cmd := exec.Command(exe, args...)
 	cmd.Stdout = logFile
@@ -201,6 +316,9 @@ func (m *Manager) StartDetached(name string, debug bool) (*RuntimeState, error)
 		return nil, err
 	}
 	st := &RuntimeState{Name: name, Hostname: t.Hostname, Local: t.Local, PID: cmd.Process.Pid, LogPath: logPath, StartedAt: time.Now()}
+	if o.InspectAddr != "" {
+		st.Inspector = "http://" + NormalizeInspectAddr(o.InspectAddr)
+	}
 	if err := writeRuntimeState(st); err != nil {
 		_ = cmd.Process.Kill()
 		return nil, err
@@ -300,6 +418,10 @@ func (m *Manager) fromAPI(rec apiTunnel) *Tunnel {
 		AssignedAgent: rec.AssignedAgent,
 		DesiredState:  rec.DesiredState,
 		ActualState:   rec.ActualState,
+		ExpiresAt:     rec.ExpiresAt,
+		ExpiryAction:  rec.ExpiryAction,
+		Maintenance:   rec.Maintenance,
+		Capture:       rec.Capture,
 		CreatedAt:     rec.CreatedAt,
 		UpdatedAt:     rec.UpdatedAt,
 	}
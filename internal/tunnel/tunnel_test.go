@@ -16,8 +16,9 @@ import (
 )
 
 type mockControlPlane struct {
-	tunnels map[string]map[string]any
-	agentID int64
+	tunnels     map[string]map[string]any
+	agentID     int64
+	maintenance map[string]any
 }
 
 func newMockControlPlane() *mockControlPlane {
@@ -98,6 +99,12 @@ func (m *mockControlPlane) handler() http.Handler {
 		case http.MethodPost:
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		case http.MethodPatch:
+			m.maintenance = map[string]any{}
+			_ = json.NewDecoder(r.Body).Decode(&m.maintenance)
+			rec["maintenance"] = m.maintenance["enabled"]
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(rec)
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
@@ -179,6 +186,39 @@ func TestManager_Create_Get_List_Delete_Remote(t *testing.T) {
 	}
 }
 
+func TestManager_SetMaintenance_ClearBypass(t *testing.T) {
+	cp := newMockControlPlane()
+	srv := httptest.NewServer(cp.handler())
+	defer srv.Close()
+	setTestEnv(t, srv.URL)
+
+	m := NewManager()
+	if _, err := m.Create("localhost:8080", "maint", "", "maint"); err != nil {
+		t.Fatal(err)
+	}
+	cp.tunnels["maint"]["maintenance_bypass_ips"] = []string{"203.0.113.0/24"}
+
+	if _, err := m.SetMaintenance("maint", true, MaintenanceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ips, _ := cp.maintenance["bypass_ips"].([]any); len(ips) != 1 {
+		t.Fatalf("bypass_ips=%v, want current list kept", cp.maintenance["bypass_ips"])
+	}
+	if _, ok := cp.maintenance["clear_bypass_secret"]; ok {
+		t.Fatal("clear_bypass_secret sent without ClearBypass")
+	}
+
+	if _, err := m.SetMaintenance("maint", true, MaintenanceOptions{ClearBypass: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ips, ok := cp.maintenance["bypass_ips"].([]any); !ok || len(ips) != 0 {
+		t.Fatalf("bypass_ips=%v, want empty list", cp.maintenance["bypass_ips"])
+	}
+	if cp.maintenance["clear_bypass_secret"] != true {
+		t.Fatalf("clear_bypass_secret=%v", cp.maintenance["clear_bypass_secret"])
+	}
+}
+
 func TestManager_Stop_NotRunning(t *testing.T) {
 	cp := newMockControlPlane()
 	srv := httptest.NewServer(cp.handler())
@@ -207,7 +247,7 @@ func TestManager_Start_AlreadyRunning_StateFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer removeRuntimeState("dup-tunnel")
-	if err := m.Start("dup-tunnel", false); err == nil {
+	if err := m.Start("dup-tunnel"); err == nil {
 		t.Fatal("expected already running error")
 	}
 }
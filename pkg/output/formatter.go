@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/BRAVO68WEB/fwdx/internal/tunnel"
 	"github.com/fatih/color"
@@ -63,5 +64,34 @@ func PrintTunnelDetails(t *tunnel.Tunnel) {
 	if t.PID > 0 {
 		fmt.Printf("PID:       %d\n", t.PID)
 	}
+	if t.Maintenance {
+		fmt.Println("Maintenance: on")
+	}
+	if t.Capture {
+		fmt.Println("Capture:   on")
+	}
+	if !t.ExpiresAt.IsZero() {
+		fmt.Printf("Expires:   %s (then %s)\n", t.ExpiresAt.Local().Format("2006-01-02 15:04:05"), t.ExpiryAction)
+	}
 	fmt.Printf("Created:   %s\n", t.CreatedAt.Format("2006-01-02 15:04:05"))
 }
+
+func PrintCredentialList(creds []tunnel.Credential) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Kind", "Username", "Expires", "Last Used"})
+	for _, c := range creds {
+		username := "-"
+		if c.Username != "" {
+			username = c.Username
+		}
+		table.Append([]string{c.Name, c.Kind, username, formatOptionalTime(c.ExpiresAt, "never"), formatOptionalTime(c.LastUsedAt, "-")})
+	}
+	table.Render()
+}
+
+func formatOptionalTime(t time.Time, zero string) string {
+	if t.IsZero() {
+		return zero
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
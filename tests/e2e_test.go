@@ -8,15 +8,24 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/BRAVO68WEB/fwdx/internal/server"
+	"github.com/BRAVO68WEB/fwdx/internal/tracing"
 	"github.com/BRAVO68WEB/fwdx/internal/tunnel"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -43,11 +52,11 @@ type DomainStore = server.DomainStore
 func startTestEnv(t *testing.T) *testEnv {
 	t.Helper()
 	reg := server.NewRegistry()
-	domains := server.NewDomainStore(t.TempDir())
 	store, err := server.NewStore(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
+	domains := server.NewDomainStore(store, t.TempDir())
 	auth, err := server.NewAuthManager(context.Background(), server.Config{Hostname: testHostname}, store, false)
 	if err != nil {
 		t.Fatal(err)
@@ -67,7 +76,7 @@ func startTestEnv(t *testing.T) *testEnv {
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		_ = server.RunGrpcServer(grpcLn, reg, domains.List, testHostname, false, "", "", store)
+		_ = server.RunGrpcServer(grpcLn, reg, domains.Allowed, testHostname, false, "", "", store)
 		<-ctx.Done()
 	}()
 
@@ -141,7 +150,7 @@ func (e *testEnv) runTunnel(ctx context.Context, name, hostname, localURL string
 	done := make(chan struct{})
 	tunnelCtx, cancel := context.WithCancel(ctx)
 	go func() {
-		_ = tunnel.Connect(tunnelCtx, "http://"+e.GrpcAddr, token, name, localURL, false)
+		_ = tunnel.Connect(tunnelCtx, "http://"+e.GrpcAddr, token, name, localURL, nil, nil)
 		close(done)
 	}()
 	return cancel, done
@@ -166,6 +175,16 @@ func (e *testEnv) provisionAgentAndTunnel(ctx context.Context, name, hostname st
 	return token
 }
 
+// staticTXT is a DomainResolver answering from a fixed map.
+type staticTXT map[string][]string
+
+func (s staticTXT) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if v, ok := s[name]; ok {
+		return v, nil
+	}
+	return nil, errors.New("no such host")
+}
+
 func hashCredential(raw string) string {
 	sum := sha256.Sum256([]byte(raw))
 	return hex.EncodeToString(sum[:])
@@ -213,6 +232,152 @@ func TestE2E_Proxy_ServerHostname_InfoPage(t *testing.T) {
 	}
 }
 
+func TestE2E_TrafficMirrorReports(t *testing.T) {
+	env := startTestEnv(t)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer local.Close()
+	mirrored := make(chan struct{}, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		mirrored <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	token := env.provisionAgentAndTunnel(ctx, "app", "app."+testHostname)
+	go func() {
+		_ = tunnel.Connect(ctx, "http://"+env.GrpcAddr, token, "app", local.URL, nil, tunnel.NewMirror(shadow.URL, 100))
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	req, _ := http.NewRequest(http.MethodGet, env.WebURL+"/orders", nil)
+	req.Host = "app." + testHostname
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "primary" {
+		t.Fatalf("status=%d body=%q; mirror must not affect the primary response", resp.StatusCode, body)
+	}
+	select {
+	case <-mirrored:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	tun, err := env.Store.GetTunnelByName(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary server.MirrorSummary
+	deadline := time.Now().Add(5 * time.Second)
+	for summary.Total == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		if summary, err = env.Store.GetMirrorSummary(ctx, tun.ID, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if summary.Total != 1 || summary.StatusMismatches != 1 || len(summary.RecentDiffs) != 1 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if d := summary.RecentDiffs[0]; d.Path != "/orders" || d.PrimaryStatus != 200 || d.MirrorStatus != 404 {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+}
+
+func TestE2E_TracePropagatesThroughTunnel(t *testing.T) {
+	var mu sync.Mutex
+	spans := map[string]*tracepb.Span{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, sp := range ss.Spans {
+					spans[sp.Name] = sp
+				}
+			}
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+	shutdown, err := tracing.Setup(context.Background(), "fwdx-e2e", collector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	env := startTestEnv(t)
+	localTraceparent := make(chan string, 1)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		localTraceparent <- r.Header.Get("Traceparent")
+		w.Write([]byte("traced"))
+	}))
+	defer local.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _ = env.runTunnel(ctx, "app", "app."+testHostname, local.URL)
+	time.Sleep(200 * time.Millisecond)
+
+	const clientTrace, clientSpan = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, _ := http.NewRequest(http.MethodGet, env.WebURL+"/traced", nil)
+	req.Host = "app." + testHostname
+	req.Header.Set("Traceparent", "00-"+clientTrace+"-"+clientSpan+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status=%d", resp.StatusCode)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	parents := map[string]string{
+		"proxy GET":      clientSpan,
+		"tunnel enqueue": "proxy GET",
+		"tunnel wait":    "proxy GET",
+		"tunnel receive": "tunnel enqueue",
+		"local GET":      "tunnel receive",
+	}
+	for name, parent := range parents {
+		sp := spans[name]
+		if sp == nil {
+			t.Fatalf("missing span %q; got %v", name, spans)
+		}
+		if hex.EncodeToString(sp.TraceId) != clientTrace {
+			t.Fatalf("%s: trace %x, want the client's trace", name, sp.TraceId)
+		}
+		wantParent := parent
+		if p := spans[parent]; p != nil {
+			wantParent = hex.EncodeToString(p.SpanId)
+		}
+		if got := hex.EncodeToString(sp.ParentSpanId); got != wantParent {
+			t.Fatalf("%s: parent %s, want %s (%s)", name, got, wantParent, parent)
+		}
+	}
+	want := "00-" + clientTrace + "-" + hex.EncodeToString(spans["local GET"].SpanId) + "-01"
+	if got := <-localTraceparent; got != want {
+		t.Fatalf("local app traceparent=%q want %q", got, want)
+	}
+}
+
 func TestE2E_Proxy_SubdomainTunnel(t *testing.T) {
 	env := startTestEnv(t)
 	localBody := []byte("hello from subdomain backend")
@@ -365,9 +530,9 @@ func TestE2E_Proxy_AfterDisconnect(t *testing.T) {
 
 func TestE2E_Admin_Unauthorized(t *testing.T) {
 	reg := server.NewRegistry()
-	domains := server.NewDomainStore(t.TempDir())
 	store, auth, _ := newAdminSession(t)
 	defer store.Close()
+	domains := server.NewDomainStore(store, t.TempDir())
 	mux := http.NewServeMux()
 	mux.Handle("/admin/", server.AdminRouter(testHostname, reg, domains, auth, nil, store))
 	mux.Handle("/", server.ProxyHandler(reg, testHostname))
@@ -388,9 +553,9 @@ func TestE2E_Admin_Unauthorized(t *testing.T) {
 
 func TestE2E_Admin_Info(t *testing.T) {
 	reg := server.NewRegistry()
-	domains := server.NewDomainStore(t.TempDir())
 	store, auth, raw := newAdminSession(t)
 	defer store.Close()
+	domains := server.NewDomainStore(store, t.TempDir())
 	mux := http.NewServeMux()
 	mux.Handle("/admin/", server.AdminRouter(testHostname, reg, domains, auth, nil, store))
 	srv := httptest.NewServer(mux)
@@ -467,9 +632,9 @@ func TestE2E_Admin_Tunnels_EmptyThenWithTunnel(t *testing.T) {
 
 func TestE2E_Admin_Domains_ListAddDelete(t *testing.T) {
 	reg := server.NewRegistry()
-	domains := server.NewDomainStore(t.TempDir())
 	store, auth, raw := newAdminSession(t)
 	defer store.Close()
+	domains := server.NewDomainStore(store, t.TempDir())
 	mux := http.NewServeMux()
 	mux.Handle("/admin/", server.AdminRouter(testHostname, reg, domains, auth, nil, store))
 	srv := httptest.NewServer(mux)
@@ -545,7 +710,7 @@ func TestE2E_Tunnel_WrongToken(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	env.provisionAgentAndTunnel(context.Background(), "app", "app."+testHostname)
-	err := tunnel.Connect(ctx, "http://"+env.GrpcAddr, "wrong-token", "app", local.URL, false)
+	err := tunnel.Connect(ctx, "http://"+env.GrpcAddr, "wrong-token", "app", local.URL, nil, nil)
 	if err == nil {
 		t.Fatal("expected error when token is wrong")
 	}
@@ -562,7 +727,7 @@ func TestE2E_Tunnel_UnassignedRejected(t *testing.T) {
 	_ = env.provisionAgentAndTunnel(context.Background(), "restricted", "custom.example.com")
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	err := tunnel.Connect(ctx, "http://"+env.GrpcAddr, "wrong-agent-token", "restricted", local.URL, false)
+	err := tunnel.Connect(ctx, "http://"+env.GrpcAddr, "wrong-agent-token", "restricted", local.URL, nil, nil)
 	if err == nil {
 		t.Fatal("expected error when agent is not assigned")
 	}
@@ -580,6 +745,21 @@ func TestE2E_Tunnel_CustomDomain_Allowed(t *testing.T) {
 	}))
 	defer local.Close()
 
+	// Pending domains cannot carry tunnels.
+	env.provisionAgentAndTunnel(context.Background(), "custom-allowed", "custom.allowed.com")
+	pendingCtx, pendingCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer pendingCancel()
+	err := tunnel.Connect(pendingCtx, "http://"+env.GrpcAddr, "agent-token-custom-allowed", "custom-allowed", local.URL, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "domain not verified") {
+		t.Fatalf("expected unverified domain to be refused, got %v", err)
+	}
+
+	rec, _ := env.Domains.Get("custom.allowed.com")
+	env.Domains.SetVerifier(staticTXT{rec.TXTName(): {rec.TXTValue()}}, nil)
+	if _, err := env.Domains.Verify(context.Background(), "custom.allowed.com"); err != nil {
+		t.Fatal(err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	env.runTunnel(ctx, "custom-allowed", "custom.allowed.com", local.URL)
@@ -615,7 +795,7 @@ func TestE2E_Tunnel_HostnameConflictRejected(t *testing.T) {
 
 	ctxB, cancelB := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancelB()
-	err := tunnel.Connect(ctxB, "http://"+env.GrpcAddr, "agent-token-dup", "dup", localB.URL, false)
+	err := tunnel.Connect(ctxB, "http://"+env.GrpcAddr, "agent-token-dup", "dup", localB.URL, nil, nil)
 	if err == nil {
 		t.Fatal("expected hostname conflict error")
 	}